@@ -0,0 +1,550 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// RunOptions controls how Run executes a command. The zero value matches
+// a plain 'ddash run': writes per config, no network proxy, env scrubbed.
+type RunOptions struct {
+	DenyWrite      bool     // deny all filesystem writes
+	InteractiveNet bool     // route network through the prompting proxy
+	PassEnv        bool     // pass the environment through unscrubbed
+	RedactEnv      bool     // pass the environment, mask secrets in ddash output
+	ParanoidEnv    bool     // scrub everything but a curated safe set
+	KeepEnv        []string // variables passed through despite scrubbing
+	Verbose        bool     // print a preflight banner before exec
+	LogDenials     bool     // collect and report sandbox violations
+
+	// ForwardSignals relays SIGINT/SIGTERM received by this process to the
+	// child. The CLI sets it; embedders usually cancel ctx instead.
+	ForwardSignals bool
+
+	// GroupPrompts, with InteractiveNet, asks about new domains requested
+	// close together in one prompt (see NetworkProxy.SetPromptGroup).
+	GroupPrompts bool
+
+	// Monitor, with InteractiveNet, lets every domain through unasked,
+	// including ones saved as "never", and reports the ones a strict
+	// policy would have blocked in ExitResult.WouldDeny. A config's
+	// net_mode "monitor" has no effect without it.
+	Monitor bool
+
+	// NoSandbox runs the command without a sandbox profile; env scrubbing
+	// and the --net proxy still apply. A config's isolation "none" is an
+	// error without it.
+	NoSandbox bool
+
+	// Audit runs under "enforcement": "audit": an allow-all profile that
+	// logs access instead of blocking it. A config's enforcement "audit"
+	// has no effect without it.
+	Audit bool
+
+	// PromptTimeout, with InteractiveNet, gives up on a prompt nobody
+	// answers after this long and denies every new domain from then on
+	// (see NetworkProxy.SetPromptWatchdog). Zero waits indefinitely.
+	PromptTimeout time.Duration
+
+	// HTTPLog, with InteractiveNet, receives a "method host path -> status"
+	// line per forwarded plain HTTP request. HTTPS is not covered.
+	HTTPLog io.Writer
+
+	// AuditLog, with InteractiveNet and a Path, records every connection
+	// decision of the proxy in a size-rotated file.
+	AuditLog AuditConfig
+
+	// SandboxExec is the sandbox-exec binary to run under. Empty uses
+	// $DDASH_SANDBOX_EXEC, or sandbox-exec from PATH.
+	SandboxExec string
+
+	// KeepProfile, if set, is where the generated profile is written
+	// before it is checked, so it can be inspected after a failed run.
+	// The file is left in place.
+	KeepProfile string
+
+	// MetricsAddr, with InteractiveNet, serves the proxy's counters in
+	// Prometheus text format at http://MetricsAddr/metrics (loopback only).
+	MetricsAddr string
+
+	// Confirm, if set, gets a one-line summary of the policy before the
+	// child starts. Returning false aborts the run with ErrNotConfirmed.
+	Confirm func(summary string) bool
+
+	// Prompter decides on unknown domains with InteractiveNet.
+	// Nil uses the /dev/tty prompt.
+	Prompter Prompter
+
+	// Decider, with InteractiveNet, is consulted about unknown domains
+	// before Prompter, which is only asked if the Decider fails.
+	Decider Decider
+
+	// Dir is the child's working directory; empty means the current one.
+	Dir string
+
+	// Env is appended to the child's (scrubbed) environment.
+	Env []string
+
+	// Child stdio. Nil means the corresponding os.Std* stream. ddash's own
+	// banners and warnings go to Stderr too.
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+// stderr is where the child's stderr and ddash's messages about the run go.
+func (opts RunOptions) stderr() io.Writer {
+	if opts.Stderr == nil {
+		return os.Stderr
+	}
+	return opts.Stderr
+}
+
+// ErrNotConfirmed is returned by Run when RunOptions.Confirm declines.
+var ErrNotConfirmed = errors.New("run not confirmed")
+
+// ExitResult is the outcome of Run.
+type ExitResult struct {
+	ExitCode  int               // child exit code (-1 if killed by a signal)
+	Decisions map[string]string // proxy domain decisions, with InteractiveNet
+	Blocked   []string          // domains the proxy refused, sorted, with InteractiveNet
+	WouldDeny []string          // domains allowed only by "log" or in Monitor mode, sorted
+	Denials   []Denial          // sandbox violations, with LogDenials
+}
+
+// Run executes argv under the sandbox policy in cfg: it generates the
+// profile, scrubs the environment, starts the network proxy if requested
+// and waits for the child. A non-zero exit of the child is reported in
+// ExitResult, not as an error. Cancelling ctx kills the child.
+func Run(ctx context.Context, cfg SandboxConfig, argv []string, opts RunOptions) (ExitResult, error) {
+	result := ExitResult{ExitCode: -1}
+	if len(argv) == 0 {
+		return result, fmt.Errorf("no command specified")
+	}
+
+	binary, err := lookBinary(argv[0])
+	if err != nil {
+		return result, err
+	}
+
+	s, err := newRunSession(ctx, cfg, redactSecrets(strings.Join(argv, " ")), opts)
+	if err != nil {
+		return result, err
+	}
+	defer s.Close()
+
+	if opts.Confirm != nil && !opts.Confirm(s.summary(argv)) {
+		return result, ErrNotConfirmed
+	}
+
+	s.printBanner(argv[0])
+	return s.exec(ctx, binary, argv)
+}
+
+// lookBinary finds the command binary. A relative path like ./script.sh
+// is made absolute so it still works when RunOptions.Dir is set.
+func lookBinary(name string) (string, error) {
+	binary, err := exec.LookPath(name)
+	if err != nil {
+		return "", fmt.Errorf("command not found: %s", redactSecrets(name))
+	}
+	if !filepath.IsAbs(binary) {
+		if abs, err := filepath.Abs(binary); err == nil {
+			binary = abs
+		}
+	}
+	return binary, nil
+}
+
+// execCommand builds every command ddash runs under sandbox-exec: the
+// child itself and the profile check before it. Tests replace it to see
+// what would run without running it.
+var execCommand = exec.CommandContext
+
+// runSession is what commands run under one policy share: the checked
+// profile, the child environment and the --net proxy. Run uses a session
+// per command; batch reuses one for many.
+type runSession struct {
+	cfg         SandboxConfig
+	opts        RunOptions
+	profile     string
+	sandboxExec string // empty when unsandboxed
+	unsandboxed bool
+	env         []string
+	envStatus   string
+	netStatus   string
+	scrubbed    int // env vars removed by scrubbing
+	proxy       *NetworkProxy
+	auditLog    string // kept log of an audit-mode run
+}
+
+// newRunSession generates and checks the profile, builds the environment
+// and starts the proxy if opts ask for it. cmdName is shown in prompts.
+// The proxy serves until ctx is cancelled or Close is called.
+func newRunSession(ctx context.Context, cfg SandboxConfig, cmdName string, opts RunOptions) (*runSession, error) {
+	cfg = withAuditFlag(opts.stderr(), cfg, opts.Audit)
+	if cfg.Isolation == isolationNone && !opts.NoSandbox {
+		return nil, fmt.Errorf(`config sets "isolation": "none"; pass --no-sandbox to run without the sandbox`)
+	}
+	for _, ext := range cfg.DenyWriteExts {
+		if err := validateWriteExt(ext); err != nil {
+			return nil, err
+		}
+	}
+	for _, device := range cfg.AllowDevices {
+		if err := validateDevice(device); err != nil {
+			return nil, err
+		}
+	}
+	for _, fifo := range cfg.AllowFIFOs {
+		if err := validateFIFO(fifo); err != nil {
+			return nil, err
+		}
+	}
+
+	s := &runSession{
+		cfg:         cfg,
+		opts:        opts,
+		profile:     GenerateProfile(cfg, opts.DenyWrite, opts.InteractiveNet),
+		unsandboxed: opts.NoSandbox,
+	}
+
+	if opts.KeepProfile != "" {
+		if err := keepProfile(opts.KeepProfile, s.profile); err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(opts.stderr(), "ddash: profile kept at %s\n", opts.KeepProfile)
+	}
+
+	if !s.unsandboxed && !cfg.auditMode() {
+		cwd, _ := os.Getwd()
+		for _, warning := range append(warnMissingPaths(cfg, cwd), ignoreWarnings(cwd)...) {
+			fmt.Fprintf(opts.stderr(), "ddash: warning: %s\n", warning)
+		}
+	}
+
+	if !s.unsandboxed {
+		sandboxExec, err := findSandboxExec(opts.stderr(), opts.SandboxExec)
+		if err != nil {
+			return nil, err
+		}
+		s.sandboxExec = sandboxExec
+	}
+
+	// Build environment
+	if opts.PassEnv {
+		s.env = os.Environ()
+	} else if opts.RedactEnv {
+		s.env = redactedEnv()
+	} else {
+		s.env = scrubEnv(opts.stderr(), opts.ParanoidEnv, opts.KeepEnv)
+		s.scrubbed = len(os.Environ()) - len(s.env)
+	}
+
+	// Start interactive proxy if requested
+	if opts.InteractiveNet {
+		if err := s.startProxy(ctx, cmdName); err != nil {
+			s.Close()
+			return nil, err
+		}
+	}
+
+	s.envStatus = "scrubbed"
+	if opts.PassEnv {
+		s.envStatus = "passed"
+	} else if opts.RedactEnv {
+		s.envStatus = "redacted"
+	} else if opts.ParanoidEnv {
+		s.envStatus = "paranoid"
+	}
+	s.netStatus = networkStatus(s.profile)
+	if opts.InteractiveNet {
+		s.netStatus = proxyNetStatus(opts.Monitor)
+	}
+
+	if opts.Verbose {
+		proxyAddr := ""
+		if s.proxy != nil {
+			proxyAddr = s.proxy.Addr()
+		}
+		writePreflight(opts.stderr(), s.profile, cfg, s.scrubbed, proxyAddr, opts.Monitor)
+	}
+
+	// Audit runs keep their access log for review; nothing is denied, so
+	// it replaces the denial log
+	if cfg.auditMode() && !s.unsandboxed {
+		logFile, err := os.CreateTemp("", "ddash-audit-*.log")
+		if err != nil {
+			s.Close()
+			return nil, fmt.Errorf("failed to create audit log: %w", err)
+		}
+		logFile.Close()
+		s.auditLog = logFile.Name()
+	}
+
+	return s, nil
+}
+
+// keepProfile writes profile to path, creating its directory.
+func keepProfile(path, profile string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("failed to keep profile: %w", err)
+	}
+	if err := os.WriteFile(path, []byte(profile), 0600); err != nil {
+		return fmt.Errorf("failed to keep profile: %w", err)
+	}
+	return nil
+}
+
+// startProxy starts the --net proxy and points the environment at it.
+func (s *runSession) startProxy(ctx context.Context, cmdName string) error {
+	proxy, err := newConfiguredProxy(s.cfg, s.opts, cmdName)
+	if err != nil {
+		return err
+	}
+	s.proxy = proxy
+	proxy.StartContext(ctx)
+
+	proxyURL := "http://" + proxy.Addr()
+	s.env = append(s.env,
+		"HTTP_PROXY="+proxyURL,
+		"HTTPS_PROXY="+proxyURL,
+		"http_proxy="+proxyURL,
+		"https_proxy="+proxyURL,
+	)
+	return nil
+}
+
+// newConfiguredProxy creates a --net proxy enforcing cfg's network policy
+// with the proxy settings of opts. It is not started yet.
+func newConfiguredProxy(cfg SandboxConfig, opts RunOptions, cmdName string) (*NetworkProxy, error) {
+	if allowsAllNet(cfg) {
+		fmt.Fprintf(opts.stderr(), "ddash: --net takes precedence over allow_net [\"*\"]: every new domain is prompted\n")
+	}
+	domains, httpsOnly := proxyDomains(cfg)
+	proxy, err := NewProxy(domains, cmdName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start network proxy: %w", err)
+	}
+	if err := configureProxy(proxy, cfg, opts, httpsOnly); err != nil {
+		proxy.Shutdown()
+		return nil, err
+	}
+	return proxy, nil
+}
+
+// configureProxy applies the settings of newConfiguredProxy.
+func configureProxy(proxy *NetworkProxy, cfg SandboxConfig, opts RunOptions, httpsOnly []string) error {
+	proxy.SetHTTPSOnly(httpsOnly)
+	proxy.SetStripHeaders(cfg.StripHeaders)
+	proxy.SetStrictSNI(cfg.StrictSNI)
+	if err := proxy.SetPromptOptions(cfg.PromptOptions); err != nil {
+		return err
+	}
+	if cfg.BlockedNets != nil {
+		if err := proxy.SetBlockedNets(*cfg.BlockedNets); err != nil {
+			return err
+		}
+	}
+	if err := proxy.SetPins(cfg.PinNet); err != nil {
+		return err
+	}
+	if err := proxy.SetRewrite(cfg.NetRewrite); err != nil {
+		return err
+	}
+	if cfg.auditMode() {
+		proxy.SetAudit(opts.stderr())
+	}
+	if opts.Monitor {
+		proxy.SetMonitor(true)
+	}
+	if opts.Prompter != nil {
+		proxy.SetPrompter(opts.Prompter)
+	}
+	if opts.Decider != nil {
+		proxy.SetDecider(opts.Decider)
+	}
+	if opts.GroupPrompts {
+		proxy.SetPromptGroup(promptGroupWindow)
+	}
+	if opts.PromptTimeout > 0 {
+		proxy.SetPromptWatchdog(opts.PromptTimeout)
+	}
+	if opts.HTTPLog != nil {
+		proxy.SetHTTPLog(opts.HTTPLog)
+	}
+	if opts.AuditLog.Path != "" {
+		if err := proxy.SetAuditLog(opts.AuditLog); err != nil {
+			return err
+		}
+	}
+	if opts.MetricsAddr != "" {
+		if err := proxy.ServeMetrics(opts.MetricsAddr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// proxyDrainTimeout bounds how long Close lets in-flight proxy requests
+// and tunnels finish before cutting them off.
+const proxyDrainTimeout = 2 * time.Second
+
+// Close shuts the proxy down, draining open connections first.
+func (s *runSession) Close() {
+	if s.proxy != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), proxyDrainTimeout)
+		defer cancel()
+		s.proxy.ShutdownContext(ctx)
+	}
+}
+
+// printBanner announces the policy a command named name runs under.
+func (s *runSession) printBanner(name string) {
+	w := s.opts.stderr()
+	if s.unsandboxed {
+		fmt.Fprintf(w, "ddash: WARNING: sandbox DISABLED (isolation=none) — no filesystem or network isolation\n")
+		fmt.Fprintf(w, "ddash: WARNING: use this only to debug ddash itself, never for untrusted code\n")
+		fmt.Fprintf(w, "ddash: running %s unsandboxed (network=%s, writes=unrestricted, env=%s)\n",
+			redactSecrets(name), unsandboxedNetStatus(s.opts.InteractiveNet), s.envStatus)
+		return
+	}
+	if s.cfg.auditMode() {
+		fmt.Fprintf(w, "ddash: AUDIT mode (enforcement: audit): nothing is blocked, access is logged\n")
+		fmt.Fprintf(w, "ddash: auditing %s (env=%s)\n", redactSecrets(name), s.envStatus)
+		fmt.Fprintf(w, "ddash: audit log: %s\n", s.auditLog)
+		return
+	}
+	fmt.Fprintf(w, "ddash: sandboxing %s (network=%s, writes=%s, env=%s)\n",
+		redactSecrets(name), s.netStatus, writeStatus(s.profile), s.envStatus)
+}
+
+// summary describes in one line what argv would run with, for a
+// confirmation prompt.
+func (s *runSession) summary(argv []string) string {
+	network, writes := s.netStatus, "denied"
+	switch {
+	case s.unsandboxed:
+		network, writes = unsandboxedNetStatus(s.opts.InteractiveNet), "unrestricted"
+	case s.cfg.auditMode():
+		network, writes = "audited, not blocked", "audited, not blocked"
+	case !s.opts.DenyWrite:
+		paths := append([]string(nil), s.cfg.AllowWrite...)
+		if s.cfg.tmpWriteAllowed() {
+			paths = append(paths, "/tmp")
+		}
+		if len(paths) > 0 {
+			writes = strings.Join(paths, ", ") + " only"
+		}
+	}
+
+	env := s.envStatus
+	if env == "scrubbed" {
+		env = fmt.Sprintf("%d vars scrubbed", s.scrubbed)
+	}
+	return fmt.Sprintf("network: %s, writes: %s, env: %s, command: %s",
+		network, writes, env, redactSecrets(strings.Join(argv, " ")))
+}
+
+// exec runs binary (argv[0] resolved) under the session and waits for it.
+func (s *runSession) exec(ctx context.Context, binary string, argv []string) (ExitResult, error) {
+	result := ExitResult{ExitCode: -1}
+	opts := s.opts
+
+	var cmd *exec.Cmd
+	if s.unsandboxed {
+		cmd = execCommand(ctx, binary, argv[1:]...)
+	} else {
+		// Build sandbox-exec command args
+		cmdArgs := []string{"-p", s.profile, binary}
+		cmdArgs = append(cmdArgs, argv[1:]...)
+
+		// Use exec.Command instead of syscall.Exec for proper stdin/stdout/stderr
+		// piping. syscall.Exec replaces the process which breaks piped input.
+		cmd = execCommand(ctx, s.sandboxExec, cmdArgs...)
+	}
+	cmd.Stdin = opts.Stdin
+	if cmd.Stdin == nil {
+		cmd.Stdin = os.Stdin
+	}
+	cmd.Stdout = opts.Stdout
+	if cmd.Stdout == nil {
+		cmd.Stdout = os.Stdout
+	}
+	cmd.Stderr = opts.stderr()
+	flushStderr := func() {}
+	if !s.unsandboxed {
+		cmd.Stderr, flushStderr = stderrFilter(cmd.Stderr)
+	}
+	cmd.Dir = opts.Dir
+	cmd.Env = append(append([]string(nil), s.env...), opts.Env...)
+	if s.auditLog != "" {
+		cmd.Env = append(cmd.Env, "SANDBOX_LOG_FILE="+s.auditLog)
+	}
+
+	// Collect sandbox violation reports in a temp log, like trace does
+	denialLog := ""
+	if opts.LogDenials && !s.unsandboxed && !s.cfg.auditMode() {
+		logFile, err := os.CreateTemp("", "ddash-denials-*.log")
+		if err != nil {
+			return result, fmt.Errorf("failed to create denial log: %w", err)
+		}
+		denialLog = logFile.Name()
+		logFile.Close()
+		defer os.Remove(denialLog)
+		cmd.Env = append(cmd.Env, "SANDBOX_LOG_FILE="+denialLog)
+	}
+
+	// Forward signals to the child process
+	if opts.ForwardSignals {
+		sigCh := make(chan os.Signal, 1)
+		signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+		go func() {
+			for sig := range sigCh {
+				if cmd.Process != nil {
+					cmd.Process.Signal(sig)
+				}
+			}
+		}()
+		defer signal.Stop(sigCh)
+	}
+
+	runErr := cmd.Run()
+	flushStderr()
+
+	if s.proxy != nil {
+		result.Decisions = s.proxy.Domains()
+		result.Blocked = s.proxy.DeniedDomains()
+		result.WouldDeny = s.proxy.WouldDeny()
+	}
+	if denialLog != "" {
+		result.Denials = collectDenials(denialLog)
+	}
+
+	if runErr != nil {
+		if exitErr, ok := runErr.(*exec.ExitError); ok {
+			result.ExitCode = exitErr.ExitCode()
+			// Either the command failed or sandbox-exec never started it;
+			// only the second is ddash's to report
+			if !s.unsandboxed {
+				if err := checkProfile(opts.stderr(), s.sandboxExec, s.profile); err != nil {
+					return result, err
+				}
+			}
+			return result, nil
+		}
+		return result, runErr
+	}
+
+	result.ExitCode = 0
+	return result, nil
+}
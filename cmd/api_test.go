@@ -0,0 +1,240 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRunReportsExitCode(t *testing.T) {
+	cfg := SandboxConfig{Isolation: isolationNone}
+	res, err := Run(context.Background(), cfg, []string{"sh", "-c", "exit 3"}, RunOptions{NoSandbox: true})
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if res.ExitCode != 3 {
+		t.Errorf("ExitCode = %d, want 3", res.ExitCode)
+	}
+}
+
+func TestRunCapturesOutput(t *testing.T) {
+	var stdout bytes.Buffer
+	cfg := SandboxConfig{Isolation: isolationNone}
+	res, err := Run(context.Background(), cfg, []string{"echo", "hello"}, RunOptions{NoSandbox: true, Stdout: &stdout})
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if res.ExitCode != 0 {
+		t.Errorf("ExitCode = %d, want 0", res.ExitCode)
+	}
+	if got := strings.TrimSpace(stdout.String()); got != "hello" {
+		t.Errorf("stdout = %q, want %q", got, "hello")
+	}
+}
+
+func TestRunScrubsEnv(t *testing.T) {
+	os.Setenv("DDASH_TEST_SECRET_KEY", "should_be_scrubbed")
+	defer os.Unsetenv("DDASH_TEST_SECRET_KEY")
+
+	var stdout bytes.Buffer
+	cfg := SandboxConfig{Isolation: isolationNone}
+	_, err := Run(context.Background(), cfg, []string{"env"}, RunOptions{NoSandbox: true, Stdout: &stdout})
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if strings.Contains(stdout.String(), "should_be_scrubbed") {
+		t.Error("secret env var reached the child")
+	}
+}
+
+func TestRunCommandNotFound(t *testing.T) {
+	cfg := SandboxConfig{Isolation: isolationNone}
+	_, err := Run(context.Background(), cfg, []string{"ddash-no-such-command"}, RunOptions{NoSandbox: true})
+	if err == nil || !strings.Contains(err.Error(), "command not found") {
+		t.Errorf("err = %v, want command not found", err)
+	}
+}
+
+func TestRunCancelKillsChild(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	cfg := SandboxConfig{Isolation: isolationNone}
+	res, err := Run(ctx, cfg, []string{"sleep", "10"}, RunOptions{NoSandbox: true})
+	if err == nil && res.ExitCode == 0 {
+		t.Error("expected cancelled run to fail")
+	}
+}
+
+func TestRunInDir(t *testing.T) {
+	dir, _ := filepath.EvalSymlinks(t.TempDir())
+
+	var stdout bytes.Buffer
+	cfg := SandboxConfig{Isolation: isolationNone}
+	if _, err := Run(context.Background(), cfg, []string{"pwd"}, RunOptions{NoSandbox: true, Dir: dir, Stdout: &stdout}); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if got := strings.TrimSpace(stdout.String()); got != dir {
+		t.Errorf("child ran in %q, want %q", got, dir)
+	}
+
+	// Relative config paths still resolve against ddash's own directory
+	cwd, _ := os.Getwd()
+	profile := GenerateProfile(SandboxConfig{AllowWrite: []string{"."}}, false, false)
+	if !strings.Contains(profile, `(allow file-write* (subpath "`+cwd+`"))`) {
+		t.Error("allow_write \".\" should resolve against the config root, not the child's dir")
+	}
+}
+
+// writeSandboxExecStub writes a sandbox-exec stand-in to dir that logs its
+// arguments to log, one per line, then runs the command after the profile.
+func writeSandboxExecStub(t *testing.T, dir, log string) string {
+	t.Helper()
+	stub := filepath.Join(dir, "sandbox-exec-stub")
+	script := "#!/bin/sh\nprintf '%s\\n' \"$@\" >> " + log + "\nshift 2\nexec \"$@\"\n"
+	if err := os.WriteFile(stub, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return stub
+}
+
+func TestFindSandboxExec(t *testing.T) {
+	dir := t.TempDir()
+	stub := writeSandboxExecStub(t, dir, filepath.Join(dir, "log"))
+	plain := filepath.Join(dir, "not-executable")
+	os.WriteFile(plain, []byte("#!/bin/sh\n"), 0644)
+
+	t.Setenv(sandboxExecEnv, stub)
+	var notice strings.Builder
+	if got, err := findSandboxExec(&notice, ""); err != nil || got != stub {
+		t.Errorf("findSandboxExec with $%s = %q, %v; want %q", sandboxExecEnv, got, err, stub)
+	}
+	if !strings.Contains(notice.String(), stub) || !strings.Contains(notice.String(), sandboxExecEnv) {
+		t.Errorf("notice = %q, want the override and the variable named", notice.String())
+	}
+	// The flag wins over the environment, and needs no notice
+	notice.Reset()
+	if got, err := findSandboxExec(&notice, stub); err != nil || got != stub || notice.Len() != 0 {
+		t.Errorf("findSandboxExec(flag) = %q, %v, notice %q; want %q quietly", got, err, notice.String(), stub)
+	}
+	if _, err := findSandboxExec(io.Discard, plain); err == nil || !strings.Contains(err.Error(), "--sandbox-exec") {
+		t.Errorf("findSandboxExec(non-executable) err = %v, want one naming --sandbox-exec", err)
+	}
+	for _, bad := range []string{plain, dir, filepath.Join(dir, "missing")} {
+		t.Setenv(sandboxExecEnv, bad)
+		if _, err := findSandboxExec(io.Discard, ""); err == nil || !strings.Contains(err.Error(), sandboxExecEnv) {
+			t.Errorf("findSandboxExec with $%s=%s err = %v, want one naming the variable", sandboxExecEnv, bad, err)
+		}
+	}
+}
+
+func TestRunUsesSandboxExecOverride(t *testing.T) {
+	dir := t.TempDir()
+	log := filepath.Join(dir, "log")
+	stub := writeSandboxExecStub(t, dir, log)
+
+	var stdout bytes.Buffer
+	cfg := SandboxConfig{Isolation: isolationProcess, AllowWrite: []string{"."}}
+	res, err := Run(context.Background(), cfg, []string{"echo", "hello"}, RunOptions{SandboxExec: stub, Stdout: &stdout})
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if res.ExitCode != 0 || strings.TrimSpace(stdout.String()) != "hello" {
+		t.Errorf("got exit %d, stdout %q; want the command run through the stub", res.ExitCode, stdout.String())
+	}
+
+	data, _ := os.ReadFile(log)
+	got := string(data)
+	if !strings.Contains(got, "(version 1)") || !strings.Contains(got, "/echo\nhello\n") {
+		t.Errorf("stub was not given the profile and command:\n%s", got)
+	}
+}
+
+func TestRunConfirmDeclined(t *testing.T) {
+	marker := filepath.Join(t.TempDir(), "ran")
+	cfg := SandboxConfig{Isolation: isolationNone}
+
+	var summary string
+	_, err := Run(context.Background(), cfg, []string{"touch", marker}, RunOptions{
+		NoSandbox: true,
+		Confirm: func(s string) bool {
+			summary = s
+			return confirmRun(strings.NewReader("\n"), io.Discard, s)
+		},
+	})
+	if !errors.Is(err, ErrNotConfirmed) {
+		t.Fatalf("err = %v, want ErrNotConfirmed", err)
+	}
+	if _, err := os.Stat(marker); err == nil {
+		t.Error("the command ran although the confirmation was declined")
+	}
+	if !strings.Contains(summary, "network: unrestricted") || !strings.Contains(summary, "command: touch "+marker) {
+		t.Errorf("unexpected summary %q", summary)
+	}
+}
+
+func TestConfirmRun(t *testing.T) {
+	tests := []struct {
+		input string
+		want  bool
+	}{
+		{"y\n", true},
+		{"YES\n", true},
+		{"\n", false},
+		{"n\n", false},
+		{"sure\n", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		var out bytes.Buffer
+		if got := confirmRun(strings.NewReader(tt.input), &out, "network: denied"); got != tt.want {
+			t.Errorf("confirmRun(%q) = %v, want %v", tt.input, got, tt.want)
+		}
+		if !strings.Contains(out.String(), "network: denied") || !strings.Contains(out.String(), "[y/N]") {
+			t.Errorf("prompt should show the summary and [y/N], got %q", out.String())
+		}
+	}
+}
+
+func TestRunSessionSummary(t *testing.T) {
+	s := &runSession{
+		cfg:       SandboxConfig{AllowWrite: []string{"."}},
+		netStatus: "denied",
+		envStatus: "scrubbed",
+		scrubbed:  3,
+	}
+	want := "network: denied, writes: ., /tmp only, env: 3 vars scrubbed, command: npm test"
+	if got := s.summary([]string{"npm", "test"}); got != want {
+		t.Errorf("summary = %q, want %q", got, want)
+	}
+
+	s.opts.DenyWrite = true
+	if got := s.summary([]string{"npm", "test"}); !strings.Contains(got, "writes: denied") {
+		t.Errorf("summary with --deny-write = %q", got)
+	}
+}
+
+func TestConfigureProxyMonitorNeedsOptIn(t *testing.T) {
+	cfg := SandboxConfig{NetMode: netModeMonitor}
+	for _, monitor := range []bool{false, true} {
+		proxy, err := newConfiguredProxy(cfg, RunOptions{InteractiveNet: true, Monitor: monitor}, "test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if proxy.monitor != monitor {
+			t.Errorf("with Monitor %v the proxy's monitor mode is %v", monitor, proxy.monitor)
+		}
+		proxy.Shutdown()
+
+		var out bytes.Buffer
+		noteIgnoredMonitor(&out, cfg, monitor)
+		if ignored := strings.Contains(out.String(), "ignored without --monitor"); ignored == monitor {
+			t.Errorf("with --monitor %v the notice was printed: %v", monitor, ignored)
+		}
+	}
+}
@@ -0,0 +1,220 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+const batchUsage = `Run a command many times under one sandbox
+
+Usage:
+  ddash batch [flags] -- <command> [args...]
+
+Reads one set of extra arguments per line from stdin and runs the command
+once per line, with that line's arguments appended. The sandbox profile is
+generated and checked once, and with --net a single proxy serves every
+run, so startup cost is paid once and --net decisions carry over between
+runs. Lines are split on whitespace; empty lines and lines starting with
+# are skipped. The command gets an empty stdin, since ddash's stdin
+carries the argument lines.
+
+The policy is checked like that of 'ddash run': reads of credential
+directories need --i-know, and --confine-to limits grants to a directory.
+
+Prints each run's exit code and duration, then a timing summary. Exits 1
+if any run failed.
+
+Examples:
+  ls testdata/*.json | ddash batch -- ./parse
+  seq 100 | ddash batch --config bench.ddash.json -- ./bench --seed
+
+Flags:
+  --config <file>  Load this config instead of .ddash.json (repeatable)
+  --net            Interactive network through one shared proxy
+  --deny-write     Deny all filesystem writes (overrides config)
+  --audit          Honor the config's "enforcement": "audit"
+  --pass-env       Pass all environment variables (disables scrubbing)
+  --i-know         Run even if the config grants reads of credential dirs
+  --confine-to <dir>
+                   Refuse to run if the config grants access outside <dir>
+  -q, --quiet      Print only the summary, not a line per run
+  -h, --help       Show help`
+
+// batchStats aggregates the timing of a batch.
+type batchStats struct {
+	runs   int
+	failed int
+	total  time.Duration
+	min    time.Duration
+	max    time.Duration
+}
+
+func (st *batchStats) add(d time.Duration, failed bool) {
+	if st.runs == 0 || d < st.min {
+		st.min = d
+	}
+	if d > st.max {
+		st.max = d
+	}
+	st.runs++
+	st.total += d
+	if failed {
+		st.failed++
+	}
+}
+
+func (st batchStats) String() string {
+	if st.runs == 0 {
+		return "0 runs"
+	}
+	mean := st.total / time.Duration(st.runs)
+	return fmt.Sprintf("%d runs (%d failed) in %s: min %s, mean %s, max %s",
+		st.runs, st.failed, roundDuration(st.total), roundDuration(st.min), roundDuration(mean), roundDuration(st.max))
+}
+
+// roundDuration keeps timings readable.
+func roundDuration(d time.Duration) time.Duration {
+	if d < time.Second {
+		return d.Round(time.Microsecond)
+	}
+	return d.Round(time.Millisecond)
+}
+
+func batchCmd() error {
+	var configs []string
+	var interactiveNet, denyWrite, audit, passEnv, iKnow, quiet bool
+	var confineTo string
+
+	flagArgs, command := splitCommand(os.Args[2:])
+	fs := newFlagSet("batch")
+	fs.Var((*stringList)(&configs), "config", "")
+	fs.BoolVar(&interactiveNet, "net", false, "")
+	fs.BoolVar(&denyWrite, "deny-write", false, "")
+	fs.BoolVar(&audit, "audit", false, "")
+	fs.BoolVar(&passEnv, "pass-env", false, "")
+	fs.BoolVar(&iKnow, "i-know", false, "")
+	fs.StringVar(&confineTo, "confine-to", "", "")
+	fs.BoolVar(&quiet, "q", false, "")
+	fs.BoolVar(&quiet, "quiet", false, "")
+	err := fs.Parse(flagArgs)
+	if errors.Is(err, flag.ErrHelp) {
+		fmt.Println(batchUsage)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return fmt.Errorf("unknown flag: %s\nUse -- before the command, e.g.: ddash batch -- %s", fs.Arg(0), fs.Arg(0))
+	}
+	if command == nil {
+		fmt.Println(batchUsage)
+		return fmt.Errorf("no command specified; use -- before the command")
+	}
+
+	cfg, err := loadRunConfigs(configs)
+	if err != nil {
+		return err
+	}
+	loaded := cfg
+	if key, ok := matchCommandProfile(cfg, command); ok {
+		fmt.Fprintf(os.Stderr, "ddash: applying the %q entry of commands\n", key)
+	}
+	if err := checkIsolation(cfg, command, false); err != nil {
+		return err
+	}
+	if cfg, err = withRemoteNet(selectCommandProfile(cfg, command)); err != nil {
+		return err
+	}
+	if denyWrite {
+		cfg.AllowWrite = []string{}
+	}
+	cwd, _ := os.Getwd()
+	home, _ := os.UserHomeDir()
+	if err := checkRunPolicy(os.Stderr, loaded, cfg, cwd, home, iKnow, confineTo); err != nil {
+		return err
+	}
+
+	binary, err := lookBinary(command[0])
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	s, err := newRunSession(ctx, cfg, redactSecrets(strings.Join(command, " ")), RunOptions{
+		DenyWrite:      denyWrite,
+		InteractiveNet: interactiveNet,
+		Audit:          audit,
+		PassEnv:        passEnv,
+		ForwardSignals: true,
+		Stdin:          strings.NewReader(""),
+	})
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	s.printBanner(command[0])
+
+	progress := io.Writer(os.Stderr)
+	if quiet {
+		progress = io.Discard
+	}
+	stats, err := runBatch(ctx, s, binary, command, os.Stdin, progress)
+	fmt.Fprintf(os.Stderr, "ddash: batch: %s\n", stats)
+
+	if s.proxy != nil {
+		path := configPath()
+		if len(configs) > 0 {
+			path = configs[len(configs)-1]
+		}
+		saveDomainDecisions(s.proxy.Domains(), cfg, path)
+		printBlockedDomains(os.Stderr, s.proxy.DeniedDomains())
+		printWouldDeny(os.Stderr, s.proxy.WouldDeny())
+	}
+
+	if err != nil {
+		return err
+	}
+	if stats.failed > 0 {
+		// os.Exit skips deferred calls
+		s.Close()
+		os.Exit(1)
+	}
+	return nil
+}
+
+// runBatch runs command once per line of lines, appending the line's
+// arguments, all under session s. It reports each run to progress and
+// stops early only if a run can't be started at all.
+func runBatch(ctx context.Context, s *runSession, binary string, command []string, lines io.Reader, progress io.Writer) (batchStats, error) {
+	var stats batchStats
+	scanner := bufio.NewScanner(lines)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		argv := append(append([]string(nil), command...), strings.Fields(line)...)
+
+		start := time.Now()
+		result, err := s.exec(ctx, binary, argv)
+		elapsed := time.Since(start)
+		if err != nil {
+			return stats, fmt.Errorf("run %d (%s): %w", stats.runs+1, redactSecrets(line), err)
+		}
+		stats.add(elapsed, result.ExitCode != 0)
+		fmt.Fprintf(progress, "ddash: batch: [%d] %s -> exit %d (%s)\n",
+			stats.runs, redactSecrets(line), result.ExitCode, roundDuration(elapsed))
+	}
+	if err := scanner.Err(); err != nil {
+		return stats, fmt.Errorf("failed to read arguments: %w", err)
+	}
+	return stats, nil
+}
@@ -0,0 +1,87 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunBatch(t *testing.T) {
+	var stdout, progress bytes.Buffer
+	cfg := SandboxConfig{Isolation: isolationNone, AllowWrite: []string{"."}}
+	s, err := newRunSession(context.Background(), cfg, "sh", RunOptions{
+		NoSandbox: true,
+		PassEnv:   true,
+		Stdin:     strings.NewReader(""),
+		Stdout:    &stdout,
+	})
+	if err != nil {
+		t.Fatalf("newRunSession failed: %v", err)
+	}
+	defer s.Close()
+
+	binary, err := lookBinary("sh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	command := []string{"sh", "-c", `echo "run $1 $2"; [ "$1" != fail ]`, "sh"}
+	lines := "alpha 1\n\n# comment\nbeta 2\nfail 3\n"
+
+	stats, err := runBatch(context.Background(), s, binary, command, strings.NewReader(lines), &progress)
+	if err != nil {
+		t.Fatalf("runBatch failed: %v", err)
+	}
+
+	if got, want := stdout.String(), "run alpha 1\nrun beta 2\nrun fail 3\n"; got != want {
+		t.Errorf("child output = %q, want %q", got, want)
+	}
+	if stats.runs != 3 || stats.failed != 1 {
+		t.Errorf("stats = %d runs, %d failed; want 3 and 1", stats.runs, stats.failed)
+	}
+	if !strings.Contains(progress.String(), "[3] fail 3 -> exit 1") {
+		t.Errorf("progress should report the failed run, got %q", progress.String())
+	}
+}
+
+func TestBatchCmdChecksPolicy(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	os.Mkdir(filepath.Join(home, ".ssh"), 0700)
+	dir := t.TempDir()
+	origDir, _ := os.Getwd()
+	os.Chdir(dir)
+	t.Cleanup(func() { os.Chdir(origDir) })
+	os.WriteFile(".ddash.json", []byte(`{"name":"t","allow_read":["~"]}`), 0644)
+	origArgs := os.Args
+	t.Cleanup(func() { os.Args = origArgs })
+	calls := stubExecCommand(t, "exit 0")
+
+	os.Args = []string{"ddash", "batch", "--", "true"}
+	if err := batchCmd(); err == nil || !strings.Contains(err.Error(), "credential directories") {
+		t.Errorf("batchCmd = %v, want the credential directory check", err)
+	}
+	os.Args = []string{"ddash", "batch", "--i-know", "--confine-to", t.TempDir(), "--", "true"}
+	if err := batchCmd(); err == nil || !strings.Contains(err.Error(), "outside") {
+		t.Errorf("batchCmd with --confine-to = %v, want a confinement error", err)
+	}
+	if len(*calls) != 0 {
+		t.Errorf("batch ran %d commands despite failing its checks", len(*calls))
+	}
+}
+
+func TestBatchStats(t *testing.T) {
+	var st batchStats
+	if st.String() != "0 runs" {
+		t.Errorf("empty stats = %q", st.String())
+	}
+	st.add(10*time.Millisecond, false)
+	st.add(30*time.Millisecond, true)
+	want := "2 runs (1 failed) in 40ms: min 10ms, mean 20ms, max 30ms"
+	if st.String() != want {
+		t.Errorf("stats = %q, want %q", st.String(), want)
+	}
+}
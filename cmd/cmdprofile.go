@@ -0,0 +1,49 @@
+package cmd
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// matchCommandProfile returns the key of the commands entry that applies
+// to argv: one whose words start argv, with the program compared by base
+// name, so "npm install" matches "/usr/local/bin/npm install --save" but
+// not "npm test". The entry with the most words wins.
+func matchCommandProfile(cfg SandboxConfig, argv []string) (string, bool) {
+	best, bestLen := "", 0
+	for prefix := range cfg.Commands {
+		words := strings.Fields(prefix)
+		if len(words) == 0 || len(words) > len(argv) || len(words) <= bestLen {
+			continue
+		}
+		if filepath.Base(argv[0]) != filepath.Base(words[0]) {
+			continue
+		}
+		match := true
+		for i := 1; i < len(words); i++ {
+			if argv[i] != words[i] {
+				match = false
+				break
+			}
+		}
+		if match {
+			best, bestLen = prefix, len(words)
+		}
+	}
+	return best, bestLen > 0
+}
+
+// selectCommandProfile returns the policy for running argv: cfg with its
+// matching commands entry, if any, merged over it the way a later
+// --config is. The result has no commands of its own.
+func selectCommandProfile(cfg SandboxConfig, argv []string) SandboxConfig {
+	profiles := cfg.Commands
+	cfg.Commands = nil
+	key, ok := matchCommandProfile(SandboxConfig{Commands: profiles}, argv)
+	if !ok {
+		return cfg
+	}
+	override := profiles[key]
+	override.Commands = nil
+	return mergeConfigs(cfg, override)
+}
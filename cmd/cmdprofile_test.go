@@ -0,0 +1,68 @@
+package cmd
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestSelectCommandProfile(t *testing.T) {
+	var cfg SandboxConfig
+	if err := json.Unmarshal([]byte(`{
+		"allow_net": [],
+		"allow_write": ["."],
+		"commands": {
+			"npm": {"allow_write": ["./node_modules"]},
+			"npm install": {"allow_net": ["registry.npmjs.org"]},
+			"npm install --global": {"isolation": "none"},
+			"make": {"deny_write_exts": [".sh"]}
+		}
+	}`), &cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		argv      []string
+		net       int
+		writes    int
+		isolation string
+	}{
+		{"no match", []string{"python3", "train.py"}, 0, 1, ""},
+		{"program only", []string{"npm", "test"}, 0, 2, ""},
+		{"longer prefix wins", []string{"npm", "install", "--save", "left-pad"}, 1, 1, ""},
+		{"longest prefix wins", []string{"npm", "install", "--global", "ddash"}, 0, 1, "none"},
+		{"path to program", []string{"/usr/local/bin/npm", "install"}, 1, 1, ""},
+		{"words, not substrings", []string{"npm", "installer"}, 0, 2, ""},
+		{"prefix longer than argv", []string{"npm"}, 0, 2, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := selectCommandProfile(cfg, tt.argv)
+			if len(got.AllowNet) != tt.net || len(got.AllowWrite) != tt.writes || got.Isolation != tt.isolation {
+				t.Errorf("got allow_net %v, allow_write %v, isolation %q", got.AllowNet, got.AllowWrite, got.Isolation)
+			}
+			if got.Commands != nil {
+				t.Error("the selected policy should not carry commands")
+			}
+		})
+	}
+
+	if len(cfg.Commands) != 4 {
+		t.Errorf("selectCommandProfile modified its input: %v", cfg.Commands)
+	}
+}
+
+func TestMergeConfigsCommands(t *testing.T) {
+	base := SandboxConfig{Commands: map[string]SandboxConfig{
+		"npm install": {AllowNet: netEntries("registry.npmjs.org")},
+		"make":        {AllowWrite: []string{"./build"}},
+	}}
+	over := SandboxConfig{Commands: map[string]SandboxConfig{
+		"npm install": {AllowNet: netEntries("npm.mirror.internal")},
+	}}
+
+	merged := mergeConfigs(base, over)
+	if len(merged.Commands) != 2 || merged.Commands["npm install"].AllowNet[0].Host != "npm.mirror.internal" {
+		t.Errorf("merged commands = %v, want the later entry to replace the earlier one", merged.Commands)
+	}
+}
@@ -0,0 +1,29 @@
+package cmd
+
+// Decision is a verdict on a network domain. The proxy's domain map and
+// .ddash.json keep these as plain strings; Decision gives them behavior.
+type Decision string
+
+const (
+	DecisionAllow   Decision = "allow"   // allow for the rest of this run, not saved
+	DecisionDeny    Decision = "deny"    // deny for the rest of this run, not saved
+	DecisionAlways  Decision = "always"  // allow, saved to the config
+	DecisionNever   Decision = "never"   // deny, saved to the config
+	DecisionSession Decision = "session" // allow until the shell session ends
+	DecisionLog     Decision = "log"     // allow, but report as one a strict policy would deny
+
+	// DecisionAllowRest is a prompt answer, never stored: allow this
+	// domain and every new domain after it for the rest of the run.
+	DecisionAllowRest Decision = "allow-rest"
+)
+
+// IsAllowed reports whether the connection should proceed. Unknown values
+// deny.
+func (d Decision) IsAllowed() bool {
+	return d == DecisionAllow || d == DecisionAlways || d == DecisionSession || d == DecisionLog
+}
+
+// IsPersistent reports whether the decision belongs in .ddash.json.
+func (d Decision) IsPersistent() bool {
+	return d == DecisionAlways || d == DecisionNever
+}
@@ -0,0 +1,28 @@
+package cmd
+
+import "testing"
+
+func TestDecision(t *testing.T) {
+	tests := []struct {
+		decision   Decision
+		allowed    bool
+		persistent bool
+	}{
+		{DecisionAllow, true, false},
+		{DecisionDeny, false, false},
+		{DecisionAlways, true, true},
+		{DecisionNever, false, true},
+		{DecisionSession, true, false},
+		{"", false, false},
+		{"bogus", false, false},
+	}
+
+	for _, tt := range tests {
+		if got := tt.decision.IsAllowed(); got != tt.allowed {
+			t.Errorf("Decision(%q).IsAllowed() = %v, want %v", tt.decision, got, tt.allowed)
+		}
+		if got := tt.decision.IsPersistent(); got != tt.persistent {
+			t.Errorf("Decision(%q).IsPersistent() = %v, want %v", tt.decision, got, tt.persistent)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// maxDenialsShown bounds the post-run denial report.
+const maxDenialsShown = 20
+
+// Denial is one distinct operation the sandbox blocked.
+type Denial struct {
+	Process   string `json:"process,omitempty"` // process name, if the log line carries one
+	Operation string `json:"operation"`         // e.g. "file-read-data", "network-outbound"
+	Target    string `json:"target,omitempty"`  // path or address the operation was aimed at
+	Count     int    `json:"count"`             // how many times it was blocked
+}
+
+// collectDenials parses a sandbox violation log and returns the distinct
+// denials, most frequent first. Lines look like:
+//
+//	Sandbox: python3(4242) deny(1) file-read-data /Users/me/.ssh/id_ed25519
+//	deny file-write-create /etc/hosts
+//
+// Unreadable logs and unrecognized lines are ignored.
+func collectDenials(logPath string) []Denial {
+	data, err := os.ReadFile(logPath)
+	if err != nil {
+		return nil
+	}
+
+	index := make(map[string]int)
+	var denials []Denial
+	for _, line := range strings.Split(string(data), "\n") {
+		d, ok := parseDenial(line)
+		if !ok {
+			continue
+		}
+		key := d.Process + "\x00" + d.Operation + "\x00" + d.Target
+		if i, seen := index[key]; seen {
+			denials[i].Count++
+			continue
+		}
+		d.Count = 1
+		index[key] = len(denials)
+		denials = append(denials, d)
+	}
+
+	sort.SliceStable(denials, func(i, j int) bool {
+		return denials[i].Count > denials[j].Count
+	})
+	return denials
+}
+
+// parseDenial extracts a Denial from a single violation log line.
+func parseDenial(line string) (Denial, bool) {
+	fields := strings.Fields(line)
+	for i, field := range fields {
+		if field != "deny" && !strings.HasPrefix(field, "deny(") {
+			continue
+		}
+		if i+1 >= len(fields) {
+			return Denial{}, false
+		}
+
+		d := Denial{
+			Operation: fields[i+1],
+			Target:    strings.Join(fields[i+2:], " "),
+		}
+		if i > 0 {
+			// "python3(4242)" -> "python3"
+			proc := fields[i-1]
+			if idx := strings.Index(proc, "("); idx > 0 {
+				proc = proc[:idx]
+			}
+			d.Process = strings.TrimSuffix(proc, ":")
+			if d.Process == "Sandbox" {
+				d.Process = ""
+			}
+		}
+		return d, true
+	}
+	return Denial{}, false
+}
+
+// printDenials writes a concise report of blocked operations.
+func printDenials(w io.Writer, denials []Denial) {
+	if len(denials) == 0 {
+		fmt.Fprintf(w, "ddash: no sandbox denials recorded\n")
+		return
+	}
+
+	fmt.Fprintf(w, "ddash: %d denied operation(s):\n", len(denials))
+	for i, d := range denials {
+		if i == maxDenialsShown {
+			fmt.Fprintf(w, "  ... and %d more\n", len(denials)-maxDenialsShown)
+			break
+		}
+		line := d.Operation
+		if d.Target != "" {
+			line += " " + d.Target
+		}
+		if d.Process != "" {
+			line = d.Process + ": " + line
+		}
+		if d.Count > 1 {
+			line += fmt.Sprintf(" (x%d)", d.Count)
+		}
+		fmt.Fprintf(w, "  %s\n", redactSecrets(line))
+	}
+}
@@ -0,0 +1,64 @@
+package cmd
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestCollectDenials(t *testing.T) {
+	logPath := t.TempDir() + "/sandbox.log"
+	sample := `Sandbox: python3(4242) deny(1) file-read-data /Users/mark/.ssh/id_ed25519
+Sandbox: python3(4242) deny(1) file-read-data /Users/mark/.ssh/id_ed25519
+Sandbox: curl(4250) deny(1) network-outbound 93.184.216.34:443
+deny file-write-create /etc/hosts
+some unrelated line
+Sandbox: python3(4242) allow file-read-data /usr/lib/libz.dylib
+`
+	os.WriteFile(logPath, []byte(sample), 0644)
+
+	denials := collectDenials(logPath)
+
+	if len(denials) != 3 {
+		t.Fatalf("expected 3 distinct denials, got %d: %+v", len(denials), denials)
+	}
+
+	first := denials[0]
+	if first.Process != "python3" || first.Operation != "file-read-data" ||
+		first.Target != "/Users/mark/.ssh/id_ed25519" || first.Count != 2 {
+		t.Errorf("unexpected most frequent denial: %+v", first)
+	}
+	if denials[1].Process != "curl" || denials[1].Operation != "network-outbound" || denials[1].Target != "93.184.216.34:443" {
+		t.Errorf("unexpected network denial: %+v", denials[1])
+	}
+	if denials[2].Process != "" || denials[2].Operation != "file-write-create" || denials[2].Target != "/etc/hosts" {
+		t.Errorf("unexpected bare denial: %+v", denials[2])
+	}
+}
+
+func TestCollectDenialsMissingLog(t *testing.T) {
+	if denials := collectDenials(t.TempDir() + "/missing.log"); len(denials) != 0 {
+		t.Errorf("expected no denials for a missing log, got %+v", denials)
+	}
+}
+
+func TestPrintDenials(t *testing.T) {
+	var buf strings.Builder
+	printDenials(&buf, []Denial{
+		{Process: "python3", Operation: "file-read-data", Target: "/Users/mark/.aws/credentials", Count: 3},
+	})
+	out := buf.String()
+
+	if !strings.Contains(out, "1 denied operation(s)") {
+		t.Errorf("expected denial count, got: %s", out)
+	}
+	if !strings.Contains(out, "python3: file-read-data /Users/mark/.aws/credentials (x3)") {
+		t.Errorf("expected formatted denial line, got: %s", out)
+	}
+
+	buf.Reset()
+	printDenials(&buf, nil)
+	if !strings.Contains(buf.String(), "no sandbox denials") {
+		t.Errorf("expected empty report, got: %s", buf.String())
+	}
+}
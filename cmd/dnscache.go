@@ -0,0 +1,64 @@
+package cmd
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+)
+
+// dnsCacheTTL is how long the proxy reuses a resolution. It is short, so
+// a host that really moves is picked up within a build, but long enough
+// that a round-robin host keeps the addresses it was checked against.
+const dnsCacheTTL = 30 * time.Second
+
+type dnsEntry struct {
+	addrs   []net.IPAddr
+	expires time.Time
+}
+
+// dnsCache remembers lookups for one run, so every connection to a host
+// is checked against and dialed to the same addresses. Failed lookups
+// aren't cached. It has its own lock, which is never held across a lookup
+// or together with p.mu: dialGuarded resolves without p.mu, so a slow
+// resolver holds up only the connections waiting on it, not the decisions
+// and prompts of other requests.
+type dnsCache struct {
+	mu      sync.Mutex
+	entries map[string]dnsEntry
+	lookup  func(ctx context.Context, host string) ([]net.IPAddr, error)
+	now     func() time.Time
+}
+
+func newDNSCache() *dnsCache {
+	return &dnsCache{
+		entries: make(map[string]dnsEntry),
+		lookup:  net.DefaultResolver.LookupIPAddr,
+		now:     time.Now,
+	}
+}
+
+// resolve returns the addresses of host, from the cache if still fresh.
+func (c *dnsCache) resolve(ctx context.Context, host string) ([]net.IPAddr, error) {
+	c.mu.Lock()
+	entry, ok := c.entries[host]
+	c.mu.Unlock()
+	if ok && c.now().Before(entry.expires) {
+		return entry.addrs, nil
+	}
+
+	addrs, err := c.lookup(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// A concurrent lookup may have finished first; keep its answer so
+	// both connections agree
+	if entry, ok := c.entries[host]; ok && c.now().Before(entry.expires) {
+		return entry.addrs, nil
+	}
+	c.entries[host] = dnsEntry{addrs: addrs, expires: c.now().Add(dnsCacheTTL)}
+	return addrs, nil
+}
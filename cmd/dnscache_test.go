@@ -0,0 +1,91 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestDNSCacheReusesResolution(t *testing.T) {
+	now := time.Now()
+	var lookups int
+	c := newDNSCache()
+	c.now = func() time.Time { return now }
+	c.lookup = func(ctx context.Context, host string) ([]net.IPAddr, error) {
+		lookups++
+		// A round-robin host answers differently every time
+		return []net.IPAddr{{IP: net.IPv4(203, 0, 113, byte(lookups))}}, nil
+	}
+
+	first, err := c.resolve(context.Background(), "cdn.example.com")
+	if err != nil {
+		t.Fatalf("resolve failed: %v", err)
+	}
+	second, _ := c.resolve(context.Background(), "cdn.example.com")
+	if lookups != 1 || !first[0].IP.Equal(second[0].IP) {
+		t.Errorf("got %v then %v after %d lookups, want one cached answer", first, second, lookups)
+	}
+
+	now = now.Add(dnsCacheTTL)
+	third, _ := c.resolve(context.Background(), "cdn.example.com")
+	if lookups != 2 || third[0].IP.Equal(first[0].IP) {
+		t.Errorf("expired entry not refreshed: got %v after %d lookups", third, lookups)
+	}
+}
+
+func TestSlowLookupLeavesProxyFree(t *testing.T) {
+	p, err := NewProxy(map[string]string{}, "test")
+	if err != nil {
+		t.Fatalf("NewProxy failed: %v", err)
+	}
+	defer p.Shutdown()
+	release := make(chan struct{})
+	defer close(release)
+	started := make(chan struct{}, 2)
+	p.dns.lookup = func(ctx context.Context, host string) ([]net.IPAddr, error) {
+		started <- struct{}{}
+		<-release
+		return nil, errors.New("no such host")
+	}
+
+	go p.dialGuarded(context.Background(), "tcp", "slow.example.com:443")
+	<-started
+
+	// Neither the proxy's lock nor the cache's is held during the lookup,
+	// so another request gets as far as its own lookup
+	go func() {
+		p.Domains()
+		p.dialGuarded(context.Background(), "tcp", "other.example.com:443")
+	}()
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("a slow lookup held up the rest of the proxy")
+	}
+}
+
+func TestDNSCacheSkipsFailures(t *testing.T) {
+	fail := true
+	var lookups int
+	c := newDNSCache()
+	c.lookup = func(ctx context.Context, host string) ([]net.IPAddr, error) {
+		lookups++
+		if fail {
+			return nil, errors.New("no such host")
+		}
+		return []net.IPAddr{{IP: net.IPv4(203, 0, 113, 7)}}, nil
+	}
+
+	if _, err := c.resolve(context.Background(), "flaky.example.com"); err == nil {
+		t.Fatal("expected the lookup error")
+	}
+	fail = false
+	if addrs, err := c.resolve(context.Background(), "flaky.example.com"); err != nil || len(addrs) != 1 {
+		t.Errorf("got %v, %v; want a fresh lookup after a failure", addrs, err)
+	}
+	if lookups != 2 {
+		t.Errorf("lookups = %d, want 2", lookups)
+	}
+}
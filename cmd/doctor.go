@@ -0,0 +1,149 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+)
+
+const doctorUsage = `Check that this machine can run ddash
+
+Usage:
+  ddash doctor
+
+Checks the OS, that sandbox-exec is available and works, whether
+DDASH_SANDBOX_EXEC replaces it, that /dev/tty can be opened for --net
+prompts, and that the current directory is writable. Prints a hint for each problem and exits non-zero if a required
+check fails.`
+
+// checkResult is the outcome of one doctor check. Hard checks are required
+// for ddash to work at all; the others only affect some features.
+type checkResult struct {
+	Name   string
+	OK     bool
+	Hard   bool
+	Detail string
+	Hint   string
+}
+
+func doctorCmd() error {
+	if len(os.Args) > 2 && (os.Args[2] == "-h" || os.Args[2] == "--help") {
+		fmt.Println(doctorUsage)
+		return nil
+	}
+
+	cwd, _ := os.Getwd()
+	results := []checkResult{
+		checkOS(runtime.GOOS),
+		checkSandboxExec(exec.LookPath, runSandboxExecProbe),
+		checkSandboxExecOverride(os.Getenv),
+		checkTTY(openTTY),
+		checkWritable(cwd),
+	}
+
+	failed := 0
+	for _, r := range results {
+		status := "ok"
+		switch {
+		case !r.OK && r.Hard:
+			status = "FAIL"
+			failed++
+		case !r.OK:
+			status = "warn"
+		}
+		fmt.Printf("  %-5s %s: %s\n", status, r.Name, r.Detail)
+		if !r.OK && r.Hint != "" {
+			fmt.Printf("        hint: %s\n", r.Hint)
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d required check(s) failed", failed)
+	}
+	return nil
+}
+
+func checkOS(goos string) checkResult {
+	r := checkResult{Name: "os", Hard: true, Detail: goos}
+	if goos == "darwin" {
+		r.OK = true
+		r.Detail = "macOS"
+		return r
+	}
+	r.Hint = "ddash uses the macOS sandbox (sandbox-exec); on Linux use a container or bubblewrap"
+	return r
+}
+
+// checkSandboxExec verifies sandbox-exec is on PATH and can apply a
+// trivial profile. probe runs it; it is injectable for tests.
+func checkSandboxExec(lookPath func(string) (string, error), probe func(path string) error) checkResult {
+	r := checkResult{Name: "sandbox-exec", Hard: true}
+	path, err := lookPath("sandbox-exec")
+	if err != nil {
+		r.Detail = "not found on PATH"
+		r.Hint = "sandbox-exec ships with macOS in /usr/bin; make sure /usr/bin is on PATH"
+		return r
+	}
+	if err := probe(path); err != nil {
+		r.Detail = fmt.Sprintf("%s failed to run (%v)", path, err)
+		r.Hint = "sandbox-exec is deprecated by Apple and may be restricted by MDM or SIP settings"
+		return r
+	}
+	r.OK = true
+	r.Detail = path
+	return r
+}
+
+func runSandboxExecProbe(path string) error {
+	return exec.Command(path, "-p", "(version 1)(allow default)", "/usr/bin/true").Run()
+}
+
+// checkSandboxExecOverride shows whether $DDASH_SANDBOX_EXEC replaces the
+// sandbox-exec on PATH. It isn't an error, but the binary it names enforces
+// every policy, so it's flagged for the user to confirm.
+func checkSandboxExecOverride(getenv func(string) string) checkResult {
+	r := checkResult{Name: sandboxExecEnv, OK: true, Detail: "not set"}
+	if override := getenv(sandboxExecEnv); override != "" {
+		r.OK = false
+		r.Detail = fmt.Sprintf("set: commands run under %s instead of the sandbox-exec on PATH", override)
+		r.Hint = "unset it unless you set it yourself; a project's .envrc can set it too"
+	}
+	return r
+}
+
+// checkTTY verifies /dev/tty can be opened, which --net prompts need.
+func checkTTY(open func() error) checkResult {
+	r := checkResult{Name: "/dev/tty", Detail: "available for --net prompts"}
+	if err := open(); err != nil {
+		r.Detail = fmt.Sprintf("can't open (%v)", err)
+		r.Hint = "--net can't prompt without a terminal; use --notify, or a config with saved network_domains"
+		return r
+	}
+	r.OK = true
+	return r
+}
+
+func openTTY() error {
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	return tty.Close()
+}
+
+// checkWritable verifies dir accepts writes, which the default policy
+// grants to sandboxed commands.
+func checkWritable(dir string) checkResult {
+	r := checkResult{Name: "current dir", Detail: dir + " is writable"}
+	f, err := os.CreateTemp(dir, ".ddash-doctor-*")
+	if err != nil {
+		r.Detail = fmt.Sprintf("%s is not writable (%v)", dir, err)
+		r.Hint = "sandboxed commands can only write to the current directory by default; run from a writable one"
+		return r
+	}
+	f.Close()
+	os.Remove(f.Name())
+	r.OK = true
+	return r
+}
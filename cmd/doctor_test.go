@@ -0,0 +1,74 @@
+package cmd
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestCheckOS(t *testing.T) {
+	if r := checkOS("darwin"); !r.OK {
+		t.Errorf("darwin should pass: %+v", r)
+	}
+	if r := checkOS("linux"); r.OK || !r.Hard || r.Hint == "" {
+		t.Errorf("linux should fail hard with a hint: %+v", r)
+	}
+}
+
+func TestCheckSandboxExec(t *testing.T) {
+	found := func(string) (string, error) { return "/usr/bin/sandbox-exec", nil }
+	missing := func(string) (string, error) { return "", errors.New("not found") }
+	works := func(string) error { return nil }
+	broken := func(string) error { return errors.New("exit status 71") }
+
+	if r := checkSandboxExec(found, works); !r.OK {
+		t.Errorf("working sandbox-exec should pass: %+v", r)
+	}
+	if r := checkSandboxExec(missing, works); r.OK || !r.Hard {
+		t.Errorf("missing sandbox-exec should fail hard: %+v", r)
+	}
+	if r := checkSandboxExec(found, broken); r.OK || r.Hint == "" {
+		t.Errorf("broken sandbox-exec should fail with a hint: %+v", r)
+	}
+}
+
+func TestCheckSandboxExecOverride(t *testing.T) {
+	if r := checkSandboxExecOverride(func(string) string { return "" }); !r.OK {
+		t.Errorf("no override should pass: %+v", r)
+	}
+	r := checkSandboxExecOverride(func(string) string { return "/tmp/fake-sandbox-exec" })
+	if r.OK || r.Hard || !strings.Contains(r.Detail, "/tmp/fake-sandbox-exec") {
+		t.Errorf("an override should be a soft failure naming it: %+v", r)
+	}
+}
+
+func TestCheckTTY(t *testing.T) {
+	if r := checkTTY(func() error { return nil }); !r.OK {
+		t.Errorf("openable tty should pass: %+v", r)
+	}
+	r := checkTTY(func() error { return errors.New("device not configured") })
+	if r.OK || r.Hard {
+		t.Errorf("missing tty should be a soft failure: %+v", r)
+	}
+}
+
+func TestCheckWritable(t *testing.T) {
+	dir := t.TempDir()
+	if r := checkWritable(dir); !r.OK {
+		t.Errorf("temp dir should be writable: %+v", r)
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 0 {
+		t.Errorf("check left files behind: %v", entries)
+	}
+
+	if os.Getuid() == 0 {
+		t.Skip("root can write to read-only dirs")
+	}
+	os.Chmod(dir, 0500)
+	defer os.Chmod(dir, 0700)
+	if r := checkWritable(dir); r.OK {
+		t.Errorf("read-only dir should fail: %+v", r)
+	}
+}
@@ -0,0 +1,42 @@
+package cmd
+
+import (
+	"flag"
+	"io"
+	"strings"
+)
+
+// stringList is a flag.Value collecting every occurrence of a repeatable
+// flag, e.g. --config a.json --config b.json.
+type stringList []string
+
+func (l *stringList) String() string { return strings.Join(*l, ",") }
+
+func (l *stringList) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
+
+// newFlagSet returns a FlagSet that reports errors instead of printing
+// them, so each subcommand can show its own usage text.
+func newFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	fs.Usage = func() {}
+	return fs
+}
+
+// splitCommand splits args at the first "--". Everything after it is the
+// child command, verbatim, even if it looks like a ddash flag. cmd is nil
+// when there is no "--" or nothing follows it.
+func splitCommand(args []string) (flagArgs, cmd []string) {
+	for i, a := range args {
+		if a == "--" {
+			if i+1 < len(args) {
+				cmd = args[i+1:]
+			}
+			return args[:i], cmd
+		}
+	}
+	return args, nil
+}
@@ -0,0 +1,157 @@
+package cmd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ddashIgnoreFile lists paths, gitignore-style, that may not be written
+// even where allow_write covers them (.git/, node_modules/, ...). It is
+// read from the config root.
+const ddashIgnoreFile = ".ddashignore"
+
+// ignorePattern is one pattern of a .ddashignore and the line it came from.
+type ignorePattern struct {
+	Line    int
+	Pattern string
+}
+
+// loadIgnorePatterns reads the .ddashignore in dir. Blank lines and #
+// comments are skipped. A missing file means no patterns.
+func loadIgnorePatterns(dir string) ([]ignorePattern, error) {
+	f, err := os.Open(filepath.Join(dir, ddashIgnoreFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var patterns []ignorePattern
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimRight(scanner.Text(), " \t\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		patterns = append(patterns, ignorePattern{Line: n, Pattern: line})
+	}
+	return patterns, scanner.Err()
+}
+
+// ignoreWarnings describes the patterns of dir's .ddashignore that can't
+// be enforced and are skipped, for the run banner and 'sandbox status'.
+func ignoreWarnings(dir string) []string {
+	patterns, err := loadIgnorePatterns(dir)
+	if err != nil {
+		return []string{fmt.Sprintf("can't read %s: %v", ddashIgnoreFile, err)}
+	}
+	var warnings []string
+	for _, p := range patterns {
+		if _, err := ignoreRegex(dir, p.Pattern); err != nil {
+			warnings = append(warnings, fmt.Sprintf("%s line %d: %v; skipped", ddashIgnoreFile, p.Line, err))
+		}
+	}
+	return warnings
+}
+
+// ignoreRules returns the sandbox regexes denying writes to what root's
+// .ddashignore lists, one per pattern and real path of root. Patterns
+// that can't be translated are left out (see ignoreWarnings).
+func ignoreRules(root string) []string {
+	patterns, _ := loadIgnorePatterns(root)
+	var rules []string
+	for _, p := range patterns {
+		for _, resolved := range withRealPaths([]string{root}) {
+			if rule, err := ignoreRegex(resolved, p.Pattern); err == nil {
+				rules = append(rules, rule)
+			}
+		}
+	}
+	return rules
+}
+
+// ignoreRegex translates a gitignore-style pattern into a sandbox regex
+// over absolute paths under root. As in .gitignore, a pattern without a
+// slash matches at any depth, one with a leading or inner slash is
+// anchored at root, * and ? stay within a path component and ** crosses
+// them. A match covers everything beneath it too. A trailing slash is
+// accepted, but the sandbox can't tell directories from files, so "logs/"
+// also blocks creating a file named logs. Negation (!) can't be expressed
+// as a deny and is an error.
+func ignoreRegex(root, pattern string) (string, error) {
+	if strings.HasPrefix(pattern, "!") {
+		return "", fmt.Errorf("negated pattern %q is not supported", pattern)
+	}
+	if strings.Contains(pattern, `"`) {
+		return "", fmt.Errorf("pattern %q contains a double quote", pattern)
+	}
+	p := strings.TrimSuffix(pattern, "/")
+	anchored := strings.Contains(p, "/")
+	p = strings.TrimPrefix(p, "/")
+	if p == "" {
+		return "", fmt.Errorf("pattern %q matches the whole project", pattern)
+	}
+
+	var sb strings.Builder
+	sb.WriteString("^" + regexQuote(root) + "/")
+	if !anchored {
+		sb.WriteString("(.*/)?")
+	}
+	sb.WriteString(globRegex(p))
+	sb.WriteString("(/.*)?$")
+	return sb.String(), nil
+}
+
+// globRegex translates the glob syntax of a .gitignore pattern (*, ?, **,
+// [...] and \-escapes) into a regex.
+func globRegex(glob string) string {
+	var sb strings.Builder
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+		switch {
+		case strings.HasPrefix(glob[i:], "**/") && (i == 0 || glob[i-1] == '/'):
+			sb.WriteString("(.*/)?")
+			i += 2
+		case strings.HasPrefix(glob[i:], "**"):
+			sb.WriteString(".*")
+			i++
+		case c == '*':
+			sb.WriteString("[^/]*")
+		case c == '?':
+			sb.WriteString("[^/]")
+		case c == '[' && strings.IndexByte(glob[i+1:], ']') > 0:
+			end := i + 1 + strings.IndexByte(glob[i+1:], ']')
+			class := glob[i+1 : end]
+			if class[0] == '!' {
+				class = "^" + class[1:]
+			}
+			sb.WriteString("[" + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(class) + "]")
+			i = end
+		case c == '\\' && i+1 < len(glob):
+			i++
+			sb.WriteString(regexQuote(glob[i : i+1]))
+		default:
+			sb.WriteString(regexQuote(string(c)))
+		}
+	}
+	return sb.String()
+}
+
+// regexQuote escapes the regex metacharacters in s, and double quotes,
+// which would otherwise end the #"..." literal the regex goes into.
+func regexQuote(s string) string {
+	var sb strings.Builder
+	for _, r := range s {
+		if strings.ContainsRune(`\.+*?()|[]{}^$"`, r) {
+			sb.WriteRune('\\')
+		}
+		sb.WriteRune(r)
+	}
+	return sb.String()
+}
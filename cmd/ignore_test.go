@@ -0,0 +1,112 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestIgnoreRegex(t *testing.T) {
+	tests := []struct {
+		pattern string
+		matches []string
+		misses  []string
+	}{
+		{".git/", []string{"/p/.git", "/p/.git/config", "/p/sub/.git/HEAD"}, []string{"/p/.github/x", "/p/main.go", "/other/.git"}},
+		{"node_modules", []string{"/p/node_modules/x/index.js", "/p/web/node_modules"}, []string{"/p/node_modules2"}},
+		{"*.log", []string{"/p/a.log", "/p/logs/b.log"}, []string{"/p/a.log.txt", "/p/log"}},
+		{"/build", []string{"/p/build", "/p/build/out.o"}, []string{"/p/src/build"}},
+		{"docs/*.md", []string{"/p/docs/a.md"}, []string{"/p/docs/sub/a.md", "/p/x/docs/a.md"}},
+		{"**/gen/**", []string{"/p/gen/a", "/p/x/y/gen/b"}, []string{"/p/generated/a"}},
+		{"file[0-9].txt", []string{"/p/file1.txt"}, []string{"/p/fileA.txt"}},
+		{"a+b(1).txt", []string{"/p/a+b(1).txt"}, []string{"/p/aab1.txt"}},
+	}
+	for _, tt := range tests {
+		rule, err := ignoreRegex("/p", tt.pattern)
+		if err != nil {
+			t.Errorf("ignoreRegex(%q): %v", tt.pattern, err)
+			continue
+		}
+		re := regexp.MustCompile(rule)
+		for _, path := range tt.matches {
+			if !re.MatchString(path) {
+				t.Errorf("%q (%s) should match %s", tt.pattern, rule, path)
+			}
+		}
+		for _, path := range tt.misses {
+			if re.MatchString(path) {
+				t.Errorf("%q (%s) should not match %s", tt.pattern, rule, path)
+			}
+		}
+	}
+
+	for _, pattern := range []string{"!keep.log", "/", `a"b`} {
+		if _, err := ignoreRegex("/p", pattern); err == nil {
+			t.Errorf("ignoreRegex(%q) should fail", pattern)
+		}
+	}
+
+	// A quote in the project path must not end the #"..." literal
+	rule, err := ignoreRegex(`/p")) (allow default`, "x")
+	if err != nil {
+		t.Fatalf("ignoreRegex: %v", err)
+	}
+	if strings.Contains(strings.ReplaceAll(rule, `\"`, ""), `"`) {
+		t.Errorf("rule %s has an unescaped quote", rule)
+	}
+	if !regexp.MustCompile(rule).MatchString(`/p")) (allow default/x`) {
+		t.Errorf("rule %s should match the file in the quoted project path", rule)
+	}
+}
+
+func TestGenerateProfileDdashIgnore(t *testing.T) {
+	origDir, _ := os.Getwd()
+	dir, _ := filepath.EvalSymlinks(t.TempDir())
+	os.Chdir(dir)
+	defer os.Chdir(origDir)
+	os.WriteFile(ddashIgnoreFile, []byte("# keep history safe\n.git/\nnode_modules\n\n!node_modules/keep\n"), 0644)
+
+	profile := GenerateProfile(SandboxConfig{AllowWrite: []string{"."}}, false, false)
+	var denies []*regexp.Regexp
+	for _, line := range strings.Split(profile, "\n") {
+		if strings.HasPrefix(line, "(deny file-write* (regex") {
+			denies = append(denies, regexp.MustCompile(ruleRegex(line)))
+		}
+	}
+	if len(denies) != 2 {
+		t.Fatalf("got %d deny rules, want one per supported pattern:\n%s", len(denies), profile)
+	}
+	denied := func(path string) bool {
+		for _, re := range denies {
+			if re.MatchString(path) {
+				return true
+			}
+		}
+		return false
+	}
+	for _, path := range []string{".git/config", "node_modules/left-pad/index.js"} {
+		if !denied(filepath.Join(dir, path)) {
+			t.Errorf("%s should be denied", path)
+		}
+	}
+	for _, path := range []string{"main.go", "src/app.js"} {
+		if denied(filepath.Join(dir, path)) {
+			t.Errorf("%s should stay writable", path)
+		}
+	}
+	// Denies must come after the allow they carve out of
+	if strings.Index(profile, "(deny file-write*") < strings.LastIndex(profile, "(allow file-write*") {
+		t.Error("ignore denies should follow the write allows")
+	}
+
+	warnings := ignoreWarnings(dir)
+	if len(warnings) != 1 || !strings.Contains(warnings[0], "line 5") {
+		t.Errorf("ignoreWarnings = %q, want the negated pattern on line 5", warnings)
+	}
+
+	if p := GenerateProfile(SandboxConfig{AllowWrite: []string{"."}}, true, false); strings.Contains(p, "regex") {
+		t.Error("--deny-write already blocks everything; no ignore rules expected")
+	}
+}
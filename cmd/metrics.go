@@ -0,0 +1,166 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// decisionBuckets are the upper bounds, in seconds, of the decision
+// latency histogram. Config and session decisions land in the first
+// bucket; prompts take seconds, and a dialog times out after a minute.
+var decisionBuckets = []float64{0.001, 0.01, 0.1, 1, 10, 60}
+
+// ProxyStats is a snapshot of what a NetworkProxy has done so far.
+type ProxyStats struct {
+	Connections   int64 // requests received, CONNECT and plain HTTP
+	Allowed       int64 // decisions that let a connection through
+	Denied        int64 // decisions that refused one, by policy or a guard
+	Prompts       int64 // prompts shown; a grouped prompt counts once
+	BytesSent     int64 // client to upstream, through tunnels and HTTP bodies
+	BytesReceived int64 // upstream to client
+}
+
+// latencyHistogram counts observations per decisionBuckets bucket.
+type latencyHistogram struct {
+	counts []int64 // per bucket, not cumulative; the last is +Inf
+	sum    float64
+	total  int64
+}
+
+// proxyMetrics counts proxy activity for Stats and the metrics endpoint.
+type proxyMetrics struct {
+	connections   atomic.Int64
+	allowed       atomic.Int64
+	denied        atomic.Int64
+	prompts       atomic.Int64
+	bytesSent     atomic.Int64
+	bytesReceived atomic.Int64
+
+	mu      sync.Mutex
+	latency map[string]*latencyHistogram // "allowed" / "denied"
+}
+
+// observe records how long a domain decision took.
+func (m *proxyMetrics) observe(allowed bool, d time.Duration) {
+	outcome := "denied"
+	if allowed {
+		outcome = "allowed"
+	}
+	secs := d.Seconds()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.latency == nil {
+		m.latency = make(map[string]*latencyHistogram)
+	}
+	h := m.latency[outcome]
+	if h == nil {
+		h = &latencyHistogram{counts: make([]int64, len(decisionBuckets)+1)}
+		m.latency[outcome] = h
+	}
+	i := 0
+	for i < len(decisionBuckets) && secs > decisionBuckets[i] {
+		i++
+	}
+	h.counts[i]++
+	h.sum += secs
+	h.total++
+}
+
+// Stats returns the proxy's counters so far.
+func (p *NetworkProxy) Stats() ProxyStats {
+	m := &p.metrics
+	return ProxyStats{
+		Connections:   m.connections.Load(),
+		Allowed:       m.allowed.Load(),
+		Denied:        m.denied.Load(),
+		Prompts:       m.prompts.Load(),
+		BytesSent:     m.bytesSent.Load(),
+		BytesReceived: m.bytesReceived.Load(),
+	}
+}
+
+// writeMetrics writes the proxy's counters in the Prometheus text
+// exposition format.
+func (p *NetworkProxy) writeMetrics(w io.Writer) {
+	st := p.Stats()
+	counter := func(name, help string) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", name, help, name)
+	}
+
+	counter("ddash_proxy_connections_total", "Requests received by the proxy, CONNECT and plain HTTP.")
+	fmt.Fprintf(w, "ddash_proxy_connections_total %d\n", st.Connections)
+	counter("ddash_proxy_decisions_total", "Connection decisions by verdict.")
+	fmt.Fprintf(w, "ddash_proxy_decisions_total{verdict=\"allowed\"} %d\n", st.Allowed)
+	fmt.Fprintf(w, "ddash_proxy_decisions_total{verdict=\"denied\"} %d\n", st.Denied)
+	counter("ddash_proxy_prompts_total", "Prompts shown to the user.")
+	fmt.Fprintf(w, "ddash_proxy_prompts_total %d\n", st.Prompts)
+	counter("ddash_proxy_bytes_total", "Bytes relayed, by direction.")
+	fmt.Fprintf(w, "ddash_proxy_bytes_total{direction=\"sent\"} %d\n", st.BytesSent)
+	fmt.Fprintf(w, "ddash_proxy_bytes_total{direction=\"received\"} %d\n", st.BytesReceived)
+
+	name := "ddash_proxy_decision_seconds"
+	fmt.Fprintf(w, "# HELP %s Time taken to decide on a domain, including prompts.\n# TYPE %s histogram\n", name, name)
+	p.metrics.mu.Lock()
+	defer p.metrics.mu.Unlock()
+	for _, outcome := range []string{"allowed", "denied"} {
+		h := p.metrics.latency[outcome]
+		if h == nil {
+			continue
+		}
+		var cumulative int64
+		for i, bound := range decisionBuckets {
+			cumulative += h.counts[i]
+			fmt.Fprintf(w, "%s_bucket{outcome=%q,le=%q} %d\n",
+				name, outcome, strconv.FormatFloat(bound, 'g', -1, 64), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket{outcome=%q,le=\"+Inf\"} %d\n", name, outcome, h.total)
+		fmt.Fprintf(w, "%s_sum{outcome=%q} %g\n", name, outcome, h.sum)
+		fmt.Fprintf(w, "%s_count{outcome=%q} %d\n", name, outcome, h.total)
+	}
+}
+
+// ServeMetrics exposes the proxy's counters for Prometheus at
+// http://addr/metrics until Shutdown. addr must be a loopback address:
+// the counters name no hosts, but nothing outside the machine needs them.
+func (p *NetworkProxy) ServeMetrics(addr string) error {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("invalid metrics address %q: %w", addr, err)
+	}
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return fmt.Errorf("metrics address %q must be on localhost (e.g. 127.0.0.1:9464)", addr)
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to start metrics listener: %w", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		p.writeMetrics(w)
+	})
+	srv := &http.Server{Handler: mux}
+	p.metricsServer.Store(srv)
+	go srv.Serve(ln)
+	return nil
+}
+
+// countingReader adds the bytes read through it to n.
+type countingReader struct {
+	r io.Reader
+	n *atomic.Int64
+}
+
+func (c countingReader) Read(b []byte) (int, error) {
+	n, err := c.r.Read(b)
+	c.n.Add(int64(n))
+	return n, err
+}
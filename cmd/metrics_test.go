@@ -0,0 +1,125 @@
+package cmd
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProxyStatsAndMetrics(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		w.Write([]byte("hello"))
+	}))
+	defer backend.Close()
+
+	backendURL, _ := url.Parse(backend.URL)
+	p, err := NewProxy(map[string]string{stripPort(backendURL.Host): "allow"}, "test")
+	if err != nil {
+		t.Fatalf("NewProxy failed: %v", err)
+	}
+	defer p.Shutdown()
+	p.SetPrompter(DenyPrompter{})
+	p.Start()
+
+	proxyURL, _ := url.Parse("http://" + p.Addr())
+	client := &http.Client{
+		Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)},
+		Timeout:   5 * time.Second,
+	}
+	resp, err := client.Post(backend.URL, "text/plain", strings.NewReader("ping"))
+	if err != nil {
+		t.Fatalf("request through proxy failed: %v", err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	resp, err = client.Get("http://telemetry.example.com/collect")
+	if err != nil {
+		t.Fatalf("request through proxy failed: %v", err)
+	}
+	resp.Body.Close()
+
+	want := ProxyStats{Connections: 2, Allowed: 1, Denied: 1, Prompts: 1, BytesSent: 4, BytesReceived: 5}
+	if got := p.Stats(); got != want {
+		t.Errorf("Stats = %+v, want %+v", got, want)
+	}
+
+	var out strings.Builder
+	p.writeMetrics(&out)
+	metrics := out.String()
+	for _, line := range []string{
+		"# TYPE ddash_proxy_connections_total counter",
+		"ddash_proxy_connections_total 2",
+		`ddash_proxy_decisions_total{verdict="allowed"} 1`,
+		`ddash_proxy_decisions_total{verdict="denied"} 1`,
+		"ddash_proxy_prompts_total 1",
+		`ddash_proxy_bytes_total{direction="sent"} 4`,
+		`ddash_proxy_bytes_total{direction="received"} 5`,
+		"# TYPE ddash_proxy_decision_seconds histogram",
+		`ddash_proxy_decision_seconds_bucket{outcome="allowed",le="+Inf"} 1`,
+		`ddash_proxy_decision_seconds_count{outcome="denied"} 1`,
+	} {
+		if !strings.Contains(metrics, line+"\n") {
+			t.Errorf("metrics missing %q:\n%s", line, metrics)
+		}
+	}
+}
+
+func TestDecisionHistogramBuckets(t *testing.T) {
+	var m proxyMetrics
+	m.observe(true, 500*time.Microsecond)
+	m.observe(true, 2*time.Second)
+	m.observe(true, 2*time.Minute)
+
+	h := m.latency["allowed"]
+	// 0.001, 0.01, 0.1, 1, 10, 60, +Inf
+	want := []int64{1, 0, 0, 0, 1, 0, 1}
+	for i := range want {
+		if h.counts[i] != want[i] {
+			t.Fatalf("bucket counts = %v, want %v", h.counts, want)
+		}
+	}
+	if h.total != 3 {
+		t.Errorf("total = %d, want 3", h.total)
+	}
+}
+
+func TestServeMetrics(t *testing.T) {
+	p, err := NewProxy(nil, "test")
+	if err != nil {
+		t.Fatalf("NewProxy failed: %v", err)
+	}
+	defer p.Shutdown()
+
+	for _, addr := range []string{"0.0.0.0:9464", "192.0.2.1:9464", "no-port"} {
+		if err := p.ServeMetrics(addr); err == nil {
+			t.Errorf("ServeMetrics(%q) should fail", addr)
+		}
+	}
+
+	// Find a free port for the endpoint
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	if err := p.ServeMetrics(addr); err != nil {
+		t.Fatalf("ServeMetrics failed: %v", err)
+	}
+	resp, err := http.Get("http://" + addr + "/metrics")
+	if err != nil {
+		t.Fatalf("GET /metrics failed: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !strings.Contains(string(body), "ddash_proxy_connections_total 0") {
+		t.Errorf("unexpected metrics body:\n%s", body)
+	}
+}
@@ -0,0 +1,135 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// NetEntry is one allow_net entry. In JSON it is either a plain host
+// string or an object that annotates the host for reviewers:
+// {"host": "api.example.com", "reason": "telemetry", "owner": "web-team",
+// "until": "2025-12-31"}. An entry with an until date stops allowing the
+// host after that day.
+type NetEntry struct {
+	Host   string
+	Reason string
+	Owner  string
+	Until  string // YYYY-MM-DD, last day the entry applies
+}
+
+// netUntilLayout is the date format of the until field.
+const netUntilLayout = "2006-01-02"
+
+// netEntries returns unannotated entries for hosts.
+func netEntries(hosts ...string) []NetEntry {
+	entries := make([]NetEntry, len(hosts))
+	for i, h := range hosts {
+		entries[i] = NetEntry{Host: h}
+	}
+	return entries
+}
+
+// entryHosts returns just the hosts of entries.
+func entryHosts(entries []NetEntry) []string {
+	var hosts []string
+	for _, e := range entries {
+		hosts = append(hosts, e.Host)
+	}
+	return hosts
+}
+
+// expired reports whether now is past the entry's until date, which is
+// in local time and inclusive.
+func (e NetEntry) expired(now time.Time) bool {
+	if e.Until == "" {
+		return false
+	}
+	until, err := time.ParseInLocation(netUntilLayout, e.Until, now.Location())
+	return err == nil && !now.Before(until.AddDate(0, 0, 1))
+}
+
+// dropExpiredNet removes the allow_net entries of cfg that expired by now
+// and returns them.
+func dropExpiredNet(cfg SandboxConfig, now time.Time) (SandboxConfig, []NetEntry) {
+	var kept, expired []NetEntry
+	for _, e := range cfg.AllowNet {
+		if e.expired(now) {
+			expired = append(expired, e)
+		} else {
+			kept = append(kept, e)
+		}
+	}
+	if expired == nil {
+		return cfg, nil
+	}
+	if kept == nil {
+		kept = []NetEntry{}
+	}
+	cfg.AllowNet = kept
+	return cfg, expired
+}
+
+// expiredNetWarning describes an allow_net entry that no longer applies.
+func expiredNetWarning(e NetEntry) string {
+	warning := fmt.Sprintf("allow_net entry %s expired on %s", e.Host, e.Until)
+	if e.Reason != "" {
+		warning += fmt.Sprintf(" (%s)", e.Reason)
+	}
+	return warning + "; the host is no longer pre-allowed"
+}
+
+func (e *NetEntry) UnmarshalJSON(data []byte) error {
+	var host string
+	if err := json.Unmarshal(data, &host); err == nil {
+		*e = NetEntry{Host: host}
+		return nil
+	}
+
+	var obj struct {
+		Host   string `json:"host"`
+		Reason string `json:"reason"`
+		Owner  string `json:"owner"`
+		Until  string `json:"until"`
+	}
+	// A misspelt key ("expires" for "until") must not leave the host
+	// allowed forever
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&obj); err != nil {
+		if key, ok := strings.CutPrefix(err.Error(), "json: unknown field "); ok {
+			return fmt.Errorf("allow_net entry has unknown key %s; known keys are host, reason, owner and until", key)
+		}
+		return fmt.Errorf("allow_net entry must be a string or {\"host\": ..., \"reason\": ..., \"until\": ...}: %s", strings.TrimSpace(string(data)))
+	}
+	if obj.Host == "" {
+		return fmt.Errorf("allow_net entry is missing \"host\"")
+	}
+	if obj.Until != "" {
+		if _, err := time.Parse(netUntilLayout, obj.Until); err != nil {
+			return fmt.Errorf("allow_net entry %s: until %q is not a YYYY-MM-DD date", obj.Host, obj.Until)
+		}
+	}
+	*e = NetEntry(obj)
+	return nil
+}
+
+// MarshalJSON writes unannotated entries as plain strings, so configs
+// without annotations (and their checksums) are unchanged.
+func (e NetEntry) MarshalJSON() ([]byte, error) {
+	if e.Reason == "" && e.Owner == "" && e.Until == "" {
+		return json.Marshal(e.Host)
+	}
+	return json.Marshal(struct {
+		Host   string `json:"host"`
+		Reason string `json:"reason,omitempty"`
+		Owner  string `json:"owner,omitempty"`
+		Until  string `json:"until,omitempty"`
+	}{e.Host, e.Reason, e.Owner, e.Until})
+}
+
+func (e NetEntry) String() string {
+	return e.Host
+}
@@ -0,0 +1,86 @@
+package cmd
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNetEntryUnmarshalForms(t *testing.T) {
+	var cfg SandboxConfig
+	data := `{"allow_net": ["registry.npmjs.org", {"host": "api.example.com", "reason": "telemetry", "owner": "web", "until": "2025-12-31"}]}`
+	if err := json.Unmarshal([]byte(data), &cfg); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+
+	want := []NetEntry{{Host: "registry.npmjs.org"}, {Host: "api.example.com", Reason: "telemetry", Owner: "web", Until: "2025-12-31"}}
+	if len(cfg.AllowNet) != len(want) {
+		t.Fatalf("AllowNet = %v, want %v", cfg.AllowNet, want)
+	}
+	for i := range want {
+		if cfg.AllowNet[i] != want[i] {
+			t.Errorf("AllowNet[%d] = %+v, want %+v", i, cfg.AllowNet[i], want[i])
+		}
+	}
+}
+
+func TestNetEntryUnmarshalErrors(t *testing.T) {
+	for _, data := range []string{`[42]`, `[{"reason": "no host"}]`, `[{"host": "a.example.com", "until": "next week"}]`} {
+		var entries []NetEntry
+		if err := json.Unmarshal([]byte(data), &entries); err == nil {
+			t.Errorf("Unmarshal(%s) should fail", data)
+		}
+	}
+}
+
+func TestNetEntryUnmarshalUnknownKey(t *testing.T) {
+	var entries []NetEntry
+	err := json.Unmarshal([]byte(`[{"host": "a.example.com", "expires": "2025-12-31"}]`), &entries)
+	if err == nil || !strings.Contains(err.Error(), `unknown key "expires"`) {
+		t.Errorf("err = %v, want the unknown key named", err)
+	}
+}
+
+func TestNetEntryMarshalKeepsStringForm(t *testing.T) {
+	data, err := json.Marshal([]NetEntry{{Host: "github.com"}, {Host: "api.example.com", Reason: "telemetry"}})
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	want := `["github.com",{"host":"api.example.com","reason":"telemetry"}]`
+	if string(data) != want {
+		t.Errorf("Marshal = %s, want %s", data, want)
+	}
+}
+
+func TestExpiredNetEntry(t *testing.T) {
+	now := time.Date(2025, 12, 31, 23, 0, 0, 0, time.Local)
+	cfg := SandboxConfig{AllowNet: []NetEntry{
+		{Host: "github.com"},
+		{Host: "today.example.com", Until: "2025-12-31"},
+		{Host: "old.example.com", Reason: "migration", Until: "2025-06-30"},
+	}}
+
+	kept, expired := dropExpiredNet(cfg, now)
+	if got := strings.Join(entryHosts(kept.AllowNet), ","); got != "github.com,today.example.com" {
+		t.Errorf("kept %s, want entries valid through their until day", got)
+	}
+	if len(expired) != 1 || expired[0].Host != "old.example.com" {
+		t.Fatalf("expired = %v, want old.example.com", expired)
+	}
+	if warning := expiredNetWarning(expired[0]); !strings.Contains(warning, "2025-06-30") || !strings.Contains(warning, "migration") {
+		t.Errorf("warning %q should name the date and reason", warning)
+	}
+
+	// The expired host is prompted for again rather than pre-allowed
+	domains, _ := proxyDomains(kept)
+	if _, ok := domains["old.example.com"]; ok {
+		t.Error("an expired entry should not reach the proxy")
+	}
+	if domains["today.example.com"] != string(DecisionAllow) {
+		t.Error("an entry on its until day should still be allowed")
+	}
+	if len(cfg.AllowNet) != 3 {
+		t.Error("dropExpiredNet should not modify its argument")
+	}
+}
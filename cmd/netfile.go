@@ -0,0 +1,141 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+)
+
+// readNetFile reads a host list kept as a flat file: one host, "*.domain"
+// wildcard or CIDR per line. Blank lines and everything after '#' are
+// ignored, so the file can carry its own review notes.
+func readNetFile(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	hosts := []string{}
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		entry, _, _ := strings.Cut(scanner.Text(), "#")
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if err := validateNetPattern(entry); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		hosts = append(hosts, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return hosts, nil
+}
+
+// validateNetPattern checks one line of a host file: a host (optionally
+// "https://"), a "*.domain" wildcard or a CIDR. A bare "*" is refused;
+// allowing everything belongs in the config, not an inventory.
+func validateNetPattern(entry string) error {
+	if err := validateNetPort(entry); err != nil {
+		return err
+	}
+	host, _, _ := parseNetEntry(entry)
+	switch {
+	case host == "*":
+		return fmt.Errorf("%q would allow every host; use allow_net [\"*\"] or --allow-net instead", entry)
+	case strings.HasPrefix(host, remoteNetPrefix):
+		return fmt.Errorf("%q: host files can't include remote lists", entry)
+	case strings.Contains(host, "/"):
+		if _, _, err := net.ParseCIDR(host); err != nil {
+			return fmt.Errorf("%q is not a valid CIDR", entry)
+		}
+	case strings.HasPrefix(host, "*."):
+		if strings.Contains(host[2:], "*") || host[2:] == "" {
+			return fmt.Errorf("%q: only a single leading \"*.\" wildcard is supported", entry)
+		}
+	case strings.ContainsAny(host, "* \t"):
+		return fmt.Errorf("%q is not a host, \"*.domain\" wildcard or CIDR", entry)
+	}
+	return nil
+}
+
+// withNetFiles appends the hosts listed in cfg.AllowNetFile and in paths
+// to cfg.AllowNet. The file field is cleared once read, so splicing an
+// already resolved config again changes nothing.
+func withNetFiles(cfg SandboxConfig, paths ...string) (SandboxConfig, error) {
+	if cfg.AllowNetFile != "" {
+		paths = append([]string{cfg.AllowNetFile}, paths...)
+	}
+	for _, path := range paths {
+		hosts, err := readNetFile(path)
+		if err != nil {
+			return SandboxConfig{}, fmt.Errorf("failed to read host file: %w", err)
+		}
+		cfg.AllowNet = appendUnique(cfg.AllowNet, netEntries(hosts...))
+	}
+	cfg.AllowNetFile = ""
+	return cfg, nil
+}
+
+// lookupDecision returns the decision domains holds for domain on port,
+// and the key it came from, most specific entry first: "host:port", then
+// "host:*", then the bare host, which also covers every port. Failing
+// those, the most specific "*.suffix" wildcard or CIDR covering domain
+// decides, again preferring one for this port. The --net proxy and
+// 'ddash probe' both decide through it.
+func lookupDecision(domains map[string]string, domain, port string) (decision, key string, ok bool) {
+	for _, key := range []string{net.JoinHostPort(domain, port), net.JoinHostPort(domain, "*"), domain} {
+		if decision, ok := domains[key]; ok {
+			return decision, key, true
+		}
+	}
+	bestRank := -1
+	for k, d := range domains {
+		pattern, keyPort := splitHostPort(k, "")
+		n := patternMatch(pattern, domain)
+		if n < 0 || keyPort != "" && keyPort != "*" && keyPort != port {
+			continue
+		}
+		rank := 3 * n
+		switch keyPort {
+		case port:
+			rank += 2
+		case "*":
+			rank++
+		}
+		// Equal ranks (one CIDR written two ways) pick the smaller key, so
+		// the result doesn't depend on map order
+		if rank > bestRank || rank == bestRank && k < key {
+			decision, key, bestRank = d, k, rank
+		}
+	}
+	return decision, key, bestRank >= 0
+}
+
+// patternMatch reports how specifically the allow_net pattern covers
+// domain: the length of a matching "*.suffix" wildcard, or the prefix
+// length of a CIDR containing domain's IP. It returns -1 if pattern is a
+// plain host or doesn't match.
+func patternMatch(pattern, domain string) int {
+	if suffix, ok := strings.CutPrefix(pattern, "*."); ok {
+		if net.ParseIP(domain) == nil && strings.HasSuffix(domain, "."+suffix) {
+			return len(suffix)
+		}
+		return -1
+	}
+	if !strings.Contains(pattern, "/") {
+		return -1
+	}
+	ip := net.ParseIP(domain)
+	_, n, err := net.ParseCIDR(pattern)
+	if ip == nil || err != nil || !n.Contains(ip) {
+		return -1
+	}
+	ones, _ := n.Mask.Size()
+	return ones
+}
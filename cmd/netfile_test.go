@@ -0,0 +1,91 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReadNetFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hosts.txt")
+	os.WriteFile(path, []byte(`# corporate inventory
+registry.npmjs.org
+  *.corp.example.com   # internal services
+
+https://api.example.com
+10.20.0.0/16
+`), 0644)
+
+	hosts, err := readNetFile(path)
+	if err != nil {
+		t.Fatalf("readNetFile: %v", err)
+	}
+	want := "registry.npmjs.org,*.corp.example.com,https://api.example.com,10.20.0.0/16"
+	if got := strings.Join(hosts, ","); got != want {
+		t.Errorf("hosts = %s, want %s", got, want)
+	}
+
+	for _, bad := range []string{"*", "10.0.0.0/33", "*.*.example.com", "foo*.example.com", "@https://policy.example.com/hosts.json"} {
+		os.WriteFile(path, []byte("github.com\n"+bad+"\n"), 0644)
+		if _, err := readNetFile(path); err == nil || !strings.Contains(err.Error(), ":2:") {
+			t.Errorf("readNetFile with %q: err = %v, want an error naming line 2", bad, err)
+		}
+	}
+}
+
+func TestWithNetFiles(t *testing.T) {
+	dir := t.TempDir()
+	team := filepath.Join(dir, "team.txt")
+	extra := filepath.Join(dir, "extra.txt")
+	os.WriteFile(team, []byte("github.com\n*.corp.example.com\n"), 0644)
+	os.WriteFile(extra, []byte("github.com\npypi.org\n"), 0644)
+
+	cfg := SandboxConfig{AllowNet: netEntries("registry.npmjs.org"), AllowNetFile: team}
+	cfg, err := withNetFiles(cfg, extra)
+	if err != nil {
+		t.Fatalf("withNetFiles: %v", err)
+	}
+	want := "registry.npmjs.org,github.com,*.corp.example.com,pypi.org"
+	if got := strings.Join(entryHosts(cfg.AllowNet), ","); got != want {
+		t.Errorf("AllowNet = %s, want %s", got, want)
+	}
+	if cfg.AllowNetFile != "" {
+		t.Error("allow_net_file should be cleared once spliced in")
+	}
+
+	if _, err := withNetFiles(SandboxConfig{}, filepath.Join(dir, "missing.txt")); err == nil {
+		t.Error("a missing host file should fail")
+	}
+}
+
+func TestProxyLookupPatterns(t *testing.T) {
+	p, err := NewProxy(map[string]string{
+		"*.example.com":            "allow",
+		"*.internal.example.com":   "never",
+		"api.internal.example.com": "allow",
+		"10.0.0.0/8":               "allow",
+		"10.1.0.0/16":              "never",
+	}, "test")
+	if err != nil {
+		t.Fatalf("NewProxy: %v", err)
+	}
+	defer p.Shutdown()
+
+	for domain, want := range map[string]string{
+		"www.example.com":          "allow",
+		"db.internal.example.com":  "never", // longer wildcard wins
+		"api.internal.example.com": "allow", // exact host wins
+		"10.2.3.4":                 "allow",
+		"10.1.2.3":                 "never", // narrower CIDR wins
+		"example.com":              "",      // wildcards cover subdomains only
+		"11.0.0.1":                 "",
+	} {
+		p.mu.Lock()
+		got, known := p.lookup(domain, "443")
+		p.mu.Unlock()
+		if got != want || known != (want != "") {
+			t.Errorf("lookup(%s) = %q, %v, want %q", domain, got, known, want)
+		}
+	}
+}
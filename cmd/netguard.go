@@ -0,0 +1,129 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// defaultBlockedNets are address ranges the --net proxy refuses even when
+// the user would allow them: cloud metadata services and link-local
+// addresses are classic SSRF and credential exfiltration targets.
+var defaultBlockedNets = []string{
+	"169.254.0.0/16",     // IPv4 link-local, incl. AWS/GCP/Azure metadata at 169.254.169.254
+	"fe80::/10",          // IPv6 link-local
+	"fd00:ec2::254/128",  // AWS metadata over IPv6
+	"100.100.100.200/32", // Alibaba Cloud metadata
+}
+
+// parseBlockedNets parses CIDRs, or single addresses taken as one host.
+func parseBlockedNets(entries []string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid blocked_nets entry %q: want an IP or CIDR", entry)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid blocked_nets entry %q: %w", entry, err)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// blockedAddrError is returned when a host resolves into a blocked range.
+type blockedAddrError struct {
+	host string
+	ip   net.IP
+}
+
+func (e *blockedAddrError) Error() string {
+	return fmt.Sprintf("%s resolves to %s, a link-local or cloud metadata address", e.host, e.ip)
+}
+
+// blockedIP reports whether ip falls in a blocked range.
+func (p *NetworkProxy) blockedIP(ip net.IP) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, n := range p.blocked {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// exempt reports whether the config allowlisted domain explicitly on port,
+// which lifts the blocked range check for it there. An entry for another
+// port ("host:443") exempts only that port.
+func (p *NetworkProxy) exempt(domain, port string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.preset[domain] || p.preset[net.JoinHostPort(domain, port)] || p.preset[net.JoinHostPort(domain, "*")]
+}
+
+// blockedLiteral reports whether domain is an IP literal in a blocked range
+// that the config doesn't allowlist on port.
+func (p *NetworkProxy) blockedLiteral(domain, port string) bool {
+	ip := net.ParseIP(domain)
+	return ip != nil && !p.exempt(domain, port) && p.blockedIP(ip)
+}
+
+// dialGuarded dials address, or its net_rewrite upstream, after checking
+// that the host doesn't resolve into a blocked range. It connects to the
+// checked address rather than resolving again, so DNS rebinding can't slip
+// a metadata IP in between, and resolutions are cached for the run (see
+// dnsCache).
+func (p *NetworkProxy) dialGuarded(ctx context.Context, network, address string) (net.Conn, error) {
+	address, rewritten := p.upstreamAddr(address)
+	host, port, err := net.SplitHostPort(address)
+	if err == nil && rewritten && p.blockedLiteral(host, port) {
+		return nil, &blockedAddrError{host: host, ip: net.ParseIP(host)}
+	}
+	if err != nil || net.ParseIP(host) != nil || p.exempt(host, port) {
+		// Literals were checked by the handlers, or above if rewritten
+		return p.dial(network, address)
+	}
+
+	addrs, err := p.dns.resolve(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	for _, addr := range addrs {
+		if p.blockedIP(addr.IP) {
+			return nil, &blockedAddrError{host: host, ip: addr.IP}
+		}
+	}
+
+	var lastErr error
+	for _, addr := range addrs {
+		conn, err := p.dial(network, net.JoinHostPort(addr.IP.String(), port))
+		if err == nil {
+			return conn, nil
+		}
+		lastErr = err
+	}
+	if lastErr == nil {
+		lastErr = fmt.Errorf("no addresses for %s", host)
+	}
+	return nil, lastErr
+}
+
+// writeBlockedAddr refuses a connection to a blocked range.
+func writeBlockedAddr(w http.ResponseWriter, domain, reason string) {
+	writeBlocked(w, domain, reason,
+		fmt.Sprintf("list %q explicitly in allow_net, or change blocked_nets in .ddash.json", domain))
+}
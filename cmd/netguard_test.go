@@ -0,0 +1,66 @@
+package cmd
+
+import (
+	"net"
+	"testing"
+)
+
+func TestParseBlockedNets(t *testing.T) {
+	nets, err := parseBlockedNets([]string{"10.0.0.0/8", "192.0.2.7", "fd00::1"})
+	if err != nil {
+		t.Fatalf("parseBlockedNets: %v", err)
+	}
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{"10.1.2.3", true},
+		{"192.0.2.7", true},
+		{"192.0.2.8", false},
+		{"fd00::1", true},
+		{"fd00::2", false},
+	}
+	for _, tt := range tests {
+		got := false
+		for _, n := range nets {
+			if n.Contains(net.ParseIP(tt.ip)) {
+				got = true
+			}
+		}
+		if got != tt.want {
+			t.Errorf("%s blocked = %v, want %v", tt.ip, got, tt.want)
+		}
+	}
+
+	if _, err := parseBlockedNets([]string{"metadata.google.internal"}); err == nil {
+		t.Error("expected error for a hostname")
+	}
+	if _, err := parseBlockedNets([]string{"10.0.0.0/33"}); err == nil {
+		t.Error("expected error for a bad prefix length")
+	}
+}
+
+func TestDefaultBlockedNets(t *testing.T) {
+	nets, err := parseBlockedNets(defaultBlockedNets)
+	if err != nil {
+		t.Fatalf("defaultBlockedNets: %v", err)
+	}
+	blocked := func(ip string) bool {
+		for _, n := range nets {
+			if n.Contains(net.ParseIP(ip)) {
+				return true
+			}
+		}
+		return false
+	}
+	for _, ip := range []string{"169.254.169.254", "::ffff:169.254.169.254", "fe80::1", "fd00:ec2::254", "100.100.100.200"} {
+		if !blocked(ip) {
+			t.Errorf("%s should be blocked by default", ip)
+		}
+	}
+	for _, ip := range []string{"127.0.0.1", "::1", "93.184.216.34", "10.0.0.1"} {
+		if blocked(ip) {
+			t.Errorf("%s should not be blocked by default", ip)
+		}
+	}
+}
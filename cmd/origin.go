@@ -0,0 +1,84 @@
+package cmd
+
+import "fmt"
+
+// Where list entries came from, for --explain. Indexes into the effective
+// config mean little once configs are stacked, a commands entry is merged
+// in or flags add paths, so each entry is tagged with its source when it
+// enters the config, and merges carry the tags along.
+
+// originKey identifies an entry of a config list by field and value.
+func originKey(field, value string) string {
+	return field + "\x00" + value
+}
+
+// setOrigin records that value in field came from origin, replacing what
+// was recorded before. Flags use it for the entries they add.
+func (cfg *SandboxConfig) setOrigin(field, value, origin string) {
+	if cfg.origins == nil {
+		cfg.origins = make(map[string]string)
+	}
+	cfg.origins[originKey(field, value)] = origin
+}
+
+// origin describes where value, entry i of field, came from: the recorded
+// source, or just its place in cfg for a config built in code.
+func (cfg SandboxConfig) origin(field string, i int, value string) string {
+	if origin, ok := cfg.origins[originKey(field, value)]; ok {
+		return origin
+	}
+	return fmt.Sprintf("%s[%d] = %q", field, i, value)
+}
+
+// withOrigins tags every list entry of cfg, and of its commands entries,
+// as coming from source, e.g. "team.json". A value listed twice keeps its
+// first index.
+func withOrigins(cfg SandboxConfig, source string) SandboxConfig {
+	cfg.origins = nil
+	tagOrigins(&cfg, "", source)
+	if cfg.Commands != nil {
+		commands := make(map[string]SandboxConfig, len(cfg.Commands))
+		for key, sub := range cfg.Commands {
+			sub.origins = nil
+			tagOrigins(&sub, fmt.Sprintf("commands[%q].", key), source)
+			commands[key] = sub
+		}
+		cfg.Commands = commands
+	}
+	return cfg
+}
+
+// tagOrigins records the entries of cfg's own lists, with prefix before
+// the field name.
+func tagOrigins(cfg *SandboxConfig, prefix, source string) {
+	tag := func(field string, values []string) {
+		for i, value := range values {
+			key := originKey(field, value)
+			if _, seen := cfg.origins[key]; !seen {
+				cfg.setOrigin(field, value, fmt.Sprintf("%s%s[%d] = %q in %s", prefix, field, i, value, source))
+			}
+		}
+	}
+	tag("allow_net", entryHosts(cfg.AllowNet))
+	tag("allow_read", entryPaths(cfg.AllowRead))
+	tag("allow_write", cfg.AllowWrite)
+	tag("allow_fifos", cfg.AllowFIFOs)
+	tag("allow_devices", cfg.AllowDevices)
+	tag("deny_write_exts", cfg.DenyWriteExts)
+}
+
+// mergeOrigins combines the tags of two configs being merged. Merged lists
+// keep the first copy of a duplicate, so base's tag wins.
+func mergeOrigins(base, over map[string]string) map[string]string {
+	if base == nil && over == nil {
+		return nil
+	}
+	merged := make(map[string]string, len(base)+len(over))
+	for k, v := range over {
+		merged[k] = v
+	}
+	for k, v := range base {
+		merged[k] = v
+	}
+	return merged
+}
@@ -0,0 +1,83 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// PathEntry is one allow_read entry. In JSON it is either a plain path
+// string, which grants the whole subtree, or an object
+// {"path": ".", "recursive": false}, which grants the directory and its
+// immediate children but nothing below them (sibling projects, .git).
+type PathEntry struct {
+	Path string
+	// NonRecursive is the inverse of the JSON "recursive" field, so the
+	// zero value matches the plain string form.
+	NonRecursive bool
+}
+
+// pathEntries returns recursive entries for paths.
+func pathEntries(paths ...string) []PathEntry {
+	entries := make([]PathEntry, len(paths))
+	for i, p := range paths {
+		entries[i] = PathEntry{Path: p}
+	}
+	return entries
+}
+
+// entryPaths returns just the paths of entries.
+func entryPaths(entries []PathEntry) []string {
+	var paths []string
+	for _, e := range entries {
+		paths = append(paths, e.Path)
+	}
+	return paths
+}
+
+func (e *PathEntry) UnmarshalJSON(data []byte) error {
+	var path string
+	if err := json.Unmarshal(data, &path); err == nil {
+		*e = PathEntry{Path: path}
+		return nil
+	}
+
+	var obj struct {
+		Path      string `json:"path"`
+		Recursive *bool  `json:"recursive"`
+	}
+	// A misspelt "recursive" must not quietly grant the whole subtree
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&obj); err != nil {
+		if key, ok := strings.CutPrefix(err.Error(), "json: unknown field "); ok {
+			return fmt.Errorf("path entry has unknown key %s; known keys are path and recursive", key)
+		}
+		return fmt.Errorf("path entry must be a string or {\"path\": ..., \"recursive\": ...}: %s", strings.TrimSpace(string(data)))
+	}
+	if obj.Path == "" {
+		return fmt.Errorf("path entry is missing \"path\"")
+	}
+	*e = PathEntry{Path: obj.Path, NonRecursive: obj.Recursive != nil && !*obj.Recursive}
+	return nil
+}
+
+// MarshalJSON writes recursive entries as plain strings, so configs without
+// non-recursive entries (and their checksums) are unchanged.
+func (e PathEntry) MarshalJSON() ([]byte, error) {
+	if !e.NonRecursive {
+		return json.Marshal(e.Path)
+	}
+	return json.Marshal(struct {
+		Path      string `json:"path"`
+		Recursive bool   `json:"recursive"`
+	}{e.Path, false})
+}
+
+func (e PathEntry) String() string {
+	if e.NonRecursive {
+		return e.Path + " (non-recursive)"
+	}
+	return e.Path
+}
@@ -0,0 +1,78 @@
+package cmd
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPathEntryUnmarshalForms(t *testing.T) {
+	var cfg SandboxConfig
+	data := `{"allow_read": [".", {"path": "/opt/data", "recursive": false}, {"path": "/srv", "recursive": true}]}`
+	if err := json.Unmarshal([]byte(data), &cfg); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+
+	want := []PathEntry{{Path: "."}, {Path: "/opt/data", NonRecursive: true}, {Path: "/srv"}}
+	if len(cfg.AllowRead) != len(want) {
+		t.Fatalf("AllowRead = %v, want %v", cfg.AllowRead, want)
+	}
+	for i := range want {
+		if cfg.AllowRead[i] != want[i] {
+			t.Errorf("AllowRead[%d] = %+v, want %+v", i, cfg.AllowRead[i], want[i])
+		}
+	}
+}
+
+func TestPathEntryUnmarshalErrors(t *testing.T) {
+	for _, data := range []string{`[42]`, `[{"recursive": false}]`} {
+		var entries []PathEntry
+		if err := json.Unmarshal([]byte(data), &entries); err == nil {
+			t.Errorf("Unmarshal(%s) should fail", data)
+		}
+	}
+
+	var entries []PathEntry
+	err := json.Unmarshal([]byte(`[{"path": "/opt/data", "recursve": false}]`), &entries)
+	if err == nil || !strings.Contains(err.Error(), `unknown key "recursve"`) {
+		t.Errorf("err = %v, want the unknown key named", err)
+	}
+}
+
+func TestPathEntryMarshalKeepsStringForm(t *testing.T) {
+	data, err := json.Marshal([]PathEntry{{Path: "."}, {Path: "/opt/data", NonRecursive: true}})
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	want := `[".",{"path":"/opt/data","recursive":false}]`
+	if string(data) != want {
+		t.Errorf("Marshal = %s, want %s", data, want)
+	}
+}
+
+func TestGenerateProfileNonRecursiveRead(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "package.json"), []byte("{}"), 0644)
+	os.MkdirAll(filepath.Join(dir, ".git", "objects"), 0755)
+
+	cfg := SandboxConfig{AllowRead: []PathEntry{{Path: dir, NonRecursive: true}}}
+	profile := GenerateProfile(cfg, false, false)
+
+	for _, want := range []string{
+		`(allow file-read* (literal "` + dir + `"))`,
+		`(allow file-read* (literal "` + filepath.Join(dir, "package.json") + `"))`,
+		`(allow file-read* (literal "` + filepath.Join(dir, ".git") + `"))`,
+	} {
+		if !strings.Contains(profile, want) {
+			t.Errorf("profile missing %s", want)
+		}
+	}
+	if strings.Contains(profile, `(subpath "`+dir+`")`) {
+		t.Error("non-recursive entry should not grant the subtree")
+	}
+	if strings.Contains(profile, filepath.Join(dir, ".git", "objects")) {
+		t.Error("non-recursive entry should not reach grandchildren")
+	}
+}
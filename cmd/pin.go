@@ -0,0 +1,111 @@
+package cmd
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// pinHandshakeTimeout bounds the handshake used to check a pinned host.
+const pinHandshakeTimeout = 5 * time.Second
+
+// parsePin normalizes a pin_net fingerprint: hex SHA-256 of the DER
+// certificate, with or without a "sha256:" prefix and colons, in any case.
+func parsePin(host, pin string) (string, error) {
+	fp := strings.ToLower(strings.TrimSpace(pin))
+	fp = strings.TrimPrefix(fp, "sha256:")
+	fp = strings.ReplaceAll(fp, ":", "")
+	if b, err := hex.DecodeString(fp); err != nil || len(b) != sha256.Size {
+		return "", fmt.Errorf("invalid pin_net fingerprint for %s: want a hex SHA-256, got %q", host, pin)
+	}
+	return fp, nil
+}
+
+// certFingerprint returns the hex SHA-256 of a DER certificate, the form
+// pin_net entries are compared in.
+func certFingerprint(der []byte) string {
+	sum := sha256.Sum256(der)
+	return hex.EncodeToString(sum[:])
+}
+
+// pinMismatchError is returned when a pinned host presents another
+// certificate.
+type pinMismatchError struct {
+	host string
+	got  string
+}
+
+func (e *pinMismatchError) Error() string {
+	return fmt.Sprintf("%s presented certificate sha256:%s, which doesn't match pin_net", e.host, e.got)
+}
+
+// SetPins pins hosts to the SHA-256 fingerprint of their leaf TLS
+// certificate. Tunnels to a pinned host are opened only after the
+// certificate served at the dialed address matches; plain HTTP to it is
+// refused, since there is no certificate to check. The check is
+// best-effort, see checkPin.
+func (p *NetworkProxy) SetPins(pins map[string]string) error {
+	parsed := make(map[string]string, len(pins))
+	for host, pin := range pins {
+		fp, err := parsePin(host, pin)
+		if err != nil {
+			return err
+		}
+		parsed[strings.Trim(host, "[]")] = fp
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.pins = parsed
+	return nil
+}
+
+// pinned returns the fingerprint domain is pinned to, if any.
+func (p *NetworkProxy) pinned(domain string) (string, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	fp, ok := p.pins[domain]
+	return fp, ok
+}
+
+// checkPin verifies a pinned domain's certificate at addr, the address the
+// tunnel was dialed to. The proxy doesn't terminate TLS, and TLS 1.3
+// encrypts the certificate, so it can't be read off the tunnel: a separate
+// handshake to the same address fetches it instead. That makes the pin
+// best-effort: a server can present another certificate on the tunnel's
+// own connection, and nothing here would notice. The chain itself isn't
+// verified; the pin replaces that, as with SSH host keys.
+func (p *NetworkProxy) checkPin(domain, addr string) error {
+	want, ok := p.pinned(domain)
+	if !ok {
+		return nil
+	}
+
+	conn, err := p.dial("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to check pinned certificate of %s: %w", domain, err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(pinHandshakeTimeout))
+
+	serverName := domain
+	if net.ParseIP(domain) != nil {
+		serverName = ""
+	}
+	tlsConn := tls.Client(conn, &tls.Config{ServerName: serverName, InsecureSkipVerify: true})
+	if err := tlsConn.Handshake(); err != nil {
+		return fmt.Errorf("failed to check pinned certificate of %s: %w", domain, err)
+	}
+
+	certs := tlsConn.ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		return fmt.Errorf("%s presented no certificate", domain)
+	}
+	if got := certFingerprint(certs[0].Raw); got != want {
+		return &pinMismatchError{host: domain, got: got}
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParsePin(t *testing.T) {
+	fp := strings.Repeat("ab", 32)
+	valid := []string{
+		fp,
+		strings.ToUpper(fp),
+		"sha256:" + fp,
+		strings.TrimSuffix(strings.Repeat("AB:", 32), ":"),
+	}
+	for _, pin := range valid {
+		got, err := parsePin("example.com", pin)
+		if err != nil {
+			t.Errorf("parsePin(%q) failed: %v", pin, err)
+		} else if got != fp {
+			t.Errorf("parsePin(%q) = %q, want %q", pin, got, fp)
+		}
+	}
+
+	for _, pin := range []string{"", "abcd", "sha1:" + fp, strings.Repeat("zz", 32)} {
+		if _, err := parsePin("example.com", pin); err == nil {
+			t.Errorf("parsePin(%q) should fail", pin)
+		}
+	}
+}
+
+// connectThroughPin opens a CONNECT tunnel to backend through a proxy
+// that pins the backend host to pin, and returns the proxy's status.
+func connectThroughPin(t *testing.T, backend *httptest.Server, pin string) int {
+	t.Helper()
+	backendURL, _ := url.Parse(backend.URL)
+	host := backendURL.Hostname()
+
+	p, err := NewProxy(map[string]string{host: "always"}, "test")
+	if err != nil {
+		t.Fatalf("NewProxy failed: %v", err)
+	}
+	defer p.Shutdown()
+	if err := p.SetPins(map[string]string{host: pin}); err != nil {
+		t.Fatalf("SetPins failed: %v", err)
+	}
+	p.Start()
+
+	conn, err := net.Dial("tcp", p.Addr())
+	if err != nil {
+		t.Fatalf("dial proxy: %v", err)
+	}
+	defer conn.Close()
+	fmt.Fprintf(conn, "CONNECT %s HTTP/1.1\r\nHost: %s\r\n\r\n", backendURL.Host, backendURL.Host)
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatalf("read CONNECT response: %v", err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestProxyPinMatches(t *testing.T) {
+	backend := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer backend.Close()
+
+	pin := "sha256:" + certFingerprint(backend.Certificate().Raw)
+	if status := connectThroughPin(t, backend, pin); status != http.StatusOK {
+		t.Errorf("expected the tunnel to open for a matching pin, got %d", status)
+	}
+}
+
+func TestProxyPinMismatch(t *testing.T) {
+	backend := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer backend.Close()
+
+	if status := connectThroughPin(t, backend, strings.Repeat("00", 32)); status != http.StatusForbidden {
+		t.Errorf("expected 403 for a mismatched pin, got %d", status)
+	}
+}
+
+func TestProxyPinRefusesPlainHTTP(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("should-not-reach"))
+	}))
+	defer backend.Close()
+
+	backendURL, _ := url.Parse(backend.URL)
+	host := backendURL.Hostname()
+	p, err := NewProxy(map[string]string{host: "always"}, "test")
+	if err != nil {
+		t.Fatalf("NewProxy failed: %v", err)
+	}
+	defer p.Shutdown()
+	p.SetPins(map[string]string{host: strings.Repeat("00", 32)})
+	p.Start()
+
+	proxyURL, _ := url.Parse("http://" + p.Addr())
+	client := &http.Client{
+		Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)},
+		Timeout:   5 * time.Second,
+	}
+	resp, err := client.Get(backend.URL)
+	if err != nil {
+		t.Fatalf("request through proxy failed: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden || !strings.Contains(string(body), "pin_net") {
+		t.Errorf("expected 403 mentioning pin_net, got %d: %s", resp.StatusCode, body)
+	}
+}
@@ -0,0 +1,181 @@
+package cmd
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+)
+
+const probeUsage = `Check whether the current policy lets a host through
+
+Usage:
+  ddash probe [flags] <host>...
+
+Reports, for each host, whether 'ddash run' and 'ddash run --net' would
+allow the connection, deny it, or prompt for it. Nothing is executed and
+the host is never contacted; only allow_net and network_domains from the
+config are consulted.
+
+A host may be given as "example.com", "example.com:8443",
+"https://example.com" or "http://example.com" (plain HTTP on port 80).
+Without a scheme or port, HTTPS on 443 is assumed.
+
+Examples:
+  ddash probe registry.npmjs.org
+  ddash probe --config ci.ddash.json https://pypi.org http://mirror.local
+
+Flags:
+  --config <file>  Load this config instead of .ddash.json (repeatable)
+  -h, --help       Show help`
+
+// probeResult is how a run would treat a connection to Host:Port.
+type probeResult struct {
+	Host        string
+	Port        string
+	Plain       string // verdict under 'ddash run': "allow" or "deny"
+	PlainReason string
+	Net         string // verdict under 'ddash run --net': "allow", "deny" or "prompt"
+	NetReason   string
+}
+
+func probeCmd() error {
+	var configs []string
+	fs := newFlagSet("probe")
+	fs.Var((*stringList)(&configs), "config", "")
+	err := fs.Parse(os.Args[2:])
+	if errors.Is(err, flag.ErrHelp) || (err == nil && fs.NArg() == 0) {
+		fmt.Println(probeUsage)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	cfg, err := loadRunConfigs(configs)
+	if err != nil {
+		return err
+	}
+
+	for _, target := range fs.Args() {
+		r, err := probeHost(cfg, target)
+		if err != nil {
+			return err
+		}
+		fmt.Println(net.JoinHostPort(r.Host, r.Port))
+		fmt.Printf("  ddash run:        %-6s (%s)\n", r.Plain, r.PlainReason)
+		fmt.Printf("  ddash run --net:  %-6s (%s)\n", r.Net, r.NetReason)
+	}
+	return nil
+}
+
+// probeHost evaluates target against cfg the way the sandbox profile and
+// the --net proxy would, minus the prompt.
+func probeHost(cfg SandboxConfig, target string) (probeResult, error) {
+	host, port, plainHTTP, err := parseProbeTarget(target)
+	if err != nil {
+		return probeResult{}, err
+	}
+	r := probeResult{Host: host, Port: port}
+
+	// Without --net the profile allows all of the network or none of it
+	switch {
+	case cfg.Isolation == isolationNone:
+		r.Plain, r.PlainReason = "allow", "sandbox disabled"
+	case allowsAllNet(cfg):
+		r.Plain, r.PlainReason = "allow", `allow_net ["*"]`
+	default:
+		r.Plain, r.PlainReason = "deny", "only --net applies per-host rules"
+	}
+
+	domains, httpsOnly := proxyDomains(cfg)
+	for _, h := range httpsOnly {
+		if h == host && (plainHTTP || port != "443") {
+			r.Net, r.NetReason = "deny", "allow_net limits it to HTTPS on port 443"
+			return r, nil
+		}
+	}
+
+	if _, ok := cfg.PinNet[host]; ok && plainHTTP {
+		r.Net, r.NetReason = "deny", "certificate pinned in pin_net, HTTPS only"
+		return r, nil
+	}
+
+	decision, key, known := lookupDecision(domains, host, port)
+	// Only an explicit entry for the host lifts the blocked range check,
+	// not a wildcard or CIDR, as in the proxy
+	explicit := key == host || key == net.JoinHostPort(host, port) || key == net.JoinHostPort(host, "*")
+	if ip := net.ParseIP(host); ip != nil && !(known && explicit && Decision(decision).IsAllowed()) {
+		blocked := defaultBlockedNets
+		if cfg.BlockedNets != nil {
+			blocked = *cfg.BlockedNets
+		}
+		nets, err := parseBlockedNets(blocked)
+		if err != nil {
+			return r, err
+		}
+		for _, n := range nets {
+			if n.Contains(ip) {
+				r.Net, r.NetReason = "deny", "link-local or cloud metadata address (blocked_nets)"
+				return r, nil
+			}
+		}
+	}
+
+	switch {
+	case !known && allowsAllNet(cfg):
+		r.Net, r.NetReason = "prompt", `allow_net ["*"] is overridden by --net`
+	case !known:
+		r.Net, r.NetReason = "prompt", "not in allow_net or network_domains"
+	case Decision(decision).IsAllowed():
+		r.Net, r.NetReason = "allow", probeSource(cfg, host, key)
+	default:
+		r.Net, r.NetReason = "deny", probeSource(cfg, host, key)
+	}
+	return r, nil
+}
+
+// probeSource names the config entry behind key, the entry that decided
+// host. network_domains wins over allow_net, as in proxyDomains.
+func probeSource(cfg SandboxConfig, host, key string) string {
+	if decision, ok := cfg.NetworkDomains[key]; ok {
+		if key != host {
+			return fmt.Sprintf("network_domains %q: %s", key, decision)
+		}
+		return fmt.Sprintf("network_domains: %s", decision)
+	}
+	if key != host {
+		return fmt.Sprintf("allow_net %q", key)
+	}
+	return "allow_net"
+}
+
+// parseProbeTarget splits a probe argument into host and port, and
+// reports whether it names plain HTTP rather than HTTPS/CONNECT.
+func parseProbeTarget(target string) (host, port string, plainHTTP bool, err error) {
+	hostport := target
+	defaultPort := "443"
+	if strings.Contains(target, "://") {
+		u, err := url.Parse(target)
+		if err != nil || u.Host == "" {
+			return "", "", false, fmt.Errorf("invalid host %q", target)
+		}
+		switch u.Scheme {
+		case "https":
+		case "http":
+			defaultPort, plainHTTP = "80", true
+		default:
+			return "", "", false, fmt.Errorf("unsupported scheme in %q: use http:// or https://", target)
+		}
+		hostport = u.Host
+	}
+
+	host, port = splitHostPort(hostport, defaultPort)
+	if host == "" {
+		return "", "", false, fmt.Errorf("invalid host %q", target)
+	}
+	return host, port, plainHTTP, nil
+}
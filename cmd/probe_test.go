@@ -0,0 +1,133 @@
+package cmd
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseProbeTarget(t *testing.T) {
+	tests := []struct {
+		target    string
+		host      string
+		port      string
+		plainHTTP bool
+	}{
+		{"registry.npmjs.org", "registry.npmjs.org", "443", false},
+		{"example.com:8443", "example.com", "8443", false},
+		{"https://pypi.org/simple/", "pypi.org", "443", false},
+		{"http://mirror.local", "mirror.local", "80", true},
+		{"http://mirror.local:8080", "mirror.local", "8080", true},
+		{"[2001:db8::1]", "2001:db8::1", "443", false},
+	}
+	for _, tt := range tests {
+		host, port, plain, err := parseProbeTarget(tt.target)
+		if err != nil {
+			t.Errorf("parseProbeTarget(%q): %v", tt.target, err)
+			continue
+		}
+		if host != tt.host || port != tt.port || plain != tt.plainHTTP {
+			t.Errorf("parseProbeTarget(%q) = %q, %q, %v, want %q, %q, %v",
+				tt.target, host, port, plain, tt.host, tt.port, tt.plainHTTP)
+		}
+	}
+
+	for _, bad := range []string{"ftp://example.com", "https://", ":443"} {
+		if _, _, _, err := parseProbeTarget(bad); err == nil {
+			t.Errorf("parseProbeTarget(%q): expected error", bad)
+		}
+	}
+}
+
+func TestProbeHost(t *testing.T) {
+	cfg := SandboxConfig{
+		Isolation:      isolationProcess,
+		AllowNet:       netEntries("registry.npmjs.org", "https://api.github.com", "tracker.example.com"),
+		NetworkDomains: map[string]string{"tracker.example.com": "never", "cdn.example.com": "always"},
+	}
+
+	tests := []struct {
+		target string
+		net    string
+		reason string
+	}{
+		{"registry.npmjs.org", "allow", "allow_net"},
+		{"https://api.github.com", "allow", "allow_net"},
+		{"http://api.github.com", "deny", "allow_net limits it to HTTPS on port 443"},
+		{"api.github.com:8443", "deny", "allow_net limits it to HTTPS on port 443"},
+		{"tracker.example.com", "deny", "network_domains: never"},
+		{"cdn.example.com", "allow", "network_domains: always"},
+		{"unknown.example.com", "prompt", "not in allow_net or network_domains"},
+	}
+	for _, tt := range tests {
+		r, err := probeHost(cfg, tt.target)
+		if err != nil {
+			t.Fatalf("probeHost(%q): %v", tt.target, err)
+		}
+		if r.Net != tt.net || r.NetReason != tt.reason {
+			t.Errorf("probeHost(%q) --net = %s (%s), want %s (%s)", tt.target, r.Net, r.NetReason, tt.net, tt.reason)
+		}
+		if r.Plain != "deny" {
+			t.Errorf("probeHost(%q) without --net = %s, want deny", tt.target, r.Plain)
+		}
+	}
+
+	open := SandboxConfig{Isolation: isolationProcess, AllowNet: netEntries("*")}
+	r, _ := probeHost(open, "anything.example.com")
+	if r.Plain != "allow" || r.Net != "prompt" {
+		t.Errorf("allow_net [*]: plain = %s, net = %s, want allow and prompt", r.Plain, r.Net)
+	}
+}
+
+func TestProbeHostBlockedNets(t *testing.T) {
+	cfg := SandboxConfig{Isolation: isolationProcess, AllowNet: netEntries("*")}
+	r, err := probeHost(cfg, "http://169.254.169.254")
+	if err != nil {
+		t.Fatalf("probeHost: %v", err)
+	}
+	if r.Net != "deny" || !strings.Contains(r.NetReason, "metadata") {
+		t.Errorf("metadata IP --net = %s (%s), want deny", r.Net, r.NetReason)
+	}
+
+	cfg.AllowNet = append(cfg.AllowNet, NetEntry{Host: "169.254.169.254"})
+	if r, _ := probeHost(cfg, "http://169.254.169.254"); r.Net != "allow" {
+		t.Errorf("allowlisted metadata IP --net = %s, want allow", r.Net)
+	}
+}
+
+func TestProbeHostPatternsAndPorts(t *testing.T) {
+	cfg := SandboxConfig{
+		Isolation:      isolationProcess,
+		AllowNet:       netEntries("*.corp.example.com", "10.20.0.0/16", "web.example.com:443", "any.example.com:*"),
+		NetworkDomains: map[string]string{"legacy.corp.example.com": "never"},
+	}
+
+	tests := []struct {
+		target string
+		net    string
+		reason string
+	}{
+		{"git.corp.example.com", "allow", `allow_net "*.corp.example.com"`},
+		{"legacy.corp.example.com", "deny", "network_domains: never"},
+		{"corp.example.com", "prompt", "not in allow_net or network_domains"},
+		{"10.20.3.4", "allow", `allow_net "10.20.0.0/16"`},
+		{"10.21.3.4", "prompt", "not in allow_net or network_domains"},
+		{"web.example.com", "allow", `allow_net "web.example.com:443"`},
+		{"http://web.example.com", "prompt", "not in allow_net or network_domains"},
+		{"any.example.com:8080", "allow", `allow_net "any.example.com:*"`},
+	}
+	for _, tt := range tests {
+		r, err := probeHost(cfg, tt.target)
+		if err != nil {
+			t.Fatalf("probeHost(%q): %v", tt.target, err)
+		}
+		if r.Net != tt.net || r.NetReason != tt.reason {
+			t.Errorf("probeHost(%q) --net = %s (%s), want %s (%s)", tt.target, r.Net, r.NetReason, tt.net, tt.reason)
+		}
+	}
+
+	// A CIDR doesn't lift the metadata block; only the exact address does
+	cfg.AllowNet = netEntries("169.254.0.0/16")
+	if r, _ := probeHost(cfg, "http://169.254.169.254"); r.Net != "deny" {
+		t.Errorf("metadata IP under an allow_net CIDR --net = %s, want deny", r.Net)
+	}
+}
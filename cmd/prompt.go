@@ -0,0 +1,364 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+	"time"
+)
+
+// PromptRequest describes a connection waiting for an allow/deny decision.
+type PromptRequest struct {
+	Command string // sandboxed command line, for display
+	Domain  string // requested domain
+	Port    string // requested port
+
+	// URL is the full request URL for plain HTTP, which often tells a
+	// package download from telemetry. Empty for CONNECT, where only
+	// host and port are known.
+	URL string
+
+	// Info writes extra context (attempt counts, recent decisions) for
+	// prompters that can show it on demand. May be nil.
+	Info func(w io.Writer)
+
+	// Whois writes registration details for Domain. May be nil.
+	Whois func(w io.Writer)
+
+	// Options are the decisions the prompter may offer (prompt_options).
+	// Nil offers all of them. Deny is always offered.
+	Options []string
+}
+
+// promptOptionNames are the valid prompt_options entries, in menu order.
+var promptOptionNames = []string{
+	string(DecisionAllow),
+	string(DecisionDeny),
+	string(DecisionAlways),
+	string(DecisionNever),
+	string(DecisionSession),
+	string(DecisionAllowRest),
+}
+
+// validatePromptOptions checks prompt_options entries.
+func validatePromptOptions(options []string) error {
+	for _, option := range options {
+		if !slices.Contains(promptOptionNames, option) {
+			return fmt.Errorf("unknown prompt_options entry %q: want one of %s", option, strings.Join(promptOptionNames, ", "))
+		}
+	}
+	return nil
+}
+
+// offers reports whether the prompter may offer decision d.
+func (req PromptRequest) offers(d Decision) bool {
+	return req.Options == nil || d == DecisionDeny || slices.Contains(req.Options, string(d))
+}
+
+// ttyChoices are the decision options of the terminal prompt.
+var ttyChoices = []struct {
+	label    string
+	decision Decision
+}{
+	{"[a]llow", DecisionAllow},
+	{"[d]eny", DecisionDeny},
+	{"a[l]ways", DecisionAlways},
+	{"[n]ever", DecisionNever},
+	{"[o]nce-session", DecisionSession},
+}
+
+// ttyMenu is the option line of the terminal prompt, limited to what req
+// offers.
+func ttyMenu(req PromptRequest) string {
+	var labels []string
+	for _, choice := range ttyChoices {
+		if req.offers(choice.decision) {
+			labels = append(labels, choice.label)
+		}
+	}
+	menu := "       " + strings.Join(append(labels, "[w]hois", "[i]nfo"), "  ")
+	if req.offers(DecisionAllowRest) {
+		menu += "\n       type allow-rest to allow all the rest (stops asking, less safe)"
+	}
+	return menu + ": "
+}
+
+// target is what the connection is for: the URL if known, otherwise
+// host:port.
+func (req PromptRequest) target() string {
+	if req.URL != "" {
+		return req.URL
+	}
+	if req.Port != "" {
+		return net.JoinHostPort(req.Domain, req.Port)
+	}
+	return req.Domain
+}
+
+// Prompter decides whether a new domain may be reached. Ask returns one of
+// "allow", "deny", "always", "never", "session" (allowed until the shell
+// session ends, see session.go) or "allow-rest" (allow this and every
+// later new domain this run). An error means no decision could be
+// obtained; the proxy then denies the connection.
+type Prompter interface {
+	Ask(req PromptRequest) (string, error)
+}
+
+// GroupPrompter is a Prompter that can also decide several new domains
+// with one question. The proxy uses it for domains requested close
+// together when prompt grouping is on; AskGroup returns one decision per
+// request, in order.
+type GroupPrompter interface {
+	Prompter
+	AskGroup(reqs []PromptRequest) ([]string, error)
+}
+
+// ttyPrompter asks on /dev/tty so it doesn't conflict with the sandboxed
+// process's stdin. The tty is opened on first use.
+type ttyPrompter struct {
+	tty    *os.File
+	reader *bufio.Reader // shared across prompts so no typed-ahead answer is lost
+}
+
+// open opens the tty on first use.
+func (t *ttyPrompter) open() error {
+	if t.tty == nil {
+		tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+		if err != nil {
+			return fmt.Errorf("can't open /dev/tty")
+		}
+		t.tty = tty
+	}
+	if t.reader == nil {
+		t.reader = bufio.NewReader(t.tty)
+	}
+	return nil
+}
+
+// Ask prompts on the terminal. Answering [i]nfo or [w]hois prints
+// req.Info or req.Whois and asks again. Allow-all-rest takes the full word,
+// so no single keystroke, mistyped or not, can turn off the prompts.
+func (t *ttyPrompter) Ask(req PromptRequest) (string, error) {
+	if err := t.open(); err != nil {
+		return "", err
+	}
+
+	fmt.Fprintf(t.tty, "\nddash: %s wants to connect to %s\n", req.Command, req.target())
+
+	reader := t.reader
+	for {
+		fmt.Fprint(t.tty, ttyMenu(req))
+
+		line, _ := reader.ReadString('\n')
+		line = strings.ToLower(strings.TrimSpace(line))
+
+		var answer Decision
+		switch line {
+		case "allow-rest", "allow-all-rest":
+			answer = DecisionAllowRest
+		case "a", "allow":
+			answer = DecisionAllow
+		case "d", "deny":
+			answer = DecisionDeny
+		case "l", "always":
+			answer = DecisionAlways
+		case "n", "never":
+			answer = DecisionNever
+		case "o", "session":
+			answer = DecisionSession
+		case "i", "info":
+			if req.Info != nil {
+				req.Info(t.tty)
+			}
+		case "w", "whois":
+			if req.Whois != nil {
+				req.Whois(t.tty)
+			}
+		default:
+			// Unknown input — treat as deny for safety
+			fmt.Fprintf(t.tty, "       (unknown input %q, denying)\n", line)
+			return "deny", nil
+		}
+		if answer == "" {
+			continue
+		}
+		if !req.offers(answer) {
+			// A hidden option counts as unknown input
+			fmt.Fprintf(t.tty, "       (%s is not offered, denying)\n", answer)
+			return "deny", nil
+		}
+		return string(answer), nil
+	}
+}
+
+// AskGroup asks once about several new domains. Answering [e]ach asks
+// about every domain in turn.
+func (t *ttyPrompter) AskGroup(reqs []PromptRequest) ([]string, error) {
+	if err := t.open(); err != nil {
+		return nil, err
+	}
+
+	fmt.Fprintf(t.tty, "\nddash: %s wants to connect to %d new hosts:\n", reqs[0].Command, len(reqs))
+	for _, req := range reqs {
+		fmt.Fprintf(t.tty, "         %s\n", req.target())
+	}
+	allowAll := reqs[0].offers(DecisionAllow)
+	if allowAll {
+		fmt.Fprintf(t.tty, "       [a]llow all  [d]eny all  [e]ach: ")
+	} else {
+		fmt.Fprintf(t.tty, "       [d]eny all  [e]ach: ")
+	}
+
+	line, _ := t.reader.ReadString('\n')
+	line = strings.TrimSpace(strings.ToLower(line))
+
+	answer := "deny"
+	switch line {
+	case "a", "allow":
+		if allowAll {
+			answer = "allow"
+		} else {
+			fmt.Fprintf(t.tty, "       (allow is not offered, denying all)\n")
+		}
+	case "d", "deny":
+	case "e", "each":
+		answers := make([]string, len(reqs))
+		for i, req := range reqs {
+			decision, err := t.Ask(req)
+			if err != nil {
+				return nil, err
+			}
+			answers[i] = decision
+		}
+		return answers, nil
+	default:
+		// Unknown input — treat as deny for safety
+		fmt.Fprintf(t.tty, "       (unknown input %q, denying all)\n", line)
+	}
+
+	answers := make([]string, len(reqs))
+	for i := range answers {
+		answers[i] = answer
+	}
+	return answers, nil
+}
+
+// Close releases the tty if it was opened.
+func (t *ttyPrompter) Close() error {
+	if t.tty == nil {
+		return nil
+	}
+	return t.tty.Close()
+}
+
+// DenyPrompter denies every new domain without asking. Useful for CI and
+// other unattended runs where only pre-approved domains should be reachable.
+type DenyPrompter struct{}
+
+// Ask always returns "deny".
+func (DenyPrompter) Ask(req PromptRequest) (string, error) {
+	return "deny", nil
+}
+
+// dialogTimeout is how long a dialog prompt waits before denying.
+const dialogTimeout = 60 * time.Second
+
+// dialogChoices maps the dialog's list entries, in order, to decisions.
+var dialogChoices = []struct {
+	item     string
+	decision Decision
+}{
+	{"Allow", DecisionAllow},
+	{"Deny", DecisionDeny},
+	{"Always", DecisionAlways},
+	{"Never", DecisionNever},
+	{"This session", DecisionSession},
+}
+
+// DialogPrompter asks in a macOS dialog (via osascript) so long builds
+// don't need someone watching the terminal. Unanswered dialogs deny after
+// timeout; if the dialog can't be shown at all, it falls back to fallback.
+type DialogPrompter struct {
+	timeout  time.Duration
+	fallback Prompter
+
+	// run executes an AppleScript and returns its trimmed output.
+	run func(ctx context.Context, script string) (string, error)
+}
+
+// NewDialogPrompter returns a dialog prompter that falls back to the
+// /dev/tty prompt when no dialog can be shown.
+func NewDialogPrompter() *DialogPrompter {
+	return &DialogPrompter{
+		timeout:  dialogTimeout,
+		fallback: &ttyPrompter{},
+		run:      runOsascript,
+	}
+}
+
+// Ask shows the dialog and maps the chosen button to a decision.
+func (d *DialogPrompter) Ask(req PromptRequest) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), d.timeout)
+	defer cancel()
+
+	out, err := d.run(ctx, dialogScript(req))
+	if ctx.Err() == context.DeadlineExceeded {
+		fmt.Fprintf(os.Stderr, "ddash: no answer for %s within %s, denying\n", req.Domain, d.timeout)
+		return "deny", nil
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ddash: can't show dialog (%v), asking in terminal\n", err)
+		return d.fallback.Ask(req)
+	}
+
+	if out == "false" {
+		// Cancel button
+		return "deny", nil
+	}
+	for _, choice := range dialogChoices {
+		if choice.item == out && req.offers(choice.decision) {
+			return string(choice.decision), nil
+		}
+	}
+	return "deny", nil
+}
+
+// Close releases the fallback prompter.
+func (d *DialogPrompter) Close() error {
+	if closer, ok := d.fallback.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// dialogScript builds a "choose from list" AppleScript for req, listing
+// the choices it offers. A list is used rather than "display dialog"
+// because dialogs allow only 3 buttons.
+func dialogScript(req PromptRequest) string {
+	var items []string
+	for _, choice := range dialogChoices {
+		if req.offers(choice.decision) {
+			items = append(items, `"`+choice.item+`"`)
+		}
+	}
+	prompt := fmt.Sprintf("Allow %s to connect to %s?", req.Command, req.target())
+	return fmt.Sprintf(`choose from list {%s} `+
+		`with title "ddash" with prompt "%s" default items {"Deny"}`, strings.Join(items, ", "), appleScriptEscape(prompt))
+}
+
+// appleScriptEscape escapes s for use inside an AppleScript string literal.
+func appleScriptEscape(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	return strings.ReplaceAll(s, `"`, `\"`)
+}
+
+func runOsascript(ctx context.Context, script string) (string, error) {
+	out, err := exec.CommandContext(ctx, "osascript", "-e", script).Output()
+	return strings.TrimSpace(string(out)), err
+}
@@ -0,0 +1,683 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// stubPrompter answers from a fixed map and records what it was asked.
+type stubPrompter struct {
+	answers map[string]string
+	asked   []PromptRequest
+	err     error
+}
+
+func (s *stubPrompter) Ask(req PromptRequest) (string, error) {
+	s.asked = append(s.asked, req)
+	if s.err != nil {
+		return "", s.err
+	}
+	return s.answers[req.Domain], nil
+}
+
+func TestSetPrompterDecides(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("reached"))
+	}))
+	defer backend.Close()
+
+	backendURL, _ := url.Parse(backend.URL)
+	domain := stripPort(backendURL.Host)
+
+	p, err := NewProxy(nil, "npm install")
+	if err != nil {
+		t.Fatalf("NewProxy failed: %v", err)
+	}
+	defer p.Shutdown()
+
+	stub := &stubPrompter{answers: map[string]string{domain: "always"}}
+	p.SetPrompter(stub)
+	p.Start()
+
+	proxyURL, _ := url.Parse("http://" + p.Addr())
+	client := &http.Client{
+		Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)},
+		Timeout:   5 * time.Second,
+	}
+
+	for i := 0; i < 2; i++ {
+		resp, err := client.Get(backend.URL)
+		if err != nil {
+			t.Fatalf("request through proxy failed: %v", err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if string(body) != "reached" {
+			t.Errorf("expected 'reached', got %q", string(body))
+		}
+	}
+
+	if len(stub.asked) != 1 {
+		t.Fatalf("expected prompter to be asked once, got %d", len(stub.asked))
+	}
+	req := stub.asked[0]
+	if req.Command != "npm install" || req.Domain != domain || req.Port != backendURL.Port() {
+		t.Errorf("unexpected prompt request: %+v", req)
+	}
+	if req.URL != backend.URL+"/" {
+		t.Errorf("prompt URL = %q, want %q", req.URL, backend.URL+"/")
+	}
+	if p.Domains()[domain] != "always" {
+		t.Errorf("expected decision cached as 'always', got %q", p.Domains()[domain])
+	}
+}
+
+func TestPrompterErrorDenies(t *testing.T) {
+	p, err := NewProxy(nil, "test")
+	if err != nil {
+		t.Fatalf("NewProxy failed: %v", err)
+	}
+	defer p.Shutdown()
+
+	p.SetPrompter(&stubPrompter{err: errors.New("approval service unavailable")})
+
+	if got := p.checkDomain("example.com", "443", ""); got != "deny" {
+		t.Errorf("expected 'deny' when the prompter fails, got %q", got)
+	}
+}
+
+func TestDenyPrompter(t *testing.T) {
+	p, err := NewProxy(map[string]string{"allowed.example.com": "always"}, "test")
+	if err != nil {
+		t.Fatalf("NewProxy failed: %v", err)
+	}
+	defer p.Shutdown()
+
+	p.SetPrompter(DenyPrompter{})
+
+	if got := p.checkDomain("new.example.com", "443", ""); got != "deny" {
+		t.Errorf("DenyPrompter should deny new domains, got %q", got)
+	}
+	if got := p.checkDomain("allowed.example.com", "443", ""); got != "always" {
+		t.Errorf("pre-approved domains should still pass, got %q", got)
+	}
+}
+
+func TestDeciderBeforePrompt(t *testing.T) {
+	p, err := NewProxy(nil, "test")
+	if err != nil {
+		t.Fatalf("NewProxy failed: %v", err)
+	}
+	defer p.Shutdown()
+
+	stub := &stubPrompter{answers: map[string]string{"unknown.example.com": "allow", "odd.example.com": "deny"}}
+	p.SetPrompter(stub)
+	var consulted []string
+	p.SetDecider(func(domain string) (string, error) {
+		consulted = append(consulted, domain)
+		switch domain {
+		case "registry.npmjs.org":
+			return "allow", nil
+		case "telemetry.example.com":
+			return "deny", nil
+		case "odd.example.com":
+			return "maybe", nil
+		}
+		return "", errors.New("policy server unreachable")
+	})
+
+	tests := []struct {
+		domain string
+		want   Decision
+	}{
+		{"registry.npmjs.org", DecisionAllow},
+		{"telemetry.example.com", DecisionDeny},
+		{"unknown.example.com", DecisionAllow}, // decider failed, prompted
+		{"odd.example.com", DecisionDeny},      // invalid answer, prompted
+		{"registry.npmjs.org", DecisionAllow},  // cached
+	}
+	for _, tt := range tests {
+		if got := p.checkDomain(tt.domain, "443", ""); got != tt.want {
+			t.Errorf("checkDomain(%s) = %q, want %q", tt.domain, got, tt.want)
+		}
+	}
+
+	if len(consulted) != 4 {
+		t.Errorf("decider consulted %d times, want 4 (cached domains aren't re-asked): %v", len(consulted), consulted)
+	}
+	var prompted []string
+	for _, req := range stub.asked {
+		prompted = append(prompted, req.Domain)
+	}
+	if strings.Join(prompted, ",") != "unknown.example.com,odd.example.com" {
+		t.Errorf("prompted for %v, want only the domains the decider couldn't answer", prompted)
+	}
+}
+
+func TestAllowRestStopsPrompting(t *testing.T) {
+	p, err := NewProxy(map[string]string{"blocked.example.com": "never"}, "test")
+	if err != nil {
+		t.Fatalf("NewProxy failed: %v", err)
+	}
+	defer p.Shutdown()
+
+	stub := &stubPrompter{answers: map[string]string{"first.example.com": "allow-rest"}}
+	p.SetPrompter(stub)
+
+	tests := []struct {
+		domain string
+		want   Decision
+	}{
+		{"first.example.com", DecisionAllow},
+		{"second.example.com", DecisionAllow},
+		{"third.example.com", DecisionAllow},
+		{"blocked.example.com", DecisionNever}, // saved decisions still apply
+	}
+	for _, tt := range tests {
+		if got := p.checkDomain(tt.domain, "443", ""); got != tt.want {
+			t.Errorf("checkDomain(%s) = %q, want %q", tt.domain, got, tt.want)
+		}
+	}
+	if len(stub.asked) != 1 {
+		t.Errorf("prompted %d times, want only for the first domain", len(stub.asked))
+	}
+	if got := p.Domains()["second.example.com"]; got != "allow" {
+		t.Errorf("auto-allowed domain recorded as %q, want allow (never saved)", got)
+	}
+}
+
+func TestProxyMonitorMode(t *testing.T) {
+	saved := map[string]string{
+		"ok.example.com":        "allow",
+		"blocked.example.com":   "never",
+		"telemetry.example.com": "log",
+	}
+	p, err := NewProxy(saved, "test")
+	if err != nil {
+		t.Fatalf("NewProxy failed: %v", err)
+	}
+	defer p.Shutdown()
+	stub := &stubPrompter{answers: map[string]string{"new.example.com": "deny"}}
+	p.SetPrompter(stub)
+
+	// Without monitor mode only "log" domains go through on probation
+	if got := p.checkDomain("telemetry.example.com", "443", ""); !got.IsAllowed() {
+		t.Errorf("log domain = %q, want allowed", got)
+	}
+	if got := p.checkDomain("blocked.example.com", "443", ""); got.IsAllowed() {
+		t.Errorf("never domain = %q, want denied outside monitor mode", got)
+	}
+	if got := strings.Join(p.WouldDeny(), ","); got != "telemetry.example.com" {
+		t.Errorf("WouldDeny = %s, want the log domain", got)
+	}
+
+	p.SetMonitor(true)
+	for _, domain := range []string{"ok.example.com", "blocked.example.com", "unknown.example.com"} {
+		if got := p.checkDomain(domain, "443", ""); !got.IsAllowed() {
+			t.Errorf("checkDomain(%s) = %q in monitor mode, want allowed", domain, got)
+		}
+	}
+	if len(stub.asked) != 0 {
+		t.Errorf("monitor mode prompted for %d domains, want none", len(stub.asked))
+	}
+	want := "blocked.example.com,telemetry.example.com,unknown.example.com"
+	if got := strings.Join(p.WouldDeny(), ","); got != want {
+		t.Errorf("WouldDeny = %s, want %s", got, want)
+	}
+	if got := p.Domains()["blocked.example.com"]; got != "never" {
+		t.Errorf("saved decision became %q, want it kept as never", got)
+	}
+}
+
+// stallingPrompter answers domains it knows at once and never answers
+// the others, like a terminal nobody is watching.
+type stallingPrompter struct {
+	answers map[string]string
+	asked   atomic.Int32
+}
+
+func (s *stallingPrompter) Ask(req PromptRequest) (string, error) {
+	s.asked.Add(1)
+	if answer, ok := s.answers[req.Domain]; ok {
+		return answer, nil
+	}
+	select {}
+}
+
+func TestPromptWatchdogDeniesUnattended(t *testing.T) {
+	p, err := NewProxy(map[string]string{"saved.example.com": "always"}, "test")
+	if err != nil {
+		t.Fatalf("NewProxy failed: %v", err)
+	}
+	defer p.Shutdown()
+
+	stub := &stallingPrompter{answers: map[string]string{"answered.example.com": "allow"}}
+	p.SetPrompter(stub)
+	p.SetPromptWatchdog(100 * time.Millisecond)
+
+	// Answered in time: the watchdog stays quiet
+	if got := p.checkDomain("answered.example.com", "443", ""); got != DecisionAllow {
+		t.Errorf("answered prompt = %q, want allow", got)
+	}
+
+	start := time.Now()
+	if got := p.checkDomain("stalled.example.com", "443", ""); got != DecisionDeny {
+		t.Errorf("unanswered prompt = %q, want deny", got)
+	}
+	if waited := time.Since(start); waited > 2*time.Second {
+		t.Errorf("unanswered prompt took %s to deny", waited)
+	}
+
+	// Tripped: new domains are denied without asking, saved ones still apply
+	asked := stub.asked.Load()
+	if got := p.checkDomain("later.example.com", "443", ""); got != DecisionDeny {
+		t.Errorf("domain after the watchdog tripped = %q, want deny", got)
+	}
+	if got := p.checkDomain("saved.example.com", "443", ""); got != DecisionAlways {
+		t.Errorf("saved domain = %q, want always", got)
+	}
+	if stub.asked.Load() != asked {
+		t.Error("prompted again after the watchdog tripped")
+	}
+}
+
+// whoisPrompter looks the domain up, as the [w]hois option does, and
+// then allows it.
+type whoisPrompter struct{}
+
+func (whoisPrompter) Ask(req PromptRequest) (string, error) {
+	req.Info(io.Discard)
+	req.Whois(io.Discard)
+	return "allow", nil
+}
+
+func TestPromptLeavesKnownDomainsAlone(t *testing.T) {
+	p, err := NewProxy(map[string]string{"saved.example.com": "allow"}, "test")
+	if err != nil {
+		t.Fatalf("NewProxy failed: %v", err)
+	}
+	defer p.Shutdown()
+
+	looking := make(chan struct{})
+	release := make(chan struct{})
+	p.whois = func(ctx context.Context, domain string) (string, error) {
+		close(looking)
+		<-release
+		return "", nil
+	}
+	p.SetPrompter(whoisPrompter{})
+
+	asked := make(chan Decision, 1)
+	go func() { asked <- p.checkDomain("new.example.com", "443", "") }()
+	<-looking
+
+	// A slow whois lookup holds up only the prompt it belongs to
+	known := make(chan Decision, 1)
+	go func() { known <- p.checkDomain("saved.example.com", "443", "") }()
+	select {
+	case got := <-known:
+		if got != DecisionAllow {
+			t.Errorf("saved domain = %q, want allow", got)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("a known domain waited for another domain's prompt")
+	}
+
+	close(release)
+	if got := <-asked; got != DecisionAllow {
+		t.Errorf("prompted domain = %q, want allow", got)
+	}
+}
+
+func TestTTYPrompterAnswers(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"a\n", "allow"},
+		{"deny\n", "deny"},
+		{"l\n", "always"},
+		{"never\n", "never"},
+		{"o\n", "session"},
+		{"bogus\n", "deny"},
+		{"i\nl\n", "always"},
+		{"w\nn\n", "never"},
+		{"A\n", "allow"},
+		{"allow-rest\n", "allow-rest"},
+	}
+
+	for _, tt := range tests {
+		mockR, mockW, _ := createPipePair()
+		go func() {
+			fmt.Fprint(mockW, tt.input)
+		}()
+
+		infoShown := false
+		prompter := &ttyPrompter{tty: mockR}
+		got, err := prompter.Ask(PromptRequest{
+			Command: "test",
+			Domain:  "example.com",
+			Info:    func(w io.Writer) { infoShown = true },
+		})
+		mockR.Close()
+		mockW.Close()
+
+		if err != nil {
+			t.Errorf("Ask(%q) returned error: %v", tt.input, err)
+		}
+		if got != tt.expected {
+			t.Errorf("Ask(%q) = %q, want %q", tt.input, got, tt.expected)
+		}
+		if strings.HasPrefix(tt.input, "i\n") && !infoShown {
+			t.Errorf("Ask(%q) should have shown info", tt.input)
+		}
+	}
+}
+
+func TestTTYPrompterOptions(t *testing.T) {
+	runOnly := []string{"allow", "deny"}
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"a\n", "allow"},
+		{"d\n", "deny"},
+		{"l\n", "deny"}, // always is hidden
+		{"never\n", "deny"},
+		{"allow-rest\n", "deny"},
+		{"i\na\n", "allow"},
+	}
+
+	for _, tt := range tests {
+		mockR, mockW, _ := createPipePair()
+		go func() {
+			fmt.Fprint(mockW, tt.input)
+		}()
+
+		got, err := (&ttyPrompter{tty: mockR}).Ask(PromptRequest{Command: "test", Domain: "example.com", Options: runOnly})
+		mockR.Close()
+		mockW.Close()
+
+		if err != nil || got != tt.expected {
+			t.Errorf("Ask(%q) = %q, %v; want %q", tt.input, got, err, tt.expected)
+		}
+	}
+
+	menu := ttyMenu(PromptRequest{Options: runOnly})
+	if strings.Contains(menu, "a[l]ways") || strings.Contains(menu, "[n]ever") || strings.Contains(menu, "allow-rest") {
+		t.Errorf("menu offers hidden options: %q", menu)
+	}
+	if !strings.Contains(menu, "[a]llow  [d]eny  [w]hois  [i]nfo") {
+		t.Errorf("menu = %q, want allow, deny, whois and info", menu)
+	}
+}
+
+func TestProxyPromptOptions(t *testing.T) {
+	p, err := NewProxy(nil, "test")
+	if err != nil {
+		t.Fatalf("NewProxy failed: %v", err)
+	}
+	defer p.Shutdown()
+
+	if err := p.SetPromptOptions([]string{"allow", "forever"}); err == nil {
+		t.Error("SetPromptOptions accepted an unknown option")
+	}
+	if err := p.SetPromptOptions([]string{"allow", "deny"}); err != nil {
+		t.Fatalf("SetPromptOptions failed: %v", err)
+	}
+
+	// A prompter that ignores Options still can't save a decision
+	stub := &stubPrompter{answers: map[string]string{"saved.example.com": "always", "run.example.com": "allow"}}
+	p.SetPrompter(stub)
+	if got := p.checkDomain("saved.example.com", "443", ""); got != DecisionDeny {
+		t.Errorf("hidden answer gave %q, want deny", got)
+	}
+	if got := p.checkDomain("run.example.com", "443", ""); got != DecisionAllow {
+		t.Errorf("offered answer gave %q, want allow", got)
+	}
+	if opts := stub.asked[0].Options; strings.Join(opts, ",") != "allow,deny" {
+		t.Errorf("prompt request Options = %v, want [allow deny]", opts)
+	}
+}
+
+func TestPromptRequestTarget(t *testing.T) {
+	tests := []struct {
+		req  PromptRequest
+		want string
+	}{
+		{PromptRequest{Domain: "registry.npmjs.org", Port: "80", URL: "http://registry.npmjs.org/express"}, "http://registry.npmjs.org/express"},
+		{PromptRequest{Domain: "registry.npmjs.org", Port: "443"}, "registry.npmjs.org:443"},
+		{PromptRequest{Domain: "2001:db8::1", Port: "443"}, "[2001:db8::1]:443"},
+		{PromptRequest{Domain: "example.com"}, "example.com"},
+	}
+	for _, tt := range tests {
+		if got := tt.req.target(); got != tt.want {
+			t.Errorf("target() of %+v = %q, want %q", tt.req, got, tt.want)
+		}
+	}
+
+	script := dialogScript(PromptRequest{Command: "npm", Domain: "telemetry.example", Port: "80", URL: "http://telemetry.example/collect"})
+	if !strings.Contains(script, "connect to http://telemetry.example/collect?") {
+		t.Errorf("dialog should show the full URL: %s", script)
+	}
+}
+
+// groupStub answers every group with answer and records what it was asked.
+type groupStub struct {
+	stubPrompter
+	answer string
+	groups [][]PromptRequest
+}
+
+func (g *groupStub) AskGroup(reqs []PromptRequest) ([]string, error) {
+	g.groups = append(g.groups, reqs)
+	answers := make([]string, len(reqs))
+	for i := range answers {
+		answers[i] = g.answer
+	}
+	return answers, nil
+}
+
+// checkConcurrently asks p about every domain at once, as a tool fanning
+// out to several hosts would, and returns the decisions by domain.
+func checkConcurrently(p *NetworkProxy, domains ...string) map[string]Decision {
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	decisions := make(map[string]Decision)
+	for _, domain := range domains {
+		wg.Add(1)
+		go func(domain string) {
+			defer wg.Done()
+			d := p.checkDomain(domain, "443", "")
+			mu.Lock()
+			decisions[domain] = d
+			mu.Unlock()
+		}(domain)
+	}
+	wg.Wait()
+	return decisions
+}
+
+func TestPromptGroup(t *testing.T) {
+	p, err := NewProxy(nil, "pip install")
+	if err != nil {
+		t.Fatalf("NewProxy failed: %v", err)
+	}
+	defer p.Shutdown()
+	stub := &groupStub{answer: "allow"}
+	p.SetPrompter(stub)
+	p.SetPromptGroup(200 * time.Millisecond)
+
+	hosts := []string{"pypi.org", "files.pythonhosted.org", "cdn.example.com"}
+	decisions := checkConcurrently(p, append(hosts, "pypi.org")...)
+
+	if len(stub.groups) != 1 || len(stub.groups[0]) != 3 {
+		t.Fatalf("expected one grouped prompt with 3 hosts, got %v", stub.groups)
+	}
+	if len(stub.asked) != 0 {
+		t.Errorf("expected no single-host prompts, got %d", len(stub.asked))
+	}
+	for _, host := range hosts {
+		if decisions[host] != DecisionAllow || p.Domains()[host] != "allow" {
+			t.Errorf("%s: decision %q, cached %q; want allow", host, decisions[host], p.Domains()[host])
+		}
+	}
+
+	// Decided hosts don't start a new group
+	if got := p.checkDomain("pypi.org", "443", ""); got != DecisionAllow || len(stub.groups) != 1 {
+		t.Errorf("known host re-prompted: %q after %d groups", got, len(stub.groups))
+	}
+}
+
+func TestPromptGroupFallsBackToSingle(t *testing.T) {
+	p, err := NewProxy(nil, "test")
+	if err != nil {
+		t.Fatalf("NewProxy failed: %v", err)
+	}
+	defer p.Shutdown()
+	stub := &stubPrompter{answers: map[string]string{"a.example.com": "allow"}}
+	p.SetPrompter(stub)
+	p.SetPromptGroup(100 * time.Millisecond)
+
+	decisions := checkConcurrently(p, "a.example.com", "b.example.com")
+	if len(stub.asked) != 2 {
+		t.Errorf("expected one prompt per host without AskGroup, got %d", len(stub.asked))
+	}
+	if decisions["a.example.com"] != DecisionAllow || decisions["b.example.com"] != "" {
+		t.Errorf("unexpected decisions %v", decisions)
+	}
+}
+
+func TestTTYPrompterAskGroup(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		{"a\n", []string{"allow", "allow"}},
+		{"deny\n", []string{"deny", "deny"}},
+		{"e\nl\nd\n", []string{"always", "deny"}},
+		{"bogus\n", []string{"deny", "deny"}},
+	}
+
+	reqs := []PromptRequest{
+		{Command: "pip", Domain: "pypi.org", Port: "443"},
+		{Command: "pip", Domain: "files.pythonhosted.org", Port: "443"},
+	}
+	for _, tt := range tests {
+		mockR, mockW, _ := createPipePair()
+		go func() {
+			fmt.Fprint(mockW, tt.input)
+		}()
+
+		got, err := (&ttyPrompter{tty: mockR}).AskGroup(reqs)
+		mockR.Close()
+		mockW.Close()
+
+		if err != nil {
+			t.Errorf("AskGroup(%q) returned error: %v", tt.input, err)
+		}
+		if strings.Join(got, ",") != strings.Join(tt.expected, ",") {
+			t.Errorf("AskGroup(%q) = %v, want %v", tt.input, got, tt.expected)
+		}
+	}
+}
+
+func TestDialogPrompterChoices(t *testing.T) {
+	tests := []struct {
+		output   string
+		expected string
+	}{
+		{"Allow", "allow"},
+		{"Deny", "deny"},
+		{"Always", "always"},
+		{"Never", "never"},
+		{"false", "deny"}, // cancelled
+		{"???", "deny"},
+	}
+
+	for _, tt := range tests {
+		d := &DialogPrompter{
+			timeout:  time.Second,
+			fallback: DenyPrompter{},
+			run: func(ctx context.Context, script string) (string, error) {
+				return tt.output, nil
+			},
+		}
+		got, err := d.Ask(PromptRequest{Command: "make", Domain: "example.com", Port: "443"})
+		if err != nil {
+			t.Errorf("Ask returned error for %q: %v", tt.output, err)
+		}
+		if got != tt.expected {
+			t.Errorf("dialog output %q = %q, want %q", tt.output, got, tt.expected)
+		}
+	}
+}
+
+func TestDialogPrompterTimeoutDenies(t *testing.T) {
+	fallback := &stubPrompter{answers: map[string]string{"example.com": "allow"}}
+	d := &DialogPrompter{
+		timeout:  50 * time.Millisecond,
+		fallback: fallback,
+		run: func(ctx context.Context, script string) (string, error) {
+			<-ctx.Done()
+			return "", ctx.Err()
+		},
+	}
+
+	got, _ := d.Ask(PromptRequest{Domain: "example.com"})
+	if got != "deny" {
+		t.Errorf("expected timeout to deny, got %q", got)
+	}
+	if len(fallback.asked) != 0 {
+		t.Error("timeout should deny, not fall back to the terminal")
+	}
+}
+
+func TestDialogPrompterFallsBack(t *testing.T) {
+	fallback := &stubPrompter{answers: map[string]string{"example.com": "always"}}
+	d := &DialogPrompter{
+		timeout:  time.Second,
+		fallback: fallback,
+		run: func(ctx context.Context, script string) (string, error) {
+			return "", errors.New("osascript: not found")
+		},
+	}
+
+	got, _ := d.Ask(PromptRequest{Domain: "example.com"})
+	if got != "always" {
+		t.Errorf("expected fallback decision 'always', got %q", got)
+	}
+	if len(fallback.asked) != 1 {
+		t.Errorf("expected fallback to be asked once, got %d", len(fallback.asked))
+	}
+}
+
+func TestDialogScriptOptions(t *testing.T) {
+	script := dialogScript(PromptRequest{Domain: "example.com", Options: []string{"allow"}})
+	if !strings.Contains(script, `choose from list {"Allow", "Deny"}`) {
+		t.Errorf("dialog should list only Allow and Deny: %s", script)
+	}
+}
+
+func TestDialogScriptEscapes(t *testing.T) {
+	script := dialogScript(PromptRequest{Command: `sh -c "echo \ hi"`, Domain: "example.com", Port: "443"})
+
+	if !strings.Contains(script, `sh -c \"echo \\ hi\" to connect to example.com:443`) {
+		t.Errorf("command not escaped in script: %s", script)
+	}
+	for _, choice := range []string{"Allow", "Deny", "Always", "Never"} {
+		if !strings.Contains(script, `"`+choice+`"`) {
+			t.Errorf("script missing choice %q: %s", choice, script)
+		}
+	}
+}
@@ -1,26 +1,97 @@
 package cmd
 
 import (
-	"bufio"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net"
 	"net/http"
+	"net/http/httptrace"
+	"net/url"
 	"os"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
+// proxyShutdownTimeout bounds how long StartContext waits for in-flight
+// requests to finish after its context is cancelled.
+const proxyShutdownTimeout = 5 * time.Second
+
+// maxRecentPrompts bounds how many past prompts the [i]nfo view shows.
+const maxRecentPrompts = 5
+
+// promptGroupWindow is how long --group-prompts collects new domains
+// before asking. Tools that fan out to a host family request them within
+// milliseconds of each other.
+const promptGroupWindow = 300 * time.Millisecond
+
+// promptRecord is one answered prompt, kept for the [i]nfo view.
+type promptRecord struct {
+	domain   string
+	decision string
+}
+
+// AuditConfig configures the proxy's audit log: one timestamped line per
+// connection decision, rotated by size so long sessions stay bounded.
+type AuditConfig struct {
+	Path     string // file to append to
+	MaxSize  int64  // rotate once the file would pass this many bytes; 0 never rotates
+	MaxFiles int    // rotated files to keep as Path.1 ... Path.N
+}
+
+// dialFunc opens a connection like net.Dial.
+type dialFunc func(network, address string) (net.Conn, error)
+
 // NetworkProxy is a local HTTP/CONNECT proxy that prompts the user
-// before allowing connections to new domains. It reads input from
-// /dev/tty so it doesn't conflict with the sandboxed process's stdin.
+// before allowing connections to new domains. By default it asks on
+// /dev/tty so it doesn't conflict with the sandboxed process's stdin;
+// SetPrompter swaps in another decision source.
 type NetworkProxy struct {
-	listener net.Listener
-	server   *http.Server
-	domains  map[string]string // domain -> "allow" or "deny"
-	mu       sync.Mutex
-	tty      *os.File // /dev/tty for interactive prompts
-	cmdName  string   // command name for prompt display
+	listener      net.Listener
+	server        *http.Server
+	domains       map[string]string // domain -> "allow" or "deny"
+	mu            sync.Mutex
+	promptMu      sync.Mutex                  // held while asking about a new domain, so one is asked at a time; taken before mu
+	prompter      Prompter                    // asked about domains not in domains
+	cmdName       string                      // command name for prompt display
+	attempts      map[string]int              // domain -> connection attempts this run
+	recent        []promptRecord              // most recent prompts, oldest first
+	httpLog       io.Writer                   // receives one line per forwarded plain HTTP request
+	whois         whoisLookup                 // backs the [w]hois prompt option
+	dial          dialFunc                    // opens upstream connections
+	dns           *dnsCache                   // resolutions reused for the run by dialGuarded
+	transport     *http.Transport             // forwards plain HTTP, dialing through dialGuarded
+	blocked       []*net.IPNet                // ranges refused unless allowlisted (metadata, link-local)
+	preset        map[string]bool             // domains the config allows explicitly
+	https         map[string]bool             // hosts limited to HTTPS on port 443
+	strip         []string                    // request headers removed before forwarding
+	pins          map[string]string           // host -> pinned certificate SHA-256 (hex)
+	rewrite       map[string]string           // requested host -> upstream host[:port] to dial
+	strictSNI     bool                        // tunnels to hostnames must carry a matching TLS server name
+	audit         io.Writer                   // if set, allow everything and log what policy would prompt or deny
+	auditLog      *rotatingWriter             // receives one line per connection decision
+	denied        map[string]bool             // domains refused at least once this run
+	monitor       bool                        // allow unknown and denied domains unasked, recording them in wouldDeny
+	wouldDeny     map[string]bool             // domains let through that a strict policy would refuse
+	group         time.Duration               // collect new domains for this long into one prompt; 0 asks one by one
+	decider       Decider                     // consulted before prompting, if set
+	pending       *promptGroup                // new domains still being collected, nil if none
+	allowRest     bool                        // answered allow-all-rest: new domains are allowed unasked
+	watchdog      time.Duration               // deny everything new once a prompt goes unanswered this long; 0 waits forever
+	unattended    bool                        // the watchdog tripped: new domains are denied unasked
+	promptOptions []string                    // decisions prompts may offer; nil offers all
+	metrics       proxyMetrics                // counters behind Stats and ServeMetrics
+	metricsServer atomic.Pointer[http.Server] // serves /metrics, if ServeMetrics was called
+	active        sync.WaitGroup              // requests and tunnels being handled
+	tunnelMu      sync.Mutex                  // guards tunnels; separate from mu
+	tunnels       map[io.Closer]bool          // connections of hijacked tunnels, cut off by Shutdown
+	closing       bool                        // Shutdown has run; new tunnels are closed at once
+	done          chan struct{}               // closed when Serve returns
+	serveErr      error                       // Serve's error, nil on clean shutdown
 }
 
 // NewProxy creates a proxy listening on 127.0.0.1:0 (random port).
@@ -33,14 +104,32 @@ func NewProxy(domains map[string]string, cmdName string) (*NetworkProxy, error)
 	}
 
 	p := &NetworkProxy{
-		listener: ln,
-		domains:  make(map[string]string),
-		cmdName:  cmdName,
+		listener:  ln,
+		domains:   make(map[string]string),
+		prompter:  &ttyPrompter{},
+		cmdName:   cmdName,
+		attempts:  make(map[string]int),
+		whois:     lookupWhois,
+		dial:      net.Dial,
+		dns:       newDNSCache(),
+		preset:    make(map[string]bool),
+		denied:    make(map[string]bool),
+		wouldDeny: make(map[string]bool),
+		done:      make(chan struct{}),
 	}
+	p.blocked, _ = parseBlockedNets(defaultBlockedNets)
+	p.transport = http.DefaultTransport.(*http.Transport).Clone()
+	p.transport.DialContext = p.dialGuarded
+	// Pass Content-Encoding through as upstream sent it instead of asking
+	// for gzip and decoding it on the client's behalf
+	p.transport.DisableCompression = true
 
 	// Copy pre-cached domains
 	for k, v := range domains {
 		p.domains[k] = v
+		if Decision(v).IsAllowed() {
+			p.preset[k] = true
+		}
 	}
 
 	p.server = &http.Server{Handler: p}
@@ -50,7 +139,47 @@ func NewProxy(domains map[string]string, cmdName string) (*NetworkProxy, error)
 
 // Start begins serving proxy connections in a background goroutine.
 func (p *NetworkProxy) Start() {
-	go p.server.Serve(p.listener)
+	p.StartContext(context.Background())
+}
+
+// StartContext is like Start, but gracefully shuts the server down once
+// ctx is cancelled. Done and Err report when and how serving ended.
+func (p *NetworkProxy) StartContext(ctx context.Context) {
+	go func() {
+		err := p.server.Serve(p.listener)
+		if errors.Is(err, http.ErrServerClosed) {
+			err = nil
+		}
+		p.mu.Lock()
+		p.serveErr = err
+		p.mu.Unlock()
+		close(p.done)
+	}()
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), proxyShutdownTimeout)
+			defer cancel()
+			if err := p.server.Shutdown(shutdownCtx); err != nil {
+				p.server.Close()
+			}
+		case <-p.done:
+		}
+	}()
+}
+
+// Done returns a channel that is closed when the proxy stops serving.
+func (p *NetworkProxy) Done() <-chan struct{} {
+	return p.done
+}
+
+// Err returns the error that stopped the server, or nil while it is
+// still running or after a clean shutdown.
+func (p *NetworkProxy) Err() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.serveErr
 }
 
 // Addr returns the proxy's listen address as "127.0.0.1:PORT".
@@ -69,17 +198,246 @@ func (p *NetworkProxy) Domains() map[string]string {
 	return result
 }
 
-// Shutdown closes the proxy listener and server.
+// DeniedDomains returns the distinct domains the proxy refused during this
+// run, whether by the user, the config or a built-in block, sorted.
+func (p *NetworkProxy) DeniedDomains() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return sortedKeys(p.denied)
+}
+
+// WouldDeny returns the distinct domains let through with a "log"
+// decision or in monitor mode that a strict policy would have refused,
+// sorted.
+func (p *NetworkProxy) WouldDeny() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return sortedKeys(p.wouldDeny)
+}
+
+// SetMonitor puts the proxy in monitor mode, for trying a tighter policy
+// against real workloads: domains that aren't allowed, whether unknown or
+// denied by a saved decision, are let through without a prompt and
+// reported by WouldDeny. Unlike audit mode only the network is affected.
+func (p *NetworkProxy) SetMonitor(on bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.monitor = on
+}
+
+// SetPrompter replaces the source of decisions for unknown domains.
+// Passing nil restores the default /dev/tty prompt.
+func (p *NetworkProxy) SetPrompter(prompter Prompter) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if prompter == nil {
+		prompter = &ttyPrompter{}
+	}
+	p.prompter = prompter
+}
+
+// SetBlockedNets replaces the address ranges (CIDRs or single IPs) the
+// proxy refuses unless the config allowlists a host explicitly. The
+// default covers link-local and cloud metadata addresses. An empty list
+// turns the check off.
+func (p *NetworkProxy) SetBlockedNets(entries []string) error {
+	nets, err := parseBlockedNets(entries)
+	if err != nil {
+		return err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.blocked = nets
+	return nil
+}
+
+// SetHTTPSOnly limits hosts to CONNECT on port 443: plain HTTP requests
+// to them are refused without prompting, whatever their decision.
+func (p *NetworkProxy) SetHTTPSOnly(hosts []string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.https = make(map[string]bool, len(hosts))
+	for _, h := range hosts {
+		p.https[h] = true
+	}
+}
+
+// httpsOnly reports whether domain was limited by SetHTTPSOnly.
+func (p *NetworkProxy) httpsOnly(domain string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.https[domain]
+}
+
+// SetStripHeaders makes the proxy remove the named headers (matched
+// case-insensitively) from plain HTTP requests before forwarding them.
+// CONNECT tunnels carry opaque TLS, so their headers can't be touched.
+func (p *NetworkProxy) SetStripHeaders(headers []string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.strip = append([]string(nil), headers...)
+}
+
+// stripHeaders removes the headers set by SetStripHeaders from h.
+func (p *NetworkProxy) stripHeaders(h http.Header) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, name := range p.strip {
+		h.Del(name)
+	}
+}
+
+// SetAudit puts the proxy in audit mode: domains that would be prompted
+// for or denied are allowed instead, and a line is written to w the
+// first time each is seen. Passing nil restores enforcement.
+func (p *NetworkProxy) SetAudit(w io.Writer) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.audit = w
+}
+
+// SetAuditLog starts appending a line per connection decision to the file
+// in cfg, replacing any earlier audit log. The file is closed by Shutdown.
+func (p *NetworkProxy) SetAuditLog(cfg AuditConfig) error {
+	w, err := newRotatingWriter(cfg.Path, cfg.MaxSize, cfg.MaxFiles)
+	if err != nil {
+		return fmt.Errorf("failed to open audit log: %w", err)
+	}
+	p.mu.Lock()
+	old := p.auditLog
+	p.auditLog = w
+	p.mu.Unlock()
+	if old != nil {
+		old.Close()
+	}
+	return nil
+}
+
+// noteDecision records a connection decision: denied domains are kept for
+// DeniedDomains, and every decision goes to the audit log if one is set.
+func (p *NetworkProxy) noteDecision(kind, domain, port, verdict string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if Decision(verdict).IsAllowed() {
+		p.metrics.allowed.Add(1)
+	} else {
+		p.metrics.denied.Add(1)
+		p.denied[domain] = true
+	}
+	if p.auditLog == nil {
+		return
+	}
+	fmt.Fprintf(p.auditLog, "%s %s %s %s\n",
+		time.Now().UTC().Format(time.RFC3339), kind, net.JoinHostPort(domain, port), verdict)
+}
+
+// SetHTTPLog makes the proxy append a "method host path -> status" line
+// to w for every plain HTTP request it forwards. HTTPS goes through
+// CONNECT as opaque TLS, so only the domain of those is ever known.
+// Passing nil disables logging.
+func (p *NetworkProxy) SetHTTPLog(w io.Writer) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.httpLog = w
+}
+
+// logHTTP records a forwarded plain HTTP request if an HTTP log is set.
+// The query string is left out since it often carries tokens.
+func (p *NetworkProxy) logHTTP(r *http.Request, status int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.httpLog == nil {
+		return
+	}
+	path := r.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	fmt.Fprintf(p.httpLog, "%s %s %s -> %d\n", r.Method, r.Host, redactSecrets(path), status)
+}
+
+// Shutdown closes the proxy listener and server, cutting off requests
+// and tunnels in flight.
 func (p *NetworkProxy) Shutdown() {
-	if p.tty != nil {
-		p.tty.Close()
+	if closer, ok := p.prompter.(io.Closer); ok {
+		closer.Close()
 	}
 	p.server.Close()
 	p.listener.Close()
+	p.tunnelMu.Lock()
+	p.closing = true
+	for conn := range p.tunnels {
+		conn.Close()
+	}
+	p.tunnelMu.Unlock()
+	// Not under p.mu, which a pending prompt may hold
+	if srv := p.metricsServer.Load(); srv != nil {
+		srv.Close()
+	}
+	p.transport.CloseIdleConnections()
+	// noteDecision writes under p.mu; a request finishing late then finds
+	// no log rather than a closed one
+	p.mu.Lock()
+	if p.auditLog != nil {
+		p.auditLog.Close()
+		p.auditLog = nil
+	}
+	p.mu.Unlock()
+}
+
+// ShutdownContext drains the proxy: it stops accepting connections,
+// waits for requests and tunnels in flight to finish, then shuts down
+// like Shutdown. If ctx ends first, whatever is left is cut off and ctx's
+// error is returned. Use it when the proxy outlives the commands it
+// serves, so a download isn't cut off mid-transfer.
+func (p *NetworkProxy) ShutdownContext(ctx context.Context) error {
+	// Once server.Shutdown returns, only hijacked tunnels are left, and
+	// they were counted in p.active before they were hijacked
+	err := p.server.Shutdown(ctx)
+	if err == nil {
+		drained := make(chan struct{})
+		go func() {
+			p.active.Wait()
+			close(drained)
+		}()
+		select {
+		case <-drained:
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+	}
+	p.Shutdown()
+	return err
+}
+
+// trackTunnel registers the connections of a hijacked tunnel so Shutdown
+// can cut them off. The returned func unregisters them.
+func (p *NetworkProxy) trackTunnel(conns ...io.Closer) func() {
+	p.tunnelMu.Lock()
+	defer p.tunnelMu.Unlock()
+	if p.tunnels == nil {
+		p.tunnels = make(map[io.Closer]bool)
+	}
+	for _, conn := range conns {
+		if p.closing {
+			conn.Close()
+		}
+		p.tunnels[conn] = true
+	}
+	return func() {
+		p.tunnelMu.Lock()
+		defer p.tunnelMu.Unlock()
+		for _, conn := range conns {
+			delete(p.tunnels, conn)
+		}
+	}
 }
 
 // ServeHTTP dispatches CONNECT (HTTPS) vs regular HTTP requests.
 func (p *NetworkProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p.active.Add(1)
+	defer p.active.Done()
+	p.metrics.connections.Add(1)
 	if r.Method == http.MethodConnect {
 		p.handleCONNECT(w, r)
 	} else {
@@ -89,18 +447,52 @@ func (p *NetworkProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 // handleCONNECT handles HTTPS proxy requests (CONNECT method).
 func (p *NetworkProxy) handleCONNECT(w http.ResponseWriter, r *http.Request) {
-	domain := stripPort(r.Host)
+	domain, port := splitHostPort(r.Host, "443")
 
-	decision := p.checkDomain(domain)
-	if !isAllowed(decision) {
-		http.Error(w, "ddash: connection blocked", http.StatusForbidden)
+	if port != "443" && p.httpsOnly(domain) {
+		p.noteDecision("CONNECT", domain, port, "blocked (https only)")
+		writeBlocked(w, domain, fmt.Sprintf("%s is allowed over HTTPS (port 443) only", domain),
+			fmt.Sprintf("connect on port 443, or list %q without https:// in allow_net", domain))
 		return
 	}
 
-	// Dial the target
-	targetConn, err := net.Dial("tcp", r.Host)
+	if p.blockedLiteral(domain, port) {
+		p.noteDecision("CONNECT", domain, port, "blocked (blocked_nets)")
+		writeBlockedAddr(w, domain, fmt.Sprintf("%s is a link-local or cloud metadata address", domain))
+		return
+	}
+
+	start := time.Now()
+	decision := p.checkDomain(domain, port, "")
+	p.metrics.observe(decision.IsAllowed(), time.Since(start))
+	if !decision.IsAllowed() {
+		p.noteDecision("CONNECT", domain, port, string(decision))
+		writeDenied(w, domain, decision)
+		return
+	}
+	// An allowed tunnel is noted once, after the checks below that may
+	// still refuse it, so a refusal isn't counted as an allow as well
+
+	// Dial the target. JoinHostPort re-brackets IPv6 literals and adds
+	// the default port when the client left it out.
+	target := net.JoinHostPort(domain, port)
+	targetConn, err := p.dialGuarded(r.Context(), "tcp", target)
+	var blockedErr *blockedAddrError
+	if errors.As(err, &blockedErr) {
+		p.noteDecision("CONNECT", domain, port, "blocked (blocked_nets)")
+		writeBlockedAddr(w, domain, blockedErr.Error())
+		return
+	}
 	if err != nil {
-		http.Error(w, fmt.Sprintf("ddash: failed to connect to %s: %v", r.Host, err), http.StatusBadGateway)
+		p.noteDecision("CONNECT", domain, port, string(decision))
+		http.Error(w, redactSecrets(fmt.Sprintf("ddash: failed to connect to %s: %v", target, err)), http.StatusBadGateway)
+		return
+	}
+	if err := p.checkPin(domain, targetConn.RemoteAddr().String()); err != nil {
+		targetConn.Close()
+		p.noteDecision("CONNECT", domain, port, "blocked (pin_net)")
+		writeBlocked(w, domain, err.Error(),
+			fmt.Sprintf("if the certificate was rotated legitimately, update the pin_net entry for %q", domain))
 		return
 	}
 
@@ -108,127 +500,699 @@ func (p *NetworkProxy) handleCONNECT(w http.ResponseWriter, r *http.Request) {
 	hijacker, ok := w.(http.Hijacker)
 	if !ok {
 		targetConn.Close()
+		p.noteDecision("CONNECT", domain, port, string(decision))
 		http.Error(w, "ddash: hijacking not supported", http.StatusInternalServerError)
 		return
 	}
 
-	clientConn, _, err := hijacker.Hijack()
+	clientConn, clientBuf, err := hijacker.Hijack()
 	if err != nil {
 		targetConn.Close()
+		p.noteDecision("CONNECT", domain, port, string(decision))
 		http.Error(w, fmt.Sprintf("ddash: hijack failed: %v", err), http.StatusInternalServerError)
 		return
 	}
 
+	defer p.trackTunnel(clientConn, targetConn)()
+
 	// Send 200 Connection Established
 	clientConn.Write([]byte("HTTP/1.1 200 Connection Established\r\n\r\n"))
 
-	// Bidirectional tunnel
-	go func() {
-		io.Copy(targetConn, clientConn)
+	// The approval covers domain only: refuse a ClientHello that names
+	// another server, which could smuggle traffic under an approved name
+	peeked, refused := p.checkTunnelSNI(domain, clientConn, clientBuf)
+	if refused != "" {
+		p.noteDecision("CONNECT", domain, port, refused)
+		clientConn.Close()
 		targetConn.Close()
-	}()
+		return
+	}
+	p.noteDecision("CONNECT", domain, port, string(decision))
+	if len(peeked) > 0 {
+		if _, err := targetConn.Write(peeked); err != nil {
+			clientConn.Close()
+			targetConn.Close()
+			return
+		}
+		p.metrics.bytesSent.Add(int64(len(peeked)))
+	}
+
+	// Bidirectional tunnel. clientBuf may hold bytes read past the
+	// ClientHello. The handler stays until both directions are done, so
+	// ShutdownContext can wait for the tunnel.
+	done := make(chan struct{})
 	go func() {
-		io.Copy(clientConn, targetConn)
-		clientConn.Close()
+		n, _ := io.Copy(targetConn, clientBuf)
+		p.metrics.bytesSent.Add(n)
+		targetConn.Close()
+		close(done)
 	}()
+	n, _ := io.Copy(clientConn, targetConn)
+	p.metrics.bytesReceived.Add(n)
+	clientConn.Close()
+	<-done
 }
 
 // handleHTTP handles plain HTTP proxy requests (non-CONNECT).
 func (p *NetworkProxy) handleHTTP(w http.ResponseWriter, r *http.Request) {
-	domain := stripPort(r.Host)
+	domain, port := splitHostPort(r.Host, "80")
 
-	decision := p.checkDomain(domain)
-	if !isAllowed(decision) {
-		http.Error(w, "ddash: connection blocked", http.StatusForbidden)
+	if p.httpsOnly(domain) {
+		p.noteDecision("HTTP", domain, port, "blocked (https only)")
+		writeBlocked(w, domain, fmt.Sprintf("plain HTTP to %s blocked (allowed over HTTPS only)", domain),
+			fmt.Sprintf("use https://, or list %q without https:// in allow_net", domain))
 		return
 	}
 
-	// Forward the request
-	outReq, err := http.NewRequest(r.Method, r.URL.String(), r.Body)
+	if _, ok := p.pinned(domain); ok {
+		p.noteDecision("HTTP", domain, port, "blocked (pin_net)")
+		writeBlocked(w, domain, fmt.Sprintf("plain HTTP to %s blocked (certificate pinned in pin_net)", domain),
+			"use https://, or remove the pin_net entry")
+		return
+	}
+
+	if p.blockedLiteral(domain, port) {
+		p.noteDecision("HTTP", domain, port, "blocked (blocked_nets)")
+		writeBlockedAddr(w, domain, fmt.Sprintf("%s is a link-local or cloud metadata address", domain))
+		return
+	}
+
+	start := time.Now()
+	decision := p.checkDomain(domain, port, redactSecrets(r.URL.String()))
+	p.metrics.observe(decision.IsAllowed(), time.Since(start))
+	if !decision.IsAllowed() {
+		p.noteDecision("HTTP", domain, port, string(decision))
+		writeDenied(w, domain, decision)
+		return
+	}
+	// An allowed request is noted once the dial has passed blocked_nets,
+	// so a refusal isn't counted as an allow as well
+
+	// Forward the request. Bodies are counted only when present, so a
+	// bodiless request still reaches the transport as http.NoBody.
+	body := r.Body
+	if r.ContentLength != 0 {
+		body = io.NopCloser(countingReader{r: r.Body, n: &p.metrics.bytesSent})
+	}
+	outReq, err := http.NewRequest(r.Method, r.URL.String(), body)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("ddash: bad request: %v", err), http.StatusBadRequest)
+		p.noteDecision("HTTP", domain, port, string(decision))
+		http.Error(w, redactSecrets(fmt.Sprintf("ddash: bad request: %v", err)), http.StatusBadRequest)
 		return
 	}
 	outReq.Header = r.Header.Clone()
+	p.stripHeaders(outReq.Header)
+	outReq.Host = r.Host
+	outReq.ContentLength = r.ContentLength
+	// The server fills r.Trailer once the body is read, which is just
+	// before the transport sends it on
+	outReq.Trailer = r.Trailer
+	preserveRequestURI(outReq.URL, r)
+	if expectsContinue(r) {
+		// The transport holds the body back until upstream answers
+		// "100 Continue"; pass that on so the client starts sending. If
+		// upstream replies with a final status instead, the client gets
+		// only that and never sends the body.
+		outReq = outReq.WithContext(httptrace.WithClientTrace(outReq.Context(), &httptrace.ClientTrace{
+			Got100Continue: func() {
+				w.WriteHeader(http.StatusContinue)
+			},
+		}))
+	}
 
-	resp, err := http.DefaultTransport.RoundTrip(outReq)
+	resp, err := p.transport.RoundTrip(outReq)
+	var blockedErr *blockedAddrError
+	if errors.As(err, &blockedErr) {
+		p.noteDecision("HTTP", domain, port, "blocked (blocked_nets)")
+		writeBlockedAddr(w, domain, blockedErr.Error())
+		return
+	}
+	p.noteDecision("HTTP", domain, port, string(decision))
 	if err != nil {
-		http.Error(w, fmt.Sprintf("ddash: upstream error: %v", err), http.StatusBadGateway)
+		http.Error(w, redactSecrets(fmt.Sprintf("ddash: upstream error: %v", err)), http.StatusBadGateway)
 		return
 	}
 	defer resp.Body.Close()
+	p.logHTTP(r, resp.StatusCode)
+
+	// Upgraded connections (WebSocket etc.) become a raw tunnel
+	if resp.StatusCode == http.StatusSwitchingProtocols {
+		p.spliceUpgrade(w, resp)
+		return
+	}
 
-	// Copy response headers and body
+	// Copy response headers, body and trailers. Trailers upstream
+	// announced are announced to the client too; any that only show up
+	// after the body go out with http.TrailerPrefix.
 	for k, vv := range resp.Header {
 		for _, v := range vv {
 			w.Header().Add(k, v)
 		}
 	}
+	announced := len(resp.Trailer)
+	for k := range resp.Trailer {
+		w.Header().Add("Trailer", k)
+	}
 	w.WriteHeader(resp.StatusCode)
-	io.Copy(w, resp.Body)
+	n, _ := io.Copy(w, resp.Body)
+	p.metrics.bytesReceived.Add(n)
+	for k, vv := range resp.Trailer {
+		if len(resp.Trailer) != announced {
+			k = http.TrailerPrefix + k
+		}
+		for _, v := range vv {
+			w.Header().Add(k, v)
+		}
+	}
+}
+
+// blockedHeader names the domain in every response ddash refuses, so
+// tools and scripts can tell a policy denial from a server's own 403.
+const blockedHeader = "X-Ddash-Blocked"
+
+// writeDenied refuses a connection the user or the config denied.
+func writeDenied(w http.ResponseWriter, domain string, decision Decision) {
+	hint := fmt.Sprintf("add %q to allow_net in .ddash.json, or answer 'always' when prompted", domain)
+	if decision == DecisionNever {
+		hint = fmt.Sprintf("remove %q (\"never\") from network_domains in .ddash.json", domain)
+	}
+	writeBlocked(w, domain, fmt.Sprintf("connection to %s blocked", domain), hint)
+}
+
+// writeBlocked sends a 403 explaining what was blocked and how to allow
+// it. CONNECT clients get the same response, since it goes out before the
+// connection would be hijacked; most of them only show the status line.
+func writeBlocked(w http.ResponseWriter, domain, reason, hint string) {
+	w.Header().Set(blockedHeader, domain)
+	http.Error(w, fmt.Sprintf("ddash: %s\nddash: to allow it, %s", reason, hint), http.StatusForbidden)
+}
+
+// expectsContinue reports whether the client waits for "100 Continue"
+// before sending the request body.
+func expectsContinue(r *http.Request) bool {
+	return strings.EqualFold(r.Header.Get("Expect"), "100-continue")
+}
+
+// spliceUpgrade completes a protocol upgrade (e.g. WebSocket) by relaying
+// the 101 response to the client and then tunneling bytes in both
+// directions, like handleCONNECT does for HTTPS.
+func (p *NetworkProxy) spliceUpgrade(w http.ResponseWriter, resp *http.Response) {
+	backConn, ok := resp.Body.(io.ReadWriteCloser)
+	if !ok {
+		http.Error(w, "ddash: upstream upgrade is not writable", http.StatusBadGateway)
+		return
+	}
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "ddash: hijacking not supported", http.StatusInternalServerError)
+		return
+	}
+
+	clientConn, clientBuf, err := hijacker.Hijack()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("ddash: hijack failed: %v", err), http.StatusInternalServerError)
+		return
+	}
+	defer p.trackTunnel(clientConn, backConn)()
+
+	// Relay the 101 Switching Protocols response as-is
+	fmt.Fprintf(clientBuf, "HTTP/1.1 %s\r\n", resp.Status)
+	resp.Header.Write(clientBuf)
+	clientBuf.WriteString("\r\n")
+	if err := clientBuf.Flush(); err != nil {
+		clientConn.Close()
+		return
+	}
+
+	// Bidirectional tunnel. Read from clientBuf so bytes the client sent
+	// right after the handshake aren't lost.
+	done := make(chan struct{})
+	go func() {
+		n, _ := io.Copy(backConn, clientBuf)
+		p.metrics.bytesSent.Add(n)
+		backConn.Close()
+		close(done)
+	}()
+	n, _ := io.Copy(clientConn, backConn)
+	p.metrics.bytesReceived.Add(n)
+	clientConn.Close()
+	<-done
 }
 
-// checkDomain returns "allow" or "deny" for a domain, prompting the user
-// interactively if the domain hasn't been seen before.
-func (p *NetworkProxy) checkDomain(domain string) string {
+// preserveRequestURI makes u serialize to the exact path and query the client
+// sent. r.URL.String() re-escapes the path whenever Go considers the raw form
+// non-canonical (e.g. "%2F" next to "{"), which breaks servers that sign or
+// route on the raw request line.
+func preserveRequestURI(u *url.URL, r *http.Request) {
+	rawPath, rawQuery := splitRequestURI(r.RequestURI)
+	if rawPath == "" {
+		return
+	}
+	if strings.HasPrefix(rawPath, "//") {
+		// Opaque values starting with "//" are read as an authority
+		u.Opaque = "//" + u.Host + rawPath
+	} else {
+		u.Opaque = rawPath
+	}
+	u.RawPath = ""
+	u.RawQuery = rawQuery
+	u.ForceQuery = strings.HasSuffix(r.RequestURI, "?")
+}
+
+// splitRequestURI returns the raw path and query of an origin-form or
+// absolute-form request target, without decoding either. Only a target
+// not starting with "/" has a scheme and host to strip; an origin-form
+// target may carry "://" in its query.
+func splitRequestURI(requestURI string) (rawPath, rawQuery string) {
+	target := requestURI
+	if idx := strings.Index(target, "://"); idx >= 0 && !strings.HasPrefix(target, "/") {
+		rest := target[idx+3:]
+		slash := strings.IndexAny(rest, "/?")
+		if slash < 0 {
+			return "/", ""
+		}
+		target = rest[slash:]
+	}
+	rawPath, rawQuery, _ = strings.Cut(target, "?")
+	if rawPath == "" {
+		rawPath = "/"
+	}
+	return rawPath, rawQuery
+}
+
+// checkDomain returns the decision for a domain, prompting the user
+// interactively if the domain hasn't been seen before. port and reqURL
+// (the full request URL, empty for CONNECT) are only used for display in
+// the prompt; the decision still covers the whole domain.
+func (p *NetworkProxy) checkDomain(domain, port, reqURL string) Decision {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
-	if decision, ok := p.domains[domain]; ok {
-		return decision
+	p.attempts[domain]++
+	if d, ok := p.settled(domain, port); ok {
+		return d
+	}
+
+	// A new domain waits its turn to be asked about; whoever asked before
+	// may have settled it meanwhile
+	p.waitTurn()
+	defer p.promptMu.Unlock()
+	if d, ok := p.settled(domain, port); ok {
+		return d
+	}
+	if answer, ok := p.decide(domain); ok {
+		p.domains[domain] = string(answer)
+		return answer
+	}
+	if p.unattended {
+		fmt.Fprintf(os.Stderr, "ddash: denied %s unasked (an earlier prompt went unanswered)\n", net.JoinHostPort(domain, port))
+		p.domains[domain] = string(DecisionDeny)
+		return DecisionDeny
+	}
+	if p.group > 0 {
+		return p.checkGrouped(domain, port, reqURL)
 	}
 
 	// New domain — prompt
-	decision := p.promptUser(domain)
-	p.domains[domain] = decision
-	return decision
-}
-
-// promptUser opens /dev/tty and asks the user about a domain.
-// Returns "allow" or "deny".
-func (p *NetworkProxy) promptUser(domain string) string {
-	if p.tty == nil {
-		tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
-		if err != nil {
-			// Can't open tty — deny by default
-			fmt.Fprintf(os.Stderr, "ddash: can't open /dev/tty, denying %s\n", domain)
-			return "deny"
-		}
-		p.tty = tty
-	}
-
-	fmt.Fprintf(p.tty, "\nddash: %s wants to connect to %s\n", p.cmdName, domain)
-	fmt.Fprintf(p.tty, "       [a]llow  [d]eny  a[l]ways  [n]ever: ")
-
-	reader := bufio.NewReader(p.tty)
-	line, _ := reader.ReadString('\n')
-	line = strings.TrimSpace(strings.ToLower(line))
-
-	switch line {
-	case "a", "allow":
-		return "allow"
-	case "d", "deny":
-		return "deny"
-	case "l", "always":
-		return "always"
-	case "n", "never":
-		return "never"
+	answer := p.promptUser(domain, port, reqURL)
+	p.domains[domain] = string(answer)
+	p.recordPrompt(domain, string(answer))
+	return answer
+}
+
+// settled returns the decision for domain on port if it needs no asking:
+// audit and monitor mode, a known domain, or allow-all-rest. p.mu must be
+// held.
+func (p *NetworkProxy) settled(domain, port string) (Decision, bool) {
+	decision, known := p.lookup(domain, port)
+	if p.audit != nil && !Decision(decision).IsAllowed() {
+		if p.attempts[domain] == 1 {
+			verdict := "would prompt"
+			if known {
+				verdict = "would deny (" + decision + ")"
+			}
+			fmt.Fprintf(p.audit, "ddash: audit: allowed %s, %s\n", net.JoinHostPort(domain, port), verdict)
+		}
+		return DecisionAllow, true
+	}
+	if Decision(decision) == DecisionLog || p.monitor && !Decision(decision).IsAllowed() {
+		p.wouldDeny[domain] = true
+		if !known {
+			p.domains[domain] = string(DecisionLog)
+		}
+		return DecisionLog, true
+	}
+	if known {
+		return Decision(decision), true
+	}
+	if p.allowRest {
+		fmt.Fprintf(os.Stderr, "ddash: allowed %s unasked (allow-all-rest)\n", net.JoinHostPort(domain, port))
+		p.domains[domain] = string(DecisionAllow)
+		return DecisionAllow, true
+	}
+	return "", false
+}
+
+// waitTurn takes p.promptMu, releasing p.mu while it waits so that
+// connections to known domains go on. Caller must hold p.mu.
+func (p *NetworkProxy) waitTurn() {
+	p.mu.Unlock()
+	p.promptMu.Lock()
+	p.mu.Lock()
+}
+
+// unlocked runs fn with p.mu released: the decider, a prompt and its whois
+// lookup can take minutes, and every other connection needs p.mu. Caller
+// must hold p.mu and p.promptMu, so nothing else is asked meanwhile.
+func (p *NetworkProxy) unlocked(fn func()) {
+	p.mu.Unlock()
+	defer p.mu.Lock()
+	fn()
+}
+
+// lookup returns the decision for domain on port (see lookupDecision).
+// p.mu must be held.
+func (p *NetworkProxy) lookup(domain, port string) (string, bool) {
+	decision, _, ok := lookupDecision(p.domains, domain, port)
+	return decision, ok
+}
+
+// Decider answers for a new domain without a human, e.g. by asking a
+// central policy server. It returns one of the Prompter decisions.
+type Decider func(domain string) (string, error)
+
+// SetDecider makes the proxy ask decider about new domains before
+// prompting. If the decider fails or returns something that isn't a
+// decision, the proxy prompts as usual. Like a prompt it is asked about one
+// domain at a time, so it should answer quickly; connections to known
+// domains go on meanwhile.
+func (p *NetworkProxy) SetDecider(decider Decider) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.decider = decider
+}
+
+// decide asks the decider about domain. ok is false when there is no
+// decider or it had no usable answer. Caller must hold p.mu and p.promptMu.
+func (p *NetworkProxy) decide(domain string) (Decision, bool) {
+	decider := p.decider
+	if decider == nil {
+		return "", false
+	}
+	var answer string
+	var err error
+	p.unlocked(func() { answer, err = decider(domain) })
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ddash: decider failed for %s (%s), asking instead\n", domain, redactSecrets(err.Error()))
+		return "", false
+	}
+	switch d := Decision(answer); d {
+	case DecisionAllow, DecisionDeny, DecisionAlways, DecisionNever, DecisionSession:
+		return d, true
 	default:
-		// Unknown input — treat as deny for safety
-		fmt.Fprintf(p.tty, "       (unknown input %q, denying)\n", line)
-		return "deny"
+		fmt.Fprintf(os.Stderr, "ddash: decider returned %q for %s, asking instead\n", answer, domain)
+		return "", false
+	}
+}
+
+// promptGroup collects new domains requested within the grouping window.
+type promptGroup struct {
+	reqs []PromptRequest
+	done chan struct{} // closed once every domain in reqs is decided
+}
+
+// SetPromptGroup makes the proxy wait window after a new domain and ask
+// about every new domain requested meanwhile in one prompt, for tools
+// that fan out to a family of hosts (pypi.org, files.pythonhosted.org,
+// ...). It needs a GroupPrompter; others are asked one domain at a time
+// after the window. Zero turns grouping off.
+func (p *NetworkProxy) SetPromptGroup(window time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.group = window
+}
+
+// checkGrouped adds domain to the pending group, starting one if needed,
+// and waits for the group's decision. The first domain of a group waits
+// out the window and asks. Both locks are released while waiting, so
+// other domains can join; called with p.mu and p.promptMu held, it returns
+// with them held.
+func (p *NetworkProxy) checkGrouped(domain, port, reqURL string) Decision {
+	if g := p.pending; g != nil {
+		if !groupHas(g, domain) {
+			g.reqs = append(g.reqs, p.promptRequest(domain, port, reqURL))
+		}
+		p.mu.Unlock()
+		p.promptMu.Unlock()
+		<-g.done
+		p.promptMu.Lock()
+		p.mu.Lock()
+		return Decision(p.domains[domain])
+	}
+
+	g := &promptGroup{reqs: []PromptRequest{p.promptRequest(domain, port, reqURL)}, done: make(chan struct{})}
+	p.pending = g
+	p.mu.Unlock()
+	p.promptMu.Unlock()
+	time.Sleep(p.group)
+	p.promptMu.Lock()
+	p.mu.Lock()
+	p.pending = nil
+
+	answers := p.askGroup(g.reqs)
+	for i, req := range g.reqs {
+		p.domains[req.Domain] = string(answers[i])
+		p.recordPrompt(req.Domain, string(answers[i]))
+	}
+	close(g.done)
+	return Decision(p.domains[domain])
+}
+
+func groupHas(g *promptGroup, domain string) bool {
+	for _, req := range g.reqs {
+		if req.Domain == domain {
+			return true
+		}
+	}
+	return false
+}
+
+// askGroup decides reqs with one prompt if the prompter supports it, and
+// one by one otherwise. If no decision can be obtained all are denied.
+// Caller must hold p.mu and p.promptMu.
+func (p *NetworkProxy) askGroup(reqs []PromptRequest) []Decision {
+	answers := make([]Decision, len(reqs))
+	gp, ok := p.prompter.(GroupPrompter)
+	if len(reqs) == 1 || !ok {
+		for i, req := range reqs {
+			answers[i] = p.promptUser(req.Domain, req.Port, req.URL)
+		}
+		return answers
+	}
+
+	p.metrics.prompts.Add(1)
+	var decisions []string
+	var err error
+	if !p.awaitAnswer(func() { decisions, err = gp.AskGroup(reqs) }) {
+		for i := range answers {
+			answers[i] = DecisionDeny
+		}
+		return answers
 	}
+	if err != nil || len(decisions) != len(reqs) {
+		if err == nil {
+			err = fmt.Errorf("prompter answered %d of %d hosts", len(decisions), len(reqs))
+		}
+		fmt.Fprintf(os.Stderr, "ddash: %v, denying %d hosts\n", err, len(reqs))
+		for i := range answers {
+			answers[i] = DecisionDeny
+		}
+		return answers
+	}
+	for i, d := range decisions {
+		answers[i] = p.takeAnswer(Decision(d))
+	}
+	return answers
 }
 
-// stripPort removes :port from a host:port string.
+// recordPrompt remembers an answered prompt for the [i]nfo view.
+// Caller must hold p.mu.
+func (p *NetworkProxy) recordPrompt(domain, decision string) {
+	p.recent = append(p.recent, promptRecord{domain: domain, decision: decision})
+	if len(p.recent) > maxRecentPrompts {
+		p.recent = p.recent[len(p.recent)-maxRecentPrompts:]
+	}
+}
+
+// writeInfo prints the context shown by the [i]nfo prompt option: the
+// requested port, how often the domain was attempted this run, which
+// domains are already allowed, and the most recent prompts.
+// Caller must hold p.mu.
+func (p *NetworkProxy) writeInfo(w io.Writer, domain, port string) {
+	fmt.Fprintf(w, "       port:            %s\n", port)
+	fmt.Fprintf(w, "       attempts:        %d this run\n", p.attempts[domain])
+
+	var allowed []string
+	for d, decision := range p.domains {
+		if isAllowed(decision) {
+			allowed = append(allowed, d)
+		}
+	}
+	sort.Strings(allowed)
+	if len(allowed) == 0 {
+		fmt.Fprintf(w, "       allowed so far:  none\n")
+	} else {
+		fmt.Fprintf(w, "       allowed so far:  %s\n", strings.Join(allowed, ", "))
+	}
+
+	if len(p.recent) == 0 {
+		fmt.Fprintf(w, "       recent prompts:  none\n")
+		return
+	}
+	var recent []string
+	for i := len(p.recent) - 1; i >= 0; i-- {
+		recent = append(recent, fmt.Sprintf("%s (%s)", p.recent[i].domain, p.recent[i].decision))
+	}
+	fmt.Fprintf(w, "       recent prompts:  %s\n", strings.Join(recent, ", "))
+}
+
+// promptUser asks the prompter about a domain. If no decision can be
+// obtained the domain is denied.
+// Caller must hold p.mu and p.promptMu.
+func (p *NetworkProxy) promptUser(domain, port, reqURL string) Decision {
+	p.metrics.prompts.Add(1)
+	var decision string
+	var err error
+	prompter, req := p.prompter, p.promptRequest(domain, port, reqURL)
+	if !p.awaitAnswer(func() { decision, err = prompter.Ask(req) }) {
+		return DecisionDeny
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ddash: %v, denying %s\n", err, domain)
+		return DecisionDeny
+	}
+	return p.takeAnswer(Decision(decision))
+}
+
+// SetPromptWatchdog makes the proxy stop waiting for a prompt that has
+// gone unanswered for timeout, for unattended runs (CI) where nobody will
+// answer. Unlike a per-prompt timeout it trips once for the whole proxy:
+// the pending domains are denied and so is every new domain after them,
+// without asking. Zero, the default, waits for answers indefinitely.
+func (p *NetworkProxy) SetPromptWatchdog(timeout time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.watchdog = timeout
+}
+
+// awaitAnswer runs ask, a call to the prompter, and reports whether it
+// returned before the prompt watchdog tripped. If it didn't, the proxy
+// switches to denying new domains; the abandoned prompt's answer, if one
+// ever comes, is ignored. p.mu is released while waiting (see unlocked);
+// caller must hold p.mu and p.promptMu.
+func (p *NetworkProxy) awaitAnswer(ask func()) bool {
+	if p.watchdog <= 0 {
+		p.unlocked(ask)
+		return true
+	}
+	answered := make(chan struct{})
+	go func() {
+		ask()
+		close(answered)
+	}()
+	timer := time.NewTimer(p.watchdog)
+	defer timer.Stop()
+	ok := false
+	p.unlocked(func() {
+		select {
+		case <-answered:
+			ok = true
+		case <-timer.C:
+		}
+	})
+	if !ok {
+		p.unattended = true
+		fmt.Fprintf(os.Stderr, "\nddash: no answer within %s; denying this and every new domain for the rest of the run\n", p.watchdog)
+	}
+	return ok
+}
+
+// SetPromptOptions limits the decisions prompts offer, e.g. to "allow"
+// and "deny" so nothing is saved by accident. An answer outside options
+// denies, whichever prompter gave it. Nil restores all options.
+func (p *NetworkProxy) SetPromptOptions(options []string) error {
+	if err := validatePromptOptions(options); err != nil {
+		return err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.promptOptions = options
+	return nil
+}
+
+// takeAnswer turns a prompt answer into the decision to store. An answer
+// that wasn't offered denies; an allow-all-rest answer allows the domain
+// and switches off prompting for the rest of the run. Caller must hold
+// p.mu.
+func (p *NetworkProxy) takeAnswer(d Decision) Decision {
+	if !(PromptRequest{Options: p.promptOptions}).offers(d) {
+		fmt.Fprintf(os.Stderr, "ddash: prompt answered %q, which prompt_options doesn't offer; denying\n", d)
+		return DecisionDeny
+	}
+	if d != DecisionAllowRest {
+		return d
+	}
+	if !p.allowRest {
+		p.allowRest = true
+		fmt.Fprintf(os.Stderr, "ddash: warning: allowing every new domain for the rest of this run; unknown hosts are no longer blocked\n")
+	}
+	return DecisionAllow
+}
+
+// promptRequest describes a connection to domain for the prompter. The
+// prompter runs without p.mu (see unlocked), so Info takes it, and Whois
+// looks up without holding anything. Caller must hold p.mu.
+func (p *NetworkProxy) promptRequest(domain, port, reqURL string) PromptRequest {
+	whois := p.whois
+	return PromptRequest{
+		Command: p.cmdName,
+		Domain:  domain,
+		Port:    port,
+		URL:     reqURL,
+		Info: func(w io.Writer) {
+			p.mu.Lock()
+			defer p.mu.Unlock()
+			p.writeInfo(w, domain, port)
+		},
+		Whois: func(w io.Writer) {
+			writeWhois(w, whois, domain)
+		},
+		Options: p.promptOptions,
+	}
+}
+
+// stripPort removes :port from a host:port string, and the brackets
+// around an IPv6 literal ("[::1]:443" and "[::1]" both give "::1").
 func stripPort(host string) string {
-	if h, _, err := net.SplitHostPort(host); err == nil {
-		return h
+	h, _ := splitHostPort(host, "")
+	return h
+}
+
+// splitHostPort splits host:port, returning defaultPort when host has none.
+// IPv6 literals come back without brackets, so "[2001:db8::1]:443" matches
+// an allow_net entry of "2001:db8::1".
+func splitHostPort(hostport, defaultPort string) (string, string) {
+	if h, port, err := net.SplitHostPort(hostport); err == nil {
+		return h, port
+	}
+	if strings.HasPrefix(hostport, "[") && strings.HasSuffix(hostport, "]") {
+		return hostport[1 : len(hostport)-1], defaultPort
 	}
-	return host
+	return hostport, defaultPort
 }
 
 // isAllowed returns true if a decision means the connection should proceed.
 func isAllowed(decision string) bool {
-	return decision == "allow" || decision == "always"
+	return Decision(decision).IsAllowed()
 }
@@ -1,7 +1,11 @@
 package cmd
 
 import (
+	"bufio"
+	"context"
+	"crypto/sha1"
 	"crypto/tls"
+	"encoding/base64"
 	"fmt"
 	"io"
 	"net"
@@ -9,6 +13,7 @@ import (
 	"net/http/httptest"
 	"net/url"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 	"time"
@@ -35,60 +40,105 @@ func TestProxyStartsAndListens(t *testing.T) {
 	conn.Close()
 }
 
-func TestProxyCachedAllow(t *testing.T) {
-	// Start a backend HTTP server
-	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Write([]byte("backend-ok"))
-	}))
-	defer backend.Close()
+func TestProxyStartContextCancel(t *testing.T) {
+	p, err := NewProxy(nil, "test")
+	if err != nil {
+		t.Fatalf("NewProxy failed: %v", err)
+	}
+	defer p.Shutdown()
 
-	// Extract host from backend URL
-	backendURL, _ := url.Parse(backend.URL)
-	host := backendURL.Host
+	ctx, cancel := context.WithCancel(context.Background())
+	p.StartContext(ctx)
 
-	// Start proxy with this host pre-allowed
-	domains := map[string]string{stripPort(host): "allow"}
-	p, err := NewProxy(domains, "test")
+	conn, err := net.DialTimeout("tcp", p.Addr(), time.Second)
+	if err != nil {
+		t.Fatalf("cannot connect to proxy: %v", err)
+	}
+	conn.Close()
+
+	cancel()
+	select {
+	case <-p.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("proxy did not stop after context cancel")
+	}
+	if err := p.Err(); err != nil {
+		t.Errorf("Err() = %v, want nil after clean shutdown", err)
+	}
+	if _, err := net.DialTimeout("tcp", p.Addr(), time.Second); err == nil {
+		t.Error("proxy still accepting connections after cancel")
+	}
+}
+
+func TestProxyStartContextServeError(t *testing.T) {
+	p, err := NewProxy(nil, "test")
 	if err != nil {
 		t.Fatalf("NewProxy failed: %v", err)
 	}
 	defer p.Shutdown()
+
+	// Serve fails immediately on a closed listener
+	p.listener.Close()
+	p.StartContext(context.Background())
+
+	select {
+	case <-p.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("Serve did not return")
+	}
+	if p.Err() == nil {
+		t.Error("Err() = nil, want serve error")
+	}
+}
+
+func TestProxyRedactsUpstreamErrors(t *testing.T) {
+	const secret = "planted-secret-value"
+	t.Setenv("TEST_SECRET_KEY", secret)
+
+	p, err := NewProxy(map[string]string{"upstream.test": "allow"}, "test")
+	if err != nil {
+		t.Fatalf("NewProxy failed: %v", err)
+	}
+	// An upstream error can echo what the child sent, secrets included
+	p.transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return nil, fmt.Errorf("connection reset while sending token %s", secret)
+	}
 	p.Start()
+	defer p.Shutdown()
 
-	// Make HTTP request through proxy
 	proxyURL, _ := url.Parse("http://" + p.Addr())
 	client := &http.Client{
 		Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)},
 		Timeout:   5 * time.Second,
 	}
-
-	resp, err := client.Get(backend.URL)
+	resp, err := client.Get("http://upstream.test/")
 	if err != nil {
-		t.Fatalf("request through proxy failed: %v", err)
+		t.Fatalf("GET: %v", err)
 	}
 	defer resp.Body.Close()
-
 	body, _ := io.ReadAll(resp.Body)
-	if string(body) != "backend-ok" {
-		t.Errorf("expected 'backend-ok', got %q", string(body))
+	if resp.StatusCode != http.StatusBadGateway {
+		t.Fatalf("status = %d, want 502", resp.StatusCode)
+	}
+	if strings.Contains(string(body), secret) || !strings.Contains(string(body), "***") {
+		t.Errorf("body = %q, want the secret redacted", body)
 	}
 }
 
-func TestProxyCachedDeny(t *testing.T) {
+func TestProxyShutdownContextDrains(t *testing.T) {
+	started := make(chan struct{})
 	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Write([]byte("should-not-reach"))
+		close(started)
+		time.Sleep(200 * time.Millisecond)
+		w.Write([]byte("finished"))
 	}))
 	defer backend.Close()
-
 	backendURL, _ := url.Parse(backend.URL)
-	host := backendURL.Host
 
-	domains := map[string]string{stripPort(host): "deny"}
-	p, err := NewProxy(domains, "test")
+	p, err := NewProxy(map[string]string{stripPort(backendURL.Host): "allow"}, "test")
 	if err != nil {
 		t.Fatalf("NewProxy failed: %v", err)
 	}
-	defer p.Shutdown()
 	p.Start()
 
 	proxyURL, _ := url.Parse("http://" + p.Addr())
@@ -96,29 +146,96 @@ func TestProxyCachedDeny(t *testing.T) {
 		Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)},
 		Timeout:   5 * time.Second,
 	}
+	type result struct {
+		body string
+		err  error
+	}
+	got := make(chan result, 1)
+	go func() {
+		resp, err := client.Get(backend.URL)
+		if err != nil {
+			got <- result{err: err}
+			return
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		got <- result{string(body), err}
+	}()
+
+	<-started
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := p.ShutdownContext(ctx); err != nil {
+		t.Errorf("ShutdownContext = %v, want nil after draining", err)
+	}
+	if r := <-got; r.err != nil || r.body != "finished" {
+		t.Errorf("in-flight request got %q, %v; want it to finish", r.body, r.err)
+	}
+	if _, err := net.DialTimeout("tcp", p.Addr(), time.Second); err == nil {
+		t.Error("proxy still accepting connections after ShutdownContext")
+	}
+}
 
-	resp, err := client.Get(backend.URL)
+func TestProxyShutdownContextCutsOffTunnels(t *testing.T) {
+	// An upstream that accepts and then stays silent
+	upstream, err := net.Listen("tcp", "127.0.0.1:0")
 	if err != nil {
-		t.Fatalf("request through proxy failed: %v", err)
+		t.Fatal(err)
 	}
-	defer resp.Body.Close()
+	defer upstream.Close()
+	go func() {
+		for {
+			conn, err := upstream.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
 
-	if resp.StatusCode != http.StatusForbidden {
-		t.Errorf("expected 403 Forbidden, got %d", resp.StatusCode)
+	p, err := NewProxy(map[string]string{"127.0.0.1": "allow"}, "test")
+	if err != nil {
+		t.Fatalf("NewProxy failed: %v", err)
+	}
+	p.Start()
+
+	conn, err := net.Dial("tcp", p.Addr())
+	if err != nil {
+		t.Fatalf("dial proxy: %v", err)
+	}
+	defer conn.Close()
+	fmt.Fprintf(conn, "CONNECT %s HTTP/1.1\r\nHost: %s\r\n\r\n", upstream.Addr(), upstream.Addr())
+	br := bufio.NewReader(conn)
+	if resp, err := http.ReadResponse(br, nil); err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("CONNECT failed: %v", err)
+	}
+	conn.Write([]byte("not tls\n"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := p.ShutdownContext(ctx); err != context.DeadlineExceeded {
+		t.Errorf("ShutdownContext = %v, want the deadline with a tunnel still open", err)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := br.ReadByte(); err != io.EOF {
+		t.Errorf("read from cut-off tunnel = %v, want EOF", err)
 	}
 }
 
-func TestProxyCachedAlwaysAllows(t *testing.T) {
+func TestProxyCachedAllow(t *testing.T) {
+	// Start a backend HTTP server
 	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Write([]byte("ok"))
+		w.Write([]byte("backend-ok"))
 	}))
 	defer backend.Close()
 
+	// Extract host from backend URL
 	backendURL, _ := url.Parse(backend.URL)
 	host := backendURL.Host
 
-	// "always" should be treated as allowed
-	domains := map[string]string{stripPort(host): "always"}
+	// Start proxy with this host pre-allowed
+	domains := map[string]string{stripPort(host): "allow"}
 	p, err := NewProxy(domains, "test")
 	if err != nil {
 		t.Fatalf("NewProxy failed: %v", err)
@@ -126,6 +243,7 @@ func TestProxyCachedAlwaysAllows(t *testing.T) {
 	defer p.Shutdown()
 	p.Start()
 
+	// Make HTTP request through proxy
 	proxyURL, _ := url.Parse("http://" + p.Addr())
 	client := &http.Client{
 		Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)},
@@ -139,27 +257,27 @@ func TestProxyCachedAlwaysAllows(t *testing.T) {
 	defer resp.Body.Close()
 
 	body, _ := io.ReadAll(resp.Body)
-	if string(body) != "ok" {
-		t.Errorf("expected 'ok', got %q", string(body))
+	if string(body) != "backend-ok" {
+		t.Errorf("expected 'backend-ok', got %q", string(body))
 	}
 }
 
-func TestProxyCachedNeverDenies(t *testing.T) {
+func TestProxyHTTPLog(t *testing.T) {
 	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Write([]byte("should-not-reach"))
+		w.WriteHeader(http.StatusTeapot)
 	}))
 	defer backend.Close()
 
 	backendURL, _ := url.Parse(backend.URL)
 	host := backendURL.Host
 
-	// "never" should be treated as denied
-	domains := map[string]string{stripPort(host): "never"}
-	p, err := NewProxy(domains, "test")
+	p, err := NewProxy(map[string]string{stripPort(host): "allow"}, "test")
 	if err != nil {
 		t.Fatalf("NewProxy failed: %v", err)
 	}
 	defer p.Shutdown()
+	var log strings.Builder
+	p.SetHTTPLog(&log)
 	p.Start()
 
 	proxyURL, _ := url.Parse("http://" + p.Addr())
@@ -168,214 +286,848 @@ func TestProxyCachedNeverDenies(t *testing.T) {
 		Timeout:   5 * time.Second,
 	}
 
-	resp, err := client.Get(backend.URL)
+	resp, err := client.Get(backend.URL + "/api/items?token=hunter2")
 	if err != nil {
 		t.Fatalf("request through proxy failed: %v", err)
 	}
-	defer resp.Body.Close()
+	resp.Body.Close()
 
-	if resp.StatusCode != http.StatusForbidden {
-		t.Errorf("expected 403 Forbidden, got %d", resp.StatusCode)
+	want := "GET " + host + " /api/items -> 418\n"
+	if log.String() != want {
+		t.Errorf("log = %q, want %q", log.String(), want)
 	}
 }
 
-func TestProxyUnknownDomainPrompt(t *testing.T) {
+func TestProxyStripHeaders(t *testing.T) {
+	var got http.Header
 	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Write([]byte("reached"))
+		got = r.Header.Clone()
 	}))
 	defer backend.Close()
 
 	backendURL, _ := url.Parse(backend.URL)
-	host := backendURL.Host
 
-	// No pre-cached domain — proxy will try /dev/tty and fail, defaulting to deny
-	p, err := NewProxy(nil, "test")
+	p, err := NewProxy(map[string]string{stripPort(backendURL.Host): "allow"}, "test")
 	if err != nil {
 		t.Fatalf("NewProxy failed: %v", err)
 	}
 	defer p.Shutdown()
+	p.SetStripHeaders([]string{"authorization", "Cookie"})
 	p.Start()
 
-	// Mock the tty with a pipe that provides "a\n" (allow)
-	pr, pw, err := createMockTTY()
-	if err != nil {
-		t.Fatalf("failed to create mock tty: %v", err)
-	}
-	defer pr.Close()
-	defer pw.Close()
-
-	p.mu.Lock()
-	p.tty = pw // Write end as tty — but we need read+write
-	p.mu.Unlock()
-
-	// Instead of mocking /dev/tty (which is hard), test that unknown domains
-	// with no tty get denied by default
-	p.mu.Lock()
-	p.tty = nil // Force tty to be nil so it tries /dev/tty
-	p.mu.Unlock()
-
-	// For this test, pre-set a mock tty using a pipe
-	mockR, mockW, _ := createPipePair()
-	defer mockR.Close()
-	defer mockW.Close()
-
-	// Write "a\n" to mock input
-	go func() {
-		fmt.Fprint(mockW, "a\n")
-	}()
-
-	p.mu.Lock()
-	p.tty = mockR
-	p.mu.Unlock()
-
 	proxyURL, _ := url.Parse("http://" + p.Addr())
 	client := &http.Client{
 		Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)},
 		Timeout:   5 * time.Second,
 	}
 
-	resp, err := client.Get(backend.URL)
+	req, _ := http.NewRequest(http.MethodGet, backend.URL, nil)
+	req.Header.Set("Authorization", "Bearer local-secret")
+	req.Header.Set("Cookie", "session=internal")
+	req.Header.Set("X-Request-Id", "abc123")
+	resp, err := client.Do(req)
 	if err != nil {
 		t.Fatalf("request through proxy failed: %v", err)
 	}
-	defer resp.Body.Close()
+	resp.Body.Close()
 
-	body, _ := io.ReadAll(resp.Body)
-	if string(body) != "reached" {
-		t.Errorf("expected 'reached' after allowing, got %q (status %d)", string(body), resp.StatusCode)
+	if v := got.Get("Authorization"); v != "" {
+		t.Errorf("Authorization reached the backend: %q", v)
 	}
-
-	// Verify the domain was cached as "allow"
-	domains := p.Domains()
-	domain := stripPort(host)
-	if domains[domain] != "allow" {
-		t.Errorf("expected domain %q to be cached as 'allow', got %q", domain, domains[domain])
+	if v := got.Get("Cookie"); v != "" {
+		t.Errorf("Cookie reached the backend: %q", v)
+	}
+	if v := got.Get("X-Request-Id"); v != "abc123" {
+		t.Errorf("X-Request-Id = %q, want abc123", v)
 	}
 }
 
-func TestProxyAlwaysDecisionSaved(t *testing.T) {
+func TestProxyExpectContinue(t *testing.T) {
 	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Write([]byte("ok"))
+		if r.URL.Path == "/too-big" {
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		fmt.Fprintf(w, "got %d bytes", len(body))
 	}))
 	defer backend.Close()
 
 	backendURL, _ := url.Parse(backend.URL)
 	host := backendURL.Host
 
-	p, err := NewProxy(nil, "test")
+	p, err := NewProxy(map[string]string{stripPort(host): "allow"}, "test")
 	if err != nil {
 		t.Fatalf("NewProxy failed: %v", err)
 	}
 	defer p.Shutdown()
 	p.Start()
 
-	// Mock tty with "l\n" (always)
-	mockR, mockW, _ := createPipePair()
-	defer mockR.Close()
-	defer mockW.Close()
-
-	go func() {
-		fmt.Fprint(mockW, "l\n")
-	}()
-
-	p.mu.Lock()
-	p.tty = mockR
-	p.mu.Unlock()
+	payload := strings.Repeat("x", 64<<10)
 
-	proxyURL, _ := url.Parse("http://" + p.Addr())
-	client := &http.Client{
-		Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)},
-		Timeout:   5 * time.Second,
+	// send writes the request head and waits for the proxy's first response
+	send := func(path string) (net.Conn, *bufio.Reader, *http.Response) {
+		conn, err := net.Dial("tcp", p.Addr())
+		if err != nil {
+			t.Fatalf("dial proxy: %v", err)
+		}
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		fmt.Fprintf(conn, "POST %s%s HTTP/1.1\r\nHost: %s\r\nContent-Length: %d\r\nExpect: 100-continue\r\n\r\n",
+			backend.URL, path, host, len(payload))
+		br := bufio.NewReader(conn)
+		resp, err := http.ReadResponse(br, nil)
+		if err != nil {
+			conn.Close()
+			t.Fatalf("read first response for %s: %v", path, err)
+		}
+		return conn, br, resp
 	}
 
-	resp, err := client.Get(backend.URL)
+	conn, br, interim := send("/upload")
+	defer conn.Close()
+	if interim.StatusCode != http.StatusContinue {
+		t.Fatalf("expected 100 Continue before the body, got %d", interim.StatusCode)
+	}
+	io.WriteString(conn, payload)
+	final, err := http.ReadResponse(br, nil)
 	if err != nil {
-		t.Fatalf("request through proxy failed: %v", err)
+		t.Fatalf("read final response: %v", err)
+	}
+	body, _ := io.ReadAll(final.Body)
+	if want := fmt.Sprintf("got %d bytes", len(payload)); string(body) != want {
+		t.Errorf("body = %q, want %q", body, want)
 	}
-	resp.Body.Close()
 
-	// Verify the domain was cached as "always"
-	domains := p.Domains()
-	domain := stripPort(host)
-	if domains[domain] != "always" {
-		t.Errorf("expected domain %q to be cached as 'always', got %q", domain, domains[domain])
+	// A rejection reaches the client without it sending the body
+	conn2, _, rejected := send("/too-big")
+	defer conn2.Close()
+	if rejected.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected 413 without 100 Continue, got %d", rejected.StatusCode)
 	}
 }
 
-func TestProxyNeverDecisionSaved(t *testing.T) {
+func TestProxyAuditAllowsAndLogs(t *testing.T) {
 	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Write([]byte("should-not-reach"))
+		w.Write([]byte("reached"))
 	}))
 	defer backend.Close()
 
 	backendURL, _ := url.Parse(backend.URL)
-	host := backendURL.Host
+	domain := stripPort(backendURL.Host)
 
-	p, err := NewProxy(nil, "test")
-	if err != nil {
-		t.Fatalf("NewProxy failed: %v", err)
+	for _, cached := range []string{"", "never"} {
+		domains := map[string]string{}
+		if cached != "" {
+			domains[domain] = cached
+		}
+		p, err := NewProxy(domains, "test")
+		if err != nil {
+			t.Fatalf("NewProxy failed: %v", err)
+		}
+		prompter := &stubPrompter{}
+		p.SetPrompter(prompter)
+		var audit strings.Builder
+		p.SetAudit(&audit)
+		p.Start()
+
+		proxyURL, _ := url.Parse("http://" + p.Addr())
+		client := &http.Client{
+			Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)},
+			Timeout:   5 * time.Second,
+		}
+		for i := 0; i < 2; i++ {
+			resp, err := client.Get(backend.URL)
+			if err != nil {
+				t.Fatalf("request through proxy failed: %v", err)
+			}
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if string(body) != "reached" {
+				t.Errorf("cached %q: expected the request to be allowed, got %d %q", cached, resp.StatusCode, body)
+			}
+		}
+		p.Shutdown()
+
+		if len(prompter.asked) != 0 {
+			t.Errorf("cached %q: audit mode should not prompt", cached)
+		}
+		want := "would prompt"
+		if cached != "" {
+			want = "would deny (never)"
+		}
+		if strings.Count(audit.String(), "\n") != 1 || !strings.Contains(audit.String(), want) {
+			t.Errorf("cached %q: audit log = %q, want one line with %q", cached, audit.String(), want)
+		}
+		if _, saved := p.Domains()[domain]; saved != (cached != "") {
+			t.Errorf("cached %q: audit decisions must not be recorded", cached)
+		}
 	}
-	defer p.Shutdown()
-	p.Start()
+}
 
-	// Mock tty with "n\n" (never)
-	mockR, mockW, _ := createPipePair()
-	defer mockR.Close()
-	defer mockW.Close()
+func TestProxyAuditLog(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer backend.Close()
 
-	go func() {
-		fmt.Fprint(mockW, "n\n")
-	}()
+	backendURL, _ := url.Parse(backend.URL)
+	domain := stripPort(backendURL.Host)
 
-	p.mu.Lock()
-	p.tty = mockR
-	p.mu.Unlock()
+	p, err := NewProxy(map[string]string{domain: "allow"}, "test")
+	if err != nil {
+		t.Fatalf("NewProxy failed: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "audit.log")
+	if err := p.SetAuditLog(AuditConfig{Path: path, MaxSize: 1 << 20, MaxFiles: 1}); err != nil {
+		t.Fatalf("SetAuditLog: %v", err)
+	}
+	p.SetPrompter(DenyPrompter{})
+	p.Start()
 
 	proxyURL, _ := url.Parse("http://" + p.Addr())
 	client := &http.Client{
 		Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)},
 		Timeout:   5 * time.Second,
 	}
+	for _, target := range []string{backend.URL, "http://blocked.example.com/"} {
+		resp, err := client.Get(target)
+		if err != nil {
+			t.Fatalf("request through proxy failed: %v", err)
+		}
+		resp.Body.Close()
+	}
+	p.Shutdown()
+	// A decision noted after Shutdown is dropped, not written to the
+	// closed file
+	p.noteDecision("HTTP", "late.example.com", "80", "deny")
+
+	data, _ := os.ReadFile(path)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("audit log has %d lines, want 2:\n%s", len(lines), data)
+	}
+	if !strings.HasSuffix(lines[0], "HTTP "+backendURL.Host+" allow") {
+		t.Errorf("line 1 = %q", lines[0])
+	}
+	if !strings.HasSuffix(lines[1], "HTTP blocked.example.com:80 deny") {
+		t.Errorf("line 2 = %q", lines[1])
+	}
+}
 
-	resp, err := client.Get(backend.URL)
+func TestProxyDeniedDomains(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer backend.Close()
+
+	backendURL, _ := url.Parse(backend.URL)
+	p, err := NewProxy(map[string]string{stripPort(backendURL.Host): "allow", "tracker.example.com": "never"}, "test")
 	if err != nil {
-		t.Fatalf("request through proxy failed: %v", err)
+		t.Fatalf("NewProxy failed: %v", err)
 	}
-	resp.Body.Close()
+	defer p.Shutdown()
+	p.SetPrompter(DenyPrompter{})
+	p.Start()
 
-	if resp.StatusCode != http.StatusForbidden {
-		t.Errorf("expected 403 Forbidden for 'never' decision, got %d", resp.StatusCode)
+	proxyURL, _ := url.Parse("http://" + p.Addr())
+	client := &http.Client{
+		Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)},
+		Timeout:   5 * time.Second,
+	}
+	for _, target := range []string{
+		backend.URL,
+		"http://telemetry.example.com/collect",
+		"http://tracker.example.com/",
+		"http://telemetry.example.com/again",
+		"http://169.254.169.254/latest/meta-data/",
+	} {
+		resp, err := client.Get(target)
+		if err != nil {
+			t.Fatalf("request through proxy failed: %v", err)
+		}
+		resp.Body.Close()
 	}
 
-	// Verify the domain was cached as "never"
-	domains := p.Domains()
-	domain := stripPort(host)
-	if domains[domain] != "never" {
-		t.Errorf("expected domain %q to be cached as 'never', got %q", domain, domains[domain])
+	want := []string{"169.254.169.254", "telemetry.example.com", "tracker.example.com"}
+	if got := p.DeniedDomains(); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("DeniedDomains = %v, want %v", got, want)
 	}
 }
 
-func TestProxyCONNECTAllow(t *testing.T) {
-	// Start a TLS backend
-	backend := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Write([]byte("tls-ok"))
+func TestProxyHTTPSOnlyBlocksPlainHTTP(t *testing.T) {
+	hit := false
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hit = true
 	}))
 	defer backend.Close()
 
 	backendURL, _ := url.Parse(backend.URL)
-	host := backendURL.Host
+	domain := stripPort(backendURL.Host)
 
-	domains := map[string]string{stripPort(host): "allow"}
+	domains, httpsOnly := proxyDomains(SandboxConfig{AllowNet: netEntries("https://" + domain)})
 	p, err := NewProxy(domains, "test")
 	if err != nil {
 		t.Fatalf("NewProxy failed: %v", err)
 	}
 	defer p.Shutdown()
+	p.SetHTTPSOnly(httpsOnly)
+	p.SetPrompter(DenyPrompter{})
 	p.Start()
 
 	proxyURL, _ := url.Parse("http://" + p.Addr())
 	client := &http.Client{
-		Transport: &http.Transport{
+		Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)},
+		Timeout:   5 * time.Second,
+	}
+
+	resp, err := client.Get(backend.URL)
+	if err != nil {
+		t.Fatalf("request through proxy failed: %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("expected 403 for plain HTTP to an https-only host, got %d", resp.StatusCode)
+	}
+	if hit {
+		t.Error("request reached the backend")
+	}
+
+	// CONNECT to a port other than 443 is refused too
+	conn, err := net.Dial("tcp", p.Addr())
+	if err != nil {
+		t.Fatalf("dial proxy: %v", err)
+	}
+	defer conn.Close()
+	fmt.Fprintf(conn, "CONNECT %s HTTP/1.1\r\nHost: %s\r\n\r\n", backendURL.Host, backendURL.Host)
+	connectResp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatalf("read CONNECT response: %v", err)
+	}
+	if connectResp.StatusCode != http.StatusForbidden {
+		t.Errorf("expected 403 for CONNECT to port %s, got %d", backendURL.Port(), connectResp.StatusCode)
+	}
+}
+
+func TestProxyCachedDeny(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("should-not-reach"))
+	}))
+	defer backend.Close()
+
+	backendURL, _ := url.Parse(backend.URL)
+	host := backendURL.Host
+
+	domains := map[string]string{stripPort(host): "deny"}
+	p, err := NewProxy(domains, "test")
+	if err != nil {
+		t.Fatalf("NewProxy failed: %v", err)
+	}
+	defer p.Shutdown()
+	p.Start()
+
+	proxyURL, _ := url.Parse("http://" + p.Addr())
+	client := &http.Client{
+		Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)},
+		Timeout:   5 * time.Second,
+	}
+
+	resp, err := client.Get(backend.URL)
+	if err != nil {
+		t.Fatalf("request through proxy failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("expected 403 Forbidden, got %d", resp.StatusCode)
+	}
+}
+
+func TestProxyBlockedResponse(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer backend.Close()
+
+	backendURL, _ := url.Parse(backend.URL)
+	host := backendURL.Host
+	domain := stripPort(host)
+
+	p, err := NewProxy(map[string]string{domain: "deny"}, "test")
+	if err != nil {
+		t.Fatalf("NewProxy failed: %v", err)
+	}
+	defer p.Shutdown()
+	p.Start()
+
+	proxyURL, _ := url.Parse("http://" + p.Addr())
+	client := &http.Client{
+		Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)},
+		Timeout:   5 * time.Second,
+	}
+	resp, err := client.Get(backend.URL)
+	if err != nil {
+		t.Fatalf("request through proxy failed: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("status = %d, want 403", resp.StatusCode)
+	}
+	if got := resp.Header.Get("X-Ddash-Blocked"); got != domain {
+		t.Errorf("X-Ddash-Blocked = %q, want %q", got, domain)
+	}
+	if want := `add "` + domain + `" to allow_net`; !strings.Contains(string(body), want) {
+		t.Errorf("body %q does not explain how to allow the domain", body)
+	}
+
+	// A saved "never" points at network_domains, also for CONNECT
+	p2, err := NewProxy(map[string]string{domain: "never"}, "test")
+	if err != nil {
+		t.Fatalf("NewProxy failed: %v", err)
+	}
+	defer p2.Shutdown()
+	p2.Start()
+
+	conn, err := net.Dial("tcp", p2.Addr())
+	if err != nil {
+		t.Fatalf("dial proxy: %v", err)
+	}
+	defer conn.Close()
+	fmt.Fprintf(conn, "CONNECT %s HTTP/1.1\r\nHost: %s\r\n\r\n", host, host)
+	connectResp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatalf("read CONNECT response: %v", err)
+	}
+	connectBody, _ := io.ReadAll(connectResp.Body)
+	if connectResp.Status != "403 Forbidden" {
+		t.Errorf("CONNECT status = %q, want 403 Forbidden", connectResp.Status)
+	}
+	if got := connectResp.Header.Get("X-Ddash-Blocked"); got != domain {
+		t.Errorf("CONNECT X-Ddash-Blocked = %q, want %q", got, domain)
+	}
+	if !strings.Contains(string(connectBody), "network_domains") {
+		t.Errorf("CONNECT body %q should point at network_domains", connectBody)
+	}
+}
+
+func TestProxyBlocksMetadataAddress(t *testing.T) {
+	domains, _ := proxyDomains(SandboxConfig{AllowNet: netEntries("*")})
+	p, err := NewProxy(domains, "test")
+	if err != nil {
+		t.Fatalf("NewProxy failed: %v", err)
+	}
+	defer p.Shutdown()
+	prompter := &stubPrompter{answers: map[string]string{"169.254.169.254": "allow"}}
+	p.SetPrompter(prompter)
+	p.Start()
+
+	conn, err := net.Dial("tcp", p.Addr())
+	if err != nil {
+		t.Fatalf("dial proxy: %v", err)
+	}
+	defer conn.Close()
+	fmt.Fprintf(conn, "CONNECT 169.254.169.254:80 HTTP/1.1\r\nHost: 169.254.169.254:80\r\n\r\n")
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatalf("read CONNECT response: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("status = %d, want 403", resp.StatusCode)
+	}
+	if !strings.Contains(string(body), "cloud metadata address") {
+		t.Errorf("body %q should name the metadata block", body)
+	}
+	if len(prompter.asked) != 0 {
+		t.Error("metadata address should be refused before prompting")
+	}
+}
+
+func TestProxyMetadataAllowlisted(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer backend.Close()
+
+	domains, _ := proxyDomains(SandboxConfig{AllowNet: netEntries("169.254.169.254")})
+	p, err := NewProxy(domains, "test")
+	if err != nil {
+		t.Fatalf("NewProxy failed: %v", err)
+	}
+	defer p.Shutdown()
+	p.dial = func(network, address string) (net.Conn, error) {
+		return net.Dial(network, backend.Listener.Addr().String())
+	}
+	p.Start()
+
+	conn, err := net.Dial("tcp", p.Addr())
+	if err != nil {
+		t.Fatalf("dial proxy: %v", err)
+	}
+	defer conn.Close()
+	fmt.Fprintf(conn, "CONNECT 169.254.169.254:80 HTTP/1.1\r\nHost: 169.254.169.254:80\r\n\r\n")
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatalf("read CONNECT response: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("allowlisted metadata IP: status = %d, want 200", resp.StatusCode)
+	}
+}
+
+func TestProxyBlockedNetsResolvedHost(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("reached"))
+	}))
+	defer backend.Close()
+
+	backendURL, _ := url.Parse(backend.URL)
+	p, err := NewProxy(map[string]string{}, "test")
+	if err != nil {
+		t.Fatalf("NewProxy failed: %v", err)
+	}
+	defer p.Shutdown()
+	p.SetPrompter(&stubPrompter{answers: map[string]string{"localhost": "allow"}})
+	// Treat loopback as off limits so that "localhost" resolves into it
+	if err := p.SetBlockedNets([]string{"127.0.0.0/8", "::1"}); err != nil {
+		t.Fatalf("SetBlockedNets: %v", err)
+	}
+	p.Start()
+
+	proxyURL, _ := url.Parse("http://" + p.Addr())
+	client := &http.Client{
+		Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)},
+		Timeout:   5 * time.Second,
+	}
+	resp, err := client.Get("http://localhost:" + backendURL.Port())
+	if err != nil {
+		t.Fatalf("request through proxy failed: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden || !strings.Contains(string(body), "localhost resolves to") {
+		t.Errorf("got %d %q, want 403 naming the resolved address", resp.StatusCode, body)
+	}
+
+	// An empty list turns the check off
+	p.SetBlockedNets(nil)
+	resp, err = client.Get("http://localhost:" + backendURL.Port())
+	if err != nil {
+		t.Fatalf("request through proxy failed: %v", err)
+	}
+	body, _ = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "reached" {
+		t.Errorf("with no blocked nets: got %d %q", resp.StatusCode, body)
+	}
+}
+
+func TestProxyCachedAlwaysAllows(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer backend.Close()
+
+	backendURL, _ := url.Parse(backend.URL)
+	host := backendURL.Host
+
+	// "always" should be treated as allowed
+	domains := map[string]string{stripPort(host): "always"}
+	p, err := NewProxy(domains, "test")
+	if err != nil {
+		t.Fatalf("NewProxy failed: %v", err)
+	}
+	defer p.Shutdown()
+	p.Start()
+
+	proxyURL, _ := url.Parse("http://" + p.Addr())
+	client := &http.Client{
+		Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)},
+		Timeout:   5 * time.Second,
+	}
+
+	resp, err := client.Get(backend.URL)
+	if err != nil {
+		t.Fatalf("request through proxy failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+	if string(body) != "ok" {
+		t.Errorf("expected 'ok', got %q", string(body))
+	}
+}
+
+func TestProxyCachedNeverDenies(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("should-not-reach"))
+	}))
+	defer backend.Close()
+
+	backendURL, _ := url.Parse(backend.URL)
+	host := backendURL.Host
+
+	// "never" should be treated as denied
+	domains := map[string]string{stripPort(host): "never"}
+	p, err := NewProxy(domains, "test")
+	if err != nil {
+		t.Fatalf("NewProxy failed: %v", err)
+	}
+	defer p.Shutdown()
+	p.Start()
+
+	proxyURL, _ := url.Parse("http://" + p.Addr())
+	client := &http.Client{
+		Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)},
+		Timeout:   5 * time.Second,
+	}
+
+	resp, err := client.Get(backend.URL)
+	if err != nil {
+		t.Fatalf("request through proxy failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("expected 403 Forbidden, got %d", resp.StatusCode)
+	}
+}
+
+func TestProxyUnknownDomainPrompt(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("reached"))
+	}))
+	defer backend.Close()
+
+	backendURL, _ := url.Parse(backend.URL)
+	host := backendURL.Host
+
+	// No pre-cached domain — proxy will try /dev/tty and fail, defaulting to deny
+	p, err := NewProxy(nil, "test")
+	if err != nil {
+		t.Fatalf("NewProxy failed: %v", err)
+	}
+	defer p.Shutdown()
+	p.Start()
+
+	// Mock the tty with a pipe that provides "a\n" (allow)
+	pr, pw, err := createMockTTY()
+	if err != nil {
+		t.Fatalf("failed to create mock tty: %v", err)
+	}
+	defer pr.Close()
+	defer pw.Close()
+
+	p.SetPrompter(&ttyPrompter{tty: pw}) // Write end as tty — but we need read+write
+
+	// Instead of mocking /dev/tty (which is hard), test that unknown domains
+	// with no tty get denied by default
+	p.SetPrompter(nil) // Reset to the default so it tries /dev/tty
+
+	// For this test, pre-set a mock tty using a pipe
+	mockR, mockW, _ := createPipePair()
+	defer mockR.Close()
+	defer mockW.Close()
+
+	// Write "a\n" to mock input
+	go func() {
+		fmt.Fprint(mockW, "a\n")
+	}()
+
+	p.SetPrompter(&ttyPrompter{tty: mockR})
+
+	proxyURL, _ := url.Parse("http://" + p.Addr())
+	client := &http.Client{
+		Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)},
+		Timeout:   5 * time.Second,
+	}
+
+	resp, err := client.Get(backend.URL)
+	if err != nil {
+		t.Fatalf("request through proxy failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+	if string(body) != "reached" {
+		t.Errorf("expected 'reached' after allowing, got %q (status %d)", string(body), resp.StatusCode)
+	}
+
+	// Verify the domain was cached as "allow"
+	domains := p.Domains()
+	domain := stripPort(host)
+	if domains[domain] != "allow" {
+		t.Errorf("expected domain %q to be cached as 'allow', got %q", domain, domains[domain])
+	}
+}
+
+// An "allow" answer covers the domain for the rest of the run, across
+// the separate connections of subprocesses, but is never written back.
+func TestProxyAllowCoversRun(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer backend.Close()
+
+	backendURL, _ := url.Parse(backend.URL)
+	domain := stripPort(backendURL.Host)
+
+	p, err := NewProxy(nil, "npm install")
+	if err != nil {
+		t.Fatalf("NewProxy failed: %v", err)
+	}
+	defer p.Shutdown()
+	stub := &stubPrompter{answers: map[string]string{domain: "allow"}}
+	p.SetPrompter(stub)
+	p.Start()
+
+	proxyURL, _ := url.Parse("http://" + p.Addr())
+	for i := 0; i < 3; i++ {
+		// A fresh transport per request, like separate processes
+		client := &http.Client{
+			Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL), DisableKeepAlives: true},
+			Timeout:   5 * time.Second,
+		}
+		resp, err := client.Get(backend.URL + fmt.Sprintf("/pkg%d", i))
+		if err != nil {
+			t.Fatalf("request through proxy failed: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("request %d: expected 200, got %d", i, resp.StatusCode)
+		}
+	}
+	if len(stub.asked) != 1 {
+		t.Errorf("expected one prompt for the run, got %d", len(stub.asked))
+	}
+
+	path := filepath.Join(t.TempDir(), ".ddash.json")
+	cfg := SandboxConfig{Name: "test"}
+	writeConfig(path, cfg)
+	saveDomainDecisions(p.Domains(), cfg, path)
+	saved, err := readConfig(path)
+	if err != nil {
+		t.Fatalf("readConfig: %v", err)
+	}
+	if _, ok := saved.NetworkDomains[domain]; ok {
+		t.Errorf("an allow decision must not be saved, got network_domains %v", saved.NetworkDomains)
+	}
+}
+
+func TestProxyAlwaysDecisionSaved(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer backend.Close()
+
+	backendURL, _ := url.Parse(backend.URL)
+	host := backendURL.Host
+
+	p, err := NewProxy(nil, "test")
+	if err != nil {
+		t.Fatalf("NewProxy failed: %v", err)
+	}
+	defer p.Shutdown()
+	p.Start()
+
+	// Mock tty with "l\n" (always)
+	mockR, mockW, _ := createPipePair()
+	defer mockR.Close()
+	defer mockW.Close()
+
+	go func() {
+		fmt.Fprint(mockW, "l\n")
+	}()
+
+	p.SetPrompter(&ttyPrompter{tty: mockR})
+
+	proxyURL, _ := url.Parse("http://" + p.Addr())
+	client := &http.Client{
+		Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)},
+		Timeout:   5 * time.Second,
+	}
+
+	resp, err := client.Get(backend.URL)
+	if err != nil {
+		t.Fatalf("request through proxy failed: %v", err)
+	}
+	resp.Body.Close()
+
+	// Verify the domain was cached as "always"
+	domains := p.Domains()
+	domain := stripPort(host)
+	if domains[domain] != "always" {
+		t.Errorf("expected domain %q to be cached as 'always', got %q", domain, domains[domain])
+	}
+}
+
+func TestProxyNeverDecisionSaved(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("should-not-reach"))
+	}))
+	defer backend.Close()
+
+	backendURL, _ := url.Parse(backend.URL)
+	host := backendURL.Host
+
+	p, err := NewProxy(nil, "test")
+	if err != nil {
+		t.Fatalf("NewProxy failed: %v", err)
+	}
+	defer p.Shutdown()
+	p.Start()
+
+	// Mock tty with "n\n" (never)
+	mockR, mockW, _ := createPipePair()
+	defer mockR.Close()
+	defer mockW.Close()
+
+	go func() {
+		fmt.Fprint(mockW, "n\n")
+	}()
+
+	p.SetPrompter(&ttyPrompter{tty: mockR})
+
+	proxyURL, _ := url.Parse("http://" + p.Addr())
+	client := &http.Client{
+		Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)},
+		Timeout:   5 * time.Second,
+	}
+
+	resp, err := client.Get(backend.URL)
+	if err != nil {
+		t.Fatalf("request through proxy failed: %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("expected 403 Forbidden for 'never' decision, got %d", resp.StatusCode)
+	}
+
+	// Verify the domain was cached as "never"
+	domains := p.Domains()
+	domain := stripPort(host)
+	if domains[domain] != "never" {
+		t.Errorf("expected domain %q to be cached as 'never', got %q", domain, domains[domain])
+	}
+}
+
+func TestProxyCONNECTAllow(t *testing.T) {
+	// Start a TLS backend
+	backend := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("tls-ok"))
+	}))
+	defer backend.Close()
+
+	backendURL, _ := url.Parse(backend.URL)
+	host := backendURL.Host
+
+	domains := map[string]string{stripPort(host): "allow"}
+	p, err := NewProxy(domains, "test")
+	if err != nil {
+		t.Fatalf("NewProxy failed: %v", err)
+	}
+	defer p.Shutdown()
+	p.Start()
+
+	proxyURL, _ := url.Parse("http://" + p.Addr())
+	client := &http.Client{
+		Transport: &http.Transport{
 			Proxy: http.ProxyURL(proxyURL),
 			TLSClientConfig: &tls.Config{
 				InsecureSkipVerify: true,
@@ -384,15 +1136,112 @@ func TestProxyCONNECTAllow(t *testing.T) {
 		Timeout: 5 * time.Second,
 	}
 
-	resp, err := client.Get(backend.URL)
+	resp, err := client.Get(backend.URL)
+	if err != nil {
+		t.Fatalf("CONNECT request through proxy failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+	if string(body) != "tls-ok" {
+		t.Errorf("expected 'tls-ok', got %q", string(body))
+	}
+}
+
+func TestProxyCONNECTIPv6(t *testing.T) {
+	backend := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("tls-ok"))
+	}))
+	defer backend.Close()
+
+	// An allow_net entry may be written with or without brackets
+	for _, entry := range []string{"2001:db8::1", "[2001:db8::1]", "https://[2001:db8::1]:443"} {
+		for _, target := range []string{"[2001:db8::1]:443", "[2001:db8::1]"} {
+			t.Run(entry+" "+target, func(t *testing.T) {
+				domains, httpsOnly := proxyDomains(SandboxConfig{AllowNet: netEntries(entry)})
+				p, err := NewProxy(domains, "test")
+				if err != nil {
+					t.Fatalf("NewProxy failed: %v", err)
+				}
+				defer p.Shutdown()
+				p.SetHTTPSOnly(httpsOnly)
+				p.SetPrompter(DenyPrompter{})
+
+				// 2001:db8::/32 is documentation-only, so route the tunnel
+				// to the local backend and check what the proxy dialed
+				dialed := make(chan string, 1)
+				p.dial = func(network, address string) (net.Conn, error) {
+					dialed <- address
+					return net.Dial(network, backend.Listener.Addr().String())
+				}
+				p.Start()
+
+				conn, err := net.Dial("tcp", p.Addr())
+				if err != nil {
+					t.Fatalf("dial proxy: %v", err)
+				}
+				defer conn.Close()
+				fmt.Fprintf(conn, "CONNECT %s HTTP/1.1\r\nHost: %s\r\n\r\n", target, target)
+				br := bufio.NewReader(conn)
+				resp, err := http.ReadResponse(br, nil)
+				if err != nil {
+					t.Fatalf("read CONNECT response: %v", err)
+				}
+				if resp.StatusCode != http.StatusOK {
+					t.Fatalf("CONNECT %s: status %d", target, resp.StatusCode)
+				}
+				if addr := <-dialed; addr != "[2001:db8::1]:443" {
+					t.Errorf("dialed %q, want [2001:db8::1]:443", addr)
+				}
+
+				tlsConn := tls.Client(conn, &tls.Config{InsecureSkipVerify: true})
+				fmt.Fprintf(tlsConn, "GET / HTTP/1.1\r\nHost: [2001:db8::1]\r\nConnection: close\r\n\r\n")
+				tunneled, err := http.ReadResponse(bufio.NewReader(tlsConn), nil)
+				if err != nil {
+					t.Fatalf("read tunneled response: %v", err)
+				}
+				body, _ := io.ReadAll(tunneled.Body)
+				if string(body) != "tls-ok" {
+					t.Errorf("expected 'tls-ok', got %q", string(body))
+				}
+			})
+		}
+	}
+}
+
+func TestProxyIPv6Prompt(t *testing.T) {
+	p, err := NewProxy(nil, "test")
 	if err != nil {
-		t.Fatalf("CONNECT request through proxy failed: %v", err)
+		t.Fatalf("NewProxy failed: %v", err)
 	}
-	defer resp.Body.Close()
+	defer p.Shutdown()
+	prompter := &stubPrompter{answers: map[string]string{}}
+	p.SetPrompter(prompter)
+	p.Start()
 
-	body, _ := io.ReadAll(resp.Body)
-	if string(body) != "tls-ok" {
-		t.Errorf("expected 'tls-ok', got %q", string(body))
+	conn, err := net.Dial("tcp", p.Addr())
+	if err != nil {
+		t.Fatalf("dial proxy: %v", err)
+	}
+	defer conn.Close()
+	fmt.Fprintf(conn, "CONNECT [2001:db8::1]:8443 HTTP/1.1\r\nHost: [2001:db8::1]:8443\r\n\r\n")
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatalf("read CONNECT response: %v", err)
+	}
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("expected 403 for an unanswered prompt, got %d", resp.StatusCode)
+	}
+
+	if len(prompter.asked) != 1 {
+		t.Fatalf("asked %d times, want 1", len(prompter.asked))
+	}
+	req := prompter.asked[0]
+	if req.Domain != "2001:db8::1" || req.Port != "8443" {
+		t.Errorf("prompt for domain %q port %q, want 2001:db8::1 and 8443", req.Domain, req.Port)
+	}
+	if script := dialogScript(req); !strings.Contains(script, "connect to [2001:db8::1]:8443?") {
+		t.Errorf("dialog shows a malformed target: %s", script)
 	}
 }
 
@@ -435,6 +1284,409 @@ func TestProxyCONNECTDeny(t *testing.T) {
 	}
 }
 
+func TestProxyPromptInfoThenAllow(t *testing.T) {
+	p, err := NewProxy(map[string]string{"known.example.com": "always"}, "test")
+	if err != nil {
+		t.Fatalf("NewProxy failed: %v", err)
+	}
+	defer p.Shutdown()
+
+	mockR, mockW, _ := createPipePair()
+	defer mockR.Close()
+	defer mockW.Close()
+
+	// "i" shows info and re-asks; "a" is the actual decision
+	go func() {
+		fmt.Fprint(mockW, "i\na\n")
+	}()
+
+	p.SetPrompter(&ttyPrompter{tty: mockR})
+
+	if got := p.checkDomain("new.example.com", "443", ""); got != "allow" {
+		t.Errorf("expected 'allow' after info then allow, got %q", got)
+	}
+	if got := p.Domains()["new.example.com"]; got != "allow" {
+		t.Errorf("expected domain cached as 'allow', got %q", got)
+	}
+}
+
+func TestProxyPromptWhoisThenDeny(t *testing.T) {
+	p, err := NewProxy(nil, "test")
+	if err != nil {
+		t.Fatalf("NewProxy failed: %v", err)
+	}
+	defer p.Shutdown()
+
+	var looked []string
+	p.whois = func(ctx context.Context, domain string) (string, error) {
+		looked = append(looked, domain)
+		return "Creation Date: 2026-10-16T00:00:00Z\n", nil
+	}
+
+	mockR, mockW, _ := createPipePair()
+	defer mockR.Close()
+	defer mockW.Close()
+
+	// "w" shows whois and re-asks; "d" is the actual decision
+	go func() {
+		fmt.Fprint(mockW, "w\nd\n")
+	}()
+
+	p.SetPrompter(&ttyPrompter{tty: mockR})
+
+	if got := p.checkDomain("fresh.example.com", "443", ""); got != "deny" {
+		t.Errorf("expected 'deny' after whois then deny, got %q", got)
+	}
+	if len(looked) != 1 || looked[0] != "fresh.example.com" {
+		t.Errorf("whois lookups = %v, want [fresh.example.com]", looked)
+	}
+}
+
+func TestProxyPromptInfoContents(t *testing.T) {
+	p, err := NewProxy(map[string]string{"known.example.com": "always"}, "test")
+	if err != nil {
+		t.Fatalf("NewProxy failed: %v", err)
+	}
+	defer p.Shutdown()
+
+	p.mu.Lock()
+	p.attempts["new.example.com"] = 3
+	p.recordPrompt("blocked.example.com", "deny")
+	var buf strings.Builder
+	p.writeInfo(&buf, "new.example.com", "8443")
+	p.mu.Unlock()
+
+	info := buf.String()
+	for _, want := range []string{"8443", "3 this run", "known.example.com", "blocked.example.com (deny)"} {
+		if !strings.Contains(info, want) {
+			t.Errorf("info output missing %q:\n%s", want, info)
+		}
+	}
+}
+
+func TestProxyPreservesRawRequestURI(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "%s|%s", r.RequestURI, r.Host)
+	}))
+	defer backend.Close()
+
+	backendURL, _ := url.Parse(backend.URL)
+	host := backendURL.Host
+
+	p, err := NewProxy(map[string]string{stripPort(host): "allow"}, "test")
+	if err != nil {
+		t.Fatalf("NewProxy failed: %v", err)
+	}
+	defer p.Shutdown()
+	p.Start()
+
+	tests := []string{
+		"/a%2Fb/{x}?q=a+b&e=%7e&s=%2F",
+		"/plain/path?x=1",
+		"/%41%42?",
+		"//double/slash",
+	}
+
+	for _, target := range tests {
+		conn, err := net.DialTimeout("tcp", p.Addr(), time.Second)
+		if err != nil {
+			t.Fatalf("cannot connect to proxy: %v", err)
+		}
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+		// Write the request line by hand so the client can't normalize it
+		fmt.Fprintf(conn, "GET http://%s%s HTTP/1.1\r\nHost: %s\r\nConnection: close\r\n\r\n", host, target, host)
+		resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+		if err != nil {
+			conn.Close()
+			t.Fatalf("reading response for %q failed: %v", target, err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		conn.Close()
+
+		want := target + "|" + host
+		if strings.HasPrefix(target, "//") {
+			want = "http://" + host + target + "|" + host
+		}
+		if string(body) != want {
+			t.Errorf("request target not preserved:\n got  %q\n want %q", string(body), want)
+		}
+	}
+}
+
+func TestProxyForwardsTrailers(t *testing.T) {
+	var gotReqTrailer, gotEncoding string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		gotReqTrailer = r.Trailer.Get("X-Checksum")
+		gotEncoding = r.Header.Get("Accept-Encoding")
+
+		w.Header().Set("Trailer", "Grpc-Status")
+		w.Write([]byte("payload"))
+		w.Header().Set("Grpc-Status", "0")
+		w.Header().Set(http.TrailerPrefix+"Grpc-Message", "ok")
+	}))
+	defer backend.Close()
+	backendURL, _ := url.Parse(backend.URL)
+
+	p, err := NewProxy(map[string]string{stripPort(backendURL.Host): "allow"}, "test")
+	if err != nil {
+		t.Fatalf("NewProxy failed: %v", err)
+	}
+	defer p.Shutdown()
+	p.Start()
+
+	proxyURL, _ := url.Parse("http://" + p.Addr())
+	client := &http.Client{
+		Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL), DisableCompression: true},
+		Timeout:   5 * time.Second,
+	}
+
+	req, _ := http.NewRequest("POST", backend.URL, io.NopCloser(strings.NewReader("request body")))
+	req.ContentLength = -1
+	req.Trailer = http.Header{"X-Checksum": {"abc123"}}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("request through proxy failed: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+
+	if string(body) != "payload" {
+		t.Errorf("body = %q, want payload", body)
+	}
+	if got := resp.Trailer.Get("Grpc-Status"); got != "0" {
+		t.Errorf("announced trailer Grpc-Status = %q, want 0", got)
+	}
+	if got := resp.Trailer.Get("Grpc-Message"); got != "ok" {
+		t.Errorf("unannounced trailer Grpc-Message = %q, want ok", got)
+	}
+	if gotReqTrailer != "abc123" {
+		t.Errorf("upstream saw request trailer %q, want abc123", gotReqTrailer)
+	}
+	if gotEncoding != "" {
+		t.Errorf("proxy added Accept-Encoding %q the client didn't send", gotEncoding)
+	}
+}
+
+// newUpgradeEchoBackend returns a server that answers any Upgrade request
+// with 101 and then echoes every line it receives.
+func newUpgradeEchoBackend(t *testing.T) *httptest.Server {
+	t.Helper()
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Upgrade") == "" {
+			http.Error(w, "upgrade required", http.StatusUpgradeRequired)
+			return
+		}
+		conn, buf, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		fmt.Fprintf(buf, "HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: %s\r\n\r\n", r.Header.Get("Upgrade"))
+		buf.Flush()
+		for {
+			line, err := buf.ReadString('\n')
+			if err != nil {
+				return
+			}
+			buf.WriteString("echo:" + line)
+			buf.Flush()
+		}
+	}))
+}
+
+// dialUpgrade sends an Upgrade request through the proxy and returns the
+// connection and reader positioned after the 101 response.
+func dialUpgrade(t *testing.T, proxyAddr, host, protocol string) (net.Conn, *bufio.Reader) {
+	t.Helper()
+	conn, err := net.DialTimeout("tcp", proxyAddr, time.Second)
+	if err != nil {
+		t.Fatalf("cannot connect to proxy: %v", err)
+	}
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	fmt.Fprintf(conn, "GET http://%s/socket HTTP/1.1\r\nHost: %s\r\nConnection: Upgrade\r\nUpgrade: %s\r\n\r\n", host, host, protocol)
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		conn.Close()
+		t.Fatalf("reading upgrade response failed: %v", err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		conn.Close()
+		t.Fatalf("expected 101, got %d", resp.StatusCode)
+	}
+	return conn, reader
+}
+
+func TestProxyUpgradeRoundTrip(t *testing.T) {
+	backend := newUpgradeEchoBackend(t)
+	defer backend.Close()
+
+	backendURL, _ := url.Parse(backend.URL)
+	host := backendURL.Host
+
+	p, err := NewProxy(map[string]string{stripPort(host): "allow"}, "test")
+	if err != nil {
+		t.Fatalf("NewProxy failed: %v", err)
+	}
+	defer p.Shutdown()
+	p.Start()
+
+	conn, reader := dialUpgrade(t, p.Addr(), host, "echo-protocol")
+	defer conn.Close()
+
+	for _, msg := range []string{"ping", "pong"} {
+		fmt.Fprintf(conn, "%s\n", msg)
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("reading echo failed: %v", err)
+		}
+		if line != "echo:"+msg+"\n" {
+			t.Errorf("expected echo of %q, got %q", msg, line)
+		}
+	}
+}
+
+func TestProxyUpgradeDenied(t *testing.T) {
+	backend := newUpgradeEchoBackend(t)
+	defer backend.Close()
+
+	backendURL, _ := url.Parse(backend.URL)
+	host := backendURL.Host
+
+	p, err := NewProxy(map[string]string{stripPort(host): "never"}, "test")
+	if err != nil {
+		t.Fatalf("NewProxy failed: %v", err)
+	}
+	defer p.Shutdown()
+	p.Start()
+
+	conn, err := net.DialTimeout("tcp", p.Addr(), time.Second)
+	if err != nil {
+		t.Fatalf("cannot connect to proxy: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	fmt.Fprintf(conn, "GET http://%s/socket HTTP/1.1\r\nHost: %s\r\nConnection: Upgrade\r\nUpgrade: echo-protocol\r\n\r\n", host, host)
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatalf("reading response failed: %v", err)
+	}
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("expected 403 for denied upgrade, got %d", resp.StatusCode)
+	}
+}
+
+// wsAccept computes Sec-WebSocket-Accept for a handshake key (RFC 6455).
+func wsAccept(key string) string {
+	sum := sha1.Sum([]byte(key + "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// TestProxyWebSocketEcho runs a real WebSocket handshake and one masked
+// text frame through the proxy, so the Sec-WebSocket-* headers have to
+// survive the round trip and the frame has to make it over the splice.
+func TestProxyWebSocketEcho(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") || r.Header.Get("Sec-WebSocket-Key") == "" {
+			http.Error(w, "not a websocket handshake", http.StatusBadRequest)
+			return
+		}
+		conn, buf, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		fmt.Fprintf(buf, "HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: websocket\r\nSec-WebSocket-Accept: %s\r\n\r\n", wsAccept(r.Header.Get("Sec-WebSocket-Key")))
+		buf.Flush()
+
+		// Read one short masked frame and echo it back unmasked
+		header := make([]byte, 6)
+		if _, err := io.ReadFull(buf, header); err != nil {
+			return
+		}
+		payload := make([]byte, header[1]&0x7f)
+		if _, err := io.ReadFull(buf, payload); err != nil {
+			return
+		}
+		for i := range payload {
+			payload[i] ^= header[2+i%4]
+		}
+		buf.Write(append([]byte{0x81, byte(len(payload))}, payload...))
+		buf.Flush()
+	}))
+	defer backend.Close()
+
+	host := strings.TrimPrefix(backend.URL, "http://")
+	p, err := NewProxy(map[string]string{stripPort(host): "allow"}, "test")
+	if err != nil {
+		t.Fatalf("NewProxy failed: %v", err)
+	}
+	defer p.Shutdown()
+	p.Start()
+
+	conn, err := net.DialTimeout("tcp", p.Addr(), time.Second)
+	if err != nil {
+		t.Fatalf("cannot connect to proxy: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	key := "dGhlIHNhbXBsZSBub25jZQ=="
+	fmt.Fprintf(conn, "GET http://%s/ws HTTP/1.1\r\nHost: %s\r\nConnection: Upgrade\r\nUpgrade: websocket\r\nSec-WebSocket-Version: 13\r\nSec-WebSocket-Key: %s\r\n\r\n", host, host, key)
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatalf("reading handshake response failed: %v", err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("expected 101, got %d", resp.StatusCode)
+	}
+	if got := resp.Header.Get("Sec-WebSocket-Accept"); got != wsAccept(key) {
+		t.Errorf("Sec-WebSocket-Accept = %q, want %q", got, wsAccept(key))
+	}
+
+	msg := []byte("hello")
+	mask := []byte{1, 2, 3, 4}
+	frame := append([]byte{0x81, 0x80 | byte(len(msg))}, mask...)
+	for i, b := range msg {
+		frame = append(frame, b^mask[i%4])
+	}
+	conn.Write(frame)
+
+	echo := make([]byte, 2+len(msg))
+	if _, err := io.ReadFull(reader, echo); err != nil {
+		t.Fatalf("reading echoed frame failed: %v", err)
+	}
+	if echo[0] != 0x81 || string(echo[2:]) != "hello" {
+		t.Errorf("echoed frame = %q, want a text frame with %q", echo, msg)
+	}
+}
+
+func TestSplitRequestURI(t *testing.T) {
+	tests := []struct {
+		input, path, query string
+	}{
+		{"http://example.com/a%2Fb?x=%7e", "/a%2Fb", "x=%7e"},
+		{"http://example.com", "/", ""},
+		{"http://example.com?x=1", "/", "x=1"},
+		{"/origin/form?y", "/origin/form", "y"},
+		{"/a?u=http://b", "/a", "u=http://b"},
+		{"/r/http://b/c", "/r/http://b/c", ""},
+	}
+
+	for _, tt := range tests {
+		path, query := splitRequestURI(tt.input)
+		if path != tt.path || query != tt.query {
+			t.Errorf("splitRequestURI(%q) = (%q, %q), want (%q, %q)", tt.input, path, query, tt.path, tt.query)
+		}
+	}
+}
+
 func TestProxyDomainsReturnsCopy(t *testing.T) {
 	domains := map[string]string{"example.com": "allow"}
 	p, err := NewProxy(domains, "test")
@@ -463,6 +1715,9 @@ func TestStripPort(t *testing.T) {
 		{"127.0.0.1:8080", "127.0.0.1"},
 		{"example.com", "example.com"},
 		{"[::1]:443", "::1"},
+		{"[::1]", "::1"},
+		{"[2001:db8::1]:443", "2001:db8::1"},
+		{"2001:db8::1", "2001:db8::1"},
 	}
 
 	for _, tt := range tests {
@@ -473,6 +1728,83 @@ func TestStripPort(t *testing.T) {
 	}
 }
 
+func TestProxyLookupPorts(t *testing.T) {
+	domains, _ := proxyDomains(SandboxConfig{
+		AllowNet: netEntries("any.example.com:*", "https://api.example.com:443", "plain.example.com", "*.cdn.example.com:8443",
+			"web.example.com:443", "saved.example.com:*"),
+		NetworkDomains: map[string]string{
+			"api.example.com":       "never",
+			"saved.example.com":     "never",
+			"any.example.com:22":    "never",
+			"*.cdn.example.com:*":   "never",
+			"mixed.example.com":     "allow",
+			"mixed.example.com:*":   "never",
+			"mixed.example.com:443": "allow",
+		},
+	})
+	p, err := NewProxy(domains, "test")
+	if err != nil {
+		t.Fatalf("NewProxy failed: %v", err)
+	}
+	defer p.Shutdown()
+
+	tests := []struct {
+		domain, port, want string
+	}{
+		{"any.example.com", "443", "allow"},
+		{"any.example.com", "8080", "allow"},
+		{"any.example.com", "22", "never"},     // a specific port beats :*
+		{"web.example.com", "443", "allow"},    // port-specific
+		{"web.example.com", "80", ""},          // other ports are prompted for
+		{"api.example.com", "443", "never"},    // a saved bare-host decision beats allow_net host:port
+		{"api.example.com", "80", "never"},     // and covers every port
+		{"saved.example.com", "8080", "never"}, // and beats host:*
+		{"plain.example.com", "80", "allow"},   // no port: every port
+		{"plain.example.com", "9000", "allow"},
+		{"mixed.example.com", "443", "allow"},
+		{"mixed.example.com", "80", "never"}, // saved :* beats the saved bare host
+		{"img.cdn.example.com", "8443", "allow"},
+		{"img.cdn.example.com", "443", "never"},
+		{"other.example.com", "443", ""},
+	}
+	for _, tt := range tests {
+		p.mu.Lock()
+		got, known := p.lookup(tt.domain, tt.port)
+		p.mu.Unlock()
+		if got != tt.want || known != (tt.want != "") {
+			t.Errorf("lookup(%s, %s) = %q, %v, want %q", tt.domain, tt.port, got, known, tt.want)
+		}
+	}
+
+	p.SetPrompter(DenyPrompter{})
+	if d := p.checkDomain("web.example.com", "443", ""); d != DecisionAllow {
+		t.Errorf("web.example.com:443 = %s, want allow", d)
+	}
+	// The blocked_nets exemption reaches only the ports an entry allows
+	if !p.exempt("web.example.com", "443") {
+		t.Error("a host allowed on 443 should be exempt from blocked_nets there")
+	}
+	if p.exempt("web.example.com", "80") {
+		t.Error("a host allowed on 443 should not be exempt on other ports")
+	}
+	if !p.exempt("any.example.com", "9000") || !p.exempt("plain.example.com", "9000") {
+		t.Error("host:* and bare host entries should be exempt on every port")
+	}
+}
+
+func TestValidateNetPort(t *testing.T) {
+	for _, entry := range []string{"example.com", "example.com:*", "example.com:443", "https://example.com:8443/", "[2001:db8::1]:443", "2001:db8::1"} {
+		if err := validateNetPort(entry); err != nil {
+			t.Errorf("validateNetPort(%q): %v", entry, err)
+		}
+	}
+	for _, entry := range []string{"example.com:0", "example.com:70000", "example.com:https"} {
+		if err := validateNetPort(entry); err == nil {
+			t.Errorf("validateNetPort(%q) should fail", entry)
+		}
+	}
+}
+
 func TestIsAllowed(t *testing.T) {
 	if !isAllowed("allow") {
 		t.Error("'allow' should be allowed")
@@ -480,6 +1812,9 @@ func TestIsAllowed(t *testing.T) {
 	if !isAllowed("always") {
 		t.Error("'always' should be allowed")
 	}
+	if !isAllowed("session") {
+		t.Error("'session' should be allowed")
+	}
 	if isAllowed("deny") {
 		t.Error("'deny' should not be allowed")
 	}
@@ -493,12 +1828,12 @@ func TestIsAllowed(t *testing.T) {
 
 func TestGenerateProfileProxyMode(t *testing.T) {
 	cfg := SandboxConfig{
-		AllowNet:   []string{},
-		AllowRead:  []string{"."},
+		AllowNet:   []NetEntry{},
+		AllowRead:  pathEntries("."),
 		AllowWrite: []string{"."},
 	}
 
-	profile := generateProfile(cfg, false, true)
+	profile := GenerateProfile(cfg, false, true)
 
 	if !strings.Contains(profile, "Interactive proxy mode") {
 		t.Error("proxy mode profile should contain proxy mode comment")
@@ -511,6 +1846,30 @@ func TestGenerateProfileProxyMode(t *testing.T) {
 	}
 }
 
+func TestGenerateProfileProxyModeOverridesAllowAll(t *testing.T) {
+	cfg := SandboxConfig{
+		AllowNet:   netEntries("*"),
+		AllowRead:  pathEntries("."),
+		AllowWrite: []string{"."},
+	}
+
+	profile := GenerateProfile(cfg, false, true)
+
+	if strings.Contains(profile, "(allow network*)\n") {
+		t.Error(`--net must win over allow_net ["*"], but the profile opens the network`)
+	}
+	if !strings.Contains(profile, `(allow network* (remote ip "localhost:*"))`) {
+		t.Error("proxy mode profile should allow the local proxy")
+	}
+	if !strings.Contains(profile, "overridden") {
+		t.Error("profile should note that allow_net was overridden")
+	}
+
+	if !strings.Contains(GenerateProfile(cfg, false, false), "(allow network*)\n") {
+		t.Error(`without --net, allow_net ["*"] should open the network`)
+	}
+}
+
 // createMockTTY creates a pipe pair that can simulate /dev/tty for testing.
 func createMockTTY() (r *os.File, w *os.File, err error) {
 	return os.Pipe()
@@ -0,0 +1,317 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+const proxyUsage = `Run the --net proxy without 'ddash run'
+
+Usage:
+  ddash proxy [flags]
+  ddash proxy stop [pid]
+
+Starts the interactive network proxy of 'ddash run --net' on its own and
+prints shell export lines pointing HTTP_PROXY, HTTPS_PROXY and ALL_PROXY
+(and their lowercase forms) at it. Programs that honor those variables get
+the same per-domain prompts and policy as under 'ddash run --net', but no
+sandbox: nothing stops a program from ignoring them and connecting
+directly.
+
+The proxy serves until it gets SIGINT or SIGTERM, or 'ddash proxy stop'.
+Then, as after 'ddash run --net', "always"/"never" answers are saved to
+.ddash.json and [o]nce-session answers to the shell session.
+
+With --detach the proxy moves to the background once it listens, so its
+output can be eval'ed. A detached proxy has no terminal to prompt on: it
+asks in a macOS dialog and denies new domains when it can't show one.
+
+'ddash proxy stop' stops the proxy named by $DDASH_PROXY_PID (or pid),
+waits until it has saved its decisions and prints unset lines for the
+variables.
+
+Examples:
+  eval "$(ddash proxy --detach)"
+  curl https://example.com        # prompts for example.com
+  eval "$(ddash proxy stop)"
+
+  ddash proxy > proxy.env         # foreground; source proxy.env elsewhere
+
+Flags:
+  --config <file>   Load this config instead of .ddash.json (repeatable)
+  --no-config       Use the built-in default policy; decisions are not saved
+  --detach          Serve in the background and exit once listening
+  --name <name>     Name shown in prompts (default "proxy client")
+  --group-prompts   Ask once about new domains requested close together
+  --monitor         Let every domain through unasked, even ones saved as
+                    "never", and list those a strict policy would block.
+                    A config's net_mode "monitor" is ignored without it
+  --audit           Let every domain through unasked and print each one
+                    a strict policy would prompt for or deny. A config's
+                    "enforcement": "audit" is ignored without it
+  --prompt-timeout <duration>
+                    Deny a prompt nobody answers within <duration>, and
+                    every new domain after it
+  --audit-log <file>
+                    Append a timestamped line per connection decision
+  -h, --help        Show help`
+
+// proxyPIDEnv names the variable 'ddash proxy' exports with its pid, for
+// 'ddash proxy stop'.
+const proxyPIDEnv = "DDASH_PROXY_PID"
+
+// proxyEnvVars are the variables 'ddash proxy' points at itself.
+var proxyEnvVars = []string{"HTTP_PROXY", "HTTPS_PROXY", "ALL_PROXY", "http_proxy", "https_proxy", "all_proxy"}
+
+// proxyStopTimeout bounds how long 'ddash proxy stop' waits for the proxy
+// to drain and save its decisions.
+const proxyStopTimeout = 5 * time.Second
+
+// proxyState is what a running 'ddash proxy' records about itself.
+type proxyState struct {
+	PID  int    `json:"pid"`
+	Addr string `json:"addr"`
+}
+
+// proxyStatePath is where the proxy with pid keeps its state. The file
+// exists exactly while that proxy serves, so 'ddash proxy stop' only
+// signals processes that are this user's ddash proxies.
+func proxyStatePath(pid int) string {
+	return filepath.Join(os.TempDir(), fmt.Sprintf("ddash-proxy-%d-%d.json", os.Getuid(), pid))
+}
+
+func proxyCmd() error {
+	args := os.Args[2:]
+	if len(args) > 0 && args[0] == "stop" {
+		return proxyStopCmd(args[1:])
+	}
+
+	var configs []string
+	var noConfig, detach, detached, groupPrompts, monitor, audit bool
+	var name, auditLog string
+	var promptTimeout time.Duration
+	fs := newFlagSet("proxy")
+	fs.Var((*stringList)(&configs), "config", "")
+	fs.BoolVar(&noConfig, "no-config", false, "")
+	fs.BoolVar(&detach, "detach", false, "")
+	fs.BoolVar(&detached, "serve-detached", false, "") // set by --detach for the background process
+	fs.StringVar(&name, "name", "proxy client", "")
+	fs.BoolVar(&groupPrompts, "group-prompts", false, "")
+	fs.BoolVar(&monitor, "monitor", false, "")
+	fs.BoolVar(&audit, "audit", false, "")
+	fs.DurationVar(&promptTimeout, "prompt-timeout", 0, "")
+	fs.StringVar(&auditLog, "audit-log", "", "")
+	err := fs.Parse(args)
+	if errors.Is(err, flag.ErrHelp) {
+		fmt.Println(proxyUsage)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return fmt.Errorf("unexpected argument: %s", fs.Arg(0))
+	}
+	if noConfig && len(configs) > 0 {
+		return fmt.Errorf("--no-config cannot be combined with --config")
+	}
+	if promptTimeout < 0 {
+		return fmt.Errorf("--prompt-timeout must not be negative")
+	}
+	if detach && !detached {
+		return detachProxy(os.Args[1:])
+	}
+
+	var cfg SandboxConfig
+	if noConfig {
+		cfg = defaultRunConfig()
+	} else {
+		cfg, err = loadRunConfigs(configs)
+	}
+	if err != nil {
+		return err
+	}
+	if cfg, err = withRemoteNet(cfg); err != nil {
+		return err
+	}
+	runCfg := cfg
+	if !noConfig {
+		runCfg = withSessionDecisions(cfg)
+	}
+	noteIgnoredMonitor(os.Stderr, cfg, monitor)
+	runCfg = withAuditFlag(os.Stderr, runCfg, audit)
+
+	opts := RunOptions{
+		InteractiveNet: true,
+		Monitor:        monitor,
+		GroupPrompts:   groupPrompts,
+		PromptTimeout:  promptTimeout,
+	}
+	if detached {
+		opts.Prompter = NewDialogPrompter()
+	}
+	if auditLog != "" {
+		opts.AuditLog = AuditConfig{Path: auditLog, MaxSize: 10 << 20, MaxFiles: 3}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	pid := os.Getpid()
+	statePath := proxyStatePath(pid)
+	decisions, err := serveProxy(ctx, runCfg, opts, name, func(addr string) error {
+		data, _ := json.Marshal(proxyState{PID: pid, Addr: addr})
+		if err := os.WriteFile(statePath, data, 0600); err != nil {
+			return fmt.Errorf("failed to record proxy state: %w", err)
+		}
+		writeProxyExports(os.Stdout, addr, pid)
+		fmt.Fprintf(os.Stderr, "ddash: proxy listening on %s (pid %d); stop it with 'ddash proxy stop'\n", addr, pid)
+		if detached {
+			// EOF tells the foreground ddash the exports are complete
+			os.Stdout.Close()
+		}
+		return nil
+	})
+	defer os.Remove(statePath)
+	if err != nil {
+		return err
+	}
+	if !noConfig {
+		saveRunDecisions(decisions, cfg, configs)
+	}
+	return nil
+}
+
+// serveProxy runs a --net proxy for cfg until ctx is cancelled and returns
+// its domain decisions. ready is called with the proxy's address once it
+// serves; if it fails, the proxy is shut down.
+func serveProxy(ctx context.Context, cfg SandboxConfig, opts RunOptions, name string, ready func(addr string) error) (map[string]string, error) {
+	proxy, err := newConfiguredProxy(cfg, opts, name)
+	if err != nil {
+		return nil, err
+	}
+	proxy.Start()
+	if err := ready(proxy.Addr()); err != nil {
+		proxy.Shutdown()
+		return nil, err
+	}
+
+	<-ctx.Done()
+	drainCtx, cancel := context.WithTimeout(context.Background(), proxyDrainTimeout)
+	defer cancel()
+	proxy.ShutdownContext(drainCtx)
+	return proxy.Domains(), nil
+}
+
+// writeProxyExports prints the shell lines that point programs at the
+// proxy listening on addr.
+func writeProxyExports(w io.Writer, addr string, pid int) {
+	proxyURL := "http://" + addr
+	for _, name := range proxyEnvVars {
+		fmt.Fprintf(w, "export %s=%s\n", name, proxyURL)
+	}
+	fmt.Fprintf(w, "export %s=%d\n", proxyPIDEnv, pid)
+}
+
+// detachProxy starts 'ddash proxy' again as a background process in a
+// session of its own, relays the export lines it prints once listening,
+// and returns while it keeps serving. args are ddash's arguments.
+func detachProxy(args []string) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("can't find the ddash binary: %w", err)
+	}
+	cmd := exec.Command(exe, append(args, "--serve-detached")...)
+	cmd.Env = detachedEnv()
+	cmd.Stderr = os.Stderr
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start the proxy: %w", err)
+	}
+	exports, _ := io.ReadAll(stdout)
+	if len(exports) == 0 {
+		// It exited before listening and has said why on stderr
+		if err := cmd.Wait(); err != nil {
+			return fmt.Errorf("proxy failed to start: %w", err)
+		}
+		return fmt.Errorf("proxy exited before listening")
+	}
+	os.Stdout.Write(exports)
+	return cmd.Process.Release()
+}
+
+// detachedEnv is the environment of a detached proxy. Its parent is this
+// short-lived ddash rather than the shell, so the shell's session key is
+// resolved here and handed down in $DDASH_SESSION; otherwise [o]nce-session
+// answers would end up in a session nobody else shares.
+func detachedEnv() []string {
+	env := os.Environ()
+	if os.Getenv(sessionEnv) == "" {
+		key, _ := sessionKey()
+		env = append(env, sessionEnv+"="+key)
+	}
+	return env
+}
+
+// proxyStopCmd stops the proxy named by pid or $DDASH_PROXY_PID.
+func proxyStopCmd(args []string) error {
+	target := os.Getenv(proxyPIDEnv)
+	if len(args) > 0 {
+		target = args[0]
+	}
+	if target == "" {
+		return fmt.Errorf("no proxy to stop: %s is not set and no pid was given", proxyPIDEnv)
+	}
+	pid, err := strconv.Atoi(target)
+	if err != nil || pid <= 0 {
+		return fmt.Errorf("invalid proxy pid %q", target)
+	}
+
+	path := proxyStatePath(pid)
+	info, err := os.Lstat(path)
+	if err != nil || !info.Mode().IsRegular() {
+		return fmt.Errorf("no ddash proxy with pid %d is running", pid)
+	}
+	if st, ok := info.Sys().(*syscall.Stat_t); ok && int(st.Uid) != os.Getuid() {
+		return fmt.Errorf("no ddash proxy with pid %d is running", pid)
+	}
+	if !processAlive(pid) {
+		// Killed before it could clean up
+		os.Remove(path)
+		return fmt.Errorf("no ddash proxy with pid %d is running", pid)
+	}
+	if err := syscall.Kill(pid, syscall.SIGTERM); err != nil {
+		return fmt.Errorf("failed to stop proxy %d: %w", pid, err)
+	}
+
+	// The proxy removes its state file once its decisions are saved
+	deadline := time.Now().Add(proxyStopTimeout)
+	for {
+		if _, err := os.Lstat(path); errors.Is(err, os.ErrNotExist) {
+			break
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("proxy %d did not stop within %s", pid, proxyStopTimeout)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	for _, name := range proxyEnvVars {
+		fmt.Printf("unset %s\n", name)
+	}
+	fmt.Printf("unset %s\n", proxyPIDEnv)
+	return nil
+}
@@ -0,0 +1,138 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestServeProxyReturnsDecisions(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("reached"))
+	}))
+	defer backend.Close()
+	backendURL, _ := url.Parse(backend.URL)
+
+	cfg := SandboxConfig{AllowNet: []NetEntry{}, NetworkDomains: map[string]string{"blocked.example": "never"}}
+	opts := RunOptions{InteractiveNet: true, Prompter: &stubPrompter{answers: map[string]string{"127.0.0.1": "always"}}}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ready := make(chan string, 1)
+	type served struct {
+		decisions map[string]string
+		err       error
+	}
+	done := make(chan served, 1)
+	go func() {
+		decisions, err := serveProxy(ctx, cfg, opts, "test", func(addr string) error {
+			ready <- addr
+			return nil
+		})
+		done <- served{decisions, err}
+	}()
+
+	var addr string
+	select {
+	case addr = <-ready:
+	case res := <-done:
+		t.Fatalf("serveProxy returned before listening: %v", res.err)
+	}
+
+	var exports bytes.Buffer
+	writeProxyExports(&exports, addr, 42)
+	for _, want := range []string{"export HTTPS_PROXY=http://" + addr + "\n", "export ALL_PROXY=http://" + addr + "\n", "export DDASH_PROXY_PID=42\n"} {
+		if !strings.Contains(exports.String(), want) {
+			t.Errorf("exports missing %q:\n%s", want, exports.String())
+		}
+	}
+
+	proxyURL, _ := url.Parse("http://" + addr)
+	client := &http.Client{
+		Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)},
+		Timeout:   5 * time.Second,
+	}
+	resp, err := client.Get(backend.URL)
+	if err != nil {
+		t.Fatalf("request through proxy failed: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "reached" {
+		t.Errorf("got %d %q, want the backend reached", resp.StatusCode, body)
+	}
+
+	cancel()
+	res := <-done
+	if res.err != nil {
+		t.Fatalf("serveProxy: %v", res.err)
+	}
+	if got := res.decisions[backendURL.Hostname()]; got != "always" {
+		t.Errorf("decision for %s = %q, want the prompt's answer", backendURL.Hostname(), got)
+	}
+	if got := res.decisions["blocked.example"]; got != "never" {
+		t.Errorf("decision for blocked.example = %q, want the config's", got)
+	}
+}
+
+func TestProxyStopRequiresRunningProxy(t *testing.T) {
+	t.Setenv(proxyPIDEnv, "")
+	if err := proxyStopCmd(nil); err == nil || !strings.Contains(err.Error(), proxyPIDEnv) {
+		t.Errorf("err = %v, want a hint about %s", err, proxyPIDEnv)
+	}
+	if err := proxyStopCmd([]string{"abc"}); err == nil {
+		t.Error("a non-numeric pid should be rejected")
+	}
+
+	// A live process that isn't a ddash proxy is never signalled
+	sleeper := exec.Command("sleep", "10")
+	if err := sleeper.Start(); err != nil {
+		t.Fatalf("starting sleep: %v", err)
+	}
+	defer sleeper.Process.Kill()
+	pid := sleeper.Process.Pid
+	if err := proxyStopCmd([]string{strconv.Itoa(pid)}); err == nil || !strings.Contains(err.Error(), "no ddash proxy") {
+		t.Errorf("err = %v, want no proxy found", err)
+	}
+	if !processAlive(pid) {
+		t.Error("stop signalled a process without proxy state")
+	}
+
+	// State left by a proxy that was killed is cleaned up
+	sleeper.Process.Kill()
+	sleeper.Wait()
+	path := proxyStatePath(pid)
+	os.WriteFile(path, []byte(`{"pid":1,"addr":"127.0.0.1:1"}`), 0600)
+	defer os.Remove(path)
+	if err := proxyStopCmd([]string{strconv.Itoa(pid)}); err == nil {
+		t.Error("stopping an exited proxy should fail")
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Error("stale proxy state should be removed")
+	}
+}
+
+func TestDetachedEnvKeepsShellSession(t *testing.T) {
+	t.Setenv(sessionEnv, "")
+	want := fmt.Sprintf("%s=pid-%d", sessionEnv, os.Getppid())
+	if env := detachedEnv(); env[len(env)-1] != want {
+		t.Errorf("detached env ends with %q, want %q", env[len(env)-1], want)
+	}
+
+	t.Setenv(sessionEnv, "ci-42")
+	for _, kv := range detachedEnv() {
+		if strings.HasPrefix(kv, sessionEnv+"=") && kv != sessionEnv+"=ci-42" {
+			t.Errorf("an explicit session was replaced by %q", kv)
+		}
+	}
+}
@@ -0,0 +1,277 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// recordVersion is bumped when runRecord changes incompatibly.
+const recordVersion = 1
+
+// runRecord captures the effective inputs and outcome of a 'ddash run' so
+// it can be replayed later with --replay and compared.
+type runRecord struct {
+	Version     int               `json:"version"`
+	RecordedAt  string            `json:"recorded_at"`
+	Command     []string          `json:"command"`
+	Dir         string            `json:"dir"`             // where ddash ran; config paths resolve here
+	Chdir       string            `json:"chdir,omitempty"` // child working directory, from --chdir
+	Config      SandboxConfig     `json:"config"`
+	Flags       recordFlags       `json:"flags"`
+	ScrubbedEnv []string          `json:"scrubbed_env,omitempty"` // names only, never values
+	Decisions   map[string]string `json:"decisions,omitempty"`
+	Denials     []Denial          `json:"denials,omitempty"`
+	ExitCode    int               `json:"exit_code"`
+}
+
+// recordFlags are the run flags that shape the policy beyond the config.
+type recordFlags struct {
+	DenyWrite      bool     `json:"deny_write,omitempty"`
+	InteractiveNet bool     `json:"net,omitempty"`
+	Monitor        bool     `json:"monitor,omitempty"`
+	Audit          bool     `json:"audit,omitempty"`
+	NoSandbox      bool     `json:"no_sandbox,omitempty"`
+	PassEnv        bool     `json:"pass_env,omitempty"`
+	RedactEnv      bool     `json:"redact,omitempty"`
+	ParanoidEnv    bool     `json:"paranoid,omitempty"`
+	KeepEnv        []string `json:"keep_env,omitempty"`
+}
+
+// newRunRecord builds the record of a finished run.
+func newRunRecord(cfg SandboxConfig, command []string, opts RunOptions, result ExitResult) runRecord {
+	dir, _ := os.Getwd()
+	rec := runRecord{
+		Version:    recordVersion,
+		RecordedAt: time.Now().UTC().Format(time.RFC3339),
+		Command:    command,
+		Dir:        dir,
+		Chdir:      opts.Dir,
+		Config:     cfg,
+		Flags: recordFlags{
+			DenyWrite:      opts.DenyWrite,
+			InteractiveNet: opts.InteractiveNet,
+			Monitor:        opts.Monitor,
+			Audit:          opts.Audit,
+			NoSandbox:      opts.NoSandbox,
+			PassEnv:        opts.PassEnv,
+			RedactEnv:      opts.RedactEnv,
+			ParanoidEnv:    opts.ParanoidEnv,
+			KeepEnv:        opts.KeepEnv,
+		},
+		Decisions: result.Decisions,
+		Denials:   result.Denials,
+		ExitCode:  result.ExitCode,
+	}
+	// Stamped over the effective policy, so a replay notices edits to it
+	rec.Config.Checksum = computeChecksum(cfg)
+	if !opts.PassEnv && !opts.RedactEnv {
+		rec.ScrubbedEnv = sensitiveEnvNames(opts.ParanoidEnv, opts.KeepEnv)
+	}
+	return rec
+}
+
+// writeRunRecord saves rec as JSON. The file is private to the user since
+// the command line may carry arguments worth keeping to oneself.
+func writeRunRecord(path string, rec runRecord) error {
+	data, err := json.MarshalIndent(rec, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0600); err != nil {
+		return fmt.Errorf("failed to write record: %w", err)
+	}
+	return nil
+}
+
+// readRunRecord loads a record written by --record.
+func readRunRecord(path string) (runRecord, error) {
+	var rec runRecord
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return rec, fmt.Errorf("failed to read record: %w", err)
+	}
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return rec, fmt.Errorf("invalid record %s: %w", path, err)
+	}
+	if rec.Version != recordVersion {
+		return rec, fmt.Errorf("record %s has version %d, this ddash reads version %d", path, rec.Version, recordVersion)
+	}
+	if len(rec.Command) == 0 {
+		return rec, fmt.Errorf("record %s has no command", path)
+	}
+	return rec, nil
+}
+
+// sensitiveEnvNames lists the variables in the environment that scrubbing
+// removes, sorted. paranoid and keep are as for scrubEnv.
+func sensitiveEnvNames(paranoid bool, keep []string) []string {
+	var names []string
+	for _, env := range os.Environ() {
+		name, _, _ := strings.Cut(env, "=")
+		if envScrubbed(name, paranoid, keep) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// replayRun re-runs a recorded command under its recorded policy and
+// reports how the outcome differs. Domains the recording never saw are
+// denied rather than prompted, so a replay needs no answers and every new
+// host shows up as a difference. The recorded policy goes through the same
+// checks as a fresh run (see checkRunPolicy).
+func replayRun(path, recordPath string, iKnow bool, confineTo string) error {
+	rec, err := readRunRecord(path)
+	if err != nil {
+		return err
+	}
+
+	// Relative config paths resolve against the working directory
+	if err := os.Chdir(rec.Dir); err != nil {
+		return fmt.Errorf("cannot replay in %s: %w", rec.Dir, err)
+	}
+	home, _ := os.UserHomeDir()
+	if err := checkRunPolicy(os.Stderr, rec.Config, rec.Config, rec.Dir, home, iKnow, confineTo); err != nil {
+		return err
+	}
+
+	opts := RunOptions{
+		DenyWrite:      rec.Flags.DenyWrite,
+		InteractiveNet: rec.Flags.InteractiveNet,
+		Monitor:        rec.Flags.Monitor,
+		Audit:          rec.Flags.Audit,
+		NoSandbox:      rec.Flags.NoSandbox,
+		PassEnv:        rec.Flags.PassEnv,
+		RedactEnv:      rec.Flags.RedactEnv,
+		ParanoidEnv:    rec.Flags.ParanoidEnv,
+		KeepEnv:        rec.Flags.KeepEnv,
+		LogDenials:     true,
+		ForwardSignals: true,
+		Dir:            rec.Chdir,
+		Prompter:       DenyPrompter{},
+	}
+
+	runCfg := rec.Config
+	if opts.InteractiveNet {
+		runCfg.NetworkDomains = make(map[string]string)
+		for domain, decision := range rec.Config.NetworkDomains {
+			runCfg.NetworkDomains[domain] = decision
+		}
+		for domain, decision := range rec.Decisions {
+			runCfg.NetworkDomains[domain] = decision
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "ddash: replaying %s recorded %s\n", path, rec.RecordedAt)
+	result, runErr := Run(context.Background(), runCfg, rec.Command, opts)
+	if runErr != nil {
+		return runErr
+	}
+
+	replayed := newRunRecord(rec.Config, rec.Command, opts, result)
+	if recordPath != "" {
+		if err := writeRunRecord(recordPath, replayed); err != nil {
+			return err
+		}
+	}
+
+	diffs := compareRuns(rec, replayed)
+	printRunDiff(os.Stderr, diffs)
+	if len(diffs) > 0 {
+		return fmt.Errorf("replay differs from %s (%d difference(s))", path, len(diffs))
+	}
+	if result.ExitCode != 0 {
+		os.Exit(exitStatus(result.ExitCode))
+	}
+	return nil
+}
+
+// compareRuns describes how replayed differs from recorded: exit code,
+// new or changed network decisions, new or vanished sandbox denials and
+// changes in which env vars were scrubbed.
+func compareRuns(recorded, replayed runRecord) []string {
+	var diffs []string
+
+	if recorded.ExitCode != replayed.ExitCode {
+		diffs = append(diffs, fmt.Sprintf("exit code %d -> %d", recorded.ExitCode, replayed.ExitCode))
+	}
+
+	for _, domain := range sortedKeys(replayed.Decisions) {
+		now := Decision(replayed.Decisions[domain])
+		before, seen := recorded.Decisions[domain]
+		switch {
+		case !seen:
+			diffs = append(diffs, fmt.Sprintf("new host %s (%s)", domain, now))
+		case Decision(before).IsAllowed() != now.IsAllowed():
+			diffs = append(diffs, fmt.Sprintf("host %s %s -> %s", domain, before, now))
+		}
+	}
+
+	before := denialSet(recorded.Denials)
+	after := denialSet(replayed.Denials)
+	for _, key := range sortedKeys(after) {
+		if _, ok := before[key]; !ok {
+			diffs = append(diffs, "new denial "+key)
+		}
+	}
+	for _, key := range sortedKeys(before) {
+		if _, ok := after[key]; !ok {
+			diffs = append(diffs, "denial gone "+key)
+		}
+	}
+
+	wasScrubbed := make(map[string]bool)
+	for _, name := range recorded.ScrubbedEnv {
+		wasScrubbed[name] = true
+	}
+	isScrubbed := make(map[string]bool)
+	for _, name := range replayed.ScrubbedEnv {
+		isScrubbed[name] = true
+		if !wasScrubbed[name] {
+			diffs = append(diffs, "env var now scrubbed "+name)
+		}
+	}
+	for _, name := range recorded.ScrubbedEnv {
+		if !isScrubbed[name] {
+			diffs = append(diffs, "env var no longer scrubbed "+name)
+		}
+	}
+
+	return diffs
+}
+
+// denialSet keys denials by process, operation and target, ignoring counts
+// which vary from run to run.
+func denialSet(denials []Denial) map[string]Denial {
+	set := make(map[string]Denial, len(denials))
+	for _, d := range denials {
+		key := d.Operation
+		if d.Target != "" {
+			key += " " + d.Target
+		}
+		if d.Process != "" {
+			key = d.Process + ": " + key
+		}
+		set[key] = d
+	}
+	return set
+}
+
+// printRunDiff reports the result of compareRuns.
+func printRunDiff(w io.Writer, diffs []string) {
+	if len(diffs) == 0 {
+		fmt.Fprintf(w, "ddash: replay matches the recording\n")
+		return
+	}
+	fmt.Fprintf(w, "ddash: replay differs from the recording:\n")
+	for _, d := range diffs {
+		fmt.Fprintf(w, "  %s\n", redactSecrets(d))
+	}
+}
@@ -0,0 +1,158 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRunRecordRoundTrip(t *testing.T) {
+	t.Setenv("DDASH_TEST_API_KEY", "planted-secret-value")
+
+	cfg := SandboxConfig{Isolation: isolationProcess, AllowNet: netEntries("registry.npmjs.org"), AllowWrite: []string{"."}}
+	opts := RunOptions{InteractiveNet: true, DenyWrite: true}
+	result := ExitResult{
+		ExitCode:  3,
+		Decisions: map[string]string{"registry.npmjs.org": "allow"},
+		Denials:   []Denial{{Process: "node", Operation: "file-write-create", Target: "/etc/x", Count: 2}},
+	}
+
+	rec := newRunRecord(cfg, []string{"npm", "install"}, opts, result)
+	path := filepath.Join(t.TempDir(), "run.json")
+	if err := writeRunRecord(path, rec); err != nil {
+		t.Fatalf("writeRunRecord: %v", err)
+	}
+
+	data, _ := os.ReadFile(path)
+	if strings.Contains(string(data), "planted-secret-value") {
+		t.Error("record contains an env var value")
+	}
+	if !strings.Contains(string(data), "DDASH_TEST_API_KEY") {
+		t.Error("record is missing the scrubbed env var name")
+	}
+
+	got, err := readRunRecord(path)
+	if err != nil {
+		t.Fatalf("readRunRecord: %v", err)
+	}
+	if !got.Flags.InteractiveNet || !got.Flags.DenyWrite || got.ExitCode != 3 {
+		t.Errorf("unexpected record: %+v", got)
+	}
+	if diffs := compareRuns(rec, got); len(diffs) != 0 {
+		t.Errorf("round trip differs: %v", diffs)
+	}
+}
+
+func TestReplayRunChecksPolicy(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	os.Mkdir(filepath.Join(home, ".ssh"), 0700)
+	origDir, _ := os.Getwd()
+	t.Cleanup(func() { os.Chdir(origDir) })
+	calls := stubExecCommand(t, "exit 0")
+
+	rec := newRunRecord(SandboxConfig{AllowRead: pathEntries("~")}, []string{"true"}, RunOptions{}, ExitResult{})
+	rec.Dir = home
+	path := filepath.Join(t.TempDir(), "run.json")
+	if err := writeRunRecord(path, rec); err != nil {
+		t.Fatal(err)
+	}
+
+	err := replayRun(path, "", false, "")
+	if err == nil || !strings.Contains(err.Error(), "credential directories") {
+		t.Errorf("replayRun = %v, want the credential directory check", err)
+	}
+	if err := replayRun(path, "", true, t.TempDir()); err == nil || !strings.Contains(err.Error(), "outside") {
+		t.Errorf("replayRun with confineTo = %v, want a confinement error", err)
+	}
+	if len(*calls) != 0 {
+		t.Errorf("replay ran %d commands despite failing its checks", len(*calls))
+	}
+}
+
+func TestReadRunRecordErrors(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		os.WriteFile(path, []byte(content), 0600)
+		return path
+	}
+
+	tests := []struct {
+		name    string
+		path    string
+		wantErr string
+	}{
+		{"missing", filepath.Join(dir, "nope.json"), "failed to read record"},
+		{"garbage", write("garbage.json", "{"), "invalid record"},
+		{"version", write("v9.json", `{"version": 9, "command": ["ls"]}`), "version 9"},
+		{"no command", write("empty.json", `{"version": 1}`), "no command"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := readRunRecord(tt.path)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("err = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCompareRuns(t *testing.T) {
+	recorded := runRecord{
+		ExitCode:    0,
+		Decisions:   map[string]string{"registry.npmjs.org": "allow", "cdn.example.com": "always"},
+		Denials:     []Denial{{Operation: "file-read-data", Target: "/Users/mark/.ssh", Count: 1}},
+		ScrubbedEnv: []string{"AWS_SECRET_ACCESS_KEY", "NPM_TOKEN"},
+	}
+	replayed := runRecord{
+		ExitCode: 1,
+		Decisions: map[string]string{
+			"registry.npmjs.org": "allow",
+			"cdn.example.com":    "never",
+			"evil.example.net":   "deny",
+		},
+		Denials: []Denial{
+			{Operation: "file-read-data", Target: "/Users/mark/.ssh", Count: 7},
+			{Process: "node", Operation: "network-outbound", Target: "1.2.3.4:443", Count: 1},
+		},
+		ScrubbedEnv: []string{"NPM_TOKEN", "STRIPE_KEY"},
+	}
+
+	got := compareRuns(recorded, replayed)
+	want := []string{
+		"exit code 0 -> 1",
+		"host cdn.example.com always -> never",
+		"new host evil.example.net (deny)",
+		"new denial node: network-outbound 1.2.3.4:443",
+		"env var now scrubbed STRIPE_KEY",
+		"env var no longer scrubbed AWS_SECRET_ACCESS_KEY",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("compareRuns =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	// Equivalent decisions and changed counts are not differences
+	same := recorded
+	same.Decisions = map[string]string{"registry.npmjs.org": "always", "cdn.example.com": "allow"}
+	same.Denials = []Denial{{Operation: "file-read-data", Target: "/Users/mark/.ssh", Count: 5}}
+	if diffs := compareRuns(recorded, same); len(diffs) != 0 {
+		t.Errorf("expected no differences, got %v", diffs)
+	}
+}
+
+func TestParseRunArgsRecordReplay(t *testing.T) {
+	flags, command, err := parseRunArgs([]string{"--record", "run.json", "--", "make"})
+	if err != nil || flags.record != "run.json" || len(command) != 1 {
+		t.Errorf("--record: flags = %+v, command = %v, err = %v", flags, command, err)
+	}
+
+	flags, command, err = parseRunArgs([]string{"--replay", "run.json", "--net", "--config", "x.json"})
+	if err != nil || flags.replay != "run.json" || command != nil {
+		t.Fatalf("--replay: flags = %+v, command = %v, err = %v", flags, command, err)
+	}
+	if got := strings.Join(replayConflicts(flags), ","); got != "--net,--config" {
+		t.Errorf("replayConflicts = %q, want --net,--config", got)
+	}
+}
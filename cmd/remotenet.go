@@ -0,0 +1,198 @@
+package cmd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// remoteNetPrefix marks an allow_net entry that names a shared host list
+// by URL instead of a host, e.g. "@https://policy.example.com/hosts.json".
+const remoteNetPrefix = "@"
+
+const (
+	remoteNetTimeout  = 5 * time.Second
+	remoteNetCacheTTL = time.Hour
+	remoteNetMaxBytes = 1 << 20
+)
+
+// remoteNetClient fetches host lists.
+var remoteNetClient = &http.Client{Timeout: remoteNetTimeout}
+
+// remoteNetCache is one cached host list.
+type remoteNetCache struct {
+	URL       string   `json:"url"`
+	FetchedAt string   `json:"fetched_at"`
+	Hosts     []string `json:"hosts"`
+}
+
+// resolveRemoteNet replaces every "@https://..." entry in entries with the
+// hosts listed at that URL, keeping the other entries in place and
+// dropping duplicates. Lists are fetched at most once per
+// remoteNetCacheTTL; if a refetch fails, the stale copy is used with a
+// warning. The fetch runs in ddash itself, before the sandbox exists.
+func resolveRemoteNet(entries []NetEntry) ([]NetEntry, error) {
+	var resolved []NetEntry
+	seen := make(map[string]bool)
+	add := func(entry NetEntry) {
+		if !seen[entry.Host] {
+			seen[entry.Host] = true
+			resolved = append(resolved, entry)
+		}
+	}
+
+	remote := false
+	for _, entry := range entries {
+		if !strings.HasPrefix(entry.Host, remoteNetPrefix) {
+			add(entry)
+			continue
+		}
+		remote = true
+		hosts, err := remoteHosts(strings.TrimPrefix(entry.Host, remoteNetPrefix))
+		if err != nil {
+			return nil, fmt.Errorf("allow_net entry %s: %w", entry.Host, err)
+		}
+		for _, host := range hosts {
+			// Listed hosts carry the include's annotations
+			entry.Host = host
+			add(entry)
+		}
+	}
+	if !remote {
+		return entries, nil
+	}
+	if resolved == nil {
+		resolved = []NetEntry{}
+	}
+	return resolved, nil
+}
+
+// remoteHosts returns the host list at rawURL, from the cache if fresh.
+func remoteHosts(rawURL string) ([]string, error) {
+	if !strings.HasPrefix(rawURL, "https://") {
+		return nil, fmt.Errorf("host lists must be fetched over https://")
+	}
+
+	path := remoteNetCachePath(rawURL)
+	cached, cacheErr := readRemoteNetCache(path)
+	if cacheErr == nil {
+		// Caches written before lists were validated may hold anything
+		cacheErr = validateHostList(cached.Hosts)
+	}
+	if cacheErr == nil {
+		if fetched, err := time.Parse(time.RFC3339, cached.FetchedAt); err == nil && time.Since(fetched) < remoteNetCacheTTL {
+			return cached.Hosts, nil
+		}
+	}
+
+	hosts, err := fetchRemoteHosts(rawURL)
+	if err != nil {
+		if cacheErr == nil {
+			fmt.Fprintf(os.Stderr, "ddash: failed to refresh %s (%v), using the copy fetched at %s\n",
+				rawURL, err, cached.FetchedAt)
+			return cached.Hosts, nil
+		}
+		return nil, err
+	}
+
+	if err := writeRemoteNetCache(path, remoteNetCache{
+		URL:       rawURL,
+		FetchedAt: time.Now().UTC().Format(time.RFC3339),
+		Hosts:     hosts,
+	}); err != nil {
+		fmt.Fprintf(os.Stderr, "ddash: failed to cache %s: %v\n", rawURL, err)
+	}
+	return hosts, nil
+}
+
+// fetchRemoteHosts downloads a host list: a JSON array of hosts, or an
+// object with an "allow_net" array such as a shared .ddash.json. Lists
+// can't point at further lists.
+func fetchRemoteHosts(rawURL string) ([]string, error) {
+	resp, err := remoteNetClient.Get(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("fetch failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetch failed: %s", resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, remoteNetMaxBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("fetch failed: %w", err)
+	}
+	if len(data) > remoteNetMaxBytes {
+		return nil, fmt.Errorf("host list is larger than %d bytes", remoteNetMaxBytes)
+	}
+
+	var hosts []string
+	if err := json.Unmarshal(data, &hosts); err != nil {
+		var doc struct {
+			AllowNet []string `json:"allow_net"`
+		}
+		if err := json.Unmarshal(data, &doc); err != nil || doc.AllowNet == nil {
+			return nil, fmt.Errorf("expected a JSON array of hosts or an object with allow_net")
+		}
+		hosts = doc.AllowNet
+	}
+	if err := validateHostList(hosts); err != nil {
+		return nil, err
+	}
+	return hosts, nil
+}
+
+// validateHostList checks every host of a fetched list like a line of an
+// allow_net_file: hosts, wildcards and CIDRs only, so a list can neither
+// open the whole network with "*" nor include further lists.
+func validateHostList(hosts []string) error {
+	for _, host := range hosts {
+		if strings.HasPrefix(strings.TrimSpace(host), remoteNetPrefix) {
+			return fmt.Errorf("host list refers to another list (%s)", host)
+		}
+		if err := validateNetPattern(host); err != nil {
+			return fmt.Errorf("invalid host list: %w", err)
+		}
+	}
+	return nil
+}
+
+// remoteNetCachePath is where the list at rawURL is cached, under the
+// user's cache directory.
+func remoteNetCachePath(rawURL string) string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	sum := sha256.Sum256([]byte(rawURL))
+	return filepath.Join(dir, "ddash", "allow-net", hex.EncodeToString(sum[:8])+".json")
+}
+
+func readRemoteNetCache(path string) (remoteNetCache, error) {
+	var cached remoteNetCache
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return cached, err
+	}
+	if err := json.Unmarshal(data, &cached); err != nil {
+		return cached, err
+	}
+	return cached, nil
+}
+
+func writeRemoteNetCache(path string, cached remoteNetCache) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(cached, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0600)
+}
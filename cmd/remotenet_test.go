@@ -0,0 +1,134 @@
+package cmd
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// hostListServer serves body over TLS, counting requests, and points
+// remoteNetClient and the cache at test-local state.
+func hostListServer(t *testing.T, status int, body string) (*httptest.Server, *atomic.Int64) {
+	t.Helper()
+	var hits atomic.Int64
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(srv.Close)
+
+	cache := t.TempDir()
+	t.Setenv("XDG_CACHE_HOME", cache)
+	t.Setenv("HOME", cache)
+	orig := remoteNetClient
+	remoteNetClient = srv.Client()
+	t.Cleanup(func() { remoteNetClient = orig })
+	return srv, &hits
+}
+
+func TestResolveRemoteNet(t *testing.T) {
+	srv, hits := hostListServer(t, http.StatusOK, `["registry.npmjs.org", "github.com"]`)
+
+	got, err := resolveRemoteNet(netEntries("github.com", "@"+srv.URL+"/hosts.json", "pypi.org"))
+	if err != nil {
+		t.Fatalf("resolveRemoteNet failed: %v", err)
+	}
+	if want := "github.com,registry.npmjs.org,pypi.org"; strings.Join(entryHosts(got), ",") != want {
+		t.Errorf("resolved = %v, want %s", got, want)
+	}
+
+	// Cache hit: no second fetch, even with the server gone
+	srv.Close()
+	got, err = resolveRemoteNet(netEntries("@" + srv.URL + "/hosts.json"))
+	if err != nil {
+		t.Fatalf("resolveRemoteNet from cache failed: %v", err)
+	}
+	if len(got) != 2 || hits.Load() != 1 {
+		t.Errorf("got %v after %d fetches, want the cached list after 1", got, hits.Load())
+	}
+}
+
+func TestResolveRemoteNetConfigObject(t *testing.T) {
+	srv, _ := hostListServer(t, http.StatusOK, `{"name": "shared", "allow_net": ["api.example.com"]}`)
+
+	got, err := resolveRemoteNet(netEntries("@" + srv.URL))
+	if err != nil {
+		t.Fatalf("resolveRemoteNet failed: %v", err)
+	}
+	if len(got) != 1 || got[0].Host != "api.example.com" {
+		t.Errorf("resolved = %v, want [api.example.com]", got)
+	}
+}
+
+func TestResolveRemoteNetStaleCache(t *testing.T) {
+	srv, _ := hostListServer(t, http.StatusInternalServerError, "")
+	rawURL := srv.URL + "/hosts.json"
+
+	if _, err := resolveRemoteNet(netEntries("@" + rawURL)); err == nil {
+		t.Fatal("expected an error when the fetch fails and nothing is cached")
+	}
+
+	stale := time.Now().Add(-2 * remoteNetCacheTTL).UTC().Format(time.RFC3339)
+	if err := writeRemoteNetCache(remoteNetCachePath(rawURL), remoteNetCache{
+		URL: rawURL, FetchedAt: stale, Hosts: []string{"old.example.com"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	got, err := resolveRemoteNet(netEntries("@" + rawURL))
+	if err != nil || len(got) != 1 || got[0].Host != "old.example.com" {
+		t.Errorf("got %v, %v; want the stale cached list", got, err)
+	}
+}
+
+func TestResolveRemoteNetInvalidFallsBackToCache(t *testing.T) {
+	srv, _ := hostListServer(t, http.StatusOK, `["*"]`)
+	rawURL := srv.URL + "/hosts.json"
+
+	stale := time.Now().Add(-2 * remoteNetCacheTTL).UTC().Format(time.RFC3339)
+	if err := writeRemoteNetCache(remoteNetCachePath(rawURL), remoteNetCache{
+		URL: rawURL, FetchedAt: stale, Hosts: []string{"old.example.com"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	got, err := resolveRemoteNet(netEntries("@" + rawURL))
+	if err != nil || len(got) != 1 || got[0].Host != "old.example.com" {
+		t.Errorf("got %v, %v; want the cached list when the fetched one is invalid", got, err)
+	}
+
+	// A fresh cache holding "*" is not trusted either
+	fresh := time.Now().UTC().Format(time.RFC3339)
+	writeRemoteNetCache(remoteNetCachePath(rawURL), remoteNetCache{URL: rawURL, FetchedAt: fresh, Hosts: []string{"*"}})
+	if _, err := resolveRemoteNet(netEntries("@" + rawURL)); err == nil {
+		t.Error("a cached \"*\" should be rejected")
+	}
+}
+
+func TestResolveRemoteNetRejects(t *testing.T) {
+	srv, _ := hostListServer(t, http.StatusOK, `["@https://elsewhere.example.com/hosts.json"]`)
+
+	for _, entry := range []string{
+		"@http://policy.example.com/hosts.json",
+		"@" + srv.URL + "/nested.json",
+	} {
+		if _, err := resolveRemoteNet(netEntries(entry)); err == nil {
+			t.Errorf("resolveRemoteNet(%q) should fail", entry)
+		}
+	}
+
+	// A list can't open the whole network or carry malformed entries
+	for _, body := range []string{`["github.com", "*"]`, `["github.com:99999"]`, `["10.0.0.0/33"]`} {
+		srv, _ := hostListServer(t, http.StatusOK, body)
+		if _, err := resolveRemoteNet(netEntries("@" + srv.URL + "/hosts.json")); err == nil {
+			t.Errorf("a list of %s should be rejected", body)
+		}
+	}
+
+	plain := netEntries("github.com")
+	if got, err := resolveRemoteNet(plain); err != nil || len(got) != 1 {
+		t.Errorf("plain entries should pass through, got %v, %v", got, err)
+	}
+}
@@ -0,0 +1,220 @@
+package cmd
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const reportUsage = `Summarize how tight a project's sandbox policy is
+
+Usage:
+  ddash report [flags]
+
+Reads the effective policy (.ddash.json, or the built-in defaults) and
+reports network exposure, whether writes escape the project, whether
+reads reach credential directories, and a score with hints for
+tightening it. Nothing is executed.
+
+Flags:
+  --config <file>  Load this config instead of .ddash.json (repeatable)
+  -h, --help       Show help`
+
+// postureFinding is one line of a report, with optional detail lines.
+// Penalty is what it costs the score; findings with a penalty carry a
+// hint.
+type postureFinding struct {
+	Area    string
+	Summary string
+	Details []string
+	Penalty int
+	Hint    string
+}
+
+// postureReport is the assessment printed by ddash report.
+type postureReport struct {
+	Findings []postureFinding
+	Score    int // 0-100
+	Grade    string
+}
+
+func reportCmd() error {
+	var configs []string
+	fs := newFlagSet("report")
+	fs.Var((*stringList)(&configs), "config", "")
+	err := fs.Parse(os.Args[2:])
+	if errors.Is(err, flag.ErrHelp) {
+		fmt.Println(reportUsage)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return fmt.Errorf("unexpected argument: %s", fs.Arg(0))
+	}
+
+	cfg, err := loadRunConfigs(configs)
+	if err != nil {
+		return err
+	}
+	source := "built-in defaults (no .ddash.json)"
+	if len(configs) > 0 {
+		source = strings.Join(configs, ", ")
+	} else if _, err := os.Stat(configPath()); err == nil {
+		source = configPath()
+	}
+
+	cwd, _ := os.Getwd()
+	home, _ := os.UserHomeDir()
+	fmt.Printf("ddash report: %s\n", source)
+	writeReport(os.Stdout, assessPosture(cfg, cwd, home))
+	return nil
+}
+
+// assessPosture grades cfg as a plain 'ddash run' in cwd would apply it.
+// A commands entry loosens the policy for the commands it matches, so
+// each one is graded as merged over cfg, and the loosest policy of them
+// all is the one reported.
+func assessPosture(cfg SandboxConfig, cwd, home string) postureReport {
+	findings := policyFindings(cfg, cwd, home)
+	loosest := ""
+	for _, key := range sortedKeys(cfg.Commands) {
+		entry := policyFindings(selectCommandProfile(cfg, strings.Fields(key)), cwd, home)
+		if totalPenalty(entry) > totalPenalty(findings) {
+			findings, loosest = entry, key
+		}
+	}
+	add := func(area, summary string, penalty int, hint string) {
+		findings = append(findings, postureFinding{Area: area, Summary: summary, Penalty: penalty, Hint: hint})
+	}
+	if loosest != "" {
+		add("command", fmt.Sprintf("graded as the %q entry of commands, the loosest policy", loosest), 0, "")
+	}
+
+	if cfg.Checksum == "" {
+		add("review", "no checksum recorded, edits to the policy go unnoticed", 5,
+			"review the config and run 'ddash sandbox verify --update'")
+	} else if !checksumValid(cfg) {
+		add("review", "modified since its checksum was recorded", 10,
+			"review the changes, then run 'ddash sandbox verify --update'")
+	} else {
+		add("review", "checksum matches", 0, "")
+	}
+
+	score := max(100-totalPenalty(findings), 0)
+	return postureReport{Findings: findings, Score: score, Grade: postureGrade(score)}
+}
+
+// totalPenalty sums what findings cost the score.
+func totalPenalty(findings []postureFinding) int {
+	total := 0
+	for _, f := range findings {
+		total += f.Penalty
+	}
+	return total
+}
+
+// policyFindings assesses what cfg lets a command do, everything but the
+// review state of the config file.
+func policyFindings(cfg SandboxConfig, cwd, home string) []postureFinding {
+	var findings []postureFinding
+	add := func(area, summary string, penalty int, hint string) {
+		findings = append(findings, postureFinding{Area: area, Summary: summary, Penalty: penalty, Hint: hint})
+	}
+
+	switch {
+	case cfg.Isolation == isolationNone:
+		add("sandbox", "off (isolation: none), nothing is enforced", 100,
+			`remove "isolation": "none" so the policy applies`)
+	case cfg.auditMode():
+		add("sandbox", "audit only (enforcement: audit), nothing is blocked", 100,
+			`switch "enforcement" to "enforce" once the audit log looks right`)
+	}
+
+	profile := GenerateProfile(cfg, false, false)
+	switch {
+	case networkStatus(profile) == "allowed":
+		add("network", "open to every host", 30,
+			`replace "*" in allow_net with the hosts the project needs, and run with --net`)
+	case len(cfg.AllowNet) > 0:
+		add("network", fmt.Sprintf("closed; %d host(s) reachable with --net", len(cfg.AllowNet)), 0, "")
+	default:
+		add("network", "closed", 0, "")
+	}
+
+	if cfg.NetMode == netModeMonitor {
+		add("monitor", `net_mode "monitor": with --net --monitor every domain goes through unasked, even "never" ones`, 15,
+			`remove "net_mode": "monitor" once the would-block list has been reviewed`)
+	}
+
+	var escapes []string
+	for _, path := range cfg.AllowWrite {
+		if !isWithin(filepath.Clean(resolvePath(path, cwd)), cwd) {
+			escapes = append(escapes, path)
+		}
+	}
+	switch {
+	case writeStatus(profile) == "restricted":
+		add("writes", "none", 0, "")
+	case len(escapes) > 0:
+		add("writes", "escape the project: "+strings.Join(escapes, ", "), 20,
+			"keep allow_write inside the project directory")
+	default:
+		add("writes", "stay in the project", 0, "")
+	}
+	if cfg.tmpWriteAllowed() {
+		add("temp", "writes allowed to /private/tmp and /dev", 5,
+			`set "tmp_write": false if the command doesn't need temp files`)
+	}
+
+	if exposed := sensitiveReadGrants(cfg, cwd, home); home != "" && len(exposed) > 0 {
+		add("reads", "reach credential directories", 30,
+			"remove allow_read entries covering ~ or its credential directories")
+		findings[len(findings)-1].Details = exposed
+	} else {
+		add("reads", "no credential directories", 0, "")
+	}
+	return findings
+}
+
+// postureGrade maps a score to a letter, in steps of ten.
+func postureGrade(score int) string {
+	switch {
+	case score >= 90:
+		return "A"
+	case score >= 80:
+		return "B"
+	case score >= 70:
+		return "C"
+	case score >= 60:
+		return "D"
+	}
+	return "F"
+}
+
+// writeReport prints r: one line per finding, the score, then hints.
+func writeReport(w io.Writer, r postureReport) {
+	var hints []string
+	for _, f := range r.Findings {
+		fmt.Fprintf(w, "  %-8s %s\n", f.Area+":", f.Summary)
+		for _, detail := range f.Details {
+			fmt.Fprintf(w, "           %s\n", detail)
+		}
+		if f.Penalty > 0 && f.Hint != "" {
+			hints = append(hints, f.Hint)
+		}
+	}
+	fmt.Fprintf(w, "\nScore: %d/100 (%s)\n", r.Score, r.Grade)
+	if len(hints) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "\nTo tighten:\n")
+	for _, hint := range hints {
+		fmt.Fprintf(w, "  - %s\n", hint)
+	}
+}
@@ -0,0 +1,107 @@
+package cmd
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestAssessPostureLockedDown(t *testing.T) {
+	cwd, home := "/Users/dev/project", "/Users/dev"
+	off := false
+	cfg := SandboxConfig{
+		Isolation:  isolationProcess,
+		AllowNet:   netEntries("registry.npmjs.org"),
+		AllowRead:  pathEntries("."),
+		AllowWrite: []string{".", "./dist"},
+		TmpWrite:   &off,
+	}
+	cfg.Checksum = computeChecksum(cfg)
+
+	r := assessPosture(cfg, cwd, home)
+	if r.Score != 100 || r.Grade != "A" {
+		t.Errorf("score %d (%s), want 100 (A): %+v", r.Score, r.Grade, r.Findings)
+	}
+	var out strings.Builder
+	writeReport(&out, r)
+	for _, want := range []string{"network: closed; 1 host(s) reachable with --net", "writes:  stay in the project", "Score: 100/100 (A)"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("report missing %q:\n%s", want, out.String())
+		}
+	}
+	if strings.Contains(out.String(), "To tighten") {
+		t.Errorf("a clean policy should have no hints:\n%s", out.String())
+	}
+
+	cfg.NetMode = netModeMonitor
+	cfg.Checksum = computeChecksum(cfg)
+	if r := assessPosture(cfg, cwd, home); r.Score != 85 {
+		t.Errorf("net_mode monitor scored %d, want 85: %+v", r.Score, r.Findings)
+	}
+}
+
+func TestAssessPostureLoose(t *testing.T) {
+	cwd, home := "/Users/dev/project", "/Users/dev"
+	t.Setenv("HOME", home)
+	cfg := SandboxConfig{
+		Isolation:  isolationProcess,
+		AllowNet:   netEntries("*"),
+		AllowRead:  pathEntries(".", "~"),
+		AllowWrite: []string{".", "/usr/local/lib"},
+	}
+
+	r := assessPosture(cfg, cwd, home)
+	// 30 network + 20 writes + 5 temp + 30 reads + 5 checksum
+	if r.Score != 10 || r.Grade != "F" {
+		t.Errorf("score %d (%s), want 10 (F): %+v", r.Score, r.Grade, r.Findings)
+	}
+	var out strings.Builder
+	writeReport(&out, r)
+	for _, want := range []string{"open to every host", "escape the project: /usr/local/lib", "exposes ~/.ssh", "To tighten:"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("report missing %q:\n%s", want, out.String())
+		}
+	}
+
+	cfg.Isolation = isolationNone
+	if r := assessPosture(cfg, cwd, home); r.Score != 0 {
+		t.Errorf("isolation none scored %d, want 0", r.Score)
+	}
+}
+
+func TestAssessPostureCommands(t *testing.T) {
+	cwd, home := "/Users/dev/project", "/Users/dev"
+	off := false
+	cfg := SandboxConfig{
+		Isolation:  isolationProcess,
+		AllowNet:   []NetEntry{},
+		AllowRead:  pathEntries("."),
+		AllowWrite: []string{"."},
+		TmpWrite:   &off,
+		Commands: map[string]SandboxConfig{
+			"npm test":    {AllowWrite: []string{"./coverage"}},
+			"npm install": {AllowNet: netEntries("*"), AllowWrite: []string{"/"}},
+		},
+	}
+	cfg.Checksum = computeChecksum(cfg)
+
+	r := assessPosture(cfg, cwd, home)
+	// 30 network + 20 writes under "npm install"
+	if r.Score != 50 {
+		t.Errorf("score %d, want 50 from the npm install entry: %+v", r.Score, r.Findings)
+	}
+	var out strings.Builder
+	writeReport(&out, r)
+	for _, want := range []string{`"npm install" entry of commands`, "open to every host", "escape the project: /", "checksum matches"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("report missing %q:\n%s", want, out.String())
+		}
+	}
+}
+
+func TestPostureGrade(t *testing.T) {
+	for score, want := range map[int]string{100: "A", 90: "A", 85: "B", 70: "C", 65: "D", 59: "F", 0: "F"} {
+		if got := postureGrade(score); got != want {
+			t.Errorf("postureGrade(%d) = %s, want %s", score, got, want)
+		}
+	}
+}
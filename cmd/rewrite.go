@@ -0,0 +1,61 @@
+package cmd
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// SetRewrite reroutes connections for requested hosts to other upstreams,
+// e.g. registry.npmjs.org to an internal mirror. Decisions, prompts and
+// logs keep using the requested host; only the dial goes elsewhere. An
+// upstream may carry a port ("mirror.internal:8443"); without one the
+// requested port is kept. The mirror must serve a certificate valid for
+// the requested host, since TLS clients still verify that name.
+func (p *NetworkProxy) SetRewrite(rewrites map[string]string) error {
+	parsed := make(map[string]string, len(rewrites))
+	for host, upstream := range rewrites {
+		upstream, err := parseRewrite(host, upstream)
+		if err != nil {
+			return err
+		}
+		parsed[strings.Trim(host, "[]")] = upstream
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.rewrite = parsed
+	return nil
+}
+
+// parseRewrite validates a net_rewrite upstream and normalizes it to host
+// or host:port.
+func parseRewrite(host, upstream string) (string, error) {
+	upstream = strings.TrimSpace(upstream)
+	h, port := splitHostPort(upstream, "")
+	if h == "" || strings.ContainsAny(h, "/ ") || strings.Contains(upstream, "://") {
+		return "", fmt.Errorf("invalid net_rewrite upstream %q for %s: want a host or host:port", upstream, host)
+	}
+	if port != "" {
+		return net.JoinHostPort(h, port), nil
+	}
+	return h, nil
+}
+
+// upstreamAddr maps a requested host:port to the address to dial, and
+// reports whether a net_rewrite entry applied.
+func (p *NetworkProxy) upstreamAddr(address string) (string, bool) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return address, false
+	}
+	p.mu.Lock()
+	upstream, ok := p.rewrite[host]
+	p.mu.Unlock()
+	if !ok {
+		return address, false
+	}
+	if h, upPort := splitHostPort(upstream, ""); upPort != "" {
+		return net.JoinHostPort(h, upPort), true
+	}
+	return net.JoinHostPort(upstream, port), true
+}
@@ -0,0 +1,109 @@
+package cmd
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestSetRewriteValidates(t *testing.T) {
+	p, err := NewProxy(map[string]string{}, "test")
+	if err != nil {
+		t.Fatalf("NewProxy failed: %v", err)
+	}
+	defer p.Shutdown()
+
+	for _, upstream := range []string{"", "https://mirror.internal", "mirror.internal/npm", "mirror internal"} {
+		if err := p.SetRewrite(map[string]string{"registry.npmjs.org": upstream}); err == nil {
+			t.Errorf("SetRewrite accepted upstream %q", upstream)
+		}
+	}
+
+	if err := p.SetRewrite(map[string]string{
+		"registry.npmjs.org": "mirror.internal",
+		"pypi.org":           "mirror.internal:8443",
+	}); err != nil {
+		t.Fatalf("SetRewrite failed: %v", err)
+	}
+	tests := []struct {
+		address, want string
+		rewritten     bool
+	}{
+		{"registry.npmjs.org:443", "mirror.internal:443", true},
+		{"pypi.org:443", "mirror.internal:8443", true},
+		{"github.com:443", "github.com:443", false},
+	}
+	for _, tt := range tests {
+		got, rewritten := p.upstreamAddr(tt.address)
+		if got != tt.want || rewritten != tt.rewritten {
+			t.Errorf("upstreamAddr(%q) = %q, %v; want %q, %v", tt.address, got, rewritten, tt.want, tt.rewritten)
+		}
+	}
+}
+
+func TestProxyRewriteHTTP(t *testing.T) {
+	var gotHost string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotHost = r.Host
+		w.Write([]byte("mirror-ok"))
+	}))
+	defer backend.Close()
+	backendURL, _ := url.Parse(backend.URL)
+
+	p, err := NewProxy(map[string]string{"registry.example.test": "always", "127.0.0.1": "always"}, "test")
+	if err != nil {
+		t.Fatalf("NewProxy failed: %v", err)
+	}
+	defer p.Shutdown()
+	if err := p.SetRewrite(map[string]string{"registry.example.test": backendURL.Host}); err != nil {
+		t.Fatalf("SetRewrite failed: %v", err)
+	}
+	p.Start()
+
+	proxyURL, _ := url.Parse("http://" + p.Addr())
+	client := &http.Client{
+		Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)},
+		Timeout:   5 * time.Second,
+	}
+	resp, err := client.Get("http://registry.example.test/pkg")
+	if err != nil {
+		t.Fatalf("request through proxy failed: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if string(body) != "mirror-ok" {
+		t.Errorf("got %q, want the mirror's response", body)
+	}
+	if gotHost != "registry.example.test" {
+		t.Errorf("mirror saw Host %q, want the requested host", gotHost)
+	}
+}
+
+func TestProxyRewriteCONNECT(t *testing.T) {
+	backend := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("tls-mirror-ok"))
+	}))
+	defer backend.Close()
+	backendURL, _ := url.Parse(backend.URL)
+
+	p, err := NewProxy(map[string]string{"registry.example.test": "always"}, "test")
+	if err != nil {
+		t.Fatalf("NewProxy failed: %v", err)
+	}
+	defer p.Shutdown()
+	if err := p.SetRewrite(map[string]string{"registry.example.test": "127.0.0.1"}); err != nil {
+		t.Fatalf("SetRewrite failed: %v", err)
+	}
+	p.Start()
+
+	// The upstream has no port, so the requested one is kept
+	connectHost := net.JoinHostPort("registry.example.test", backendURL.Port())
+	body, err := tunnelTLS(t, p, connectHost, "registry.example.test")
+	if err != nil || body != "tls-mirror-ok" {
+		t.Errorf("got %q, %v; want the mirror's response", body, err)
+	}
+}
@@ -15,8 +15,15 @@ Uses the native macOS sandbox engine (sandbox-exec) at the kernel level.
 
 Usage:
   ddash run [flags] -- <command>    Run a command in a sandbox
+  ddash batch [flags] -- <command>  Run a command once per stdin line, one sandbox
   ddash trace -- <command>          Trace access, suggest policy (experimental)
+  ddash init-from-trace -- <command>
+                                    Trace, then save the suggested .ddash.json
   ddash sandbox <subcommand>        Manage sandbox configuration
+  ddash probe <host>...             Check whether the policy allows a host
+  ddash proxy [flags]               Run the --net proxy alone, print exports
+  ddash report                      Grade the project's sandbox policy
+  ddash doctor                      Check this machine can run ddash
   ddash version                     Print version
 
 Examples:
@@ -41,12 +48,24 @@ func Execute() error {
 	switch os.Args[1] {
 	case "run":
 		return runCmd()
+	case "batch":
+		return batchCmd()
 	case "trace":
 		return traceCmd()
+	case "init-from-trace":
+		return initFromTraceCmd()
 	case "version", "-v", "--version":
 		fmt.Printf("ddash version %s\n", Version)
 	case "sandbox":
 		return sandboxCmd()
+	case "doctor":
+		return doctorCmd()
+	case "probe":
+		return probeCmd()
+	case "proxy":
+		return proxyCmd()
+	case "report":
+		return reportCmd()
 	case "help", "-h", "--help":
 		fmt.Println(usage)
 	default:
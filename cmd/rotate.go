@@ -0,0 +1,84 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+// rotatingWriter appends to a file and rotates it once it would grow past
+// maxSize: path becomes path.1, path.1 becomes path.2 and so on, keeping
+// at most keep old files. With keep 0 the file is simply truncated.
+type rotatingWriter struct {
+	mu      sync.Mutex
+	path    string
+	maxSize int64
+	keep    int
+	file    *os.File
+	size    int64
+}
+
+// newRotatingWriter opens path for appending. maxSize <= 0 disables
+// rotation.
+func newRotatingWriter(path string, maxSize int64, keep int) (*rotatingWriter, error) {
+	w := &rotatingWriter{path: path, maxSize: maxSize, keep: keep}
+	if err := w.open(os.O_APPEND); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *rotatingWriter) open(mode int) error {
+	f, err := os.OpenFile(w.path, os.O_CREATE|os.O_WRONLY|mode, 0600)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	w.file, w.size = f, info.Size()
+	return nil
+}
+
+// Write writes p to the current file, rotating first if p would take it
+// past maxSize. A single write is never split across files.
+func (w *rotatingWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.maxSize > 0 && w.size > 0 && w.size+int64(len(p)) > w.maxSize {
+		if err := w.rotate(); err != nil {
+			return 0, fmt.Errorf("failed to rotate %s: %w", w.path, err)
+		}
+	}
+	n, err := w.file.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+// rotate shifts the old files up by one and starts an empty active file.
+// Caller must hold w.mu.
+func (w *rotatingWriter) rotate() error {
+	if err := w.file.Close(); err != nil {
+		return err
+	}
+	if w.keep > 0 {
+		os.Remove(fmt.Sprintf("%s.%d", w.path, w.keep))
+		for i := w.keep - 1; i >= 1; i-- {
+			os.Rename(fmt.Sprintf("%s.%d", w.path, i), fmt.Sprintf("%s.%d", w.path, i+1))
+		}
+		if err := os.Rename(w.path, w.path+".1"); err != nil {
+			return err
+		}
+	}
+	return w.open(os.O_TRUNC)
+}
+
+// Close closes the active file.
+func (w *rotatingWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.file.Close()
+}
@@ -0,0 +1,81 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRotatingWriterRotates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	w, err := newRotatingWriter(path, 20, 2)
+	if err != nil {
+		t.Fatalf("newRotatingWriter: %v", err)
+	}
+	defer w.Close()
+
+	line := "0123456789\n" // 11 bytes: two lines pass the threshold
+	for i := 0; i < 2; i++ {
+		w.Write([]byte(line))
+	}
+	if _, err := os.Stat(path + ".1"); err != nil {
+		t.Fatalf("expected a rotated file after passing the threshold: %v", err)
+	}
+	active, _ := os.ReadFile(path)
+	if string(active) != line {
+		t.Errorf("active file = %q, want it reset to the last write", active)
+	}
+	rotated, _ := os.ReadFile(path + ".1")
+	if string(rotated) != line {
+		t.Errorf("rotated file = %q, want %q", rotated, line)
+	}
+
+	// Older files shift up and the oldest beyond keep is dropped
+	for i := 0; i < 4; i++ {
+		w.Write([]byte(line))
+	}
+	if _, err := os.Stat(path + ".2"); err != nil {
+		t.Errorf("expected %s.2: %v", path, err)
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("expected no %s.3 with keep=2", path)
+	}
+}
+
+func TestRotatingWriterAppendsToExisting(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	os.WriteFile(path, []byte(strings.Repeat("x", 15)), 0600)
+
+	w, err := newRotatingWriter(path, 20, 1)
+	if err != nil {
+		t.Fatalf("newRotatingWriter: %v", err)
+	}
+	defer w.Close()
+
+	// The existing size counts toward the threshold
+	w.Write([]byte("0123456789"))
+	rotated, _ := os.ReadFile(path + ".1")
+	if string(rotated) != strings.Repeat("x", 15) {
+		t.Errorf("rotated file = %q, want the pre-existing contents", rotated)
+	}
+}
+
+func TestRotatingWriterKeepZeroTruncates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	w, err := newRotatingWriter(path, 10, 0)
+	if err != nil {
+		t.Fatalf("newRotatingWriter: %v", err)
+	}
+	defer w.Close()
+
+	w.Write([]byte("first1234\n"))
+	w.Write([]byte("second\n"))
+	got, _ := os.ReadFile(path)
+	if string(got) != "second\n" {
+		t.Errorf("active file = %q, want only the last write", got)
+	}
+	if _, err := os.Stat(path + ".1"); !os.IsNotExist(err) {
+		t.Error("keep=0 should not leave rotated files")
+	}
+}
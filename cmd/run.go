@@ -1,13 +1,24 @@
 package cmd
 
 import (
-	"encoding/json"
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
+	"net"
 	"os"
 	"os/exec"
-	"os/signal"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
-	"syscall"
+	"sync"
+	"time"
+	"unicode"
 )
 
 const runUsage = `Run a command inside a macOS sandbox
@@ -35,14 +46,105 @@ Examples:
   ddash run --net -- npm install          Interactive per-domain network control
   ddash run --deny-write -- ./analyze     Full read-only sandbox
   ddash run --pass-env -- ./needs-creds   Pass all env vars through
+  ddash run --redact -- ./debug.sh        Pass env vars, mask secrets in ddash output
+  ddash run --paranoid -- ./untrusted     Keep only PATH, HOME, LANG and similar
   ddash run --profile -- node app.js      Print profile without running
+  ddash run --explain -- node app.js      Print it with the origin of each rule
+  ddash run --config base.ddash.json --config prod.ddash.json -- ./deploy
+                                         Stack configs (later files win)
 
 Flags:
   --allow-net       Allow all network access (overrides config)
+  --allow-net-file <file>
+                    Add the hosts in <file> to allow_net: one host, *.domain
+                    wildcard or CIDR per line, # starts a comment (repeatable)
   --net             Interactive network: prompt per domain (like Little Snitch)
+  --notify          With --net, ask in a macOS dialog instead of the terminal
+                    (denies after 60s without an answer)
+  --monitor         With --net, let every domain through unasked, even ones
+                    saved as "never", and list those a strict policy would
+                    block. A config's net_mode "monitor" is ignored without it
+  --group-prompts   With --net, ask once about new domains requested close
+                    together: allow all, deny all, or decide each
+  --prompt-timeout <duration>
+                    With --net, if a prompt goes unanswered this long (e.g.
+                    2m), deny it and every new domain after it without
+                    asking, so an unattended run can't hang. Default: wait
   --deny-write      Deny all filesystem writes (overrides config)
+  --allow-device <path>
+                    Let the command read and write this device, e.g.
+                    /dev/ttys003, even with "tmp_write": false (repeatable)
+  --no-default-tmp  Drop the implicit /private/tmp and /dev write grant for
+                    this run, leaving allow_write and /dev/null. Same as
+                    "tmp_write": false in config
   --pass-env        Pass all environment variables (disables scrubbing)
+  --redact          Pass all environment variables but mask sensitive values
+                    as *** in anything ddash prints
+  --paranoid        Scrub every environment variable except a safe set
+                    (PATH, HOME, USER, SHELL, TERM, TMPDIR, LANG, LC_*, ...)
+  --keep-env <name> Pass this variable through even though it would be
+                    scrubbed (repeatable)
+  --no-sandbox      Run without the sandbox profile (env scrubbing and --net
+                    proxy stay active). Debugging only: no filesystem or
+                    network isolation. A config's "isolation": "none" is
+                    refused without it
+  --audit           Allow everything and log access instead of blocking it.
+                    A config's "enforcement": "audit" is ignored without it
+  --sandbox-exec <path>
+                    Use this sandbox-exec binary (a wrapper, say) instead of
+                    the one on PATH. Default: $DDASH_SANDBOX_EXEC if set
+  --config <file>   Load this config instead of .ddash.json. Repeat to stack
+                    files left-to-right: later values win, lists are merged
+  --no-config       Ignore .ddash.json: run with the built-in default policy
+                    and flags only. --net decisions are not saved
+  --use-trace       Use the policy the last 'ddash trace' suggested (cached
+                    in .ddash/last-trace.json) for this run only
   --profile         Print the generated sandbox profile and exit
+  --explain         Like --profile, but note after each rule where it came
+                    from (allow_read[0] = ".", default system read, --net)
+  --keep-profile    Also save the profile this run uses to
+                    .ddash/last-profile.sb, for inspection after a failure
+  --confine-to <dir>
+                    Refuse to run if any allow_read/allow_write entry
+                    resolves outside <dir> (guards against a rogue config)
+  -v, --verbose     Print a preflight banner with the effective policy
+  --confirm         Print a one-line policy summary and ask y/N on /dev/tty
+                    before running
+  --log-denials     After the command exits, list operations the sandbox
+                    blocked (reported via SANDBOX_LOG_FILE)
+  --chdir <dir>     Run the command in <dir>. The config is still loaded from,
+                    and its relative paths resolved against, the current dir
+  --i-know          Run even if allow_read exposes credential dirs such as
+                    ~/.ssh or ~/.aws (prints a warning instead of refusing)
+  --ephemeral       Allow writes only to a fresh scratch dir, used as the
+                    working directory and deleted on exit
+  --private-tmp     Give the command a fresh temp dir as TMPDIR, deleted on
+                    exit, instead of write access to all of /private/tmp and
+                    /dev. Tools that hardcode /tmp can't write there
+  --http-log <file> With --net, append "method host path -> status" for each
+                    plain HTTP request (HTTPS is opaque, domains only)
+  --record <file>   Save the effective config, flags, scrubbed env var names,
+                    --net decisions, denials and exit code to <file>
+  --replay <file>   Re-run a recorded command under its recorded policy and
+                    report differences (new hosts, new denials, exit code).
+                    Unrecorded domains are denied, not prompted
+  --audit-log <file>
+                    With --net, append a timestamped line per connection
+                    decision to <file>
+  --audit-log-max-mb <n>
+                    Rotate the audit log at n MB (default 10, 0 = never)
+  --audit-log-keep <n>
+                    Rotated audit logs to keep as <file>.1..n (default 3)
+  --proxy-metrics-addr <host:port>
+                    With --net, serve Prometheus metrics (connections,
+                    decisions, prompts, bytes, decision latency) at
+                    http://<host:port>/metrics; localhost only
+  --stdout-file <file>
+                    Also write the command's stdout to <file>
+  --stderr-file <file>
+                    Also write the command's stderr to <file>. Output still
+                    streams to the console, but the command no longer sees
+                    a terminal on the captured streams
   -h, --help        Show help`
 
 // Env vars matching these prefixes or exact names are stripped by default.
@@ -79,212 +181,1563 @@ var sensitiveEnvPrefixes = []string{
 }
 
 var sensitiveEnvSubstrings = []string{
-	"_SECRET",
-	"_TOKEN",
-	"_KEY",
-	"_PASSWORD",
-	"_CREDENTIAL",
+	"SECRET",
+	"TOKEN",
+	"KEY",
+	"PASS",
+	"CRED",
 	"_AUTH",
 }
 
+// safeEnvNames is what --paranoid keeps: enough for shells, locales and
+// common toolchains to find their binaries, home and temp directories.
+// Everything else, including any name not listed here, is scrubbed.
+var safeEnvNames = map[string]bool{
+	"PATH":      true,
+	"HOME":      true,
+	"USER":      true,
+	"LOGNAME":   true,
+	"SHELL":     true,
+	"TERM":      true,
+	"TMPDIR":    true,
+	"PWD":       true,
+	"LANG":      true,
+	"LANGUAGE":  true,
+	"TZ":        true,
+	"EDITOR":    true,
+	"PAGER":     true,
+	"COLORTERM": true,
+	"NO_COLOR":  true,
+}
+
+// safeEnvPrefixes extends safeEnvNames to the locale family (LC_ALL,
+// LC_CTYPE, ...).
+var safeEnvPrefixes = []string{
+	"LC_",
+}
+
 type runFlags struct {
 	allowNet       bool
+	allowNetFiles  []string
 	interactiveNet bool
+	monitor        bool
+	audit          bool
+	notify         bool
+	groupPrompts   bool
+	promptTimeout  time.Duration
+	useTrace       bool
+	noConfig       bool
+	confirm        bool
 	denyWrite      bool
 	passEnv        bool
+	redactEnv      bool
+	paranoidEnv    bool
+	keepEnv        []string
+	allowDevices   []string
+	noDefaultTmp   bool
+	noSandbox      bool
+	sandboxExec    string
 	printOnly      bool
+	explain        bool
+	keepProfile    bool
+	verbose        bool
+	logDenials     bool
+	ephemeral      bool
+	privateTmp     bool
+	confineTo      string
+	httpLog        string
+	metricsAddr    string
+	chdir          string
+	iKnow          bool
+	record         string
+	replay         string
+	stdoutFile     string
+	stderrFile     string
+	auditLog       string
+	auditLogMaxMB  int
+	auditLogKeep   int
+	configs        []string
+}
+
+// Isolation modes accepted in SandboxConfig.Isolation.
+const (
+	isolationProcess = "process"
+	isolationNone    = "none"
+)
+
+// Enforcement modes accepted in SandboxConfig.Enforcement.
+const (
+	enforcementEnforce = "enforce"
+	enforcementAudit   = "audit"
+)
+
+// Network modes accepted in SandboxConfig.NetMode. Like enforcement, any
+// value but "monitor" prompts.
+const (
+	netModePrompt  = "prompt"
+	netModeMonitor = "monitor"
+)
+
+func runCmd() error {
+	if len(os.Args) < 3 {
+		fmt.Println(runUsage)
+		return nil
+	}
+
+	flags, command, err := parseRunArgs(os.Args[2:])
+	if errors.Is(err, flag.ErrHelp) {
+		fmt.Println(runUsage)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if flags.replay != "" {
+		if command != nil {
+			return fmt.Errorf("--replay runs the recorded command; drop the command after --")
+		}
+		if set := replayConflicts(flags); len(set) > 0 {
+			return fmt.Errorf("--replay uses the recorded policy and can't be combined with %s", strings.Join(set, ", "))
+		}
+		return replayRun(flags.replay, flags.record, flags.iKnow, flags.confineTo)
+	}
+
+	if command == nil {
+		fmt.Println(runUsage)
+		return fmt.Errorf("no command specified; use -- before the command")
+	}
+
+	if flags.allowNet && flags.interactiveNet {
+		return fmt.Errorf("--allow-net and --net are mutually exclusive")
+	}
+	if flags.allowNet && len(flags.allowNetFiles) > 0 {
+		return fmt.Errorf("--allow-net-file has no effect with --allow-net, which allows every host")
+	}
+	if flags.monitor && !flags.interactiveNet {
+		return fmt.Errorf("--monitor requires --net")
+	}
+	if flags.notify && !flags.interactiveNet {
+		return fmt.Errorf("--notify requires --net")
+	}
+	if flags.groupPrompts && !flags.interactiveNet {
+		return fmt.Errorf("--group-prompts requires --net")
+	}
+	if flags.promptTimeout != 0 && !flags.interactiveNet {
+		return fmt.Errorf("--prompt-timeout requires --net")
+	}
+	if flags.promptTimeout < 0 {
+		return fmt.Errorf("--prompt-timeout must not be negative")
+	}
+	if flags.passEnv && flags.redactEnv {
+		return fmt.Errorf("--pass-env and --redact are mutually exclusive")
+	}
+	if flags.paranoidEnv && (flags.passEnv || flags.redactEnv) {
+		return fmt.Errorf("--paranoid cannot be combined with --pass-env or --redact")
+	}
+	if len(flags.keepEnv) > 0 && (flags.passEnv || flags.redactEnv) {
+		return fmt.Errorf("--keep-env has no effect with --pass-env or --redact, which pass every variable")
+	}
+	if len(flags.allowDevices) > 0 && flags.denyWrite {
+		return fmt.Errorf("--allow-device has no effect with --deny-write, which denies every write")
+	}
+	if flags.noDefaultTmp && flags.denyWrite {
+		return fmt.Errorf("--no-default-tmp has no effect with --deny-write, which denies every write")
+	}
+	if flags.ephemeral && flags.denyWrite {
+		return fmt.Errorf("--ephemeral and --deny-write are mutually exclusive")
+	}
+	if flags.privateTmp && (flags.denyWrite || flags.ephemeral || flags.noDefaultTmp) {
+		return fmt.Errorf("--private-tmp cannot be combined with --deny-write, --ephemeral or --no-default-tmp")
+	}
+	if flags.ephemeral && flags.chdir != "" {
+		return fmt.Errorf("--ephemeral and --chdir are mutually exclusive")
+	}
+	if flags.keepProfile && (flags.printOnly || flags.explain) {
+		return fmt.Errorf("--keep-profile has no effect with --profile or --explain, which only print the profile")
+	}
+	if flags.httpLog != "" && !flags.interactiveNet {
+		return fmt.Errorf("--http-log requires --net")
+	}
+	if flags.auditLog != "" && !flags.interactiveNet {
+		return fmt.Errorf("--audit-log requires --net")
+	}
+	if flags.metricsAddr != "" && !flags.interactiveNet {
+		return fmt.Errorf("--proxy-metrics-addr requires --net")
+	}
+	if flags.auditLogMaxMB < 0 || flags.auditLogKeep < 0 {
+		return fmt.Errorf("--audit-log-max-mb and --audit-log-keep must not be negative")
+	}
+	if flags.record != "" && flags.ephemeral {
+		return fmt.Errorf("--record and --ephemeral are mutually exclusive")
+	}
+	if flags.record != "" && flags.privateTmp {
+		return fmt.Errorf("--record and --private-tmp are mutually exclusive")
+	}
+
+	if flags.useTrace && len(flags.configs) > 0 {
+		return fmt.Errorf("--use-trace and --config are mutually exclusive")
+	}
+	if flags.noConfig && (flags.useTrace || len(flags.configs) > 0) {
+		return fmt.Errorf("--no-config cannot be combined with --config or --use-trace")
+	}
+
+	var cfg SandboxConfig
+	if flags.useTrace {
+		cfg, err = loadTraceConfig(os.Stderr)
+	} else if flags.noConfig {
+		cfg = defaultRunConfig()
+	} else {
+		cfg, err = loadRunConfigs(flags.configs)
+	}
+	if err != nil {
+		return err
+	}
+	loaded := cfg
+	if key, ok := matchCommandProfile(cfg, command); ok {
+		fmt.Fprintf(os.Stderr, "ddash: applying the %q entry of commands\n", key)
+	}
+	if err := checkIsolation(cfg, command, flags.noSandbox); err != nil {
+		return err
+	}
+	if cfg, err = withRemoteNet(selectCommandProfile(cfg, command)); err != nil {
+		return err
+	}
+	if cfg, err = withNetFiles(cfg, flags.allowNetFiles...); err != nil {
+		return err
+	}
+
+	// CLI flags override config
+	if flags.allowNet {
+		cfg.AllowNet = netEntries("*")
+		cfg.setOrigin("allow_net", "*", "--allow-net")
+	}
+	if flags.denyWrite {
+		cfg.AllowWrite = []string{}
+	}
+	if flags.noDefaultTmp {
+		off := false
+		cfg.TmpWrite = &off
+	}
+	if flags.noSandbox {
+		cfg.Isolation = isolationNone
+	}
+	cfg.AllowDevices = appendUnique(cfg.AllowDevices, flags.allowDevices)
+	for _, device := range flags.allowDevices {
+		cfg.setOrigin("allow_devices", device, "--allow-device")
+	}
+
+	cwd, _ := os.Getwd()
+	home, _ := os.UserHomeDir()
+	if err := checkRunPolicy(os.Stderr, loaded, cfg, cwd, home, flags.iKnow, flags.confineTo); err != nil {
+		return err
+	}
+
+	// Ephemeral runs may only write to a scratch dir, which becomes the
+	// working directory and is deleted afterwards.
+	var scratch string
+	cleanupScratch := func() {}
+	if flags.ephemeral {
+		scratch, cleanupScratch, err = newScratchDir("ddash-ephemeral-*")
+		if err != nil {
+			return err
+		}
+		defer cleanupScratch()
+		cfg.AllowWrite = []string{scratch}
+		cfg.setOrigin("allow_write", scratch, "--ephemeral: the run's scratch dir")
+	}
+
+	// --private-tmp swaps the shared /private/tmp grant for a temp dir of
+	// the run's own, handed to the command as TMPDIR.
+	var privateTmp string
+	if flags.privateTmp {
+		privateTmp, cleanupScratch, err = newScratchDir("ddash-tmp-*")
+		if err != nil {
+			return err
+		}
+		defer cleanupScratch()
+		off := false
+		cfg.TmpWrite = &off
+		cfg.AllowWrite = appendUnique(cfg.AllowWrite, []string{privateTmp})
+		cfg.setOrigin("allow_write", privateTmp, "--private-tmp: the run's TMPDIR")
+	}
+
+	cfg = withAuditFlag(os.Stderr, cfg, flags.audit)
+
+	if flags.explain {
+		fmt.Println(ExplainProfile(cfg, flags.denyWrite, flags.interactiveNet))
+		return nil
+	}
+	if flags.printOnly {
+		fmt.Println(GenerateProfile(cfg, flags.denyWrite, flags.interactiveNet))
+		return nil
+	}
+
+	if flags.interactiveNet {
+		noteIgnoredMonitor(os.Stderr, cfg, flags.monitor)
+	}
+
+	opts := RunOptions{
+		DenyWrite:      flags.denyWrite,
+		InteractiveNet: flags.interactiveNet,
+		Monitor:        flags.monitor,
+		Audit:          flags.audit,
+		NoSandbox:      flags.noSandbox,
+		PassEnv:        flags.passEnv,
+		RedactEnv:      flags.redactEnv,
+		ParanoidEnv:    flags.paranoidEnv,
+		KeepEnv:        flags.keepEnv,
+		Verbose:        flags.verbose,
+		LogDenials:     flags.logDenials || flags.record != "",
+		ForwardSignals: true,
+		GroupPrompts:   flags.groupPrompts,
+		PromptTimeout:  flags.promptTimeout,
+		MetricsAddr:    flags.metricsAddr,
+		SandboxExec:    flags.sandboxExec,
+	}
+	if flags.keepProfile {
+		opts.KeepProfile = filepath.Join(cwd, keptProfilePath)
+	}
+	if flags.notify {
+		opts.Prompter = NewDialogPrompter()
+	}
+	if flags.confirm {
+		opts.Confirm = ttyConfirm
+	}
+	if flags.chdir != "" {
+		// Only the child moves; config paths still resolve against the
+		// directory ddash was started in (the config root)
+		dir, err := filepath.Abs(flags.chdir)
+		if err != nil {
+			return fmt.Errorf("invalid --chdir %s: %w", flags.chdir, err)
+		}
+		if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+			return fmt.Errorf("--chdir %s is not a directory", flags.chdir)
+		}
+		opts.Dir = dir
+	}
+	if scratch != "" {
+		opts.Dir = scratch
+		opts.Env = []string{"TMPDIR=" + scratch}
+		fmt.Fprintf(os.Stderr, "ddash: ephemeral run in %s (discarded on exit)\n", scratch)
+	}
+	if privateTmp != "" {
+		opts.Env = []string{"TMPDIR=" + privateTmp}
+		if flags.verbose {
+			fmt.Fprintf(os.Stderr, "ddash: private TMPDIR %s (discarded on exit)\n", privateTmp)
+		}
+	}
+	if flags.auditLog != "" {
+		opts.AuditLog = AuditConfig{
+			Path:     flags.auditLog,
+			MaxSize:  int64(flags.auditLogMaxMB) << 20,
+			MaxFiles: flags.auditLogKeep,
+		}
+	}
+	closeOutputs, err := teeOutputs(&opts, flags.stdoutFile, flags.stderrFile)
+	if err != nil {
+		return err
+	}
+	defer closeOutputs()
+	if flags.httpLog != "" {
+		logFile, err := os.OpenFile(flags.httpLog, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+		if err != nil {
+			return fmt.Errorf("failed to open HTTP log: %w", err)
+		}
+		defer logFile.Close()
+		opts.HTTPLog = logFile
+	}
+
+	// Domains allowed with [o]nce-session earlier in this shell session
+	runCfg := cfg
+	if flags.interactiveNet && !flags.noConfig {
+		runCfg = withSessionDecisions(cfg)
+	}
+
+	result, runErr := Run(context.Background(), runCfg, command, opts)
+
+	// After command exits, save any "always"/"never" domain decisions. A
+	// traced policy is ephemeral, and --no-config runs leave the project
+	// config alone, so nothing is written for either.
+	if result.Decisions != nil && !flags.useTrace && !flags.noConfig {
+		saveRunDecisions(result.Decisions, cfg, flags.configs)
+	}
+
+	if flags.logDenials && cfg.Isolation != isolationNone {
+		printDenials(os.Stderr, result.Denials)
+	}
+	printBlockedDomains(os.Stderr, result.Blocked)
+	printWouldDeny(os.Stderr, result.WouldDeny)
+
+	if flags.record != "" && runErr == nil {
+		if err := writeRunRecord(flags.record, newRunRecord(cfg, command, opts, result)); err != nil {
+			fmt.Fprintf(os.Stderr, "ddash: %v\n", err)
+		} else {
+			fmt.Fprintf(os.Stderr, "ddash: recorded run to %s\n", flags.record)
+		}
+	}
+
+	if runErr != nil {
+		return runErr
+	}
+	if result.ExitCode != 0 {
+		// os.Exit skips deferred calls
+		closeOutputs()
+		cleanupScratch()
+		os.Exit(exitStatus(result.ExitCode))
+	}
+	return nil
+}
+
+// exitStatus is what ddash exits with after the child exited with code. A
+// child killed by a signal has no exit status (-1), and os.Exit(-1) would
+// exit with 255, so it becomes 1.
+func exitStatus(code int) int {
+	if code < 0 {
+		return 1
+	}
+	return code
+}
+
+// ttyConfirm asks on /dev/tty whether to run, so the child's stdin is left
+// alone. Without a terminal the run is declined.
+func ttyConfirm(summary string) bool {
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ddash: --confirm needs a terminal: can't open /dev/tty\n")
+		return false
+	}
+	defer tty.Close()
+	return confirmRun(tty, tty, summary)
+}
+
+// confirmRun prints summary to w and reads a y/N answer from r. Anything
+// but yes declines.
+func confirmRun(r io.Reader, w io.Writer, summary string) bool {
+	fmt.Fprintf(w, "ddash: %s\n", summary)
+	fmt.Fprintf(w, "ddash: run it? [y/N] ")
+	answer, _ := bufio.NewReader(r).ReadString('\n')
+	answer = strings.TrimSpace(strings.ToLower(answer))
+	return answer == "y" || answer == "yes"
+}
+
+// printBlockedDomains lists the hosts --net refused, so they can be added
+// to the policy if they turn out to be needed.
+func printBlockedDomains(w io.Writer, blocked []string) {
+	if len(blocked) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "ddash: blocked %d host(s): %s — add to allow_net to permit\n",
+		len(blocked), strings.Join(blocked, ", "))
+}
+
+// printWouldDeny lists the hosts let through only by a "log" decision or
+// net_mode monitor, which an enforcing policy would have blocked.
+func printWouldDeny(w io.Writer, hosts []string) {
+	if len(hosts) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "ddash: monitor: a strict policy would have blocked %d host(s): %s\n",
+		len(hosts), strings.Join(hosts, ", "))
+}
+
+// teeOutputs makes the child's stdout and stderr also go to the given
+// files (either may be empty), streaming as the child writes. Naming the
+// same file twice captures both streams in it. The returned function
+// closes the files.
+func teeOutputs(opts *RunOptions, stdoutPath, stderrPath string) (func(), error) {
+	var files []*os.File
+	closeAll := func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}
+	open := func(path string) (*os.File, error) {
+		if stdoutPath == stderrPath && len(files) > 0 {
+			return files[0], nil
+		}
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+		if err != nil {
+			closeAll()
+			return nil, fmt.Errorf("failed to open output file: %w", err)
+		}
+		files = append(files, f)
+		return f, nil
+	}
+
+	if stdoutPath != "" {
+		f, err := open(stdoutPath)
+		if err != nil {
+			return nil, err
+		}
+		opts.Stdout = io.MultiWriter(os.Stdout, f)
+	}
+	if stderrPath != "" {
+		f, err := open(stderrPath)
+		if err != nil {
+			return nil, err
+		}
+		opts.Stderr = io.MultiWriter(os.Stderr, f)
+	}
+	return closeAll, nil
+}
+
+// keptProfilePath is where --keep-profile saves the profile, relative to
+// the config root.
+var keptProfilePath = filepath.Join(".ddash", "last-profile.sb")
+
+// newScratchDir creates the write area for --ephemeral or --private-tmp,
+// named after pattern as for os.MkdirTemp, and returns a function that
+// deletes it with everything written there. The path has symlinks
+// resolved (/var -> /private/var), since sandbox profiles match the real
+// path.
+func newScratchDir(pattern string) (string, func(), error) {
+	dir, err := os.MkdirTemp("", pattern)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to create scratch dir: %w", err)
+	}
+	if real, err := filepath.EvalSymlinks(dir); err == nil {
+		dir = real
+	}
+	cleanup := func() {
+		if err := os.RemoveAll(dir); err != nil {
+			fmt.Fprintf(os.Stderr, "ddash: failed to remove scratch dir %s: %v\n", dir, err)
+		}
+	}
+	return dir, cleanup, nil
+}
+
+// parseRunArgs parses the flags of 'ddash run' (args excludes "ddash run")
+// and returns the child command that follows "--". It returns flag.ErrHelp
+// for -h/--help.
+func parseRunArgs(args []string) (runFlags, []string, error) {
+	var flags runFlags
+	flagArgs, command := splitCommand(args)
+
+	fs := newFlagSet("run")
+	fs.BoolVar(&flags.allowNet, "allow-net", false, "")
+	fs.Var((*stringList)(&flags.allowNetFiles), "allow-net-file", "")
+	fs.BoolVar(&flags.interactiveNet, "net", false, "")
+	fs.BoolVar(&flags.monitor, "monitor", false, "")
+	fs.BoolVar(&flags.audit, "audit", false, "")
+	fs.BoolVar(&flags.notify, "notify", false, "")
+	fs.BoolVar(&flags.groupPrompts, "group-prompts", false, "")
+	fs.DurationVar(&flags.promptTimeout, "prompt-timeout", 0, "")
+	fs.BoolVar(&flags.useTrace, "use-trace", false, "")
+	fs.BoolVar(&flags.noConfig, "no-config", false, "")
+	fs.BoolVar(&flags.confirm, "confirm", false, "")
+	fs.BoolVar(&flags.denyWrite, "deny-write", false, "")
+	fs.BoolVar(&flags.passEnv, "pass-env", false, "")
+	fs.BoolVar(&flags.redactEnv, "redact", false, "")
+	fs.BoolVar(&flags.paranoidEnv, "paranoid", false, "")
+	fs.Var((*stringList)(&flags.keepEnv), "keep-env", "")
+	fs.Var((*stringList)(&flags.allowDevices), "allow-device", "")
+	fs.BoolVar(&flags.noDefaultTmp, "no-default-tmp", false, "")
+	fs.BoolVar(&flags.noSandbox, "no-sandbox", false, "")
+	fs.StringVar(&flags.sandboxExec, "sandbox-exec", "", "")
+	fs.BoolVar(&flags.printOnly, "profile", false, "")
+	fs.BoolVar(&flags.explain, "explain", false, "")
+	fs.BoolVar(&flags.keepProfile, "keep-profile", false, "")
+	fs.BoolVar(&flags.verbose, "v", false, "")
+	fs.BoolVar(&flags.verbose, "verbose", false, "")
+	fs.BoolVar(&flags.logDenials, "log-denials", false, "")
+	fs.BoolVar(&flags.ephemeral, "ephemeral", false, "")
+	fs.BoolVar(&flags.privateTmp, "private-tmp", false, "")
+	fs.Var((*stringList)(&flags.configs), "config", "")
+	fs.StringVar(&flags.confineTo, "confine-to", "", "")
+	fs.StringVar(&flags.httpLog, "http-log", "", "")
+	fs.StringVar(&flags.metricsAddr, "proxy-metrics-addr", "", "")
+	fs.StringVar(&flags.chdir, "chdir", "", "")
+	fs.BoolVar(&flags.iKnow, "i-know", false, "")
+	fs.StringVar(&flags.record, "record", "", "")
+	fs.StringVar(&flags.replay, "replay", "", "")
+	fs.StringVar(&flags.stdoutFile, "stdout-file", "", "")
+	fs.StringVar(&flags.stderrFile, "stderr-file", "", "")
+	fs.StringVar(&flags.auditLog, "audit-log", "", "")
+	fs.IntVar(&flags.auditLogMaxMB, "audit-log-max-mb", 10, "")
+	fs.IntVar(&flags.auditLogKeep, "audit-log-keep", 3, "")
+
+	if err := fs.Parse(flagArgs); err != nil {
+		return flags, nil, err
+	}
+	if fs.NArg() > 0 {
+		return flags, nil, fmt.Errorf("unknown flag: %s\nUse -- before the command, e.g.: ddash run -- %s", fs.Arg(0), fs.Arg(0))
+	}
+	return flags, command, nil
+}
+
+// replayConflicts lists the policy flags set alongside --replay, which
+// would make the replay differ from the recording for reasons of its own.
+func replayConflicts(flags runFlags) []string {
+	var set []string
+	for _, f := range []struct {
+		name string
+		on   bool
+	}{
+		{"--allow-net", flags.allowNet},
+		{"--allow-net-file", len(flags.allowNetFiles) > 0},
+		{"--net", flags.interactiveNet},
+		{"--monitor", flags.monitor},
+		{"--audit", flags.audit},
+		{"--deny-write", flags.denyWrite},
+		{"--allow-device", len(flags.allowDevices) > 0},
+		{"--no-default-tmp", flags.noDefaultTmp},
+		{"--pass-env", flags.passEnv},
+		{"--redact", flags.redactEnv},
+		{"--paranoid", flags.paranoidEnv},
+		{"--keep-env", len(flags.keepEnv) > 0},
+		{"--no-sandbox", flags.noSandbox},
+		{"--ephemeral", flags.ephemeral},
+		{"--private-tmp", flags.privateTmp},
+		{"--config", len(flags.configs) > 0},
+		{"--use-trace", flags.useTrace},
+		{"--no-config", flags.noConfig},
+		{"--chdir", flags.chdir != ""},
+	} {
+		if f.on {
+			set = append(set, f.name)
+		}
+	}
+	return set
+}
+
+// loadRunConfigs loads the configs given with --config and merges them
+// left-to-right. With no paths it falls back to .ddash.json (or defaults).
+// Unlike the implicit .ddash.json, explicitly named files must exist.
+func loadRunConfigs(paths []string) (SandboxConfig, error) {
+	if len(paths) == 0 {
+		cfg, err := loadRunConfig()
+		if err != nil {
+			return SandboxConfig{}, err
+		}
+		return withRemoteNet(cfg)
+	}
+
+	var merged SandboxConfig
+	for i, path := range paths {
+		cfg, err := readConfig(path)
+		if err != nil {
+			return SandboxConfig{}, fmt.Errorf("failed to load config %s: %w", path, err)
+		}
+		if i == 0 {
+			merged = cfg
+		} else {
+			merged = mergeConfigs(merged, cfg)
+		}
+	}
+
+	if merged.Isolation == "" {
+		merged.Isolation = isolationProcess
+	}
+	if merged.AllowWrite == nil {
+		merged.AllowWrite = []string{"."}
+		merged.setOrigin("allow_write", ".", defaultWriteOrigin)
+	}
+	// A merged config no longer matches any single file's checksum
+	merged.Checksum = ""
+
+	return withRemoteNet(merged)
+}
+
+// withRemoteNet splices the hosts of allow_net_file and of remote
+// allow_net lists into cfg. Entries past their until date are dropped
+// first, with a warning, so their hosts are prompted for (or denied) again.
+func withRemoteNet(cfg SandboxConfig) (SandboxConfig, error) {
+	cfg, err := withNetFiles(cfg)
+	if err != nil {
+		return SandboxConfig{}, err
+	}
+	cfg, expired := dropExpiredNet(cfg, time.Now())
+	for _, e := range expired {
+		fmt.Fprintf(os.Stderr, "ddash: warning: %s\n", expiredNetWarning(e))
+	}
+	hosts, err := resolveRemoteNet(cfg.AllowNet)
+	if err != nil {
+		return SandboxConfig{}, err
+	}
+	cfg.AllowNet = hosts
+	return cfg, nil
+}
+
+// loadTraceConfig returns the policy cached by the last trace, with the
+// same defaults loadRunConfigs applies, and tells w where it came from.
+func loadTraceConfig(w io.Writer) (SandboxConfig, error) {
+	cwd, _ := os.Getwd()
+	trace, err := readLastTrace(cwd)
+	if err != nil {
+		return SandboxConfig{}, err
+	}
+	cfg := withOrigins(trace.Config, lastTracePath)
+	if cfg.Isolation == "" {
+		cfg.Isolation = isolationProcess
+	}
+	if cfg.AllowWrite == nil {
+		cfg.AllowWrite = []string{"."}
+		cfg.setOrigin("allow_write", ".", defaultWriteOrigin)
+	}
+	fmt.Fprintf(w, "ddash: using the policy suggested by the trace of %q at %s (%s)\n",
+		trace.Command, trace.TracedAt, lastTracePath)
+	fmt.Fprintf(w, "ddash: this policy is ephemeral: .ddash.json is not changed, and --net decisions are not saved\n")
+	return cfg, nil
+}
+
+// mergeConfigs overlays over onto base. Non-empty scalars in over win,
+// lists are appended with duplicates removed, and map entries in over
+// replace those in base.
+func mergeConfigs(base, over SandboxConfig) SandboxConfig {
+	merged := base
+
+	if over.Name != "" {
+		merged.Name = over.Name
+	}
+	if over.Version != "" {
+		merged.Version = over.Version
+	}
+	if over.CreatedAt != "" {
+		merged.CreatedAt = over.CreatedAt
+	}
+	if over.CreatedBy != "" {
+		merged.CreatedBy = over.CreatedBy
+	}
+	if over.Hostname != "" {
+		merged.Hostname = over.Hostname
+	}
+	if over.Isolation != "" {
+		merged.Isolation = over.Isolation
+	}
+	if over.Enforcement != "" {
+		merged.Enforcement = over.Enforcement
+	}
+	if over.NetMode != "" {
+		merged.NetMode = over.NetMode
+	}
+	if over.AllowNetFile != "" {
+		merged.AllowNetFile = over.AllowNetFile
+	}
+	if over.TmpWrite != nil {
+		merged.TmpWrite = over.TmpWrite
+	}
+	if over.BlockedNets != nil {
+		merged.BlockedNets = over.BlockedNets
+	}
+	// Either config asking for strict SNI is enough
+	merged.StrictSNI = base.StrictSNI || over.StrictSNI
+	if over.PromptOptions != nil {
+		// Replaced, not appended: a union could only offer more
+		merged.PromptOptions = over.PromptOptions
+	}
+
+	merged.origins = mergeOrigins(base.origins, over.origins)
+	merged.AllowNet = appendUnique(base.AllowNet, over.AllowNet)
+	merged.AllowRead = appendUnique(base.AllowRead, over.AllowRead)
+	merged.AllowWrite = appendUnique(base.AllowWrite, over.AllowWrite)
+	merged.StripHeaders = appendUnique(base.StripHeaders, over.StripHeaders)
+	merged.DenyWriteExts = appendUnique(base.DenyWriteExts, over.DenyWriteExts)
+	merged.AllowDevices = appendUnique(base.AllowDevices, over.AllowDevices)
+	merged.AllowFIFOs = appendUnique(base.AllowFIFOs, over.AllowFIFOs)
+
+	if len(base.NetworkDomains) > 0 || len(over.NetworkDomains) > 0 {
+		merged.NetworkDomains = make(map[string]string)
+		for k, v := range base.NetworkDomains {
+			merged.NetworkDomains[k] = v
+		}
+		for k, v := range over.NetworkDomains {
+			merged.NetworkDomains[k] = v
+		}
+	}
+	if len(base.PinNet) > 0 || len(over.PinNet) > 0 {
+		merged.PinNet = make(map[string]string)
+		for k, v := range base.PinNet {
+			merged.PinNet[k] = v
+		}
+		for k, v := range over.PinNet {
+			merged.PinNet[k] = v
+		}
+	}
+	if len(base.Commands) > 0 || len(over.Commands) > 0 {
+		merged.Commands = make(map[string]SandboxConfig)
+		for k, v := range base.Commands {
+			merged.Commands[k] = v
+		}
+		for k, v := range over.Commands {
+			merged.Commands[k] = v
+		}
+	}
+	if len(base.NetRewrite) > 0 || len(over.NetRewrite) > 0 {
+		merged.NetRewrite = make(map[string]string)
+		for k, v := range base.NetRewrite {
+			merged.NetRewrite[k] = v
+		}
+		for k, v := range over.NetRewrite {
+			merged.NetRewrite[k] = v
+		}
+	}
+
+	return merged
+}
+
+// appendUnique returns a followed by the entries of b not already present.
+// A nil result is kept nil so "unset" stays distinguishable from "empty".
+func appendUnique[T comparable](a, b []T) []T {
+	if a == nil && b == nil {
+		return nil
+	}
+	result := make([]T, 0, len(a)+len(b))
+	seen := make(map[T]bool)
+	for _, list := range [][]T{a, b} {
+		for _, v := range list {
+			if !seen[v] {
+				seen[v] = true
+				result = append(result, v)
+			}
+		}
+	}
+	return result
+}
+
+// loadRunConfig loads .ddash.json from the current directory, or the
+// built-in default policy if there is none. A config that exists but
+// can't be read or parsed is an error, not a reason to run under the
+// defaults.
+func loadRunConfig() (SandboxConfig, error) {
+	cfg, err := readConfig(configPath())
+	if errors.Is(err, os.ErrNotExist) {
+		return defaultRunConfig(), nil
+	}
+	if err != nil {
+		return SandboxConfig{}, fmt.Errorf("failed to load config %s: %w", configPath(), err)
+	}
+
+	// Ensure AllowWrite has a default
+	if cfg.AllowWrite == nil {
+		cfg.AllowWrite = []string{"."}
+		cfg.setOrigin("allow_write", ".", defaultWriteOrigin)
+	}
+
+	return cfg, nil
+}
+
+// defaultWriteOrigin explains the "." write grant of a config without
+// allow_write.
+const defaultWriteOrigin = `default allow_write "." (the config sets none)`
+
+// defaultRunConfig is the built-in restrictive policy, used when there is
+// no .ddash.json or with --no-config.
+func defaultRunConfig() SandboxConfig {
+	return withOrigins(SandboxConfig{
+		Name:       "default",
+		Isolation:  isolationProcess,
+		AllowNet:   []NetEntry{},
+		AllowRead:  pathEntries("."),
+		AllowWrite: []string{"."},
+	}, "the built-in default policy")
+}
+
+var (
+	staticPreludeOnce sync.Once
+	staticPrelude     string
+)
+
+// systemReadPaths are readable in full by every sandboxed command:
+// binaries, the libraries they link against, and system configuration.
+// /private/var is opened only where programs need it: per-user temp dirs
+// (/var/folders), timezone and dyld data, sockets such as mDNSResponder,
+// and the /bin/sh selection.
+var systemReadPaths = []string{
+	"/bin",
+	"/sbin",
+	"/usr",
+	"/System",
+	"/Library",
+	"/opt/homebrew",
+	"/private/etc",
+	"/private/tmp",
+	"/private/var/folders",
+	"/private/var/db",
+	"/private/var/run",
+	"/private/var/select",
+	"/private/var/tmp",
+	"/dev",
+}
+
+// systemTraversePaths only need to be passed through on the way to the
+// paths above: stat and readlink work, listing or reading them doesn't.
+var systemTraversePaths = []string{
+	"/",
+	"/etc",
+	"/tmp",
+	"/var",
+	"/private",
+	"/private/var",
+}
+
+// staticProfilePrelude returns the part of every profile that doesn't
+// depend on the config: the header, process and system rules, and the
+// system read paths. It is built once and reused by GenerateProfile.
+func staticProfilePrelude() string {
+	staticPreludeOnce.Do(func() {
+		staticPrelude = buildStaticProfilePrelude()
+	})
+	return staticPrelude
+}
+
+func buildStaticProfilePrelude() string {
+	var b profileBuilder
+	writeStaticProfilePrelude(&b)
+	return b.String()
+}
+
+func writeStaticProfilePrelude(b *profileBuilder) {
+	b.WriteString(";; Generated by ddash " + Version + "\n")
+	b.WriteString("(version 1)\n")
+	b.rule("(deny default)", "anything not allowed below is denied")
+	b.WriteString("\n")
+
+	// Always allow basic process execution
+	b.WriteString(";; Allow process execution\n")
+	b.rule("(allow process-exec)", "default: run programs")
+	b.rule("(allow process-fork)", "default: start child processes")
+	b.rule("(allow process-info*)", "default: inspect processes")
+	b.WriteString("\n")
+
+	// Allow sysctl reads (required by most programs)
+	b.WriteString(";; System basics\n")
+	b.rule("(allow sysctl-read)", "default: needed by most programs")
+	b.rule("(allow mach-lookup)", "default: system services")
+	b.rule("(allow signal)", "default: signal processes")
+	b.rule("(allow iokit-open)", "default: device drivers")
+	b.WriteString("\n")
+
+	// File reads
+	b.WriteString(";; File read access\n")
+	// Always allow reading system libraries and common paths
+	for _, path := range systemReadPaths {
+		b.rule(fmt.Sprintf("(allow file-read* (subpath %s))", sbplString(path)), "default system read")
+	}
+	b.WriteString(";; Traversal only: no listing or reading\n")
+	for _, path := range systemTraversePaths {
+		b.rule(fmt.Sprintf("(allow file-read-metadata (literal %s))", sbplString(path)), "default: pass through to system paths")
+	}
+	// stat() anywhere, so existence checks fail with ENOENT, not EPERM
+	b.rule("(allow file-read-metadata)", "default: stat anywhere, so missing files read as ENOENT")
+}
+
+// profileBuilder accumulates a profile. With explain set, every rule is
+// followed by a comment saying where it came from.
+type profileBuilder struct {
+	strings.Builder
+	explain bool
+}
+
+// rule writes one rule line, annotated with origin when explaining.
+func (b *profileBuilder) rule(rule, origin string) {
+	b.WriteString(rule)
+	if b.explain {
+		// A comment runs to the end of the line, so a line break in an
+		// origin must not start a line of its own
+		b.WriteString("  ; " + strings.ReplaceAll(origin, "\n", " "))
+	}
+	b.WriteString("\n")
+}
+
+// GenerateProfile translates cfg into a sandbox-exec (SBPL) profile, the
+// same one 'ddash run' applies. denyAllWrites drops every write grant, as
+// --deny-write does; proxyMode limits network access to localhost so
+// traffic must go through the --net proxy. Relative paths in cfg resolve
+// against the current directory. Nothing is executed.
+func GenerateProfile(cfg SandboxConfig, denyAllWrites bool, proxyMode bool) string {
+	return generateProfile(cfg, denyAllWrites, proxyMode, false)
+}
+
+// ExplainProfile is GenerateProfile with a comment after each rule naming
+// its origin: a config entry such as allow_read[0], a flag or a built-in
+// default. The rules are the same.
+func ExplainProfile(cfg SandboxConfig, denyAllWrites bool, proxyMode bool) string {
+	return generateProfile(cfg, denyAllWrites, proxyMode, true)
+}
+
+func generateProfile(cfg SandboxConfig, denyAllWrites, proxyMode, explain bool) string {
+	if cfg.auditMode() {
+		return auditProfile()
+	}
+
+	b := &profileBuilder{explain: explain}
+	if explain {
+		writeStaticProfilePrelude(b)
+	} else {
+		prelude := staticProfilePrelude()
+		b.Grow(len(prelude) + 1024)
+		b.WriteString(prelude)
+	}
+
+	cwd, _ := os.Getwd()
+	for i, entry := range cfg.AllowRead {
+		origin := "from " + cfg.origin("allow_read", i, entry.Path)
+		for _, resolved := range withRealPaths(expandPaths([]string{entry.Path}, cwd)) {
+			if entry.NonRecursive {
+				writeNonRecursiveRead(b, resolved, origin+" (non-recursive)")
+				continue
+			}
+			b.rule(fmt.Sprintf("(allow file-read* (subpath %s))", sbplString(resolved)), origin)
+		}
+	}
+	b.WriteString("\n")
+
+	// File writes
+	b.WriteString(";; File write access\n")
+	if denyAllWrites {
+		b.WriteString(";; All writes denied (--deny-write)\n")
+		b.rule("(allow file-write* (subpath \"/dev/null\"))", "/dev/null stays writable under --deny-write")
+		if len(cfg.AllowFIFOs) > 0 {
+			b.WriteString(";; allow_fifos not granted (--deny-write)\n")
+		}
+	} else {
+		if cfg.tmpWriteAllowed() {
+			b.rule("(allow file-write* (subpath \"/private/tmp\"))", "default temp write; tmp_write: false drops it")
+			b.rule("(allow file-write* (subpath \"/dev\"))", "default device write; tmp_write: false drops it")
+		} else {
+			b.WriteString(";; Temp writes denied (tmp_write: false or --no-default-tmp)\n")
+			b.rule("(allow file-write* (subpath \"/dev/null\"))", "/dev/null stays writable without tmp_write")
+		}
+		for i, path := range cfg.AllowWrite {
+			origin := "from " + cfg.origin("allow_write", i, path)
+			for _, resolved := range withRealPaths(expandPaths([]string{path}, cwd)) {
+				if isFIFO(resolved) {
+					// Write into the pipe, but don't replace or remove it
+					b.rule(fifoRule(resolved), origin+", a named pipe")
+					continue
+				}
+				b.rule(fmt.Sprintf("(allow file-write* (subpath %s))", sbplString(resolved)), origin)
+			}
+		}
+		for i, fifo := range cfg.AllowFIFOs {
+			if validateFIFO(fifo) != nil {
+				continue
+			}
+			for _, resolved := range fifoPaths(resolvePath(fifo, cwd)) {
+				b.rule(fifoRule(resolved), "from "+cfg.origin("allow_fifos", i, fifo))
+			}
+		}
+		// Later rules win, so these override the allows above
+		for i, ext := range cfg.DenyWriteExts {
+			if validateWriteExt(ext) != nil {
+				continue
+			}
+			b.rule(fmt.Sprintf("(deny file-write* (regex #\"%s\"))", extRegex(ext)), "from "+cfg.origin("deny_write_exts", i, ext))
+		}
+		for _, rule := range ignoreRules(cwd) {
+			b.rule(fmt.Sprintf("(deny file-write* (regex #\"%s\"))", rule), "from .ddashignore")
+		}
+		if dir, err := sessionDirPath(); err == nil {
+			for _, path := range withRealPaths([]string{dir}) {
+				b.rule(fmt.Sprintf("(deny file-write* (subpath %s))", sbplString(path)), "default: ddash's once-session decisions")
+			}
+		}
+	}
+
+	// Devices beyond the defaults, each exactly as named. Later rules win,
+	// so these hold even with tmp_write off
+	if len(cfg.AllowDevices) > 0 {
+		b.WriteString("\n;; Devices\n")
+		if denyAllWrites {
+			b.WriteString(";; allow_devices not granted (--deny-write)\n")
+		} else {
+			for i, device := range cfg.AllowDevices {
+				if validateDevice(device) != nil {
+					continue
+				}
+				b.rule(fmt.Sprintf("(allow file-read* file-write* (literal %s))", sbplString(device)), "from "+cfg.origin("allow_devices", i, device))
+			}
+		}
+	}
+	b.WriteString("\n")
+
+	// Network
+	b.WriteString(";; Network access\n")
+	if proxyMode {
+		// In proxy mode, allow connections only to the local proxy (127.0.0.1).
+		// All external connections go through the proxy which prompts the user.
+		b.WriteString(";; Interactive proxy mode — only localhost allowed\n")
+		if allowsAllNet(cfg) {
+			b.WriteString(";; allow_net \"*\" overridden: --net prompts per domain\n")
+		}
+		b.rule("(allow network* (remote ip \"localhost:*\"))", "proxy mode (--net): localhost only, so traffic must pass the proxy")
+	} else if len(cfg.AllowNet) > 0 {
+		for i, n := range entryHosts(cfg.AllowNet) {
+			if n == "*" {
+				b.rule("(allow network*)", "from "+cfg.origin("allow_net", i, "*"))
+				break
+			}
+			b.WriteString(fmt.Sprintf(";; allow: %s\n", n))
+		}
+	} else {
+		b.WriteString(";; Network denied (default)\n")
+	}
+
+	return b.String()
+}
+
+// auditProfile is the profile for "enforcement": "audit". It allows
+// everything and traces every operation to SANDBOX_LOG_FILE, so a policy
+// can be rolled out by watching what it would have blocked first.
+func auditProfile() string {
+	var sb strings.Builder
+	sb.WriteString(";; Generated by ddash " + Version + "\n")
+	sb.WriteString(";; Audit mode (enforcement: audit): nothing is blocked\n")
+	sb.WriteString("(version 1)\n")
+	sb.WriteString("(allow default)\n")
+	sb.WriteString("(trace default)\n")
+	return sb.String()
+}
+
+// allowsAllNet reports whether cfg opens the network to every host. In
+// proxy mode GenerateProfile ignores this: an explicit --net means "ask",
+// so the profile allows only the local proxy and the proxy prompts.
+func allowsAllNet(cfg SandboxConfig) bool {
+	for _, e := range cfg.AllowNet {
+		if e.Host == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// validateWriteExt checks a deny_write_exts entry: a dot followed by
+// letters, digits, '_', '-', '+' or further dots (".sh", ".tar.gz").
+func validateWriteExt(ext string) error {
+	if len(ext) < 2 || ext[0] != '.' {
+		return fmt.Errorf("deny_write_exts entry %q must start with a dot, e.g. \".sh\"", ext)
+	}
+	for _, r := range ext[1:] {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("._-+", r)) {
+			return fmt.Errorf("deny_write_exts entry %q contains %q; use letters, digits, '.', '_', '-' or '+'", ext, r)
+		}
+	}
+	return nil
 }
 
-func runCmd() error {
-	if len(os.Args) < 3 {
-		fmt.Println(runUsage)
-		return nil
+// validateDevice checks an allow_devices entry: a clean absolute path
+// under /dev, such as "/dev/dri/card0".
+func validateDevice(device string) error {
+	if !strings.HasPrefix(device, "/dev/") || path.Clean(device) != device {
+		return fmt.Errorf("allow_devices entry %q must be a path under /dev, e.g. \"/dev/audio\"", device)
+	}
+	if strings.ContainsAny(device, "\"\\*") {
+		return fmt.Errorf("allow_devices entry %q names no single device; globs and quotes aren't supported", device)
 	}
+	return nil
+}
 
-	flags := runFlags{}
-	cmdStart := -1
-
-	for i := 2; i < len(os.Args); i++ {
-		switch os.Args[i] {
-		case "--allow-net":
-			flags.allowNet = true
-		case "--net":
-			flags.interactiveNet = true
-		case "--deny-write":
-			flags.denyWrite = true
-		case "--pass-env":
-			flags.passEnv = true
-		case "--profile":
-			flags.printOnly = true
-		case "-h", "--help":
-			fmt.Println(runUsage)
-			return nil
-		case "--":
-			if i+1 < len(os.Args) {
-				cmdStart = i + 1
-			}
-		default:
-			if cmdStart == -1 {
-				return fmt.Errorf("unknown flag: %s\nUse -- before the command, e.g.: ddash run -- %s", os.Args[i], os.Args[i])
-			}
-		}
-		if cmdStart != -1 {
-			break
-		}
+// validateFIFO checks an allow_fifos entry: a single path, absolute or
+// relative to the config root.
+func validateFIFO(fifo string) error {
+	if fifo == "" || strings.ContainsAny(fifo, "\"\\") || isGlob(fifo) {
+		return fmt.Errorf("allow_fifos entry %q must name a single path; globs and quotes aren't supported", fifo)
 	}
+	return nil
+}
 
-	if cmdStart == -1 {
-		fmt.Println(runUsage)
-		return fmt.Errorf("no command specified; use -- before the command")
+// isFIFO reports whether path is an existing named pipe.
+func isFIFO(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.Mode()&os.ModeNamedPipe != 0
+}
+
+// fifoPaths returns path and, if it differs, its real path. A pipe the
+// command has yet to create has no real path, so its directory is
+// resolved instead.
+func fifoPaths(path string) []string {
+	if _, err := os.Lstat(path); err == nil {
+		return withRealPaths([]string{path})
+	}
+	paths := []string{path}
+	if dir, err := filepath.EvalSymlinks(filepath.Dir(path)); err == nil {
+		if real := filepath.Join(dir, filepath.Base(path)); real != filepath.Clean(path) {
+			paths = append(paths, real)
+		}
 	}
+	return paths
+}
 
-	if flags.allowNet && flags.interactiveNet {
-		return fmt.Errorf("--allow-net and --net are mutually exclusive")
+// sbplString quotes s as an SBPL string literal. Paths come from config
+// entries and from file names on disk, so backslashes and double quotes
+// are escaped: a name like `x")) (allow default)` must stay one string
+// rather than end the literal and add rules of its own.
+func sbplString(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+// fifoRule lets the command create the named pipe at path and write into
+// it, without the rest of file-write* (unlink, rename, setattr).
+func fifoRule(path string) string {
+	return fmt.Sprintf("(allow file-write-create file-write-data (literal %s))", sbplString(path))
+}
+
+// extRegex builds the sandbox regex matching paths that end in ext. Letters
+// match either case, since macOS volumes are usually case-insensitive and
+// "evil.SH" runs as well as "evil.sh".
+func extRegex(ext string) string {
+	var sb strings.Builder
+	for _, r := range ext {
+		lower, upper := unicode.ToLower(r), unicode.ToUpper(r)
+		switch {
+		case lower != upper:
+			sb.WriteString("[" + string(lower) + string(upper) + "]")
+		case strings.ContainsRune(".+", r):
+			sb.WriteString("\\" + string(r))
+		default:
+			sb.WriteRune(r)
+		}
 	}
+	sb.WriteString("$")
+	return sb.String()
+}
 
-	cfg := loadRunConfig()
+// writeNonRecursiveRead grants reads of dir and of its immediate children
+// as they exist now, without descending into subdirectories.
+func writeNonRecursiveRead(b *profileBuilder, dir, origin string) {
+	b.rule(fmt.Sprintf("(allow file-read* (literal %s))", sbplString(dir)), origin)
+	children, _ := filepath.Glob(filepath.Join(dir, "*"))
+	for _, child := range children {
+		b.rule(fmt.Sprintf("(allow file-read* (literal %s))", sbplString(child)), origin)
+	}
+}
 
-	// CLI flags override config
-	if flags.allowNet {
-		cfg.AllowNet = []string{"*"}
+// proxyDomains builds the --net proxy's initial domain decisions: saved
+// network_domains, plus allow_net hosts as "allow" where no decision is
+// saved. Entries with a port keep it ("example.com:443", "example.com:*"),
+// but a decision saved for the bare host still governs every port, so a
+// port entry never outranks a saved "never". Entries written
+// "https://host" are also returned in httpsOnly.
+func proxyDomains(cfg SandboxConfig) (domains map[string]string, httpsOnly []string) {
+	domains = make(map[string]string)
+	for _, entry := range cfg.AllowNet {
+		host, port, https := parseNetEntry(entry.Host)
+		if host == "" || host == "*" {
+			continue
+		}
+		if _, saved := cfg.NetworkDomains[host]; saved && port != "" {
+			continue
+		}
+		if port != "" {
+			domains[net.JoinHostPort(host, port)] = string(DecisionAllow)
+		} else {
+			domains[host] = string(DecisionAllow)
+		}
+		if https {
+			httpsOnly = append(httpsOnly, host)
+		}
 	}
-	if flags.denyWrite {
-		cfg.AllowWrite = []string{}
+	for domain, decision := range cfg.NetworkDomains {
+		domains[domain] = decision
 	}
+	return domains, httpsOnly
+}
 
-	profile := generateProfile(cfg, flags.denyWrite, flags.interactiveNet)
+// parseNetEntry splits an allow_net entry into its host, its port ("" if
+// none, "*" for any) and whether it is limited to HTTPS
+// ("https://api.example.com"). "http://" and bare hosts allow both.
+func parseNetEntry(entry string) (host, port string, httpsOnly bool) {
+	host = strings.TrimSpace(entry)
+	if rest, ok := strings.CutPrefix(host, "https://"); ok {
+		host, httpsOnly = rest, true
+	} else {
+		host = strings.TrimPrefix(host, "http://")
+	}
+	host = strings.TrimSuffix(host, "/")
+	host, port = splitHostPort(host, "")
+	return host, port, httpsOnly
+}
 
-	if flags.printOnly {
-		fmt.Println(profile)
+// validateNetPort checks the port of an allow_net entry: none, "*" or a
+// number from 1 to 65535.
+func validateNetPort(entry string) error {
+	_, port, _ := parseNetEntry(entry)
+	if port == "" || port == "*" {
 		return nil
 	}
+	if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		return fmt.Errorf("allow_net entry %q: port must be a number from 1 to 65535, or * for any port", entry)
+	}
+	return nil
+}
 
-	return execSandboxed(profile, os.Args[cmdStart:], flags, cfg)
+// expandEnv expands $VAR and ${VAR} in a config path from the environment,
+// and "$$" to a literal "$". Unset and empty variables expand to "" and
+// are returned in missing, so callers can refuse a path that lost its
+// prefix.
+func expandEnv(path string) (expanded string, missing []string) {
+	if !strings.Contains(path, "$") {
+		return path, nil
+	}
+	expanded = os.Expand(path, func(name string) string {
+		if name == "$" {
+			return "$"
+		}
+		value := os.Getenv(name)
+		if value == "" {
+			missing = append(missing, name)
+		}
+		return value
+	})
+	return expanded, missing
 }
 
-func loadRunConfig() SandboxConfig {
-	data, err := os.ReadFile(configPath())
-	if err != nil {
-		// Default restrictive config
-		return SandboxConfig{
-			Name:       "default",
-			Isolation:  "process",
-			AllowNet:   []string{},
-			AllowRead:  []string{"."},
-			AllowWrite: []string{"."},
+// resolvePath turns a config path into an absolute one: environment
+// variables are expanded first, then "~" and paths relative to cwd.
+func resolvePath(path, cwd string) string {
+	path, _ = expandEnv(path)
+	if path == "." {
+		return cwd
+	}
+	if path == "~" || strings.HasPrefix(path, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			return home + path[1:]
 		}
 	}
+	if strings.HasPrefix(path, "/") {
+		return path
+	}
+	return cwd + "/" + path
+}
 
-	var cfg SandboxConfig
-	if err := json.Unmarshal(data, &cfg); err != nil {
-		return SandboxConfig{
-			Name:       "default",
-			Isolation:  "process",
-			AllowNet:   []string{},
-			AllowRead:  []string{"."},
-			AllowWrite: []string{"."},
+// sensitiveHomeDirs are credential stores under $HOME that ddash exists to
+// keep away from sandboxed commands.
+var sensitiveHomeDirs = []string{
+	".ssh",
+	".aws",
+	".azure",
+	".config/gcloud",
+	".config/gh",
+	".docker",
+	".gnupg",
+	".kube",
+	".netrc",
+	".npmrc",
+	".pypirc",
+	"Library/Keychains",
+}
+
+// checkRunPolicy applies the checks every entry point that runs a command
+// makes before starting it. Credential directories readable under cfg
+// need iKnow and are then reported on w; with confineTo, every grant must
+// stay inside it. loaded is the config as read, before command profiles
+// and flags changed it, and gets a warning if it no longer matches its
+// recorded checksum.
+func checkRunPolicy(w io.Writer, loaded, cfg SandboxConfig, cwd, home string, iKnow bool, confineTo string) error {
+	if exposed := sensitiveReadGrants(cfg, cwd, home); home != "" && len(exposed) > 0 {
+		if !iKnow {
+			return fmt.Errorf("config grants reads of credential directories:\n  %s\nremove these entries, or pass --i-know to run anyway",
+				strings.Join(exposed, "\n  "))
+		}
+		fmt.Fprintf(w, "ddash: WARNING: config grants reads of credential directories (--i-know):\n")
+		for _, e := range exposed {
+			fmt.Fprintf(w, "ddash: WARNING:   %s\n", e)
 		}
 	}
 
-	// Ensure AllowWrite has a default
-	if cfg.AllowWrite == nil {
-		cfg.AllowWrite = []string{"."}
+	if confineTo != "" {
+		if violations := confinementViolations(cfg, cwd, confineTo); len(violations) > 0 {
+			return fmt.Errorf("config grants access outside %s:\n  %s",
+				confineTo, strings.Join(violations, "\n  "))
+		}
 	}
 
-	return cfg
+	if loaded.Checksum != "" && !checksumValid(loaded) {
+		fmt.Fprintf(w, "ddash: WARNING: config was modified since its checksum was recorded; review it before trusting this run\n")
+	}
+	return nil
 }
 
-func generateProfile(cfg SandboxConfig, denyAllWrites bool, proxyMode bool) string {
-	var sb strings.Builder
+// sensitiveReadGrants lists allow_read entries that expose one of the
+// sensitiveHomeDirs under home, e.g. "~" or "~/.aws/config". Non-recursive
+// entries only count when they point inside a sensitive dir. Since the
+// profile also grants the real path of an entry, a symlink into a
+// sensitive dir ("docs" -> ~/.ssh) counts as well. Glob patterns are
+// checked by what they match, as the profile grants them.
+func sensitiveReadGrants(cfg SandboxConfig, cwd, home string) []string {
+	var exposed []string
+	for i, entry := range cfg.AllowRead {
+		matches, _ := expandPath(entry.Path, cwd)
+		for _, dir := range sensitiveHomeDirs {
+			for _, match := range matches {
+				resolved := filepath.Clean(match)
+				via, ok := exposesDir(resolved, filepath.Join(home, dir), entry.NonRecursive)
+				if !ok {
+					continue
+				}
+				warning := fmt.Sprintf("allow_read[%d] = %q exposes ~/%s", i, entry.Path, dir)
+				if isGlob(entry.Path) {
+					warning += fmt.Sprintf(" (matches %s)", resolved)
+				}
+				if via != resolved {
+					warning += fmt.Sprintf(" (a symlink to %s)", via)
+				}
+				exposed = append(exposed, warning)
+				break
+			}
+		}
+	}
+	return exposed
+}
 
-	sb.WriteString(";; Generated by ddash " + Version + "\n")
-	sb.WriteString("(version 1)\n")
-	sb.WriteString("(deny default)\n\n")
+// exposesDir reports whether a read grant of path exposes dir, comparing
+// their real paths too, and returns the form of path that does.
+func exposesDir(path, dir string, nonRecursive bool) (string, bool) {
+	for _, p := range withRealPaths([]string{path}) {
+		for _, d := range withRealPaths([]string{dir}) {
+			if isWithin(p, d) || (!nonRecursive && isWithin(d, p)) {
+				return p, true
+			}
+		}
+	}
+	return "", false
+}
 
-	// Always allow basic process execution
-	sb.WriteString(";; Allow process execution\n")
-	sb.WriteString("(allow process-exec)\n")
-	sb.WriteString("(allow process-fork)\n")
-	sb.WriteString("(allow process-info*)\n\n")
+// expandPaths resolves config paths against cwd and expands glob patterns
+// (e.g. "vendor/*/include") into the concrete paths that exist right now.
+// A pattern with no matches is skipped with a warning rather than failing,
+// since the sandbox simply has nothing to grant for it. So is a path using
+// an unset or empty variable: "$BUILD_DIR/out" must not turn into "/out".
+func expandPaths(paths []string, cwd string) []string {
+	var expanded []string
+	for _, path := range paths {
+		matches, warning := expandPath(path, cwd)
+		if warning != "" {
+			fmt.Fprintf(os.Stderr, "ddash: warning: %s\n", warning)
+		}
+		expanded = append(expanded, matches...)
+	}
+	return expanded
+}
 
-	// Allow sysctl reads (required by most programs)
-	sb.WriteString(";; System basics\n")
-	sb.WriteString("(allow sysctl-read)\n")
-	sb.WriteString("(allow mach-lookup)\n")
-	sb.WriteString("(allow signal)\n")
-	sb.WriteString("(allow iokit-open)\n\n")
+// expandPath is expandPaths for a single path. It returns the warning
+// instead of printing it, for the policy checks, which look at what an
+// entry grants without repeating the warnings of the run.
+func expandPath(path, cwd string) ([]string, string) {
+	if _, missing := expandEnv(path); len(missing) > 0 {
+		return nil, fmt.Sprintf("%q uses unset or empty $%s, skipped", path, strings.Join(missing, ", $"))
+	}
+	resolved := resolvePath(path, cwd)
+	if !isGlob(resolved) {
+		return []string{resolved}, ""
+	}
 
-	// File reads
-	sb.WriteString(";; File read access\n")
-	// Always allow reading system libraries and common paths
-	sb.WriteString("(allow file-read* (subpath \"/bin\"))\n")
-	sb.WriteString("(allow file-read* (subpath \"/sbin\"))\n")
-	sb.WriteString("(allow file-read* (subpath \"/usr\"))\n")
-	sb.WriteString("(allow file-read* (subpath \"/System\"))\n")
-	sb.WriteString("(allow file-read* (subpath \"/Library\"))\n")
-	sb.WriteString("(allow file-read* (subpath \"/opt/homebrew\"))\n")
-	sb.WriteString("(allow file-read* (subpath \"/private/etc\"))\n")
-	sb.WriteString("(allow file-read* (subpath \"/private/tmp\"))\n")
-	sb.WriteString("(allow file-read* (subpath \"/private/var\"))\n")
-	sb.WriteString("(allow file-read* (subpath \"/dev\"))\n")
-	sb.WriteString("(allow file-read* (literal \"/\"))\n")
-	sb.WriteString("(allow file-read-metadata)\n")
+	matches, err := filepath.Glob(resolved)
+	if err != nil {
+		return nil, fmt.Sprintf("invalid glob %q: %v", path, err)
+	}
+	if len(matches) == 0 {
+		return nil, fmt.Sprintf("glob %q matched nothing", path)
+	}
+	return matches, ""
+}
 
-	cwd, _ := os.Getwd()
-	for _, path := range cfg.AllowRead {
-		resolved := resolvePath(path, cwd)
-		sb.WriteString(fmt.Sprintf("(allow file-read* (subpath \"%s\"))\n", resolved))
+// withRealPaths returns paths with each one's symlink-resolved target
+// added after it, where that differs. sandbox-exec matches the real path
+// of a file, so a rule for ./output alone misses writes when output is a
+// symlink to /var/data. Paths that don't exist yet are kept as they are.
+func withRealPaths(paths []string) []string {
+	var out []string
+	for _, path := range paths {
+		out = append(out, path)
+		if real, err := filepath.EvalSymlinks(path); err == nil && real != filepath.Clean(path) {
+			out = append(out, real)
+		}
 	}
-	sb.WriteString("\n")
+	return out
+}
 
-	// File writes
-	sb.WriteString(";; File write access\n")
-	if denyAllWrites {
-		sb.WriteString(";; All writes denied (--deny-write)\n")
-		sb.WriteString("(allow file-write* (subpath \"/dev/null\"))\n")
-	} else {
-		sb.WriteString("(allow file-write* (subpath \"/private/tmp\"))\n")
-		sb.WriteString("(allow file-write* (subpath \"/dev\"))\n")
-		for _, path := range cfg.AllowWrite {
+// warnMissingPaths lists allow_read/allow_write entries that resolve to
+// paths that don't exist. The sandbox ignores rules for missing paths, so
+// a typo otherwise shows up only as a denial later. Missing paths are not
+// an error, since the command may create them. Globs and entries with
+// unset or empty variables are left to expandPaths, which already warns
+// about them.
+func warnMissingPaths(cfg SandboxConfig, cwd string) []string {
+	var warnings []string
+	check := func(field string, paths []string) {
+		for i, path := range paths {
+			if _, missing := expandEnv(path); len(missing) > 0 {
+				continue
+			}
 			resolved := resolvePath(path, cwd)
-			sb.WriteString(fmt.Sprintf("(allow file-write* (subpath \"%s\"))\n", resolved))
+			if isGlob(resolved) {
+				continue
+			}
+			if _, err := os.Stat(resolved); errors.Is(err, os.ErrNotExist) {
+				warnings = append(warnings, fmt.Sprintf("%s[%d] = %q does not exist (%s)", field, i, path, filepath.Clean(resolved)))
+			}
 		}
 	}
-	sb.WriteString("\n")
+	check("allow_read", entryPaths(cfg.AllowRead))
+	check("allow_write", cfg.AllowWrite)
+	return warnings
+}
 
-	// Network
-	sb.WriteString(";; Network access\n")
-	if proxyMode {
-		// In proxy mode, allow connections only to the local proxy (127.0.0.1).
-		// All external connections go through the proxy which prompts the user.
-		sb.WriteString(";; Interactive proxy mode — only localhost allowed\n")
-		sb.WriteString("(allow network* (remote ip \"localhost:*\"))\n")
-	} else if len(cfg.AllowNet) > 0 {
-		for _, n := range cfg.AllowNet {
-			if n == "*" {
-				sb.WriteString("(allow network*)\n")
-				break
+// confinementViolations lists allow_read/allow_write entries that resolve
+// outside root. Paths are compared lexically after resolvePath, so ".."
+// segments can't escape. A glob pattern must lie inside root as written,
+// and so must each path it matches. An entry or match that is a symlink
+// must also point inside root, since the profile grants its target too.
+func confinementViolations(cfg SandboxConfig, cwd, root string) []string {
+	absRoot, err := filepath.Abs(root)
+	if err != nil {
+		return []string{fmt.Sprintf("invalid root %s: %v", root, err)}
+	}
+	realRoot, err := filepath.EvalSymlinks(absRoot)
+	if err != nil {
+		realRoot = absRoot
+	}
+
+	var violations []string
+	check := func(field string, paths []string) {
+		for i, path := range paths {
+			resolved := filepath.Clean(resolvePath(path, cwd))
+			if !isWithin(resolved, absRoot) {
+				violations = append(violations, fmt.Sprintf("%s[%d] = %q (resolves to %s)", field, i, path, resolved))
+				continue
+			}
+			matches, _ := expandPath(path, cwd)
+			for _, match := range matches {
+				match = filepath.Clean(match)
+				if !isWithin(match, absRoot) {
+					violations = append(violations, fmt.Sprintf("%s[%d] = %q (matches %s)", field, i, path, match))
+					break
+				}
+				if real, err := filepath.EvalSymlinks(match); err == nil && !isWithin(real, realRoot) {
+					if match == resolved {
+						violations = append(violations, fmt.Sprintf("%s[%d] = %q (symlink to %s)", field, i, path, real))
+					} else {
+						violations = append(violations, fmt.Sprintf("%s[%d] = %q (matches %s, a symlink to %s)", field, i, path, match, real))
+					}
+					break
+				}
 			}
-			sb.WriteString(fmt.Sprintf(";; allow: %s\n", n))
 		}
-	} else {
-		sb.WriteString(";; Network denied (default)\n")
 	}
-
-	return sb.String()
+	check("allow_read", entryPaths(cfg.AllowRead))
+	check("allow_write", cfg.AllowWrite)
+	return violations
 }
 
-func resolvePath(path, cwd string) string {
-	if path == "." {
-		return cwd
-	}
-	if strings.HasPrefix(path, "/") {
-		return path
+// isWithin reports whether path is root or lies below it.
+func isWithin(path, root string) bool {
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return false
 	}
-	return cwd + "/" + path
+	return rel != ".." && !strings.HasPrefix(rel, "../")
+}
+
+func isGlob(path string) bool {
+	return strings.ContainsAny(path, "*?[")
 }
 
-func scrubEnv() []string {
+// scrubEnv returns the environment without sensitive variables. With
+// paranoid set it keeps only the curated safe set instead. Variables
+// named in keep always pass.
+func scrubEnv(w io.Writer, paranoid bool, keep []string) []string {
 	var clean []string
 	var stripped []string
 
@@ -294,7 +1747,7 @@ func scrubEnv() []string {
 			name = env[:idx]
 		}
 
-		if isSensitive(name) {
+		if envScrubbed(name, paranoid, keep) {
 			stripped = append(stripped, name)
 			continue
 		}
@@ -302,13 +1755,34 @@ func scrubEnv() []string {
 	}
 
 	if len(stripped) > 0 {
-		fmt.Fprintf(os.Stderr, "ddash: scrubbed %d env var(s): %s\n",
+		fmt.Fprintf(w, "ddash: scrubbed %d env var(s): %s\n",
 			len(stripped), strings.Join(stripped, ", "))
 	}
 
 	return clean
 }
 
+// envScrubbed reports whether scrubbing removes the variable name.
+func envScrubbed(name string, paranoid bool, keep []string) bool {
+	if slices.Contains(keep, name) {
+		return false
+	}
+	return isSensitive(name) || (paranoid && !isSafeEnv(name))
+}
+
+// isSafeEnv reports whether --paranoid keeps the variable name.
+func isSafeEnv(name string) bool {
+	if safeEnvNames[name] {
+		return true
+	}
+	for _, prefix := range safeEnvPrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
 func isSensitive(name string) bool {
 	upper := strings.ToUpper(name)
 
@@ -327,108 +1801,251 @@ func isSensitive(name string) bool {
 	return false
 }
 
-func execSandboxed(profile string, args []string, flags runFlags, cfg SandboxConfig) error {
-	// Find the command binary
-	binary, err := exec.LookPath(args[0])
-	if err != nil {
-		return fmt.Errorf("command not found: %s", args[0])
+// minRedactLen is the shortest secret value that gets masked. Very short
+// values ("1", "on") would otherwise mangle unrelated diagnostic text.
+const minRedactLen = 4
+
+// redactSecrets replaces the values of sensitive environment variables found
+// in s with "***". Every diagnostic that may echo user-controlled text (the
+// command line, upstream errors) goes through it so that secrets passed to
+// the child are never printed by ddash itself.
+func redactSecrets(s string) string {
+	for _, env := range os.Environ() {
+		idx := strings.Index(env, "=")
+		if idx < 0 {
+			continue
+		}
+		name, value := env[:idx], env[idx+1:]
+		if len(value) < minRedactLen || !isSensitive(name) {
+			continue
+		}
+		s = strings.ReplaceAll(s, value, "***")
 	}
+	return s
+}
 
-	sandboxExec, err := exec.LookPath("sandbox-exec")
-	if err != nil {
-		return fmt.Errorf("sandbox-exec not found — ddash requires macOS sandbox support")
+// redactedEnv passes the full environment through but reports which
+// sensitive variables are present, without printing their values.
+func redactedEnv() []string {
+	env := os.Environ()
+
+	var masked []string
+	for _, e := range env {
+		name := e
+		if idx := strings.Index(e, "="); idx >= 0 {
+			name = e[:idx]
+		}
+		if isSensitive(name) {
+			masked = append(masked, name)
+		}
 	}
 
-	// Build environment
-	var env []string
-	if flags.passEnv {
-		env = os.Environ()
-	} else {
-		env = scrubEnv()
+	if len(masked) > 0 {
+		fmt.Fprintf(os.Stderr, "ddash: passing %d sensitive env var(s) with values redacted: %s\n",
+			len(masked), strings.Join(masked, ", "))
 	}
 
-	// Start interactive proxy if --net
-	var proxy *NetworkProxy
-	if flags.interactiveNet {
-		domains := cfg.NetworkDomains
-		if domains == nil {
-			domains = make(map[string]string)
-		}
-		proxy, err = NewProxy(domains, strings.Join(args, " "))
+	return env
+}
+
+// sandboxExecEnv names the variable that points ddash at a sandbox-exec
+// other than the one on PATH, e.g. a wrapper or a test stub.
+const sandboxExecEnv = "DDASH_SANDBOX_EXEC"
+
+// findSandboxExec returns the sandbox-exec binary to use: override (from
+// --sandbox-exec) if set, else $DDASH_SANDBOX_EXEC, else the one on PATH.
+// An override must name an executable file; a bare name is looked up on
+// PATH. The variable swaps out the binary that enforces the policy and can
+// come from a project's .envrc, so using it is always noted on w.
+func findSandboxExec(w io.Writer, override string) (string, error) {
+	source := "--sandbox-exec"
+	if override == "" {
+		override = os.Getenv(sandboxExecEnv)
+		source = sandboxExecEnv
+	}
+	if override == "" {
+		path, err := exec.LookPath("sandbox-exec")
 		if err != nil {
-			return fmt.Errorf("failed to start network proxy: %w", err)
+			return "", fmt.Errorf("sandbox-exec not found — ddash requires macOS sandbox support, which Apple has deprecated; run 'ddash doctor', or set %s to another copy", sandboxExecEnv)
 		}
-		defer proxy.Shutdown()
-		proxy.Start()
+		return path, nil
+	}
 
-		proxyURL := "http://" + proxy.Addr()
-		env = append(env,
-			"HTTP_PROXY="+proxyURL,
-			"HTTPS_PROXY="+proxyURL,
-			"http_proxy="+proxyURL,
-			"https_proxy="+proxyURL,
-		)
+	path, err := exec.LookPath(override)
+	if err != nil {
+		return "", fmt.Errorf("%s %s is not an executable file", source, override)
 	}
+	// The child may run in another directory (--chdir)
+	if abs, err := filepath.Abs(path); err == nil && strings.ContainsRune(override, filepath.Separator) {
+		path = abs
+	}
+	if source == sandboxExecEnv {
+		fmt.Fprintf(w, "ddash: note: using sandbox-exec %s from $%s\n", path, sandboxExecEnv)
+	}
+	return path, nil
+}
+
+// checkProfile tells a command that failed apart from a sandbox that
+// never started: it has sandbox-exec apply profile to a no-op command and
+// returns an error if that fails too, reporting sandbox-exec's complaints
+// prefixed with "ddash:" on w so they don't pass for the program's output.
+// Callers run it only once the real command has exited non-zero, so a
+// working run costs no extra sandbox-exec.
+func checkProfile(w io.Writer, sandboxExec, profile string) error {
+	var stderr bytes.Buffer
+	cmd := execCommand(context.Background(), sandboxExec, "-p", profile, "/usr/bin/true")
+	cmd.Stderr = &stderr
+	runErr := cmd.Run()
 
-	envStatus := "scrubbed"
-	if flags.passEnv {
-		envStatus = "passed"
+	var diags []string
+	for _, d := range sandboxExecDiagnostics(stderr.String()) {
+		if isSandboxExecDeprecation(d) {
+			continue
+		}
+		diags = append(diags, d)
+		fmt.Fprintf(w, "ddash: %s\n", d)
 	}
-	netStatus := networkStatus(profile)
-	if flags.interactiveNet {
-		netStatus = "interactive"
+	if runErr != nil && len(diags) > 0 {
+		return fmt.Errorf("sandbox-exec rejected the profile (see --profile)")
 	}
+	return nil
+}
 
-	fmt.Fprintf(os.Stderr, "ddash: sandboxing %s (network=%s, writes=%s, env=%s)\n",
-		args[0], netStatus, writeStatus(profile), envStatus)
+// noteDeprecation replaces sandbox-exec's deprecation notice with one ddash
+// note on w per process, however many commands run.
+func noteDeprecation(w io.Writer) {
+	deprecationNote.Do(func() {
+		fmt.Fprintf(w, "ddash: note: this macOS marks sandbox-exec as deprecated; it still works, but a future release may remove it ('ddash doctor' checks)\n")
+	})
+}
 
-	// Build sandbox-exec command args
-	cmdArgs := []string{"-p", profile, binary}
-	cmdArgs = append(cmdArgs, args[1:]...)
+var deprecationNote sync.Once
 
-	// Use exec.Command instead of syscall.Exec for proper stdin/stdout/stderr
-	// piping. syscall.Exec replaces the process which breaks piped input.
-	cmd := exec.Command(sandboxExec, cmdArgs...)
-	cmd.Stdin = os.Stdin
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	cmd.Env = env
+// isSandboxExecDeprecation reports whether line is sandbox-exec's notice
+// that it is deprecated, as opposed to a real error.
+func isSandboxExecDeprecation(line string) bool {
+	line = strings.TrimSpace(line)
+	return strings.HasPrefix(line, "sandbox-exec:") && strings.Contains(strings.ToLower(line), "deprecated")
+}
 
-	// Forward signals to the child process
-	sigCh := make(chan os.Signal, 1)
-	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
-	go func() {
-		for sig := range sigCh {
-			if cmd.Process != nil {
-				cmd.Process.Signal(sig)
+// filterSandboxExecStderr copies r to w without sandbox-exec's deprecation
+// notice. sandbox-exec prints it before starting the command, so only the
+// first line is checked, and only held back while it still reads like one
+// of sandbox-exec's own lines: a child's "Password: " prompt or "\r"
+// progress output passes through as soon as it is written. It reports
+// whether the notice was dropped.
+func filterSandboxExecStderr(r io.Reader, w io.Writer) (deprecated bool, err error) {
+	const prefix = "sandbox-exec:"
+	var head []byte
+	buf := make([]byte, 4096)
+	for {
+		n, rerr := r.Read(buf)
+		head = append(head, buf[:n]...)
+		line, rest, complete := bytes.Cut(head, []byte("\n"))
+		ours := bytes.HasPrefix(head, []byte(prefix)) || len(head) < len(prefix) && strings.HasPrefix(prefix, string(head))
+		if complete && isSandboxExecDeprecation(string(line)) {
+			head, deprecated = rest, true
+		}
+		if complete || !ours || rerr != nil {
+			if _, err := w.Write(head); err != nil {
+				io.Copy(io.Discard, r)
+				return deprecated, err
+			}
+			if rerr != nil {
+				return deprecated, nil
 			}
+			_, err = io.Copy(w, r)
+			return deprecated, err
 		}
-	}()
-	defer signal.Stop(sigCh)
+	}
+}
 
-	runErr := cmd.Run()
+// stderrFilter returns a writer that passes what's written to it through
+// filterSandboxExecStderr to w, and a func that flushes it once the
+// command has exited, noting a dropped notice. A terminal is left alone,
+// so the child still finds one on its stderr; the notice then shows as
+// sandbox-exec printed it.
+func stderrFilter(w io.Writer) (io.Writer, func()) {
+	if isTerminal(w) {
+		return w, func() {}
+	}
+	pr, pw := io.Pipe()
+	done := make(chan struct{})
+	go func() {
+		if deprecated, _ := filterSandboxExecStderr(pr, w); deprecated {
+			noteDeprecation(w)
+		}
+		io.Copy(io.Discard, pr)
+		close(done)
+	}()
+	return pw, func() {
+		pw.Close()
+		<-done
+	}
+}
 
-	// After command exits, save any "always"/"never" domain decisions
-	if proxy != nil {
-		saveDomainDecisions(proxy.Domains(), cfg)
+// isTerminal reports whether stream, one of ddash's stdio files, is a
+// terminal (or another character device, such as /dev/null).
+func isTerminal(stream any) bool {
+	f, ok := stream.(*os.File)
+	if !ok {
+		return false
 	}
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
 
-	if runErr != nil {
-		if exitErr, ok := runErr.(*exec.ExitError); ok {
-			os.Exit(exitErr.ExitCode())
+// sandboxExecDiagnostics returns the lines of out written by sandbox-exec
+// itself, which always start with "sandbox-exec:".
+func sandboxExecDiagnostics(out string) []string {
+	var diags []string
+	for _, line := range strings.Split(out, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "sandbox-exec:") {
+			diags = append(diags, line)
 		}
-		return runErr
 	}
+	return diags
+}
 
-	return nil
+// withSessionDecisions returns a copy of cfg that also allows the domains
+// answered with [o]nce-session earlier in this shell session. Decisions
+// from the config take precedence. The copy keeps session decisions from
+// ending up in a saved config.
+func withSessionDecisions(cfg SandboxConfig) SandboxConfig {
+	domains := loadSessionDecisions()
+	if domains == nil {
+		domains = make(map[string]string)
+	}
+	for domain, decision := range cfg.NetworkDomains {
+		domains[domain] = decision
+	}
+	cfg.NetworkDomains = domains
+	return cfg
+}
+
+// saveRunDecisions saves the "always"/"never" answers among decisions to
+// the config cfg was loaded from, the last of configs if any were given,
+// and the "session" ones to the session file.
+func saveRunDecisions(decisions map[string]string, cfg SandboxConfig, configs []string) {
+	path := configPath()
+	if len(configs) > 0 {
+		// Persist into the most specific overlay
+		path = configs[len(configs)-1]
+	}
+	saveDomainDecisions(decisions, cfg, path)
+	if err := saveSessionDecisions(decisions); err != nil {
+		fmt.Fprintf(os.Stderr, "ddash: failed to save session decisions: %v\n", err)
+	}
 }
 
-// saveDomainDecisions persists "always"/"never" domain decisions to .ddash.json.
-func saveDomainDecisions(domains map[string]string, cfg SandboxConfig) {
+// saveDomainDecisions persists "always"/"never" domain decisions to the
+// config at path (normally .ddash.json).
+func saveDomainDecisions(domains map[string]string, cfg SandboxConfig, path string) {
 	// Collect only persistent decisions (always/never)
 	persistent := make(map[string]string)
 	for domain, decision := range domains {
-		if decision == "always" || decision == "never" {
+		if Decision(decision).IsPersistent() {
 			persistent[domain] = decision
 		}
 	}
@@ -437,6 +2054,16 @@ func saveDomainDecisions(domains map[string]string, cfg SandboxConfig) {
 		return
 	}
 
+	// Merge into the config on disk rather than the run's effective config,
+	// which may carry CLI overrides (--deny-write, --no-sandbox).
+	if onDisk, err := readConfig(path); err == nil {
+		cfg = onDisk
+	}
+
+	// Only re-stamp the checksum if it was valid before this change, so
+	// writing back decisions never launders an unreviewed edit.
+	restamp := checksumValid(cfg)
+
 	// Merge with existing config
 	if cfg.NetworkDomains == nil {
 		cfg.NetworkDomains = make(map[string]string)
@@ -453,18 +2080,117 @@ func saveDomainDecisions(domains map[string]string, cfg SandboxConfig) {
 		return
 	}
 
-	data, err := json.MarshalIndent(cfg, "", "  ")
-	if err != nil {
+	if restamp {
+		cfg.Checksum = computeChecksum(cfg)
+	}
+
+	if err := writeConfig(path, cfg); err != nil {
 		fmt.Fprintf(os.Stderr, "ddash: failed to save domain rules: %v\n", err)
 		return
 	}
 
-	if err := os.WriteFile(configPath(), append(data, '\n'), 0644); err != nil {
-		fmt.Fprintf(os.Stderr, "ddash: failed to write config: %v\n", err)
-		return
+	fmt.Fprintf(os.Stderr, "ddash: saved %d domain rule(s) to %s\n", newCount, filepath.Base(path))
+}
+
+// writePreflight prints the effective policy before exec so the user can
+// confirm the intended policy is in force.
+func writePreflight(w io.Writer, profile string, cfg SandboxConfig, scrubbed int, proxyAddr string, monitor bool) {
+	cwd, _ := os.Getwd()
+
+	resolveAll := func(paths []string) string {
+		if len(paths) == 0 {
+			return "none"
+		}
+		resolved := make([]string, len(paths))
+		for i, path := range paths {
+			resolved[i] = resolvePath(path, cwd)
+		}
+		return strings.Join(resolved, ", ")
+	}
+
+	netStatus := networkStatus(profile)
+	if proxyAddr != "" {
+		netStatus = proxyNetStatus(monitor)
+	}
+
+	fmt.Fprintf(w, "ddash: preflight\n")
+	fmt.Fprintf(w, "  network:  %s\n", netStatus)
+	fmt.Fprintf(w, "  writes:   %s\n", writeStatus(profile))
+	fmt.Fprintf(w, "  env:      %d var(s) scrubbed\n", scrubbed)
+	fmt.Fprintf(w, "  reads:    system paths, %s\n", resolveAll(entryPaths(cfg.AllowRead)))
+	fmt.Fprintf(w, "  write to: %s\n", resolveAll(cfg.AllowWrite))
+	if proxyAddr != "" {
+		fmt.Fprintf(w, "  proxy:    active on %s\n", proxyAddr)
+	} else {
+		fmt.Fprintf(w, "  proxy:    inactive\n")
+	}
+}
+
+// proxyNetStatus describes network access through the --net proxy.
+func proxyNetStatus(monitor bool) string {
+	if monitor {
+		return "monitor (every domain allowed unasked, blocked ones reported)"
+	}
+	return "interactive"
+}
+
+// checkIsolation refuses to run command under cfg if the config, or its
+// commands entry for command, sets "isolation": "none" without noSandbox,
+// the --no-sandbox flag: a checked-out config can't turn the sandbox off
+// by itself. The error names the entry.
+func checkIsolation(cfg SandboxConfig, command []string, noSandbox bool) error {
+	if noSandbox {
+		return nil
+	}
+	entry := ""
+	if cfg.Isolation == isolationNone {
+		entry = "isolation"
+	}
+	if key, ok := matchCommandProfile(cfg, command); ok {
+		switch cfg.Commands[key].Isolation {
+		case isolationNone:
+			entry = fmt.Sprintf("commands[%q].isolation", key)
+		case "":
+		default:
+			entry = ""
+		}
 	}
+	if entry == "" {
+		return nil
+	}
+	return fmt.Errorf(`config entry %s is "none"; pass --no-sandbox to run without the sandbox`, entry)
+}
+
+// withAuditFlag returns cfg under enforcement "audit" if audit, the --audit
+// flag, is set. Otherwise a config's enforcement "audit" is dropped with a
+// note on w: a checked-out config can't switch the sandbox off by itself.
+func withAuditFlag(w io.Writer, cfg SandboxConfig, audit bool) SandboxConfig {
+	switch {
+	case audit:
+		cfg.Enforcement = enforcementAudit
+	case cfg.auditMode():
+		fmt.Fprintf(w, "ddash: config sets enforcement \"audit\"; ignored without --audit, the policy is enforced\n")
+		cfg.Enforcement = enforcementEnforce
+	}
+	return cfg
+}
+
+// noteIgnoredMonitor tells the user that net_mode "monitor" in cfg is not
+// honored: a config can't switch off the --net prompts by itself, that
+// takes --monitor on the command line.
+func noteIgnoredMonitor(w io.Writer, cfg SandboxConfig, monitor bool) {
+	if cfg.NetMode == netModeMonitor && !monitor {
+		fmt.Fprintf(w, "ddash: config sets net_mode \"monitor\"; ignored without --monitor, new domains are prompted for\n")
+	}
+}
 
-	fmt.Fprintf(os.Stderr, "ddash: saved %d domain rule(s) to .ddash.json\n", newCount)
+// unsandboxedNetStatus describes network access when no profile is applied.
+// Only the --net proxy still has an effect, and only for proxy-aware programs.
+func unsandboxedNetStatus(interactiveNet bool) string {
+	if interactiveNet {
+		return "interactive (proxy only, not enforced)"
+	}
+	return "unrestricted"
 }
 
 func networkStatus(profile string) string {
@@ -474,9 +2200,20 @@ func networkStatus(profile string) string {
 	return "denied"
 }
 
+// writeStatus reports "allowed" if the profile grants writes beyond the
+// implicit /private/tmp and /dev (or /dev/null) rules.
 func writeStatus(profile string) string {
-	if strings.Count(profile, "file-write*") <= 2 {
-		return "restricted"
+	for _, line := range strings.Split(profile, "\n") {
+		if !strings.HasPrefix(line, "(allow file-write*") {
+			continue
+		}
+		switch {
+		case strings.Contains(line, `"/private/tmp"`),
+			strings.Contains(line, `"/dev"`),
+			strings.Contains(line, `"/dev/null"`):
+			continue
+		}
+		return "allowed"
 	}
-	return "allowed"
+	return "restricted"
 }
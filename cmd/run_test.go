@@ -1,9 +1,18 @@
 package cmd
 
 import (
+	"bytes"
+	"context"
+	"flag"
+	"io"
 	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
 	"strings"
+	"syscall"
 	"testing"
+	"time"
 )
 
 func TestIsSensitive(t *testing.T) {
@@ -30,6 +39,11 @@ func TestIsSensitive(t *testing.T) {
 		"AZURE_CLIENT_SECRET",
 		"GCP_SERVICE_ACCOUNT_KEY",
 		"GOOGLE_APPLICATION_CREDENTIALS",
+		"APIKEY",
+		"MYSECRET",
+		"DB_PASS",
+		"GH_PAT_TOKEN2",
+		"SERVICE_CREDS",
 	}
 
 	for _, name := range sensitive {
@@ -74,7 +88,7 @@ func TestScrubEnv(t *testing.T) {
 	defer os.Unsetenv("DDASH_TEST_SECRET_KEY")
 	defer os.Unsetenv("DDASH_TEST_TOKEN")
 
-	env := scrubEnv()
+	env := scrubEnv(io.Discard, false, nil)
 
 	foundSafe := false
 	for _, e := range env {
@@ -94,14 +108,59 @@ func TestScrubEnv(t *testing.T) {
 	}
 }
 
+func TestScrubEnvParanoid(t *testing.T) {
+	t.Setenv("DDASH_TEST_SAFE", "safe_value")
+	t.Setenv("LC_ALL", "C")
+
+	env := scrubEnv(io.Discard, true, nil)
+
+	kept := make(map[string]bool)
+	for _, e := range env {
+		name, _, _ := strings.Cut(e, "=")
+		kept[name] = true
+		if !isSafeEnv(name) {
+			t.Errorf("%s should have been scrubbed in paranoid mode", name)
+		}
+	}
+	if kept["DDASH_TEST_SAFE"] {
+		t.Error("DDASH_TEST_SAFE is not on the safe list and should have been scrubbed")
+	}
+	if !kept["LC_ALL"] {
+		t.Error("LC_ALL should have been kept")
+	}
+	if os.Getenv("PATH") != "" && !kept["PATH"] {
+		t.Error("PATH should have been kept")
+	}
+}
+
+func TestScrubEnvKeep(t *testing.T) {
+	t.Setenv("MY_TOKEN", "kept")
+	t.Setenv("OTHER_TOKEN", "scrubbed")
+
+	flags, _, err := parseRunArgs([]string{"--keep-env", "MY_TOKEN", "--", "env"})
+	if err != nil {
+		t.Fatalf("parseRunArgs: %v", err)
+	}
+	env := strings.Join(scrubEnv(io.Discard, false, flags.keepEnv), "\n")
+	if !strings.Contains(env, "MY_TOKEN=kept") {
+		t.Error("MY_TOKEN should pass with --keep-env")
+	}
+	if strings.Contains(env, "OTHER_TOKEN=") {
+		t.Error("OTHER_TOKEN should still be scrubbed")
+	}
+	if env := strings.Join(scrubEnv(io.Discard, true, flags.keepEnv), "\n"); !strings.Contains(env, "MY_TOKEN=kept") {
+		t.Error("--keep-env should also apply with --paranoid")
+	}
+}
+
 func TestGenerateProfileDefaults(t *testing.T) {
 	cfg := SandboxConfig{
-		AllowNet:   []string{},
-		AllowRead:  []string{"."},
+		AllowNet:   []NetEntry{},
+		AllowRead:  pathEntries("."),
 		AllowWrite: []string{"."},
 	}
 
-	profile := generateProfile(cfg, false, false)
+	profile := GenerateProfile(cfg, false, false)
 
 	// Must have deny default
 	if !strings.Contains(profile, "(deny default)") {
@@ -131,14 +190,177 @@ func TestGenerateProfileDefaults(t *testing.T) {
 	}
 }
 
+func TestGenerateProfileTraversalOnly(t *testing.T) {
+	profile := GenerateProfile(SandboxConfig{AllowWrite: []string{"."}}, false, false)
+
+	for _, path := range []string{"/", "/private", "/private/var"} {
+		if !strings.Contains(profile, `(allow file-read-metadata (literal "`+path+`"))`) {
+			t.Errorf("profile should allow traversing %s", path)
+		}
+		for _, rule := range []string{`(allow file-read* (literal "` + path + `"))`, `(allow file-read* (subpath "` + path + `"))`} {
+			if strings.Contains(profile, rule) {
+				t.Errorf("%s is traversal only, but the profile has %s", path, rule)
+			}
+		}
+	}
+
+	// What programs actually read under /private/var stays readable
+	for _, path := range []string{"/private/var/folders", "/private/var/db", "/private/var/run"} {
+		if !strings.Contains(profile, `(allow file-read* (subpath "`+path+`"))`) {
+			t.Errorf("profile missing read access to %s", path)
+		}
+	}
+}
+
+func TestGenerateProfileSymlinkedPaths(t *testing.T) {
+	dir := t.TempDir()
+	target := filepath.Join(dir, "data")
+	if err := os.Mkdir(target, 0755); err != nil {
+		t.Fatal(err)
+	}
+	link := filepath.Join(dir, "output")
+	if err := os.Symlink(target, link); err != nil {
+		t.Fatal(err)
+	}
+	realTarget, _ := filepath.EvalSymlinks(target)
+
+	profile := GenerateProfile(SandboxConfig{
+		AllowRead:  pathEntries(link),
+		AllowWrite: []string{link, filepath.Join(dir, "not-yet")},
+	}, false, false)
+
+	for _, rule := range []string{
+		`(allow file-read* (subpath "` + link + `"))`,
+		`(allow file-read* (subpath "` + realTarget + `"))`,
+		`(allow file-write* (subpath "` + link + `"))`,
+		`(allow file-write* (subpath "` + realTarget + `"))`,
+		`(allow file-write* (subpath "` + filepath.Join(dir, "not-yet") + `"))`,
+	} {
+		if !strings.Contains(profile, rule) {
+			t.Errorf("profile missing %s", rule)
+		}
+	}
+}
+
+func TestConfinementViolationsSymlink(t *testing.T) {
+	root := t.TempDir()
+	outside := t.TempDir()
+	if err := os.Symlink(outside, filepath.Join(root, "escape")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(root, "inside"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(root, "inside"), filepath.Join(root, "alias")); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := SandboxConfig{AllowWrite: []string{"./escape", "./alias"}}
+	violations := confinementViolations(cfg, root, root)
+	if len(violations) != 1 || !strings.Contains(violations[0], `allow_write[0] = "./escape" (symlink to`) {
+		t.Errorf("expected only ./escape to violate, got %v", violations)
+	}
+}
+
+func TestConfinementViolationsGlob(t *testing.T) {
+	root := t.TempDir()
+	outside := t.TempDir()
+	os.MkdirAll(filepath.Join(root, "vendor", "lib"), 0755)
+	if err := os.Symlink(outside, filepath.Join(root, "vendor", "escape")); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := SandboxConfig{AllowRead: pathEntries("./vendor/*"), AllowWrite: []string{"./vendor/l?b"}}
+	violations := confinementViolations(cfg, root, root)
+	if len(violations) != 1 || !strings.Contains(violations[0], `allow_read[0] = "./vendor/*" (matches `+filepath.Join(root, "vendor", "escape")+", a symlink to") {
+		t.Errorf("expected the symlink matched by ./vendor/* to violate, got %v", violations)
+	}
+}
+
+func TestWarnMissingPaths(t *testing.T) {
+	dir := t.TempDir()
+	os.Mkdir(filepath.Join(dir, "output"), 0755)
+
+	cfg := SandboxConfig{
+		AllowRead:  pathEntries(".", "./dta", "./vendor/*/include", "$DDASH_TEST_UNSET_VAR/x"),
+		AllowWrite: []string{"./output", "./ouptut"},
+	}
+	warnings := warnMissingPaths(cfg, dir)
+
+	if len(warnings) != 2 {
+		t.Fatalf("expected 2 warnings, got %d: %v", len(warnings), warnings)
+	}
+	if !strings.Contains(warnings[0], `allow_read[1] = "./dta" does not exist`) ||
+		!strings.Contains(warnings[1], `allow_write[1] = "./ouptut" does not exist (`+dir+"/ouptut)") {
+		t.Errorf("unexpected warnings: %v", warnings)
+	}
+}
+
+func TestGenerateProfileDenyWriteExts(t *testing.T) {
+	cfg := SandboxConfig{
+		AllowWrite:    []string{"."},
+		DenyWriteExts: []string{".sh", ".dylib", ".tar.gz", "sh"},
+	}
+	profile := GenerateProfile(cfg, false, false)
+
+	for _, rule := range []string{
+		`(deny file-write* (regex #"\.[sS][hH]$"))`,
+		`(deny file-write* (regex #"\.[dD][yY][lL][iI][bB]$"))`,
+		`(deny file-write* (regex #"\.[tT][aA][rR]\.[gG][zZ]$"))`,
+	} {
+		if !strings.Contains(profile, rule) {
+			t.Errorf("profile missing %s", rule)
+		}
+	}
+	if strings.Count(profile, "(deny file-write* (regex") != 3 {
+		t.Errorf("invalid entries should not produce rules:\n%s", profile)
+	}
+	// Denies must come after the allows they override
+	if strings.Index(profile, "(deny file-write*") < strings.LastIndex(profile, "(allow file-write*") {
+		t.Error("extension denies should follow the write allows")
+	}
+
+	// A benign extension stays writable: no rule matches it
+	for _, ext := range []string{".csv", ".json"} {
+		for _, line := range strings.Split(profile, "\n") {
+			if strings.HasPrefix(line, "(deny file-write* (regex") && regexp.MustCompile(ruleRegex(line)).MatchString("/work/out"+ext) {
+				t.Errorf("%s is not listed but %s matches it", ext, line)
+			}
+		}
+	}
+
+	if p := GenerateProfile(cfg, true, false); strings.Contains(p, "regex") {
+		t.Error("--deny-write already blocks everything; no extension rules expected")
+	}
+}
+
+// ruleRegex extracts the pattern of a (regex #"...") rule.
+func ruleRegex(rule string) string {
+	start := strings.Index(rule, `#"`) + 2
+	return rule[start:strings.LastIndex(rule, `"`)]
+}
+
+func TestValidateWriteExt(t *testing.T) {
+	for _, ext := range []string{".sh", ".dylib", ".tar.gz", ".c++", ".so_1"} {
+		if err := validateWriteExt(ext); err != nil {
+			t.Errorf("validateWriteExt(%q): %v", ext, err)
+		}
+	}
+	for _, ext := range []string{"", ".", "sh", ".s h", `.sh"`, ".sh$", "./x"} {
+		if err := validateWriteExt(ext); err == nil {
+			t.Errorf("validateWriteExt(%q) should fail", ext)
+		}
+	}
+}
+
 func TestGenerateProfileAllowNet(t *testing.T) {
 	cfg := SandboxConfig{
-		AllowNet:   []string{"*"},
-		AllowRead:  []string{"."},
+		AllowNet:   netEntries("*"),
+		AllowRead:  pathEntries("."),
 		AllowWrite: []string{"."},
 	}
 
-	profile := generateProfile(cfg, false, false)
+	profile := GenerateProfile(cfg, false, false)
 
 	if !strings.Contains(profile, "(allow network*)") {
 		t.Error("profile should allow network when configured")
@@ -147,12 +369,12 @@ func TestGenerateProfileAllowNet(t *testing.T) {
 
 func TestGenerateProfileDenyWrite(t *testing.T) {
 	cfg := SandboxConfig{
-		AllowNet:   []string{},
-		AllowRead:  []string{"."},
+		AllowNet:   []NetEntry{},
+		AllowRead:  pathEntries("."),
 		AllowWrite: []string{},
 	}
 
-	profile := generateProfile(cfg, true, false)
+	profile := GenerateProfile(cfg, true, false)
 
 	// Should NOT have /private/tmp write access
 	if strings.Contains(profile, "(allow file-write* (subpath \"/private/tmp\"))") {
@@ -173,12 +395,15 @@ func TestLoadRunConfigDefaults(t *testing.T) {
 	os.Chdir(tmpDir)
 	defer os.Chdir(origDir)
 
-	cfg := loadRunConfig()
+	cfg, err := loadRunConfig()
+	if err != nil {
+		t.Fatalf("loadRunConfig: %v", err)
+	}
 
 	if len(cfg.AllowNet) != 0 {
 		t.Errorf("expected empty AllowNet, got %v", cfg.AllowNet)
 	}
-	if len(cfg.AllowRead) != 1 || cfg.AllowRead[0] != "." {
+	if len(cfg.AllowRead) != 1 || cfg.AllowRead[0].Path != "." {
 		t.Errorf("expected AllowRead=[.], got %v", cfg.AllowRead)
 	}
 	if len(cfg.AllowWrite) != 1 || cfg.AllowWrite[0] != "." {
@@ -186,6 +411,101 @@ func TestLoadRunConfigDefaults(t *testing.T) {
 	}
 }
 
+func TestNoConfigIgnoresProjectConfig(t *testing.T) {
+	// A checked-out project that wants everything: no sandbox, the whole
+	// network and disk, and a credentials directory
+	hostile := `{"name":"project","isolation":"none","allow_net":["*"],"allow_write":["/"],"allow_read":["~/.ssh"]}`
+
+	calls := stubExecCommand(t, "exit 0")
+	if _, err := runCmdIn(t, hostile, "run", "--", "echo"); err == nil {
+		t.Fatal("the hostile config ran without --i-know; the fixture doesn't test anything")
+	}
+
+	*calls = nil
+	dir, err := runCmdIn(t, hostile, "run", "--no-config", "--", "echo")
+	if err != nil {
+		t.Fatalf("runCmd --no-config: %v", err)
+	}
+	if len(*calls) != 1 || (*calls)[0].args[0] != "-p" {
+		t.Fatalf("calls = %v, want the child under sandbox-exec", *calls)
+	}
+	profile := (*calls)[0].args[1]
+	if strings.Contains(profile, "(allow network") {
+		t.Errorf("profile allows the network:\n%s", profile)
+	}
+	if strings.Contains(profile, `(allow file-write* (subpath "/"))`) {
+		t.Errorf("profile allows writes everywhere:\n%s", profile)
+	}
+	if !strings.Contains(profile, `(allow file-write* (subpath "`+dir+`"))`) {
+		t.Errorf("profile doesn't grant the default write to the current directory:\n%s", profile)
+	}
+}
+
+func TestConfigIsolationNeedsNoSandbox(t *testing.T) {
+	calls := stubExecCommand(t, "exit 0")
+	for config, entry := range map[string]string{
+		`{"name":"project","isolation":"none"}`:                       "config entry isolation ",
+		`{"name":"project","commands":{"echo":{"isolation":"none"}}}`: `config entry commands["echo"].isolation `,
+	} {
+		_, err := runCmdIn(t, config, "run", "--", "echo")
+		if err == nil || !strings.Contains(err.Error(), entry) {
+			t.Errorf("%s: err = %v, want one naming %q", config, err, entry)
+		}
+		if _, err := runCmdIn(t, config, "run", "--no-sandbox", "--", "echo"); err != nil {
+			t.Errorf("%s with --no-sandbox: %v", config, err)
+		}
+	}
+	for _, call := range *calls {
+		if len(call.args) > 0 && call.args[0] == "-p" {
+			t.Errorf("calls = %v, want only unsandboxed runs", *calls)
+		}
+	}
+
+	// A commands entry can put the sandbox back for its command
+	if _, err := runCmdIn(t, `{"isolation":"none","commands":{"echo":{"isolation":"process"}}}`, "run", "--", "echo"); err != nil {
+		t.Errorf("runCmd: %v", err)
+	}
+
+	if _, err := Run(context.Background(), SandboxConfig{Isolation: isolationNone}, []string{"true"}, RunOptions{}); err == nil {
+		t.Error("Run honored isolation none without RunOptions.NoSandbox")
+	}
+}
+
+func TestConfigAuditNeedsFlag(t *testing.T) {
+	config := `{"name":"project","enforcement":"audit"}`
+	calls := stubExecCommand(t, "exit 0")
+
+	if _, err := runCmdIn(t, config, "run", "--", "echo"); err != nil {
+		t.Fatalf("runCmd: %v", err)
+	}
+	if len(*calls) == 0 || (*calls)[0].args[0] != "-p" {
+		t.Fatalf("calls = %v, want the child under sandbox-exec", *calls)
+	}
+	if profile := (*calls)[0].args[1]; strings.Contains(profile, "(allow default)") {
+		t.Errorf("config's enforcement audit applied without --audit:\n%s", profile)
+	}
+
+	*calls = nil
+	if _, err := runCmdIn(t, config, "run", "--audit", "--", "echo"); err != nil {
+		t.Fatalf("runCmd --audit: %v", err)
+	}
+	if len(*calls) == 0 || !strings.Contains((*calls)[0].args[1], "(allow default)") {
+		t.Errorf("calls = %v, want the audit profile with --audit", *calls)
+	}
+}
+
+func TestLoadRunConfigInvalid(t *testing.T) {
+	t.Chdir(t.TempDir())
+	os.WriteFile(".ddash.json", []byte(`{"name":"test","allow_net":[`), 0644)
+
+	if _, err := loadRunConfig(); err == nil || !strings.Contains(err.Error(), ".ddash.json") {
+		t.Errorf("loadRunConfig = %v, want an error naming .ddash.json", err)
+	}
+	if _, err := runCmdIn(t, `{"allow_read": "~"}`, "run", "--", "echo"); err == nil {
+		t.Error("runCmd ran under the defaults with an unparsable .ddash.json")
+	}
+}
+
 func TestLoadRunConfigFromFile(t *testing.T) {
 	origDir, _ := os.Getwd()
 	tmpDir, _ := os.MkdirTemp("", "ddash-test-*")
@@ -196,9 +516,12 @@ func TestLoadRunConfigFromFile(t *testing.T) {
 	config := `{"name":"test","allow_net":["*"],"allow_read":[".","./data"],"allow_write":["./output"]}`
 	os.WriteFile(".ddash.json", []byte(config), 0644)
 
-	cfg := loadRunConfig()
+	cfg, err := loadRunConfig()
+	if err != nil {
+		t.Fatalf("loadRunConfig: %v", err)
+	}
 
-	if len(cfg.AllowNet) != 1 || cfg.AllowNet[0] != "*" {
+	if len(cfg.AllowNet) != 1 || cfg.AllowNet[0].Host != "*" {
 		t.Errorf("expected AllowNet=[*], got %v", cfg.AllowNet)
 	}
 	if len(cfg.AllowRead) != 2 {
@@ -252,4 +575,1140 @@ func TestResolvePath(t *testing.T) {
 			t.Errorf("resolvePath(%q, %q) = %q, want %q", tt.input, cwd, result, tt.expected)
 		}
 	}
+
+	t.Setenv("HOME", "/Users/mark")
+	if got := resolvePath("~/.cache", cwd); got != "/Users/mark/.cache" {
+		t.Errorf("resolvePath(~/.cache) = %q, want /Users/mark/.cache", got)
+	}
+	if got := resolvePath("~", cwd); got != "/Users/mark" {
+		t.Errorf("resolvePath(~) = %q, want /Users/mark", got)
+	}
+}
+
+func TestSensitiveReadGrants(t *testing.T) {
+	home := "/Users/mark"
+	t.Setenv("HOME", home)
+	cwd := home + "/project"
+
+	tests := []struct {
+		name  string
+		read  []PathEntry
+		count int
+	}{
+		{"project only", pathEntries(".", "/usr/local"), 0},
+		{"whole home", pathEntries(home), len(sensitiveHomeDirs)},
+		{"tilde home", pathEntries("~"), len(sensitiveHomeDirs)},
+		{"aws config", pathEntries("~/.aws/config"), 1},
+		{"ssh dir", pathEntries("/Users/mark/.ssh"), 1},
+		{"non-recursive home", []PathEntry{{Path: home, NonRecursive: true}}, 0},
+		{"non-recursive inside ssh", []PathEntry{{Path: "~/.ssh", NonRecursive: true}}, 1},
+		{"sibling prefix", pathEntries("~/.sshfoo"), 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := sensitiveReadGrants(SandboxConfig{AllowRead: tt.read}, cwd, home)
+			if len(got) != tt.count {
+				t.Errorf("sensitiveReadGrants = %d entries %v, want %d", len(got), got, tt.count)
+			}
+		})
+	}
+}
+
+func TestSensitiveReadGrantsSymlink(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	os.Mkdir(filepath.Join(home, ".ssh"), 0700)
+	cwd := filepath.Join(home, "project")
+	os.Mkdir(cwd, 0755)
+	// A committed link that looks harmless but points at the keys
+	if err := os.Symlink(filepath.Join(home, ".ssh"), filepath.Join(cwd, "docs")); err != nil {
+		t.Fatal(err)
+	}
+
+	got := sensitiveReadGrants(SandboxConfig{AllowRead: pathEntries("docs")}, cwd, home)
+	if len(got) != 1 || !strings.Contains(got[0], "exposes ~/.ssh") || !strings.Contains(got[0], "symlink") {
+		t.Errorf("sensitiveReadGrants = %v, want ~/.ssh exposed through the symlink", got)
+	}
+
+	// Patterns are checked by what they match
+	for _, pattern := range []string{"~/.ss?", "~/*", "~/.[s]sh", "d?cs"} {
+		got := sensitiveReadGrants(SandboxConfig{AllowRead: pathEntries(pattern)}, cwd, home)
+		if len(got) != 1 || !strings.Contains(got[0], "exposes ~/.ssh") {
+			t.Errorf("sensitiveReadGrants(%q) = %v, want ~/.ssh exposed", pattern, got)
+		}
+	}
+
+	report := assessPosture(SandboxConfig{AllowRead: pathEntries("docs"), AllowWrite: []string{}, AllowNet: []NetEntry{}}, cwd, home)
+	found := false
+	for _, f := range report.Findings {
+		found = found || f.Area == "reads" && f.Penalty > 0
+	}
+	if !found {
+		t.Errorf("report should penalize the symlinked credential dir: %+v", report.Findings)
+	}
+}
+
+func TestCheckRunPolicyChecksumDrift(t *testing.T) {
+	cfg := SandboxConfig{AllowWrite: []string{"."}}
+	cfg.Checksum = computeChecksum(cfg)
+
+	var out strings.Builder
+	if err := checkRunPolicy(&out, cfg, cfg, "/project", "", false, ""); err != nil || out.Len() != 0 {
+		t.Errorf("a sealed config: err = %v, output %q", err, out.String())
+	}
+
+	cfg.AllowWrite = []string{"/"}
+	if err := checkRunPolicy(&out, cfg, cfg, "/project", "", false, ""); err != nil || !strings.Contains(out.String(), "modified since its checksum") {
+		t.Errorf("an edited config: err = %v, output %q", err, out.String())
+	}
+}
+
+func TestRedactSecrets(t *testing.T) {
+	os.Setenv("DDASH_TEST_API_KEY", "planted-secret-value")
+	os.Setenv("DDASH_TEST_SHORT_TOKEN", "1")
+	os.Setenv("DDASH_TEST_PLAIN", "not-a-secret")
+	defer os.Unsetenv("DDASH_TEST_API_KEY")
+	defer os.Unsetenv("DDASH_TEST_SHORT_TOKEN")
+	defer os.Unsetenv("DDASH_TEST_PLAIN")
+
+	got := redactSecrets("curl -H 'Authorization: planted-secret-value' --retry 1 not-a-secret")
+
+	if strings.Contains(got, "planted-secret-value") {
+		t.Errorf("secret value should be redacted, got: %s", got)
+	}
+	if !strings.Contains(got, "***") {
+		t.Errorf("expected *** placeholder, got: %s", got)
+	}
+	if !strings.Contains(got, "--retry 1") {
+		t.Errorf("short values should not be redacted, got: %s", got)
+	}
+	if !strings.Contains(got, "not-a-secret") {
+		t.Errorf("non-sensitive values should not be redacted, got: %s", got)
+	}
+}
+
+func TestRedactedEnvPassesValues(t *testing.T) {
+	os.Setenv("DDASH_TEST_SECRET_KEY", "passed_through")
+	defer os.Unsetenv("DDASH_TEST_SECRET_KEY")
+
+	found := false
+	for _, e := range redactedEnv() {
+		if e == "DDASH_TEST_SECRET_KEY=passed_through" {
+			found = true
+		}
+	}
+	if !found {
+		t.Error("redactedEnv should pass sensitive values to the child")
+	}
+}
+
+func TestLoadRunConfigIsolationNone(t *testing.T) {
+	origDir, _ := os.Getwd()
+	tmpDir, _ := os.MkdirTemp("", "ddash-test-*")
+	defer os.RemoveAll(tmpDir)
+	os.Chdir(tmpDir)
+	defer os.Chdir(origDir)
+
+	os.WriteFile(".ddash.json", []byte(`{"name":"test","isolation":"none"}`), 0644)
+
+	cfg, err := loadRunConfig()
+	if err != nil {
+		t.Fatalf("loadRunConfig: %v", err)
+	}
+	if cfg.Isolation != isolationNone {
+		t.Errorf("expected isolation %q, got %q", isolationNone, cfg.Isolation)
+	}
+}
+
+func TestUnsandboxedNetStatus(t *testing.T) {
+	if got := unsandboxedNetStatus(false); got != "unrestricted" {
+		t.Errorf("expected unrestricted, got %q", got)
+	}
+	if got := unsandboxedNetStatus(true); !strings.Contains(got, "not enforced") {
+		t.Errorf("expected proxy status to note it is not enforced, got %q", got)
+	}
+}
+
+func TestExpandPathsGlob(t *testing.T) {
+	cwd := t.TempDir()
+	for _, dir := range []string{"vendor/libfoo/include", "vendor/libbar/include", "vendor/libbaz/src"} {
+		os.MkdirAll(cwd+"/"+dir, 0755)
+	}
+
+	got := expandPaths([]string{".", "vendor/*/include", "missing/*/dir"}, cwd)
+
+	want := []string{
+		cwd,
+		cwd + "/vendor/libbar/include",
+		cwd + "/vendor/libfoo/include",
+	}
+	if len(got) != len(want) {
+		t.Fatalf("expandPaths = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("expandPaths[%d] = %q, want %q", i, got[i], want[i])
+		}
+	}
+}
+
+func TestExpandPathsEnv(t *testing.T) {
+	cwd := "/Users/mark/project"
+	t.Setenv("DDASH_TEST_BUILD_DIR", "/tmp/build")
+	t.Setenv("HOME", "/Users/mark")
+	os.Unsetenv("DDASH_TEST_UNSET")
+	t.Setenv("DDASH_TEST_EMPTY", "")
+
+	tests := []struct {
+		input string
+		want  []string
+	}{
+		{"$DDASH_TEST_BUILD_DIR/out", []string{"/tmp/build/out"}},
+		{"${DDASH_TEST_BUILD_DIR}/cache", []string{"/tmp/build/cache"}},
+		{"$HOME/.cache", []string{"/Users/mark/.cache"}},
+		// An unset variable must not widen "$X/out" to "/out"
+		{"$DDASH_TEST_UNSET/out", nil},
+		{"$DDASH_TEST_EMPTY/out", nil},
+		{"price$$list", []string{cwd + "/price$list"}},
+		{"$$DDASH_TEST_BUILD_DIR", []string{cwd + "/$DDASH_TEST_BUILD_DIR"}},
+	}
+
+	for _, tt := range tests {
+		got := expandPaths([]string{tt.input}, cwd)
+		if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("expandPaths(%q) = %v, want %v", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestGenerateProfileGlob(t *testing.T) {
+	origDir, _ := os.Getwd()
+	tmpDir := t.TempDir()
+	os.MkdirAll(tmpDir+"/vendor/libfoo/include", 0755)
+	os.Chdir(tmpDir)
+	defer os.Chdir(origDir)
+
+	cwd, _ := os.Getwd()
+	cfg := SandboxConfig{
+		AllowRead:  pathEntries("vendor/*/include"),
+		AllowWrite: []string{"."},
+	}
+
+	profile := GenerateProfile(cfg, false, false)
+
+	rule := `(allow file-read* (subpath "` + cwd + `/vendor/libfoo/include"))`
+	if !strings.Contains(profile, rule) {
+		t.Errorf("profile missing expanded glob rule %s", rule)
+	}
+	if strings.Contains(profile, "*/include") {
+		t.Error("profile should not contain the raw glob pattern")
+	}
+}
+
+func TestGenerateProfileHostileFileName(t *testing.T) {
+	hostile := `x")) (allow default) (allow file-read* (literal "`
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "vendor", hostile), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Chdir(dir)
+
+	for _, cfg := range []SandboxConfig{
+		{AllowRead: pathEntries("vendor/*")},
+		{AllowRead: []PathEntry{{Path: "vendor", NonRecursive: true}}},
+		{AllowWrite: []string{"vendor/*"}},
+	} {
+		profile := GenerateProfile(cfg, false, false)
+		want := sbplString(filepath.Join(dir, "vendor", hostile))
+		if !strings.Contains(profile, want) {
+			t.Errorf("profile doesn't grant %s:\n%s", want, profile)
+		}
+		if strings.Contains(sbplCode(profile), "allow default") {
+			t.Errorf("a file name added rules to the profile:\n%s", profile)
+		}
+	}
+}
+
+// sbplCode returns profile with the contents of its string literals
+// removed, leaving only what sandbox-exec reads as code.
+func sbplCode(profile string) string {
+	var sb strings.Builder
+	inString := false
+	for i := 0; i < len(profile); i++ {
+		c := profile[i]
+		switch {
+		case inString && c == '\\':
+			i++
+		case c == '"':
+			inString = !inString
+			sb.WriteByte(c)
+		case !inString:
+			sb.WriteByte(c)
+		}
+	}
+	return sb.String()
+}
+
+func TestMergeConfigs(t *testing.T) {
+	base := SandboxConfig{
+		Name:           "base",
+		Isolation:      "process",
+		AllowNet:       netEntries("registry.npmjs.org"),
+		AllowRead:      pathEntries("."),
+		AllowWrite:     []string{"."},
+		NetworkDomains: map[string]string{"a.com": "always", "b.com": "never"},
+	}
+	over := SandboxConfig{
+		Name:           "prod",
+		AllowNet:       netEntries("api.prod.example.com", "registry.npmjs.org"),
+		AllowWrite:     []string{"./dist"},
+		NetworkDomains: map[string]string{"b.com": "always"},
+	}
+
+	merged := mergeConfigs(base, over)
+
+	if merged.Name != "prod" {
+		t.Errorf("expected scalar from overlay to win, got name %q", merged.Name)
+	}
+	if merged.Isolation != "process" {
+		t.Errorf("expected unset overlay scalar to keep base value, got %q", merged.Isolation)
+	}
+	if len(merged.AllowNet) != 2 || merged.AllowNet[0].Host != "registry.npmjs.org" || merged.AllowNet[1].Host != "api.prod.example.com" {
+		t.Errorf("expected lists appended and deduped, got %v", merged.AllowNet)
+	}
+	if len(merged.AllowWrite) != 2 {
+		t.Errorf("expected both write paths, got %v", merged.AllowWrite)
+	}
+	if merged.NetworkDomains["a.com"] != "always" || merged.NetworkDomains["b.com"] != "always" {
+		t.Errorf("expected overlay domain decisions to win, got %v", merged.NetworkDomains)
+	}
+	if base.NetworkDomains["b.com"] != "never" {
+		t.Error("mergeConfigs should not mutate the base config")
+	}
+}
+
+func TestLoadRunConfigsStacked(t *testing.T) {
+	dir := t.TempDir()
+	basePath := dir + "/base.ddash.json"
+	prodPath := dir + "/prod.ddash.json"
+	os.WriteFile(basePath, []byte(`{"name":"base","allow_net":[],"allow_read":[".","./shared"],"allow_write":["."]}`), 0644)
+	os.WriteFile(prodPath, []byte(`{"name":"prod","allow_read":["./prod-data"]}`), 0644)
+
+	cfg, err := loadRunConfigs([]string{basePath, prodPath})
+	if err != nil {
+		t.Fatalf("loadRunConfigs failed: %v", err)
+	}
+
+	if cfg.Name != "prod" {
+		t.Errorf("expected name from second config, got %q", cfg.Name)
+	}
+	hasShared, hasProd := false, false
+	for _, p := range cfg.AllowRead {
+		hasShared = hasShared || p.Path == "./shared"
+		hasProd = hasProd || p.Path == "./prod-data"
+	}
+	if !hasShared || !hasProd {
+		t.Errorf("expected read entries from both configs, got %v", cfg.AllowRead)
+	}
+
+	if _, err := loadRunConfigs([]string{dir + "/missing.json"}); err == nil {
+		t.Error("expected error for a missing --config file")
+	}
+}
+
+func TestWritePreflight(t *testing.T) {
+	cfg := SandboxConfig{
+		AllowNet:   []NetEntry{},
+		AllowRead:  pathEntries(".", "/opt/data"),
+		AllowWrite: []string{"./out"},
+	}
+	profile := GenerateProfile(cfg, false, false)
+
+	var buf strings.Builder
+	writePreflight(&buf, profile, cfg, 3, "", false)
+	out := buf.String()
+
+	for _, want := range []string{"network:  denied", "writes:   allowed", "3 var(s) scrubbed", "/opt/data", "/out", "proxy:    inactive"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("preflight missing %q:\n%s", want, out)
+		}
+	}
+
+	buf.Reset()
+	writePreflight(&buf, GenerateProfile(cfg, false, true), cfg, 0, "127.0.0.1:4242", false)
+	out = buf.String()
+	if !strings.Contains(out, "network:  interactive") || !strings.Contains(out, "active on 127.0.0.1:4242") {
+		t.Errorf("preflight should report the active proxy:\n%s", out)
+	}
+
+	buf.Reset()
+	writePreflight(&buf, GenerateProfile(cfg, false, true), cfg, 0, "127.0.0.1:4242", true)
+	if out = buf.String(); !strings.Contains(out, "network:  monitor (every domain allowed unasked") {
+		t.Errorf("preflight should report monitor mode:\n%s", out)
+	}
+}
+
+func TestConfinementViolations(t *testing.T) {
+	cwd := "/work/project"
+	cfg := SandboxConfig{
+		AllowRead:  pathEntries(".", "./data", "/work/project/vendor/*/include", "/", "../other"),
+		AllowWrite: []string{"./out", "./../../etc", "/Users/mark"},
+	}
+
+	violations := confinementViolations(cfg, cwd, "/work/project")
+
+	if len(violations) != 4 {
+		t.Fatalf("expected 4 violations, got %d: %v", len(violations), violations)
+	}
+	joined := strings.Join(violations, "\n")
+	for _, want := range []string{`allow_read[3] = "/"`, `allow_read[4] = "../other"`, `allow_write[1] = "./../../etc"`, `allow_write[2] = "/Users/mark"`} {
+		if !strings.Contains(joined, want) {
+			t.Errorf("missing violation %s in:\n%s", want, joined)
+		}
+	}
+
+	if v := confinementViolations(cfg, cwd, "/"); len(v) != 0 {
+		t.Errorf("nothing should escape /, got %v", v)
+	}
+}
+
+func TestIsWithin(t *testing.T) {
+	tests := []struct {
+		path, root string
+		want       bool
+	}{
+		{"/work/project", "/work/project", true},
+		{"/work/project/a/b", "/work/project", true},
+		{"/work/project-evil", "/work/project", false},
+		{"/work", "/work/project", false},
+		{"/work/project/..data", "/work/project", true},
+	}
+	for _, tt := range tests {
+		if got := isWithin(tt.path, tt.root); got != tt.want {
+			t.Errorf("isWithin(%q, %q) = %v, want %v", tt.path, tt.root, got, tt.want)
+		}
+	}
+}
+
+func TestParseRunArgs(t *testing.T) {
+	flags, command, err := parseRunArgs([]string{
+		"--net", "-v", "--config", "a.json", "--config", "b.json",
+		"--confine-to", "/ws", "--", "make", "--allow-net", "--save",
+	})
+	if err != nil {
+		t.Fatalf("parseRunArgs: %v", err)
+	}
+	if !flags.interactiveNet || !flags.verbose || flags.allowNet {
+		t.Errorf("unexpected flags: %+v", flags)
+	}
+	if strings.Join(flags.configs, ",") != "a.json,b.json" {
+		t.Errorf("configs = %v", flags.configs)
+	}
+	if flags.confineTo != "/ws" {
+		t.Errorf("confineTo = %q", flags.confineTo)
+	}
+	want := []string{"make", "--allow-net", "--save"}
+	if strings.Join(command, " ") != strings.Join(want, " ") {
+		t.Errorf("command = %v, want %v", command, want)
+	}
+}
+
+func TestParseRunArgsErrors(t *testing.T) {
+	if _, _, err := parseRunArgs([]string{"ls"}); err == nil || !strings.Contains(err.Error(), "unknown flag: ls") {
+		t.Errorf("bare command: err = %v", err)
+	}
+	if _, _, err := parseRunArgs([]string{"--bogus", "--", "ls"}); err == nil {
+		t.Error("expected error for undefined flag")
+	}
+	if _, _, err := parseRunArgs([]string{"--config"}); err == nil {
+		t.Error("expected error for --config without a value")
+	}
+	if _, _, err := parseRunArgs([]string{"-h"}); err != flag.ErrHelp {
+		t.Errorf("-h: err = %v, want flag.ErrHelp", err)
+	}
+	_, command, err := parseRunArgs([]string{"--net", "--"})
+	if err != nil || command != nil {
+		t.Errorf("empty command: command = %v, err = %v", command, err)
+	}
+}
+
+func TestSandboxExecDiagnostics(t *testing.T) {
+	out := "sandbox-exec: unbound variable: allow-all\nsome program output\n  sandbox-exec: second\n"
+	got := sandboxExecDiagnostics(out)
+	want := []string{"sandbox-exec: unbound variable: allow-all", "sandbox-exec: second"}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if diags := sandboxExecDiagnostics("plain output\n"); diags != nil {
+		t.Errorf("expected no diagnostics, got %q", diags)
+	}
+}
+
+func TestFilterSandboxExecStderr(t *testing.T) {
+	notice := "sandbox-exec: The sandbox-exec command is deprecated and may be removed in a future release.\n"
+	tests := []struct {
+		name, in, want string
+	}{
+		{"notice only", notice, ""},
+		{"notice then output", notice + "npm WARN deprecated glob@7\nprogress 50%\r", "npm WARN deprecated glob@7\nprogress 50%\r"},
+		{"real errors kept", notice + "sandbox-exec: unbound variable: allow-all\n", "sandbox-exec: unbound variable: allow-all\n"},
+		{"no notice", "hello\n", "hello\n"},
+		// Only sandbox-exec's leading notice goes; the child's output is
+		// never rewritten
+		{"notice-like output later", "hello\n" + notice, "hello\n" + notice},
+		{"no trailing newline", "partial", "partial"},
+		{"notice without newline", "sandbox-exec: deprecated", "sandbox-exec: deprecated"},
+	}
+	for _, tt := range tests {
+		var out strings.Builder
+		deprecated, err := filterSandboxExecStderr(strings.NewReader(tt.in), &out)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		}
+		if want := strings.HasPrefix(tt.in, notice); deprecated != want {
+			t.Errorf("%s: deprecated = %v, want %v", tt.name, deprecated, want)
+		}
+		if out.String() != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, out.String(), tt.want)
+		}
+	}
+}
+
+func TestFilterSandboxExecStderrUnbuffered(t *testing.T) {
+	pr, pw := io.Pipe()
+	out := make(chan string, 1)
+	rw := &chanWriter{c: out}
+	done := make(chan error, 1)
+	go func() {
+		_, err := filterSandboxExecStderr(pr, rw)
+		done <- err
+	}()
+
+	// A prompt without a newline shows before the child writes more
+	pw.Write([]byte("Password: "))
+	select {
+	case got := <-out:
+		if got != "Password: " {
+			t.Errorf("got %q, want the prompt", got)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("prompt held back until a newline")
+	}
+
+	// Past the first line nothing is checked or held back
+	pw.Write([]byte("\rsandbox-exec: deprecated\n"))
+	if got := <-out; got != "\rsandbox-exec: deprecated\n" {
+		t.Errorf("got %q, want later output untouched", got)
+	}
+	pw.Close()
+	if err := <-done; err != nil {
+		t.Error(err)
+	}
+}
+
+// chanWriter sends each write to c.
+type chanWriter struct{ c chan string }
+
+func (w *chanWriter) Write(p []byte) (int, error) {
+	w.c <- string(p)
+	return len(p), nil
+}
+
+func TestStderrFilterLeavesTerminalAlone(t *testing.T) {
+	tty, err := os.OpenFile("/dev/null", os.O_WRONLY, 0)
+	if err != nil {
+		t.Skip(err)
+	}
+	defer tty.Close()
+	if w, _ := stderrFilter(tty); w != io.Writer(tty) {
+		t.Error("stderrFilter wrapped a character device")
+	}
+	var buf bytes.Buffer
+	if w, flush := stderrFilter(&buf); w == io.Writer(&buf) {
+		t.Error("stderrFilter left a plain writer unfiltered")
+	} else {
+		flush()
+	}
+}
+
+func TestRunFiltersDeprecationNotice(t *testing.T) {
+	dir := t.TempDir()
+	stub := filepath.Join(dir, "sandbox-exec")
+	script := "#!/bin/sh\necho 'sandbox-exec: this command is deprecated' >&2\nshift 2\nexec \"$@\"\n"
+	if err := os.WriteFile(stub, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := checkProfile(io.Discard, stub, "(version 1)(allow default)"); err != nil {
+		t.Errorf("checkProfile: %v; the notice alone is no rejection", err)
+	}
+
+	var stderr bytes.Buffer
+	cfg := SandboxConfig{Isolation: isolationProcess, AllowWrite: []string{"."}}
+	argv := []string{"sh", "-c", "echo real error >&2"}
+	if _, err := Run(context.Background(), cfg, argv, RunOptions{SandboxExec: stub, Stderr: &stderr}); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	// ddash's banner goes to RunOptions.Stderr too, not to os.Stderr
+	got := stderr.String()
+	if !strings.Contains(got, "ddash: sandboxing sh") || !strings.Contains(got, "\nreal error\n") {
+		t.Errorf("stderr = %q, want the banner and the command's output", got)
+	}
+	if strings.Contains(got, "sandbox-exec:") {
+		t.Errorf("stderr = %q, want the notice filtered", got)
+	}
+}
+
+func TestExitStatus(t *testing.T) {
+	for code, want := range map[int]int{0: 0, 2: 2, 130: 130, -1: 1} {
+		if got := exitStatus(code); got != want {
+			t.Errorf("exitStatus(%d) = %d, want %d", code, got, want)
+		}
+	}
+}
+
+// execCall is one command built through execCommand.
+type execCall struct {
+	name string
+	args []string
+	cmd  *exec.Cmd
+}
+
+// stubExecCommand makes execCommand run script under sh in place of every
+// command for the rest of the test, and returns the calls as they are made.
+// sandbox-exec is pointed at /bin/sh so it is found on any OS.
+func stubExecCommand(t *testing.T, script string) *[]execCall {
+	t.Helper()
+	t.Setenv(sandboxExecEnv, "/bin/sh")
+	var calls []execCall
+	orig := execCommand
+	t.Cleanup(func() { execCommand = orig })
+	execCommand = func(ctx context.Context, name string, args ...string) *exec.Cmd {
+		cmd := exec.CommandContext(ctx, "sh", "-c", script)
+		calls = append(calls, execCall{name, args, cmd})
+		return cmd
+	}
+	return &calls
+}
+
+// runCmdIn runs 'ddash' with args in a fresh directory holding config (if
+// not empty) as its .ddash.json, and returns that directory.
+func runCmdIn(t *testing.T, config string, args ...string) (string, error) {
+	t.Helper()
+	origDir, _ := os.Getwd()
+	dir, _ := filepath.EvalSymlinks(t.TempDir())
+	os.Chdir(dir)
+	t.Cleanup(func() { os.Chdir(origDir) })
+	if config != "" {
+		os.WriteFile(".ddash.json", []byte(config), 0644)
+	}
+	origArgs := os.Args
+	os.Args = append([]string{"ddash"}, args...)
+	t.Cleanup(func() { os.Args = origArgs })
+	return dir, runCmd()
+}
+
+func TestRunCmdBuildsSandboxedCommand(t *testing.T) {
+	t.Setenv("DDASH_TEST_SECRET_TOKEN", "should_be_scrubbed")
+	calls := stubExecCommand(t, "exit 0")
+
+	dir, err := runCmdIn(t, `{"name":"t","allow_write":["out"]}`, "run", "--", "echo", "hi")
+	if err != nil {
+		t.Fatalf("runCmd: %v", err)
+	}
+	// The profile is only checked separately once a run fails
+	if len(*calls) != 1 {
+		t.Fatalf("got %d commands, want just the child", len(*calls))
+	}
+	child := (*calls)[0]
+
+	if child.name != "/bin/sh" || len(child.args) != 4 || child.args[0] != "-p" {
+		t.Fatalf("child = %s %q, want sandbox-exec -p <profile> <binary> hi", child.name, child.args)
+	}
+	profile, binary := child.args[1], child.args[2]
+	if !strings.Contains(profile, `(allow file-write* (subpath "`+filepath.Join(dir, "out")+`"))`) {
+		t.Errorf("profile doesn't grant the configured write path:\n%s", profile)
+	}
+	if strings.Contains(profile, "(allow network") {
+		t.Errorf("profile should leave the network denied by default:\n%s", profile)
+	}
+	if filepath.Base(binary) != "echo" || child.args[3] != "hi" {
+		t.Errorf("child argv = %q, want the resolved echo and its argument", child.args[2:])
+	}
+
+	for _, kv := range child.cmd.Env {
+		if strings.HasPrefix(kv, "DDASH_TEST_SECRET_TOKEN=") {
+			t.Error("secret env var reached the child")
+		}
+		if strings.HasPrefix(kv, "HTTPS_PROXY=") {
+			t.Error("proxy set without --net")
+		}
+	}
+}
+
+func TestRunCmdWiresProxy(t *testing.T) {
+	calls := stubExecCommand(t, "exit 0")
+
+	if _, err := runCmdIn(t, "", "run", "--no-config", "--net", "--", "echo"); err != nil {
+		t.Fatalf("runCmd: %v", err)
+	}
+	child := (*calls)[len(*calls)-1]
+	if !strings.Contains(child.args[1], `(allow network* (remote ip "localhost:*"))`) {
+		t.Errorf("--net profile should only reach the local proxy:\n%s", child.args[1])
+	}
+	var proxy string
+	for _, kv := range child.cmd.Env {
+		if v, ok := strings.CutPrefix(kv, "HTTPS_PROXY="); ok {
+			proxy = v
+		}
+	}
+	if !strings.HasPrefix(proxy, "http://127.0.0.1:") {
+		t.Errorf("HTTPS_PROXY = %q, want the local proxy", proxy)
+	}
+}
+
+func TestRunCmdKeepProfile(t *testing.T) {
+	stubExecCommand(t, "exit 0")
+
+	dir, err := runCmdIn(t, "", "run", "--no-config", "--keep-profile", "--", "echo")
+	if err != nil {
+		t.Fatalf("runCmd: %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, keptProfilePath))
+	if err != nil {
+		t.Fatalf("profile not kept after the run: %v", err)
+	}
+	if !strings.Contains(string(data), "(deny default)") {
+		t.Errorf("kept profile is not the generated one:\n%s", data)
+	}
+}
+
+func TestRunCmdProfileRejected(t *testing.T) {
+	calls := stubExecCommand(t, "echo 'sandbox-exec: unbound variable: allow-all' >&2; exit 1")
+
+	_, err := runCmdIn(t, "", "run", "--no-config", "--", "echo")
+	if err == nil || !strings.Contains(err.Error(), "rejected the profile") {
+		t.Errorf("err = %v, want the profile rejected", err)
+	}
+	if len(*calls) != 2 || (*calls)[1].args[len((*calls)[1].args)-1] != "/usr/bin/true" {
+		t.Errorf("got %d commands, want the failed run and then the profile check", len(*calls))
+	}
+}
+
+func TestStaticProfilePreludeIsPrefix(t *testing.T) {
+	profile := GenerateProfile(SandboxConfig{AllowWrite: []string{"."}}, false, false)
+	if !strings.HasPrefix(profile, staticProfilePrelude()) {
+		t.Error("profile does not start with the static prelude")
+	}
+	if staticProfilePrelude() != buildStaticProfilePrelude() {
+		t.Error("cached prelude differs from a fresh build")
+	}
+}
+
+func TestExplainProfile(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(dir)
+	cfg := SandboxConfig{
+		AllowRead:     pathEntries(".", "/opt/data"),
+		AllowWrite:    []string{"out"},
+		DenyWriteExts: []string{".sh"},
+		AllowNet:      netEntries("*"),
+	}
+
+	for _, proxyMode := range []bool{false, true} {
+		explained := ExplainProfile(cfg, false, proxyMode)
+		var stripped []string
+		for _, line := range strings.Split(explained, "\n") {
+			rule, origin, found := strings.Cut(line, "  ; ")
+			if strings.HasPrefix(line, "(") && line != "(version 1)" && (!found || origin == "") {
+				t.Errorf("rule without an origin: %s", line)
+			}
+			stripped = append(stripped, rule)
+		}
+		if got := strings.Join(stripped, "\n"); got != GenerateProfile(cfg, false, proxyMode) {
+			t.Errorf("explained profile (proxy mode %v) has different rules:\n%s", proxyMode, got)
+		}
+	}
+
+	explained := ExplainProfile(cfg, false, false)
+	for _, want := range []string{
+		`(subpath "/opt/data"))  ; from allow_read[1] = "/opt/data"`,
+		`(subpath "` + filepath.Join(dir, "out") + `"))  ; from allow_write[0] = "out"`,
+		`  ; from deny_write_exts[0] = ".sh"`,
+		`(allow file-read* (subpath "/usr"))  ; default system read`,
+		`(allow network*)  ; from allow_net[0] = "*"`,
+	} {
+		if !strings.Contains(explained, want) {
+			t.Errorf("explained profile missing %q", want)
+		}
+	}
+	if !strings.Contains(ExplainProfile(cfg, false, true), `(remote ip "localhost:*"))  ; proxy mode (--net)`) {
+		t.Error("the localhost rule should be attributed to --net")
+	}
+}
+
+func TestExplainProfileSources(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(dir)
+	os.WriteFile("a.json", []byte(`{"allow_read": ["."], "allow_write": ["out"]}`), 0644)
+	os.WriteFile("b.json", []byte(`{"allow_write": ["out", "dist"], "commands": {"npm install": {"allow_write": ["node_modules"]}}}`), 0644)
+
+	cfg, err := loadRunConfigs([]string{"a.json", "b.json"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg = selectCommandProfile(cfg, []string{"npm", "install"})
+	cfg.AllowWrite = append(cfg.AllowWrite, "/tmp/ddash-tmp-1")
+	cfg.setOrigin("allow_write", "/tmp/ddash-tmp-1", "--private-tmp: the run's TMPDIR")
+
+	explained := ExplainProfile(cfg, false, false)
+	for _, want := range []string{
+		`; from allow_read[0] = "." in a.json`,
+		`; from allow_write[0] = "out" in a.json`,
+		`; from allow_write[1] = "dist" in b.json`,
+		`; from commands["npm install"].allow_write[0] = "node_modules" in b.json`,
+		`; from --private-tmp: the run's TMPDIR`,
+	} {
+		if !strings.Contains(explained, want) {
+			t.Errorf("explained profile missing %q:\n%s", want, explained)
+		}
+	}
+}
+
+var benchProfileConfig = SandboxConfig{
+	AllowNet:   []NetEntry{},
+	AllowRead:  pathEntries(".", "/opt/data"),
+	AllowWrite: []string{".", "/tmp/out"},
+}
+
+// BenchmarkGenerateProfile measures repeated generation with the cached
+// prelude; compare with BenchmarkGenerateProfileUncachedPrelude.
+func BenchmarkGenerateProfile(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		GenerateProfile(benchProfileConfig, false, false)
+	}
+}
+
+func BenchmarkGenerateProfileUncachedPrelude(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		buildStaticProfilePrelude()
+		GenerateProfile(benchProfileConfig, false, false)
+	}
+}
+
+func TestScratchDirRemovedAfterRun(t *testing.T) {
+	scratch, cleanup, err := newScratchDir("ddash-ephemeral-*")
+	if err != nil {
+		t.Fatalf("newScratchDir: %v", err)
+	}
+	defer cleanup()
+
+	cfg := SandboxConfig{Isolation: isolationNone, AllowWrite: []string{scratch}}
+	opts := RunOptions{NoSandbox: true, Dir: scratch, Env: []string{"TMPDIR=" + scratch}}
+	res, err := Run(context.Background(), cfg, []string{"sh", "-c", "mkdir sub && echo x > sub/out.txt"}, opts)
+	if err != nil || res.ExitCode != 0 {
+		t.Fatalf("Run: exit %d, err %v", res.ExitCode, err)
+	}
+	if _, err := os.Stat(filepath.Join(scratch, "sub", "out.txt")); err != nil {
+		t.Fatalf("write did not land in scratch dir: %v", err)
+	}
+
+	cleanup()
+	if _, err := os.Stat(scratch); !os.IsNotExist(err) {
+		t.Errorf("scratch dir %s still exists after cleanup", scratch)
+	}
+}
+
+func TestGenerateProfileTmpWrite(t *testing.T) {
+	cfg := SandboxConfig{AllowWrite: []string{"/repo/tmp"}}
+	if !strings.Contains(GenerateProfile(cfg, false, false), `(allow file-write* (subpath "/private/tmp"))`) {
+		t.Error("tmp writes should be allowed when tmp_write is unset")
+	}
+
+	off := false
+	cfg.TmpWrite = &off
+	profile := GenerateProfile(cfg, false, false)
+	if strings.Contains(profile, `(allow file-write* (subpath "/private/tmp"))`) ||
+		strings.Contains(profile, `(allow file-write* (subpath "/dev"))`) {
+		t.Error("tmp_write: false should drop the /private/tmp and /dev write grant")
+	}
+	if !strings.Contains(profile, `(allow file-write* (subpath "/repo/tmp"))`) {
+		t.Error("tmp_write: false should keep allow_write entries")
+	}
+	if writeStatus(profile) != "allowed" {
+		t.Errorf("writeStatus = %q, want allowed for a project write dir", writeStatus(profile))
+	}
+	if got := tmpWriteStatus(cfg); !strings.Contains(got, "denied") {
+		t.Errorf("tmpWriteStatus = %q, want denied", got)
+	}
+}
+
+func TestRunCmdNoDefaultTmp(t *testing.T) {
+	calls := stubExecCommand(t, "exit 0")
+
+	dir, err := runCmdIn(t, `{"name":"t","allow_write":["out"]}`, "run", "--no-default-tmp", "--", "echo")
+	if err != nil {
+		t.Fatalf("runCmd: %v", err)
+	}
+	profile := (*calls)[len(*calls)-1].args[1]
+	if strings.Contains(profile, `(allow file-write* (subpath "/private/tmp"))`) || strings.Contains(profile, `(allow file-write* (subpath "/dev"))`) {
+		t.Errorf("--no-default-tmp should drop the tmp write grant:\n%s", profile)
+	}
+	for _, want := range []string{filepath.Join(dir, "out"), "/dev/null"} {
+		if !strings.Contains(profile, `(allow file-write* (subpath "`+want+`"))`) {
+			t.Errorf("--no-default-tmp should keep the write grant for %s:\n%s", want, profile)
+		}
+	}
+
+	if _, err := runCmdIn(t, "", "run", "--no-default-tmp", "--deny-write", "--", "echo"); err == nil {
+		t.Error("--no-default-tmp with --deny-write should be rejected")
+	}
+}
+
+func TestRunCmdPrivateTmp(t *testing.T) {
+	calls := stubExecCommand(t, "exit 0")
+
+	if _, err := runCmdIn(t, "", "run", "--no-config", "--private-tmp", "--", "echo"); err != nil {
+		t.Fatalf("runCmd: %v", err)
+	}
+	child := (*calls)[len(*calls)-1]
+	var tmpdir string
+	for _, kv := range child.cmd.Env {
+		if v, ok := strings.CutPrefix(kv, "TMPDIR="); ok {
+			tmpdir = v // the last one wins
+		}
+	}
+	if !strings.Contains(filepath.Base(tmpdir), "ddash-tmp-") {
+		t.Fatalf("TMPDIR = %q, want a fresh ddash temp dir", tmpdir)
+	}
+	profile := child.args[1]
+	if !strings.Contains(profile, `(allow file-write* (subpath "`+tmpdir+`"))`) {
+		t.Errorf("profile doesn't grant the private temp dir:\n%s", profile)
+	}
+	if strings.Contains(profile, `(allow file-write* (subpath "/private/tmp"))`) {
+		t.Errorf("--private-tmp should replace the shared /private/tmp grant:\n%s", profile)
+	}
+	if _, err := os.Stat(tmpdir); !os.IsNotExist(err) {
+		t.Errorf("private temp dir %s still exists after the run", tmpdir)
+	}
+
+	if _, err := runCmdIn(t, "", "run", "--private-tmp", "--ephemeral", "--", "echo"); err == nil {
+		t.Error("--private-tmp with --ephemeral should be rejected")
+	}
+}
+
+func TestGenerateProfileAllowDevices(t *testing.T) {
+	off := false
+	cfg := SandboxConfig{AllowWrite: []string{"."}, TmpWrite: &off, AllowDevices: []string{"/dev/ttys003"}}
+	profile := GenerateProfile(cfg, false, false)
+	if !strings.Contains(profile, `(allow file-read* file-write* (literal "/dev/ttys003"))`) {
+		t.Errorf("requested device missing from profile:\n%s", profile)
+	}
+	if strings.Contains(profile, "/dev/ttys004") {
+		t.Error("an unlisted device should not be granted")
+	}
+	if !strings.Contains(profile, `(allow file-write* (subpath "/dev/null"))`) {
+		t.Error("the default /dev/null grant should stay")
+	}
+	// Devices must come after the write rules they widen
+	if strings.Index(profile, "/dev/ttys003") < strings.LastIndex(profile, "(allow file-write*") {
+		t.Error("device rules should follow the write rules")
+	}
+
+	if p := GenerateProfile(cfg, true, false); strings.Contains(p, `(literal "/dev/ttys003")`) {
+		t.Error("--deny-write should not grant devices")
+	}
+	cfg.AllowDevices = []string{"/dev/../etc/passwd"}
+	if p := GenerateProfile(cfg, false, false); strings.Contains(p, "passwd") {
+		t.Error("an invalid device entry should be skipped")
+	}
+}
+
+func TestValidateDevice(t *testing.T) {
+	for _, device := range []string{"/dev/ttys003", "/dev/dri/card0", "/dev/audio"} {
+		if err := validateDevice(device); err != nil {
+			t.Errorf("validateDevice(%q): %v", device, err)
+		}
+	}
+	for _, device := range []string{"", "/dev", "/dev/", "dev/tty", "/dev/../etc/passwd", "/etc/passwd", "/dev/tty*", `/dev/a"b`} {
+		if err := validateDevice(device); err == nil {
+			t.Errorf("validateDevice(%q) should fail", device)
+		}
+	}
+}
+
+func TestGenerateProfileFIFOs(t *testing.T) {
+	dir, _ := filepath.EvalSymlinks(t.TempDir())
+	existing := filepath.Join(dir, "results.fifo")
+	if err := syscall.Mkfifo(existing, 0600); err != nil {
+		t.Fatalf("mkfifo: %v", err)
+	}
+	declared := filepath.Join(dir, "later.fifo")
+
+	cfg := SandboxConfig{AllowWrite: []string{existing}, AllowFIFOs: []string{declared}}
+	profile := GenerateProfile(cfg, false, false)
+	for _, path := range []string{existing, declared} {
+		if !strings.Contains(profile, `(allow file-write-create file-write-data (literal "`+path+`"))`) {
+			t.Errorf("profile should let the command create and write %s:\n%s", path, profile)
+		}
+	}
+	if strings.Contains(profile, `(subpath "`+existing+`")`) {
+		t.Error("a pipe in allow_write should get the narrow rule, not a subpath grant")
+	}
+
+	if p := GenerateProfile(cfg, true, false); strings.Contains(p, "file-write-data") {
+		t.Error("--deny-write should override allow_fifos")
+	}
+	for _, fifo := range []string{"", "out/*.fifo", `a"b`} {
+		if validateFIFO(fifo) == nil {
+			t.Errorf("validateFIFO(%q) should fail", fifo)
+		}
+	}
+}
+
+func TestMergeConfigsTmpWrite(t *testing.T) {
+	off := false
+	merged := mergeConfigs(SandboxConfig{TmpWrite: &off}, SandboxConfig{})
+	if merged.tmpWriteAllowed() {
+		t.Error("an overlay without tmp_write should keep the base's false")
+	}
+}
+
+func TestParseRunArgsChdir(t *testing.T) {
+	flags, command, err := parseRunArgs([]string{"--chdir", "packages/web", "--", "npm", "test"})
+	if err != nil {
+		t.Fatalf("parseRunArgs: %v", err)
+	}
+	if flags.chdir != "packages/web" || len(command) != 2 {
+		t.Errorf("chdir = %q, command = %v", flags.chdir, command)
+	}
+}
+
+func TestProxyDomains(t *testing.T) {
+	cfg := SandboxConfig{
+		AllowNet:       netEntries("*", "https://api.example.com/", "cdn.example.com", "http://plain.example.com:8080", "blocked.example.com"),
+		NetworkDomains: map[string]string{"blocked.example.com": "never"},
+	}
+	domains, httpsOnly := proxyDomains(cfg)
+
+	want := map[string]string{
+		"api.example.com":        "allow",
+		"cdn.example.com":        "allow",
+		"plain.example.com:8080": "allow",
+		"blocked.example.com":    "never",
+	}
+	if len(domains) != len(want) {
+		t.Errorf("domains = %v, want %v", domains, want)
+	}
+	for d, decision := range want {
+		if domains[d] != decision {
+			t.Errorf("domains[%q] = %q, want %q", d, domains[d], decision)
+		}
+	}
+	if len(httpsOnly) != 1 || httpsOnly[0] != "api.example.com" {
+		t.Errorf("httpsOnly = %v, want [api.example.com]", httpsOnly)
+	}
+}
+
+func TestGenerateProfileEnforcement(t *testing.T) {
+	audit := GenerateProfile(SandboxConfig{Enforcement: enforcementAudit, AllowWrite: []string{"."}}, false, false)
+	if !strings.Contains(audit, "(allow default)") || !strings.Contains(audit, "(trace default)") {
+		t.Errorf("audit profile should allow and trace by default:\n%s", audit)
+	}
+	if strings.Contains(audit, "(deny default)") {
+		t.Errorf("audit profile should not deny by default:\n%s", audit)
+	}
+
+	// Anything but "audit" enforces, including typos
+	for _, mode := range []string{"", enforcementEnforce, "Audit", "warn"} {
+		profile := GenerateProfile(SandboxConfig{Enforcement: mode, AllowWrite: []string{"."}}, false, false)
+		if !strings.Contains(profile, "(deny default)") || strings.Contains(profile, "(allow default)") {
+			t.Errorf("enforcement %q: expected a deny-default profile", mode)
+		}
+	}
+}
+
+func TestTeeOutputs(t *testing.T) {
+	dir := t.TempDir()
+	outPath := filepath.Join(dir, "out.log")
+	errPath := filepath.Join(dir, "err.log")
+
+	// Capture what reaches the console
+	consoleOut, err := os.CreateTemp(dir, "console-out")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer consoleOut.Close()
+	stdout := os.Stdout
+	os.Stdout = consoleOut
+	defer func() { os.Stdout = stdout }()
+
+	opts := RunOptions{NoSandbox: true}
+	closeOutputs, err := teeOutputs(&opts, outPath, errPath)
+	if err != nil {
+		t.Fatalf("teeOutputs: %v", err)
+	}
+	script := `for i in 1 2 3; do echo "out $i"; echo "err $i" >&2; done; exit 2`
+	res, err := Run(context.Background(), SandboxConfig{Isolation: isolationNone}, []string{"sh", "-c", script}, opts)
+	closeOutputs()
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if res.ExitCode != 2 {
+		t.Errorf("ExitCode = %d, want 2", res.ExitCode)
+	}
+
+	gotOut, _ := os.ReadFile(outPath)
+	gotErr, _ := os.ReadFile(errPath)
+	if want := "out 1\nout 2\nout 3\n"; string(gotOut) != want {
+		t.Errorf("stdout file = %q, want %q", gotOut, want)
+	}
+	// The file also gets ddash's banner, as the console does
+	if want := "err 1\nerr 2\nerr 3\n"; !strings.HasSuffix(string(gotErr), want) || !strings.Contains(string(gotErr), "ddash: running sh unsandboxed") {
+		t.Errorf("stderr file = %q, want the banner and then %q", gotErr, want)
+	}
+	console, _ := os.ReadFile(consoleOut.Name())
+	if string(console) != string(gotOut) {
+		t.Errorf("console stdout = %q, file = %q", console, gotOut)
+	}
+}
+
+func TestTeeOutputsSameFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "all.log")
+	devNull, _ := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	defer devNull.Close()
+	stdout, stderr := os.Stdout, os.Stderr
+	os.Stdout, os.Stderr = devNull, devNull
+	defer func() { os.Stdout, os.Stderr = stdout, stderr }()
+
+	var opts RunOptions
+	closeOutputs, err := teeOutputs(&opts, path, path)
+	if err != nil {
+		t.Fatalf("teeOutputs: %v", err)
+	}
+	opts.Stdout.Write([]byte("one\n"))
+	opts.Stderr.Write([]byte("two\n"))
+	closeOutputs()
+
+	got, _ := os.ReadFile(path)
+	if string(got) != "one\ntwo\n" {
+		t.Errorf("combined file = %q, want both streams", got)
+	}
+}
+
+func TestPrintBlockedDomains(t *testing.T) {
+	var buf bytes.Buffer
+	printBlockedDomains(&buf, nil)
+	if buf.Len() != 0 {
+		t.Errorf("expected no output without blocked hosts, got %q", buf.String())
+	}
+
+	printBlockedDomains(&buf, []string{"a.example.com", "b.example.com"})
+	want := "ddash: blocked 2 host(s): a.example.com, b.example.com — add to allow_net to permit\n"
+	if buf.String() != want {
+		t.Errorf("got %q, want %q", buf.String(), want)
+	}
 }
@@ -2,10 +2,14 @@ package cmd
 
 import (
 	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 	"time"
 )
@@ -21,22 +25,66 @@ Usage:
 
 Commands:
   init        Create a .ddash.json (use -i for interactive setup)
-  list        Show current sandbox configuration
-  status      Check if a sandbox config exists
+  list        Show current sandbox configuration (--oneline, --format json)
+  status      Summarize the effective policy and any config problems
+  verify      Check the config against its recorded checksum
+  schema      Print a JSON Schema for .ddash.json (for editor validation)
 
 Flags:
   -h, --help  Show help`
 
 // SandboxConfig represents a sandbox configuration file.
 type SandboxConfig struct {
-	Name           string            `json:"name"`
-	Version        string            `json:"version"`
-	CreatedAt      string            `json:"created_at"`
-	Isolation      string            `json:"isolation"`
-	AllowNet       []string          `json:"allow_net"`
-	AllowRead      []string          `json:"allow_read"`
-	AllowWrite     []string          `json:"allow_write"`
-	NetworkDomains map[string]string `json:"network_domains,omitempty"`
+	Name           string                   `json:"name"`
+	Version        string                   `json:"version"`
+	CreatedAt      string                   `json:"created_at"`
+	CreatedBy      string                   `json:"created_by,omitempty"`
+	Hostname       string                   `json:"hostname,omitempty"`
+	Isolation      string                   `json:"isolation"`
+	Enforcement    string                   `json:"enforcement,omitempty"`
+	AllowNet       []NetEntry               `json:"allow_net"`
+	AllowNetFile   string                   `json:"allow_net_file,omitempty"`
+	AllowRead      []PathEntry              `json:"allow_read"`
+	AllowWrite     []string                 `json:"allow_write"`
+	TmpWrite       *bool                    `json:"tmp_write,omitempty"`
+	StripHeaders   []string                 `json:"strip_headers,omitempty"`
+	DenyWriteExts  []string                 `json:"deny_write_exts,omitempty"`
+	AllowDevices   []string                 `json:"allow_devices,omitempty"`
+	AllowFIFOs     []string                 `json:"allow_fifos,omitempty"`
+	BlockedNets    *[]string                `json:"blocked_nets,omitempty"`
+	PinNet         map[string]string        `json:"pin_net,omitempty"`
+	NetRewrite     map[string]string        `json:"net_rewrite,omitempty"`
+	StrictSNI      bool                     `json:"strict_sni,omitempty"`
+	NetMode        string                   `json:"net_mode,omitempty"`
+	PromptOptions  []string                 `json:"prompt_options,omitempty"`
+	Commands       map[string]SandboxConfig `json:"commands,omitempty"`
+	NetworkDomains map[string]string        `json:"network_domains,omitempty"`
+	Checksum       string                   `json:"checksum,omitempty"`
+
+	// origins says where list entries came from, for --explain (see
+	// withOrigins). Not part of the file.
+	origins map[string]string
+}
+
+// auditMode reports whether the config asks for audit enforcement: allow
+// everything and log access instead of blocking. Any value other than
+// "audit" enforces, so a typo never opens the sandbox.
+func (cfg SandboxConfig) auditMode() bool {
+	return cfg.Enforcement == enforcementAudit
+}
+
+// tmpWriteAllowed reports whether the implicit /private/tmp and /dev write
+// grant applies. It does unless the config sets "tmp_write": false.
+func (cfg SandboxConfig) tmpWriteAllowed() bool {
+	return cfg.TmpWrite == nil || *cfg.TmpWrite
+}
+
+// tmpWriteStatus describes the temp write policy for list/status output.
+func tmpWriteStatus(cfg SandboxConfig) string {
+	if cfg.tmpWriteAllowed() {
+		return "allowed (/private/tmp, /dev)"
+	}
+	return "denied (tmp_write: false)"
 }
 
 func sandboxCmd() error {
@@ -52,6 +100,10 @@ func sandboxCmd() error {
 		return sandboxList()
 	case "status":
 		return sandboxStatus()
+	case "verify":
+		return sandboxVerify()
+	case "schema":
+		return sandboxSchema()
 	case "help", "-h", "--help":
 		fmt.Println(sandboxUsage)
 	default:
@@ -86,18 +138,29 @@ Generated config:
 
 Flags:
   -i, --interactive   Walk through policy setup step by step
+  --name <name>       Project name (default: directory name)
   -h, --help          Show help
 
 Examples:
   ddash sandbox init           Create default restrictive config
-  ddash sandbox init -i        Interactive setup with prompts`
+  ddash sandbox init -i        Interactive setup with prompts
+  ddash sandbox init --name api
+                               Set the project name without prompts`
 
 func sandboxInit() error {
 	interactive := false
-	for _, arg := range os.Args[3:] {
-		switch arg {
+	name := ""
+	args := os.Args[3:]
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
 		case "-i", "--interactive":
 			interactive = true
+		case "--name":
+			if i+1 >= len(args) || strings.TrimSpace(args[i+1]) == "" {
+				return fmt.Errorf("--name requires a value")
+			}
+			i++
+			name = strings.TrimSpace(args[i])
 		case "-h", "--help":
 			fmt.Println(initUsage)
 			return nil
@@ -111,37 +174,46 @@ func sandboxInit() error {
 
 	var cfg SandboxConfig
 
+	if name == "" {
+		name = filepath.Base(mustGetwd())
+	}
+
 	if interactive {
-		cfg = interactiveInit()
+		cfg = interactiveInit(name)
 	} else {
 		cfg = SandboxConfig{
-			Name:       filepath.Base(mustGetwd()),
+			Name:       name,
 			Version:    Version,
 			CreatedAt:  time.Now().UTC().Format(time.RFC3339),
-			Isolation:  "process",
-			AllowNet:   []string{},
-			AllowRead:  []string{"."},
+			Isolation:  isolationProcess,
+			AllowNet:   []NetEntry{},
+			AllowRead:  pathEntries("."),
 			AllowWrite: []string{"."},
 		}
 	}
+	cfg = normalizeConfig(cfg)
+	cfg.CreatedBy, cfg.Hostname = creatorMetadata()
+	cfg.Checksum = computeChecksum(cfg)
 
-	data, err := json.MarshalIndent(cfg, "", "  ")
-	if err != nil {
-		return fmt.Errorf("failed to marshal config: %w", err)
-	}
-
-	if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil {
-		return fmt.Errorf("failed to write config: %w", err)
+	if err := writeConfig(path, cfg); err != nil {
+		return err
 	}
 
 	fmt.Printf("Initialized sandbox config at %s\n", path)
 	return nil
 }
 
-func interactiveInit() SandboxConfig {
+// creatorMetadata returns who created a config and on which machine.
+// Either value is empty when it can't be determined.
+func creatorMetadata() (user, host string) {
+	user = os.Getenv("USER")
+	host, _ = os.Hostname()
+	return user, host
+}
+
+func interactiveInit(defaultName string) SandboxConfig {
 	reader := bufio.NewReader(os.Stdin)
 
-	defaultName := filepath.Base(mustGetwd())
 	fmt.Printf("Project name [%s]: ", defaultName)
 	name := readLine(reader)
 	if name == "" {
@@ -200,9 +272,9 @@ func interactiveInit() SandboxConfig {
 		Name:       name,
 		Version:    Version,
 		CreatedAt:  time.Now().UTC().Format(time.RFC3339),
-		Isolation:  "process",
-		AllowNet:   allowNet,
-		AllowRead:  allowRead,
+		Isolation:  isolationProcess,
+		AllowNet:   netEntries(allowNet...),
+		AllowRead:  pathEntries(allowRead...),
 		AllowWrite: allowWrite,
 	}
 }
@@ -217,25 +289,259 @@ func yesNo(reader *bufio.Reader) bool {
 	return answer == "y" || answer == "yes"
 }
 
+// readConfig loads and parses a config file.
+func readConfig(path string) (SandboxConfig, error) {
+	var cfg SandboxConfig
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return cfg, err
+	}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return cfg, fmt.Errorf("failed to parse config: %w", err)
+	}
+	return withOrigins(cfg, path), nil
+}
+
+// writeConfig writes cfg as indented JSON. It does not touch cfg.Checksum;
+// callers that approve the content stamp it with computeChecksum first.
+func writeConfig(path string, cfg SandboxConfig) error {
+	data, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal config: %w", err)
+	}
+
+	if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write config: %w", err)
+	}
+	return nil
+}
+
+// normalizeConfig tidies the allow lists of a generated config before it
+// is saved, so regenerating a policy yields a minimal diff: entries are
+// trimmed and cleaned, duplicates and paths covered by a broader one
+// (./output under ".") are dropped, and the rest is sorted. Hosts next to
+// "*" are kept, since --net still prompts for anything not listed. Empty
+// lists stay empty rather than becoming unset, which would change their
+// meaning.
+func normalizeConfig(cfg SandboxConfig) SandboxConfig {
+	if cfg.AllowNet != nil {
+		hosts := make(map[string]NetEntry)
+		for _, e := range cfg.AllowNet {
+			e.Host = strings.TrimSpace(e.Host)
+			if _, seen := hosts[e.Host]; e.Host != "" && !seen {
+				hosts[e.Host] = e
+			}
+		}
+		cfg.AllowNet = []NetEntry{}
+		for _, host := range sortedKeys(hosts) {
+			cfg.AllowNet = append(cfg.AllowNet, hosts[host])
+		}
+	}
+
+	if cfg.AllowWrite != nil {
+		paths := cleanPaths(cfg.AllowWrite)
+		cfg.AllowWrite = []string{}
+		for _, path := range paths {
+			if !coveredPath(path, paths) {
+				cfg.AllowWrite = append(cfg.AllowWrite, path)
+			}
+		}
+	}
+
+	if cfg.AllowRead != nil {
+		// Only recursive entries cover others, and they win over a
+		// non-recursive entry for the same path
+		recursive := make(map[string]bool)
+		var covering []string
+		for _, e := range cfg.AllowRead {
+			path := cleanPath(e.Path)
+			if path == "" || e.NonRecursive {
+				continue
+			}
+			if !recursive[path] {
+				recursive[path] = true
+				covering = append(covering, path)
+			}
+		}
+		paths := cleanPaths(entryPaths(cfg.AllowRead))
+		cfg.AllowRead = []PathEntry{}
+		for _, path := range paths {
+			if !coveredPath(path, covering) {
+				cfg.AllowRead = append(cfg.AllowRead, PathEntry{Path: path, NonRecursive: !recursive[path]})
+			}
+		}
+	}
+	return cfg
+}
+
+// cleanPaths trims, cleans, dedupes and sorts config paths, dropping
+// empty ones.
+func cleanPaths(paths []string) []string {
+	seen := make(map[string]bool)
+	var cleaned []string
+	for _, path := range paths {
+		path = cleanPath(path)
+		if path == "" {
+			continue
+		}
+		if !seen[path] {
+			seen[path] = true
+			cleaned = append(cleaned, path)
+		}
+	}
+	slices.Sort(cleaned)
+	return cleaned
+}
+
+// cleanPath trims a config path and cleans it, unless it starts with a
+// variable or "~": "$HOME/../shared" means something else once cleaned to
+// "shared", since the prefix is only expanded at run time.
+func cleanPath(path string) string {
+	path = strings.TrimSpace(path)
+	if path == "" || unexpandedPath(path) {
+		return path
+	}
+	return filepath.Clean(path)
+}
+
+// unexpandedPath reports whether path starts with a variable or "~", so
+// what it names is only known at run time.
+func unexpandedPath(path string) bool {
+	return strings.HasPrefix(path, "$") || strings.HasPrefix(path, "~")
+}
+
+// coveredPath reports whether another entry of list grants a directory
+// path lies under. Both are cleaned config entries; globs and entries
+// with a variable or "~" prefix only ever get covered, since what they
+// match isn't known here. Neither is a path that is a symlink out of the
+// covering directory, because the profile also grants its target.
+func coveredPath(path string, list []string) bool {
+	if unexpandedPath(path) {
+		return false
+	}
+	for _, other := range list {
+		if other == path || isGlob(other) || unexpandedPath(other) {
+			continue
+		}
+		under := strings.HasPrefix(path, strings.TrimSuffix(other, "/")+"/")
+		if other == "." {
+			under = !filepath.IsAbs(path) && path != ".." && !strings.HasPrefix(path, "../")
+		}
+		if under && !linksOutside(path, other) {
+			return true
+		}
+	}
+	return false
+}
+
+// linksOutside reports whether path, which lies under dir by name,
+// resolves through a symlink to somewhere outside dir. Paths that don't
+// exist yet are taken by name.
+func linksOutside(path, dir string) bool {
+	real, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return false
+	}
+	realDir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return false
+	}
+	real, _ = filepath.Abs(real)
+	realDir, _ = filepath.Abs(realDir)
+	return !isWithin(real, realDir)
+}
+
+// computeChecksum hashes the canonical JSON form of cfg, excluding the
+// checksum field itself. encoding/json emits struct fields in declaration
+// order and map keys sorted, so the serialization is stable.
+func computeChecksum(cfg SandboxConfig) string {
+	cfg.Checksum = ""
+	data, _ := json.Marshal(cfg)
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// checksumValid reports whether cfg carries a checksum matching its content.
+func checksumValid(cfg SandboxConfig) bool {
+	return cfg.Checksum != "" && cfg.Checksum == computeChecksum(cfg)
+}
+
+const listUsage = `Show the current sandbox configuration
+
+Usage:
+  ddash sandbox list [flags]
+
+Flags:
+  --oneline          Print one line: name | net:... | r:... | w:...
+  --format <format>  Output format: text (default), oneline or json
+  -h, --help         Show help
+
+Examples:
+  for d in */; do (cd "$d" && ddash sandbox list --oneline); done
+  ddash sandbox list --format json | jq .allow_net`
+
 func sandboxList() error {
+	format := "text"
+	args := os.Args[3:]
+	for i := 0; i < len(args); i++ {
+		switch arg := args[i]; {
+		case arg == "--oneline":
+			format = "oneline"
+		case arg == "--format":
+			if i+1 >= len(args) {
+				return fmt.Errorf("--format requires a value (text, oneline or json)")
+			}
+			i++
+			format = args[i]
+		case strings.HasPrefix(arg, "--format="):
+			format = strings.TrimPrefix(arg, "--format=")
+		case arg == "-h" || arg == "--help":
+			fmt.Println(listUsage)
+			return nil
+		default:
+			return fmt.Errorf("unknown flag: %s", arg)
+		}
+	}
+	if format != "text" && format != "oneline" && format != "json" {
+		return fmt.Errorf("unknown --format %q: want text, oneline or json", format)
+	}
+
 	path := configPath()
-	data, err := os.ReadFile(path)
+	cfg, err := readConfig(path)
 	if err != nil {
 		if os.IsNotExist(err) {
+			if format != "text" {
+				// Scripts get an error status rather than prose to parse
+				return fmt.Errorf("no sandbox config at %s", path)
+			}
 			fmt.Println("No sandbox configured. Run 'ddash sandbox init' to create one.")
 			return nil
 		}
 		return fmt.Errorf("failed to read config: %w", err)
 	}
 
-	var cfg SandboxConfig
-	if err := json.Unmarshal(data, &cfg); err != nil {
-		return fmt.Errorf("failed to parse config: %w", err)
+	switch format {
+	case "oneline":
+		fmt.Println(onelineSummary(cfg))
+		return nil
+	case "json":
+		data, err := json.MarshalIndent(cfg, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+		return nil
 	}
 
 	fmt.Printf("%-12s %s\n", "Name:", cfg.Name)
 	fmt.Printf("%-12s %s\n", "Isolation:", cfg.Isolation)
+	if cfg.auditMode() {
+		fmt.Printf("%-12s %s\n", "Enforcement:", "audit (nothing is blocked)")
+	}
 	fmt.Printf("%-12s %s\n", "Created:", cfg.CreatedAt)
+	if cfg.CreatedBy != "" || cfg.Hostname != "" {
+		fmt.Printf("%-12s %s@%s\n", "Created by:", cfg.CreatedBy, cfg.Hostname)
+	}
 	if len(cfg.AllowNet) == 0 {
 		fmt.Printf("%-12s %s\n", "Network:", "denied")
 	} else {
@@ -243,16 +549,241 @@ func sandboxList() error {
 	}
 	fmt.Printf("%-12s %v\n", "Read:", cfg.AllowRead)
 	fmt.Printf("%-12s %v\n", "Write:", cfg.AllowWrite)
+	fmt.Printf("%-12s %s\n", "Temp write:", tmpWriteStatus(cfg))
 	return nil
 }
 
+// onelineSummary condenses cfg for sandbox list --oneline, e.g.
+// "test | net:api.example.com | r:. | w:.,./output". Empty lists show as
+// "-"; audit mode is flagged at the end.
+func onelineSummary(cfg SandboxConfig) string {
+	list := func(items []string) string {
+		if len(items) == 0 {
+			return "-"
+		}
+		return strings.Join(items, ",")
+	}
+	name := cfg.Name
+	if name == "" {
+		name = "-"
+	}
+	fields := []string{
+		name,
+		"net:" + list(entryHosts(cfg.AllowNet)),
+		"r:" + list(entryPaths(cfg.AllowRead)),
+		"w:" + list(cfg.AllowWrite),
+	}
+	if cfg.auditMode() {
+		fields = append(fields, "audit")
+	}
+	return strings.Join(fields, " | ")
+}
+
 func sandboxStatus() error {
+	return printSandboxStatus(os.Stdout, configPath())
+}
+
+// printSandboxStatus writes a readout of the policy 'ddash run' would
+// apply from the config at path, followed by any Validate warnings.
+func printSandboxStatus(w io.Writer, path string) error {
+	cfg, err := readConfig(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			fmt.Fprintln(w, "No sandbox configured. 'ddash run' uses the defaults: no network, writes to the current directory, secrets scrubbed.")
+			return nil
+		}
+		return fmt.Errorf("failed to read config: %w", err)
+	}
+
+	sandboxed := "yes"
+	switch {
+	case cfg.Isolation == isolationNone:
+		sandboxed = "no (isolation: none)"
+	case cfg.auditMode():
+		sandboxed = "audit only (enforcement: audit, nothing is blocked)"
+	}
+	fmt.Fprintf(w, "%-13s %s\n", "Config:", path)
+	fmt.Fprintf(w, "%-13s %s\n", "Sandboxed:", sandboxed)
+	fmt.Fprintf(w, "%-13s %s\n", "Network:", netPosture(cfg))
+	fmt.Fprintf(w, "%-13s %s\n", "Writes:", writePosture(cfg))
+	fmt.Fprintf(w, "%-13s %s\n", "Temp writes:", tmpWriteStatus(cfg))
+	fmt.Fprintf(w, "%-13s %d patterns (%d prefixes, %d substrings)\n", "Env scrub:",
+		len(sensitiveEnvPrefixes)+len(sensitiveEnvSubstrings), len(sensitiveEnvPrefixes), len(sensitiveEnvSubstrings))
+
+	cwd, _ := os.Getwd()
+	warnings := append(cfg.Validate(), ignoreWarnings(cwd)...)
+	if len(warnings) == 0 {
+		fmt.Fprintf(w, "%-13s %s\n", "Warnings:", "none")
+		return nil
+	}
+	fmt.Fprintf(w, "%-13s %d\n", "Warnings:", len(warnings))
+	for _, warning := range warnings {
+		fmt.Fprintf(w, "  - %s\n", warning)
+	}
+	return nil
+}
+
+// netPosture describes what allow_net grants a plain 'ddash run'.
+func netPosture(cfg SandboxConfig) string {
+	switch {
+	case allowsAllNet(cfg):
+		return "allowed (all hosts)"
+	case len(cfg.AllowNet) == 0 && cfg.NetMode == netModeMonitor:
+		return "denied; with --net --monitor every host is let through and reported (net_mode: monitor)"
+	case len(cfg.AllowNet) == 0:
+		return "denied"
+	case cfg.NetMode == netModeMonitor:
+		return fmt.Sprintf("host list (%s), reported but not enforced with --net --monitor (net_mode: monitor); denied without it", strings.Join(entryHosts(cfg.AllowNet), ", "))
+	}
+	return fmt.Sprintf("host list (%s), enforced with --net; denied without it", strings.Join(entryHosts(cfg.AllowNet), ", "))
+}
+
+// writePosture describes where the config lets the command write.
+func writePosture(cfg SandboxConfig) string {
+	if len(cfg.AllowWrite) == 0 {
+		return "denied"
+	}
+	posture := strings.Join(cfg.AllowWrite, ", ")
+	if len(cfg.DenyWriteExts) > 0 {
+		posture += " (except " + strings.Join(cfg.DenyWriteExts, ", ") + ")"
+	}
+	return posture
+}
+
+// Validate reports problems 'ddash run' would reject or trip over: values
+// outside their allowed choices, malformed entries, paths that don't
+// exist relative to the current directory, and checksum drift. It returns
+// nil for a sound config.
+func (cfg SandboxConfig) Validate() []string {
+	var warnings []string
+	for _, field := range []struct{ name, value string }{
+		{"isolation", cfg.Isolation},
+		{"enforcement", cfg.Enforcement},
+		{"net_mode", cfg.NetMode},
+	} {
+		if field.value != "" && !slices.Contains(schemaEnums[field.name], field.value) {
+			warnings = append(warnings, fmt.Sprintf("%s %q is not one of %s",
+				field.name, field.value, strings.Join(schemaEnums[field.name], ", ")))
+		}
+	}
+	for _, e := range cfg.AllowNet {
+		if err := validateNetPort(e.Host); err != nil {
+			warnings = append(warnings, err.Error())
+		}
+	}
+	_, expired := dropExpiredNet(cfg, time.Now())
+	for _, e := range expired {
+		warnings = append(warnings, expiredNetWarning(e))
+	}
+	if cfg.AllowNetFile != "" {
+		if _, err := readNetFile(cfg.AllowNetFile); err != nil {
+			warnings = append(warnings, fmt.Sprintf("allow_net_file: %v", err))
+		}
+	}
+	for _, ext := range cfg.DenyWriteExts {
+		if err := validateWriteExt(ext); err != nil {
+			warnings = append(warnings, err.Error())
+		}
+	}
+	for _, device := range cfg.AllowDevices {
+		if err := validateDevice(device); err != nil {
+			warnings = append(warnings, err.Error())
+		}
+	}
+	for _, fifo := range cfg.AllowFIFOs {
+		if err := validateFIFO(fifo); err != nil {
+			warnings = append(warnings, err.Error())
+		}
+	}
+	if err := validatePromptOptions(cfg.PromptOptions); err != nil {
+		warnings = append(warnings, err.Error())
+	}
+	if cfg.BlockedNets != nil {
+		if _, err := parseBlockedNets(*cfg.BlockedNets); err != nil {
+			warnings = append(warnings, err.Error())
+		}
+	}
+	for _, host := range sortedKeys(cfg.PinNet) {
+		if _, err := parsePin(host, cfg.PinNet[host]); err != nil {
+			warnings = append(warnings, err.Error())
+		}
+	}
+	for _, host := range sortedKeys(cfg.NetRewrite) {
+		if _, err := parseRewrite(host, cfg.NetRewrite[host]); err != nil {
+			warnings = append(warnings, err.Error())
+		}
+	}
+	for _, domain := range sortedKeys(cfg.NetworkDomains) {
+		decision := cfg.NetworkDomains[domain]
+		if !slices.Contains(schemaEnums["network_domains"], decision) {
+			warnings = append(warnings, fmt.Sprintf("network_domains decision %q for %s is not always, never or log", decision, domain))
+		}
+	}
+	for _, prefix := range sortedKeys(cfg.Commands) {
+		for _, warning := range cfg.Commands[prefix].Validate() {
+			warnings = append(warnings, fmt.Sprintf("commands[%q]: %s", prefix, warning))
+		}
+	}
+	cwd, _ := os.Getwd()
+	warnings = append(warnings, warnMissingPaths(cfg, cwd)...)
+	if cfg.Checksum != "" && !checksumValid(cfg) {
+		warnings = append(warnings, "config was modified since its checksum was recorded (see 'ddash sandbox verify')")
+	}
+	return warnings
+}
+
+const verifyUsage = `Verify a sandbox config against its checksum
+
+Usage:
+  ddash sandbox verify [flags]
+
+Recomputes the checksum of .ddash.json and compares it with the recorded
+"checksum" field, so you can tell whether the policy was modified since it
+was last reviewed. Exits non-zero on drift.
+
+Flags:
+  --update    Record the current checksum (after reviewing the config)
+  -h, --help  Show help`
+
+func sandboxVerify() error {
+	update := false
+	for _, arg := range os.Args[3:] {
+		switch arg {
+		case "--update":
+			update = true
+		case "-h", "--help":
+			fmt.Println(verifyUsage)
+			return nil
+		}
+	}
+
 	path := configPath()
-	if _, err := os.Stat(path); os.IsNotExist(err) {
-		fmt.Println("No sandbox configured.")
+	cfg, err := readConfig(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("no sandbox config at %s", path)
+		}
+		return fmt.Errorf("failed to read config: %w", err)
+	}
+
+	if update {
+		cfg.Checksum = computeChecksum(cfg)
+		if err := writeConfig(path, cfg); err != nil {
+			return err
+		}
+		fmt.Printf("Recorded checksum %s\n", cfg.Checksum)
 		return nil
 	}
-	fmt.Println("Sandbox: configured (inactive)")
+
+	if cfg.Checksum == "" {
+		return fmt.Errorf("%s has no checksum; review it and run 'ddash sandbox verify --update'", path)
+	}
+	if !checksumValid(cfg) {
+		return fmt.Errorf("%s was modified since its checksum was recorded (expected %s, got %s)",
+			path, cfg.Checksum, computeChecksum(cfg))
+	}
+
+	fmt.Printf("Config verified: %s\n", cfg.Checksum)
 	return nil
 }
 
@@ -2,7 +2,9 @@ package cmd
 
 import (
 	"encoding/json"
+	"fmt"
 	"os"
+	"strings"
 	"testing"
 )
 
@@ -42,7 +44,7 @@ func TestSandboxInitCreatesConfig(t *testing.T) {
 	if len(cfg.AllowNet) != 0 {
 		t.Errorf("expected empty allow_net, got %v", cfg.AllowNet)
 	}
-	if len(cfg.AllowRead) != 1 || cfg.AllowRead[0] != "." {
+	if len(cfg.AllowRead) != 1 || cfg.AllowRead[0].Path != "." {
 		t.Errorf("expected allow_read=[.], got %v", cfg.AllowRead)
 	}
 	if len(cfg.AllowWrite) != 1 || cfg.AllowWrite[0] != "." {
@@ -53,6 +55,58 @@ func TestSandboxInitCreatesConfig(t *testing.T) {
 	}
 }
 
+func TestSandboxInitName(t *testing.T) {
+	origDir, _ := os.Getwd()
+	tmpDir, _ := os.MkdirTemp("", "ddash-test-*")
+	defer os.RemoveAll(tmpDir)
+	os.Chdir(tmpDir)
+	defer os.Chdir(origDir)
+
+	origArgs := os.Args
+	os.Args = []string{"ddash", "sandbox", "init", "--name", "foo"}
+	defer func() { os.Args = origArgs }()
+
+	if err := sandboxInit(); err != nil {
+		t.Fatalf("sandboxInit failed: %v", err)
+	}
+
+	data, _ := os.ReadFile(".ddash.json")
+	var cfg SandboxConfig
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		t.Fatalf("config is not valid JSON: %v", err)
+	}
+
+	if cfg.Name != "foo" {
+		t.Errorf("expected name foo, got %q", cfg.Name)
+	}
+	if host, err := os.Hostname(); err == nil && cfg.Hostname != host {
+		t.Errorf("expected hostname %q, got %q", host, cfg.Hostname)
+	}
+	if cfg.CreatedBy != os.Getenv("USER") {
+		t.Errorf("expected created_by %q, got %q", os.Getenv("USER"), cfg.CreatedBy)
+	}
+}
+
+func TestSandboxInitNameRequiresValue(t *testing.T) {
+	origArgs := os.Args
+	os.Args = []string{"ddash", "sandbox", "init", "--name"}
+	defer func() { os.Args = origArgs }()
+
+	if err := sandboxInit(); err == nil {
+		t.Error("expected error when --name has no value")
+	}
+}
+
+func TestConfigWithoutMetadataParses(t *testing.T) {
+	var cfg SandboxConfig
+	if err := json.Unmarshal([]byte(`{"name":"old","allow_net":[],"allow_read":["."],"allow_write":["."]}`), &cfg); err != nil {
+		t.Fatalf("older config should still parse: %v", err)
+	}
+	if cfg.CreatedBy != "" || cfg.Hostname != "" {
+		t.Errorf("expected empty metadata, got created_by=%q hostname=%q", cfg.CreatedBy, cfg.Hostname)
+	}
+}
+
 func TestSandboxInitRefusesOverwrite(t *testing.T) {
 	origDir, _ := os.Getwd()
 	tmpDir, _ := os.MkdirTemp("", "ddash-test-*")
@@ -92,6 +146,10 @@ func TestSandboxList(t *testing.T) {
 	os.Chdir(tmpDir)
 	defer os.Chdir(origDir)
 
+	origArgs := os.Args
+	os.Args = []string{"ddash", "sandbox", "list"}
+	defer func() { os.Args = origArgs }()
+
 	// No config — should not error
 	err := sandboxList()
 	if err != nil {
@@ -103,8 +161,8 @@ func TestSandboxList(t *testing.T) {
 		Name:       "test",
 		Version:    Version,
 		Isolation:  "process",
-		AllowNet:   []string{"api.example.com"},
-		AllowRead:  []string{"."},
+		AllowNet:   netEntries("api.example.com"),
+		AllowRead:  pathEntries("."),
 		AllowWrite: []string{".", "./output"},
 	}
 	data, _ := json.MarshalIndent(cfg, "", "  ")
@@ -114,6 +172,41 @@ func TestSandboxList(t *testing.T) {
 	if err != nil {
 		t.Errorf("sandboxList with config should not error, got: %v", err)
 	}
+
+	for _, args := range [][]string{{"--oneline"}, {"--format", "json"}, {"--format=oneline"}} {
+		os.Args = append([]string{"ddash", "sandbox", "list"}, args...)
+		if err := sandboxList(); err != nil {
+			t.Errorf("sandboxList %v failed: %v", args, err)
+		}
+	}
+	os.Args = []string{"ddash", "sandbox", "list", "--format", "yaml"}
+	if err := sandboxList(); err == nil {
+		t.Error("expected an error for an unknown --format")
+	}
+
+	// Scripted formats report a missing config through the exit status
+	os.Remove(".ddash.json")
+	os.Args = []string{"ddash", "sandbox", "list", "--oneline"}
+	if err := sandboxList(); err == nil {
+		t.Error("expected an error for --oneline without a config")
+	}
+}
+
+func TestOnelineSummary(t *testing.T) {
+	cfg := SandboxConfig{
+		Name:       "test",
+		AllowNet:   netEntries("api.example.com"),
+		AllowRead:  pathEntries("."),
+		AllowWrite: []string{".", "./output"},
+	}
+	if got, want := onelineSummary(cfg), "test | net:api.example.com | r:. | w:.,./output"; got != want {
+		t.Errorf("onelineSummary = %q, want %q", got, want)
+	}
+
+	locked := SandboxConfig{Enforcement: enforcementAudit}
+	if got, want := onelineSummary(locked), "- | net:- | r:- | w:- | audit"; got != want {
+		t.Errorf("onelineSummary = %q, want %q", got, want)
+	}
 }
 
 func TestSandboxStatus(t *testing.T) {
@@ -137,9 +230,230 @@ func TestSandboxStatus(t *testing.T) {
 	}
 }
 
+func TestSandboxStatusReflectsPolicy(t *testing.T) {
+	origDir, _ := os.Getwd()
+	tmpDir := t.TempDir()
+	os.Chdir(tmpDir)
+	defer os.Chdir(origDir)
+
+	status := func(cfg string) string {
+		t.Helper()
+		if err := os.WriteFile(".ddash.json", []byte(cfg), 0644); err != nil {
+			t.Fatal(err)
+		}
+		var out strings.Builder
+		if err := printSandboxStatus(&out, ".ddash.json"); err != nil {
+			t.Fatalf("printSandboxStatus failed: %v", err)
+		}
+		return out.String()
+	}
+
+	open := status(`{"isolation": "process", "allow_net": ["*"], "allow_write": ["."]}`)
+	for _, want := range []string{"Sandboxed:    yes", "Network:      allowed (all hosts)", "Writes:       .", "Warnings:     none"} {
+		if !strings.Contains(open, want) {
+			t.Errorf("allow-all status missing %q:\n%s", want, open)
+		}
+	}
+
+	locked := status(`{"allow_net": [], "allow_write": [], "tmp_write": false}`)
+	for _, want := range []string{"Network:      denied", "Writes:       denied", "Temp writes:  denied"} {
+		if !strings.Contains(locked, want) {
+			t.Errorf("locked-down status missing %q:\n%s", want, locked)
+		}
+	}
+
+	hosts := status(`{"allow_net": ["api.example.com"], "allow_write": ["."], "deny_write_exts": [".sh"]}`)
+	for _, want := range []string{"host list (api.example.com), enforced with --net", "Writes:       . (except .sh)"} {
+		if !strings.Contains(hosts, want) {
+			t.Errorf("host list status missing %q:\n%s", want, hosts)
+		}
+	}
+
+	broken := status(`{"isolation": "vm", "allow_write": ["./missing"], "deny_write_exts": ["sh"], "pin_net": {"example.com": "zz"}}`)
+	for _, want := range []string{"Warnings:     4", `isolation "vm"`, "deny_write_exts", "pin_net", "missing"} {
+		if !strings.Contains(broken, want) {
+			t.Errorf("broken status missing %q:\n%s", want, broken)
+		}
+	}
+}
+
+func TestValidateSoundConfig(t *testing.T) {
+	origDir, _ := os.Getwd()
+	os.Chdir(t.TempDir())
+	defer os.Chdir(origDir)
+
+	cfg := defaultRunConfig()
+	cfg.NetworkDomains = map[string]string{"example.com": "always"}
+	cfg.NetRewrite = map[string]string{"registry.npmjs.org": "mirror.internal:8443"}
+	cfg.Checksum = computeChecksum(cfg)
+	if warnings := cfg.Validate(); warnings != nil {
+		t.Errorf("Validate = %v, want no warnings", warnings)
+	}
+
+	cfg.NetworkDomains["example.com"] = "sometimes"
+	if warnings := cfg.Validate(); len(warnings) != 2 {
+		t.Errorf("Validate = %v, want a bad decision and checksum drift", warnings)
+	}
+}
+
 func TestConfigPath(t *testing.T) {
 	path := configPath()
 	if path != ".ddash.json" {
 		t.Errorf("expected .ddash.json, got %s", path)
 	}
 }
+
+func TestComputeChecksumDetectsChange(t *testing.T) {
+	cfg := SandboxConfig{
+		Name:       "test",
+		AllowNet:   []NetEntry{},
+		AllowRead:  pathEntries("."),
+		AllowWrite: []string{"."},
+	}
+
+	sum := computeChecksum(cfg)
+	if !strings.HasPrefix(sum, "sha256:") {
+		t.Errorf("expected sha256: prefix, got %q", sum)
+	}
+	if computeChecksum(cfg) != sum {
+		t.Error("checksum should be stable across calls")
+	}
+
+	// The checksum field itself is excluded
+	cfg.Checksum = sum
+	if computeChecksum(cfg) != sum {
+		t.Error("checksum should not depend on the checksum field")
+	}
+	if !checksumValid(cfg) {
+		t.Error("expected checksum to be valid")
+	}
+
+	cfg.AllowNet = netEntries("*")
+	if checksumValid(cfg) {
+		t.Error("changing allow_net should invalidate the checksum")
+	}
+}
+
+func TestSandboxVerify(t *testing.T) {
+	origDir, _ := os.Getwd()
+	tmpDir, _ := os.MkdirTemp("", "ddash-test-*")
+	defer os.RemoveAll(tmpDir)
+	os.Chdir(tmpDir)
+	defer os.Chdir(origDir)
+
+	origArgs := os.Args
+	defer func() { os.Args = origArgs }()
+
+	os.Args = []string{"ddash", "sandbox", "init"}
+	if err := sandboxInit(); err != nil {
+		t.Fatalf("sandboxInit failed: %v", err)
+	}
+
+	os.Args = []string{"ddash", "sandbox", "verify"}
+	if err := sandboxVerify(); err != nil {
+		t.Errorf("fresh config should verify, got: %v", err)
+	}
+
+	// Tamper with the config
+	cfg, _ := readConfig(".ddash.json")
+	cfg.AllowWrite = []string{"/"}
+	writeConfig(".ddash.json", cfg)
+
+	if err := sandboxVerify(); err == nil {
+		t.Error("expected verify to report drift after tampering")
+	}
+
+	// Re-approve
+	os.Args = []string{"ddash", "sandbox", "verify", "--update"}
+	if err := sandboxVerify(); err != nil {
+		t.Fatalf("verify --update failed: %v", err)
+	}
+	os.Args = []string{"ddash", "sandbox", "verify"}
+	if err := sandboxVerify(); err != nil {
+		t.Errorf("config should verify after --update, got: %v", err)
+	}
+}
+
+func TestSaveDomainDecisionsKeepsStaleChecksum(t *testing.T) {
+	origDir, _ := os.Getwd()
+	tmpDir, _ := os.MkdirTemp("", "ddash-test-*")
+	defer os.RemoveAll(tmpDir)
+	os.Chdir(tmpDir)
+	defer os.Chdir(origDir)
+
+	// An approved config is re-stamped after writeback
+	cfg := SandboxConfig{Name: "test", AllowRead: pathEntries("."), AllowWrite: []string{"."}}
+	cfg.Checksum = computeChecksum(cfg)
+	writeConfig(".ddash.json", cfg)
+
+	saveDomainDecisions(map[string]string{"example.com": "always"}, cfg, ".ddash.json")
+	saved, _ := readConfig(".ddash.json")
+	if !checksumValid(saved) {
+		t.Error("writeback to an approved config should keep it verified")
+	}
+
+	// A tampered config is not laundered by writeback
+	saved.AllowWrite = []string{"/"}
+	writeConfig(".ddash.json", saved)
+
+	saveDomainDecisions(map[string]string{"other.com": "never"}, saved, ".ddash.json")
+	saved, _ = readConfig(".ddash.json")
+	if checksumValid(saved) {
+		t.Error("writeback must not re-stamp a config that already drifted")
+	}
+}
+
+func TestNormalizeConfig(t *testing.T) {
+	cfg := normalizeConfig(SandboxConfig{
+		AllowNet:   []NetEntry{{Host: " pypi.org "}, {Host: "github.com", Reason: "clone"}, {Host: "github.com"}, {Host: ""}},
+		AllowRead:  []PathEntry{{Path: "/opt/data"}, {Path: "./"}, {Path: "/opt/data/sub", NonRecursive: true}, {Path: "/srv", NonRecursive: true}, {Path: "src"}},
+		AllowWrite: []string{"./output", ".", "./", " /var/out/ ", "/var/out/logs", "$HOME/.cache", "../shared"},
+	})
+
+	if got := fmt.Sprint(cfg.AllowNet); got != "[github.com pypi.org]" || cfg.AllowNet[0].Reason != "clone" {
+		t.Errorf("allow_net = %+v, want sorted, deduped, first annotation kept", cfg.AllowNet)
+	}
+	if got := fmt.Sprint(cfg.AllowRead); got != "[. /opt/data /srv (non-recursive)]" {
+		t.Errorf("allow_read = %s", got)
+	}
+	if got := strings.Join(cfg.AllowWrite, ","); got != "$HOME/.cache,.,../shared,/var/out" {
+		t.Errorf("allow_write = %s", got)
+	}
+
+	// A non-recursive "." covers nothing below it
+	cfg = normalizeConfig(SandboxConfig{AllowRead: []PathEntry{{Path: ".", NonRecursive: true}, {Path: "src"}}})
+	if got := fmt.Sprint(cfg.AllowRead); got != "[. (non-recursive) src]" {
+		t.Errorf("allow_read = %s, want src kept", got)
+	}
+
+	// --net prompts despite "*", so the listed hosts still matter
+	cfg = normalizeConfig(SandboxConfig{AllowNet: netEntries("a.example.com", "*"), AllowWrite: []string{}})
+	if got := fmt.Sprint(cfg.AllowNet); got != "[* a.example.com]" {
+		t.Errorf("allow_net = %s, want the hosts kept next to *", got)
+	}
+	if cfg.AllowWrite == nil || len(cfg.AllowWrite) != 0 {
+		t.Error("an empty allow_write must stay empty, not become unset")
+	}
+
+	// Prefixes expanded at run time are neither cleaned nor covered
+	cfg = normalizeConfig(SandboxConfig{AllowWrite: []string{"$HOME/../shared", "~", "~/out", "$TMP", "$TMP/x"}})
+	if got := strings.Join(cfg.AllowWrite, ","); got != "$HOME/../shared,$TMP,$TMP/x,~,~/out" {
+		t.Errorf("allow_write = %s", got)
+	}
+}
+
+func TestNormalizeConfigKeepsSymlinkOut(t *testing.T) {
+	origDir, _ := os.Getwd()
+	dir := t.TempDir()
+	os.Chdir(dir)
+	t.Cleanup(func() { os.Chdir(origDir) })
+	if err := os.Symlink(t.TempDir(), "output"); err != nil {
+		t.Fatal(err)
+	}
+	os.Mkdir("build", 0755)
+
+	cfg := normalizeConfig(SandboxConfig{AllowWrite: []string{".", "./output", "./build"}})
+	if got := strings.Join(cfg.AllowWrite, ","); got != ".,output" {
+		t.Errorf("allow_write = %s, want output kept since it links outside .", got)
+	}
+}
@@ -0,0 +1,157 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// schemaDescriptions documents each .ddash.json field in the exported
+// JSON Schema, keyed by JSON name. A test checks that every SandboxConfig
+// field has an entry, so new fields can't be added without one.
+var schemaDescriptions = map[string]string{
+	"name":            "Project name.",
+	"version":         "Config format version.",
+	"created_at":      "When the config was created (RFC 3339).",
+	"created_by":      "User who created the config.",
+	"hostname":        "Machine the config was created on.",
+	"isolation":       `"process" runs under sandbox-exec; "none" disables the sandbox (debugging only, needs --no-sandbox).`,
+	"allow_net":       `Network access: [] denies all, ["*"] allows all, or a list of hosts. "host:443" allows one port, "host:*" or a bare host every port; the most specific entry wins. "@https://..." includes the hosts listed at that URL (a JSON array, or an object with allow_net), fetched at load time and cached for an hour. {"host": ..., "reason": ..., "owner": ..., "until": "YYYY-MM-DD"} annotates a host; after the until date it is no longer allowed.`,
+	"allow_net_file":  "Flat file of extra allow_net hosts, one host, \"*.domain\" wildcard or CIDR per line, with # comments. Read at load time; its contents are not covered by the checksum.",
+	"allow_read":      `Filesystem read paths beyond system defaults. Globs and $VARS are expanded at run time ($$ is a literal $). {"path": ..., "recursive": false} grants a directory and its immediate children only.`,
+	"allow_write":     "Filesystem write paths. [] is fully read-only. Globs and $VARS are expanded at run time ($$ is a literal $).",
+	"enforcement":     `"enforce" (default) blocks what the policy doesn't allow; "audit" allows everything and logs access and new domains instead, and only takes effect with --audit.`,
+	"tmp_write":       "Set to false to drop the implicit /private/tmp and /dev write grant (default true).",
+	"allow_devices":   `Device files (e.g. "/dev/ttys003") the command may read and write. Needed with "tmp_write": false, which otherwise leaves /dev/null as the only writable device. Exact paths under /dev; --deny-write overrides them.`,
+	"allow_fifos":     `Named pipes the command may create and write into, absolute or relative to the config root. Each gets file-write-create and file-write-data on that exact path only, not the rest of file-write*. allow_write entries that already are pipes are narrowed the same way. --deny-write overrides them.`,
+	"deny_write_exts": `File extensions (e.g. ".sh", ".dylib") that may not be written anywhere, even under allow_write. Matched case-insensitively.`,
+	"strip_headers":   "Request headers (e.g. Authorization, Cookie) the --net proxy removes from plain HTTP requests before forwarding.",
+	"blocked_nets":    "IP ranges (CIDRs) the --net proxy refuses unless a host is listed in allow_net. Replaces the default link-local and cloud metadata ranges; [] turns the check off.",
+	"pin_net":         "Host -> SHA-256 fingerprint of its TLS certificate. The --net proxy opens tunnels to a pinned host only if the certificate matches, and refuses plain HTTP to it. Best-effort: the certificate is checked on a separate connection to the same address.",
+	"net_rewrite":     "Requested host -> upstream host[:port] the --net proxy dials instead, e.g. an internal mirror. Decisions, prompts and logs still use the requested host; the mirror must serve a certificate valid for that host.",
+	"strict_sni":      "Close --net tunnels to a hostname unless they start with a TLS ClientHello naming that host: no plain TCP, no missing server name.",
+	"prompt_options":  `Decisions the --net prompt offers, e.g. ["allow", "deny"] to hide the saved always/never answers. Deny is always offered; a hidden answer typed anyway denies. Default: all.`,
+	"commands":        `Command prefix -> config merged over this one when the run's command starts with it, e.g. {"npm install": {"allow_net": ["registry.npmjs.org"]}}. The longest matching prefix wins; lists add to the base config.`,
+	"net_mode":        `"prompt" (default) asks about new domains with --net; "monitor" lets every domain through unasked and reports the ones a strict policy would have blocked, only when --monitor is also given.`,
+	"network_domains": `Saved per-domain decisions from --net mode: "always" or "never". "log" allows a domain but reports it as one a strict policy would block.`,
+	"checksum":        "SHA-256 of the rest of the config, checked by 'ddash sandbox verify'.",
+}
+
+// schemaEnums restricts string fields (or map values, or list items) to
+// fixed choices.
+var schemaEnums = map[string][]string{
+	"isolation":       {isolationProcess, isolationNone},
+	"enforcement":     {enforcementEnforce, enforcementAudit},
+	"net_mode":        {netModePrompt, netModeMonitor},
+	"network_domains": {"always", "never", "log"},
+	"prompt_options":  promptOptionNames,
+}
+
+// configSchema builds a JSON Schema for SandboxConfig from its struct
+// fields, so it stays in sync as fields are added.
+func configSchema() map[string]any {
+	properties := make(map[string]any)
+
+	t := reflect.TypeOf(SandboxConfig{})
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "" || name == "-" {
+			continue
+		}
+
+		prop := schemaType(field.Type)
+		prop["description"] = schemaDescriptions[name]
+		if enum, ok := schemaEnums[name]; ok {
+			if items, ok := prop["additionalProperties"].(map[string]any); ok {
+				items["enum"] = enum
+			} else if items, ok := prop["items"].(map[string]any); ok {
+				items["enum"] = enum
+			} else {
+				prop["enum"] = enum
+			}
+		}
+		properties[name] = prop
+	}
+
+	// Let configs point at the schema themselves
+	properties["$schema"] = map[string]any{"type": "string"}
+
+	return map[string]any{
+		"$schema":              "http://json-schema.org/draft-07/schema#",
+		"title":                "ddash sandbox config (.ddash.json)",
+		"type":                 "object",
+		"properties":           properties,
+		"additionalProperties": false,
+	}
+}
+
+// schemaType maps a config field's Go type to a JSON Schema type.
+func schemaType(t reflect.Type) map[string]any {
+	if t == reflect.TypeOf(PathEntry{}) {
+		return map[string]any{
+			"oneOf": []any{
+				map[string]any{"type": "string"},
+				map[string]any{
+					"type": "object",
+					"properties": map[string]any{
+						"path":      map[string]any{"type": "string"},
+						"recursive": map[string]any{"type": "boolean"},
+					},
+					"required":             []string{"path"},
+					"additionalProperties": false,
+				},
+			},
+		}
+	}
+
+	if t == reflect.TypeOf(NetEntry{}) {
+		return map[string]any{
+			"oneOf": []any{
+				map[string]any{"type": "string"},
+				map[string]any{
+					"type": "object",
+					"properties": map[string]any{
+						"host":   map[string]any{"type": "string"},
+						"reason": map[string]any{"type": "string"},
+						"owner":  map[string]any{"type": "string"},
+						"until":  map[string]any{"type": "string", "format": "date"},
+					},
+					"required":             []string{"host"},
+					"additionalProperties": false,
+				},
+			},
+		}
+	}
+
+	if t == reflect.TypeOf(SandboxConfig{}) {
+		// commands entries are configs themselves
+		return map[string]any{"$ref": "#"}
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Pointer:
+		// Optional fields; absence means the default
+		return schemaType(t.Elem())
+	case reflect.Slice:
+		return map[string]any{"type": "array", "items": schemaType(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": schemaType(t.Elem())}
+	default:
+		panic(fmt.Sprintf("configSchema: unsupported field type %s", t))
+	}
+}
+
+func sandboxSchema() error {
+	data, err := json.MarshalIndent(configSchema(), "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(data))
+	return nil
+}
@@ -0,0 +1,70 @@
+package cmd
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestConfigSchemaCoversAllFields(t *testing.T) {
+	props := configSchema()["properties"].(map[string]any)
+
+	typ := reflect.TypeOf(SandboxConfig{})
+	for i := 0; i < typ.NumField(); i++ {
+		if !typ.Field(i).IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(typ.Field(i).Tag.Get("json"), ",")
+		if _, ok := props[name]; !ok {
+			t.Errorf("schema is missing field %q", name)
+		}
+		if schemaDescriptions[name] == "" {
+			t.Errorf("field %q has no schema description", name)
+		}
+	}
+}
+
+func TestConfigSchemaTypes(t *testing.T) {
+	data, err := json.Marshal(configSchema())
+	if err != nil {
+		t.Fatalf("schema does not marshal: %v", err)
+	}
+
+	var schema struct {
+		Properties map[string]struct {
+			Type                 string                 `json:"type"`
+			Enum                 []string               `json:"enum"`
+			Items                *struct{ Type string } `json:"items"`
+			AdditionalProperties *struct {
+				Type string
+				Enum []string
+			} `json:"additionalProperties"`
+		} `json:"properties"`
+	}
+	if err := json.Unmarshal(data, &schema); err != nil {
+		t.Fatalf("schema is not valid JSON: %v", err)
+	}
+
+	if p := schema.Properties["allow_write"]; p.Type != "array" || p.Items == nil || p.Items.Type != "string" {
+		t.Errorf("allow_write should be an array of strings, got %+v", p)
+	}
+
+	for _, name := range []string{"allow_read", "allow_net"} {
+		items := configSchema()["properties"].(map[string]any)[name].(map[string]any)["items"].(map[string]any)
+		if forms, ok := items["oneOf"].([]any); !ok || len(forms) != 2 {
+			t.Errorf("%s items should accept a string or an object, got %v", name, items)
+		}
+	}
+
+	iso := schema.Properties["isolation"]
+	if strings.Join(iso.Enum, ",") != "process,none" {
+		t.Errorf("isolation enum = %v, want [process none]", iso.Enum)
+	}
+
+	nd := schema.Properties["network_domains"]
+	if nd.Type != "object" || nd.AdditionalProperties == nil ||
+		strings.Join(nd.AdditionalProperties.Enum, ",") != "always,never,log" {
+		t.Errorf("network_domains should map to always/never/log, got %+v", nd)
+	}
+}
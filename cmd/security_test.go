@@ -91,6 +91,30 @@ func TestSecurityDenyWriteBlocksTmp(t *testing.T) {
 	os.Remove("/tmp/ddash_test_evil")
 }
 
+func TestSecuritySymlinkedOutputWritable(t *testing.T) {
+	binary := ddashBinary(t)
+
+	project := t.TempDir()
+	data := t.TempDir()
+	if err := os.Symlink(data, project+"/output"); err != nil {
+		t.Fatal(err)
+	}
+	config := `{"name": "symlink", "isolation": "process", "allow_net": [], "allow_read": ["."], "allow_write": ["./output"]}`
+	if err := os.WriteFile(project+"/.ddash.json", []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := exec.Command(binary, "run", "--", "sh", "-c", "echo ok > output/result.txt")
+	cmd.Dir = project
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("write through symlinked allow_write dir failed: %v\n%s", err, out)
+	}
+	if _, err := os.Stat(data + "/result.txt"); err != nil {
+		t.Errorf("expected result.txt in the symlink target: %v", err)
+	}
+}
+
 func TestSecuritySubprocessInheritsSandbox(t *testing.T) {
 	binary := ddashBinary(t)
 
@@ -143,6 +167,46 @@ func TestSecurityEnvPassthrough(t *testing.T) {
 	}
 }
 
+func TestSecurityRedactHidesSecretInOutput(t *testing.T) {
+	binary := ddashBinary(t)
+
+	// The secret is the command name, which ddash echoes in its "command
+	// not found" error; it must print it redacted.
+	cmd := exec.Command(binary, "run", "--redact", "--", "planted-secret-value")
+	cmd.Env = append(os.Environ(), "TEST_SECRET_KEY=planted-secret-value")
+	out, _ := cmd.CombinedOutput()
+	output := string(out)
+
+	if strings.Contains(output, "planted-secret-value") {
+		t.Errorf("ddash output leaked a secret value: %s", output)
+	}
+	if !strings.Contains(output, "command not found: ***") {
+		t.Errorf("expected the redacted command name, got: %s", output)
+	}
+}
+
+func TestSecurityNoSandboxWarns(t *testing.T) {
+	binary := ddashBinary(t)
+
+	cmd := exec.Command(binary, "run", "--no-sandbox", "--", "echo", "unsandboxed-ok")
+	cmd.Env = append(os.Environ(), "TEST_SECRET_KEY=supersecret")
+	out, err := cmd.CombinedOutput()
+	output := string(out)
+	if err != nil {
+		t.Fatalf("--no-sandbox run failed: %v\n%s", err, output)
+	}
+
+	if !strings.Contains(output, "sandbox DISABLED") {
+		t.Errorf("expected a prominent warning that the sandbox is disabled, got: %s", output)
+	}
+	if !strings.Contains(output, "unsandboxed-ok") {
+		t.Errorf("expected command output, got: %s", output)
+	}
+	if !strings.Contains(output, "scrubbed") {
+		t.Errorf("env scrubbing should stay active without the sandbox, got: %s", output)
+	}
+}
+
 func TestSecurityStdinPiping(t *testing.T) {
 	binary := ddashBinary(t)
 
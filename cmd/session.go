@@ -0,0 +1,177 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"syscall"
+)
+
+// Domains answered with [o]nce-session are allowed for the rest of the
+// shell session without being written to .ddash.json. They are kept in
+// the user's cache dir (~/Library/Caches/ddash/sessions on macOS), not the
+// temp dir, which every sandboxed command may write to. Files are keyed by
+// $DDASH_SESSION if set, otherwise by the parent shell's PID. A PID-keyed
+// session expires once that shell has exited; a $DDASH_SESSION one lasts
+// until the file is removed.
+// $DDASH_SESSION set to a PID key ("pid-123") joins that shell's session,
+// which is how a detached proxy keeps the session of the shell that
+// started it.
+
+// sessionEnv names the variable that overrides the session key.
+const sessionEnv = "DDASH_SESSION"
+
+// sessionFile is the on-disk form of a session's decisions.
+type sessionFile struct {
+	PID     int               `json:"pid,omitempty"` // owning shell, 0 for $DDASH_SESSION
+	Domains map[string]string `json:"domains"`
+}
+
+var unsafeSessionChars = regexp.MustCompile(`[^A-Za-z0-9_.-]`)
+
+var pidSessionKey = regexp.MustCompile(`^pid-([1-9][0-9]*)$`)
+
+// sessionKey identifies the current shell session. pid is the shell's PID
+// when the key is derived from it, 0 otherwise.
+func sessionKey() (key string, pid int) {
+	if s := os.Getenv(sessionEnv); s != "" {
+		if m := pidSessionKey.FindStringSubmatch(s); m != nil {
+			if pid, err := strconv.Atoi(m[1]); err == nil {
+				return s, pid
+			}
+		}
+		return "env-" + unsafeSessionChars.ReplaceAllString(s, "_"), 0
+	}
+	ppid := os.Getppid()
+	return fmt.Sprintf("pid-%d", ppid), ppid
+}
+
+// sessionPath is the file of session key in dir, from sessionDir.
+func sessionPath(dir, key string) string {
+	return filepath.Join(dir, "session-"+key+".json")
+}
+
+// sessionDirPath is where sessionDir keeps the session files. Profiles deny
+// writes below it, so a command can't plant decisions for later runs even
+// when its config grants writes to the whole home directory.
+func sessionDirPath() (string, error) {
+	cache, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(cache, "ddash", "sessions"), nil
+}
+
+// sessionDir returns the directory holding the session files, creating it
+// if needed. It is refused unless it is a real directory of this user that
+// no one else can write to.
+func sessionDir() (string, error) {
+	dir, err := sessionDirPath()
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(filepath.Dir(dir), 0700); err != nil {
+		return "", err
+	}
+	if err := os.Mkdir(dir, 0700); err != nil && !os.IsExist(err) {
+		return "", err
+	}
+	info, err := os.Lstat(dir)
+	if err != nil {
+		return "", err
+	}
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !info.IsDir() || info.Mode().Perm()&0077 != 0 || ok && int(st.Uid) != os.Getuid() {
+		return "", fmt.Errorf("%s is not a private directory of this user", dir)
+	}
+	return dir, nil
+}
+
+// loadSessionDecisions returns the domains allowed for the current session.
+// Files not owned by this user are ignored, so another account can't plant
+// decisions; files of exited shells are removed.
+func loadSessionDecisions() map[string]string {
+	dir, err := sessionDir()
+	if err != nil {
+		return nil
+	}
+	key, _ := sessionKey()
+	path := sessionPath(dir, key)
+
+	info, err := os.Lstat(path)
+	if err != nil || !info.Mode().IsRegular() {
+		return nil
+	}
+	if st, ok := info.Sys().(*syscall.Stat_t); ok && int(st.Uid) != os.Getuid() {
+		return nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	var sf sessionFile
+	if err := json.Unmarshal(data, &sf); err != nil {
+		return nil
+	}
+	if sf.PID != 0 && !processAlive(sf.PID) {
+		os.Remove(path)
+		return nil
+	}
+	return sf.Domains
+}
+
+// saveSessionDecisions records the "session" decisions among domains. The
+// file is written under a fresh name and renamed into place, so a link
+// planted at its path is replaced rather than followed.
+func saveSessionDecisions(domains map[string]string) error {
+	session := make(map[string]string)
+	for domain, decision := range domains {
+		if Decision(decision) == DecisionSession {
+			session[domain] = decision
+		}
+	}
+	if len(session) == 0 {
+		return nil
+	}
+
+	for domain, decision := range loadSessionDecisions() {
+		if _, ok := session[domain]; !ok {
+			session[domain] = decision
+		}
+	}
+
+	key, pid := sessionKey()
+	data, err := json.MarshalIndent(sessionFile{PID: pid, Domains: session}, "", "  ")
+	if err != nil {
+		return err
+	}
+	dir, err := sessionDir()
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(dir, ".session-*")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(append(data, '\n'))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), sessionPath(dir, key))
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+// processAlive reports whether a process with pid exists.
+func processAlive(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || err == syscall.EPERM
+}
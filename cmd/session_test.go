@@ -0,0 +1,145 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSessionDecisionsRoundTrip(t *testing.T) {
+	useSessionCache(t)
+	t.Setenv(sessionEnv, "test/session")
+
+	if got := loadSessionDecisions(); got != nil {
+		t.Fatalf("expected no decisions in a new session, got %v", got)
+	}
+
+	err := saveSessionDecisions(map[string]string{
+		"cdn.example.com":  "session",
+		"api.example.com":  "always",
+		"evil.example.com": "deny",
+	})
+	if err != nil {
+		t.Fatalf("saveSessionDecisions: %v", err)
+	}
+	saveSessionDecisions(map[string]string{"other.example.com": "session"})
+
+	got := loadSessionDecisions()
+	if len(got) != 2 || got["cdn.example.com"] != "session" || got["other.example.com"] != "session" {
+		t.Errorf("session decisions = %v, want cdn and other as session", got)
+	}
+}
+
+func TestSessionKey(t *testing.T) {
+	t.Setenv(sessionEnv, "")
+	key, pid := sessionKey()
+	if pid != os.Getppid() || key == "" {
+		t.Errorf("sessionKey() = %q, %d; want keyed by parent PID %d", key, pid, os.Getppid())
+	}
+
+	// A detached proxy is handed its shell's key
+	t.Setenv(sessionEnv, "pid-4242")
+	key, pid = sessionKey()
+	if pid != 4242 || key != "pid-4242" {
+		t.Errorf("sessionKey() with a PID key = %q, %d", key, pid)
+	}
+
+	t.Setenv(sessionEnv, "../../etc/x")
+	key, pid = sessionKey()
+	if pid != 0 || key != "env-.._.._etc_x" {
+		t.Errorf("sessionKey() = %q, %d", key, pid)
+	}
+}
+
+func TestSessionExpiresWithShell(t *testing.T) {
+	useSessionCache(t)
+	t.Setenv(sessionEnv, "")
+
+	key, _ := sessionKey()
+	dir, err := sessionDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A PID that can't belong to a live process
+	data := []byte(`{"pid": 2147483647, "domains": {"cdn.example.com": "session"}}`)
+	if err := os.WriteFile(sessionPath(dir, key), data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := loadSessionDecisions(); got != nil {
+		t.Errorf("expected expired session, got %v", got)
+	}
+	if _, err := os.Stat(sessionPath(dir, key)); !os.IsNotExist(err) {
+		t.Error("expired session file was not removed")
+	}
+}
+
+func TestSaveSessionDecisionsReplacesPlantedLink(t *testing.T) {
+	useSessionCache(t)
+	t.Setenv(sessionEnv, "planted")
+	dir, err := sessionDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	victim := filepath.Join(t.TempDir(), "victim")
+	os.WriteFile(victim, []byte("keep"), 0600)
+	if err := os.Symlink(victim, sessionPath(dir, "env-planted")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := saveSessionDecisions(map[string]string{"cdn.example.com": "session"}); err != nil {
+		t.Fatalf("saveSessionDecisions: %v", err)
+	}
+	if data, _ := os.ReadFile(victim); string(data) != "keep" {
+		t.Errorf("the link target was overwritten: %q", data)
+	}
+	if got := loadSessionDecisions(); got["cdn.example.com"] != "session" {
+		t.Errorf("session decisions = %v", got)
+	}
+}
+
+func TestSessionDirMustBePrivate(t *testing.T) {
+	useSessionCache(t)
+	dir, err := sessionDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Chmod(dir, 0777)
+
+	if _, err := sessionDir(); err == nil {
+		t.Error("a world-writable session dir was accepted")
+	}
+	if err := saveSessionDecisions(map[string]string{"cdn.example.com": "session"}); err == nil {
+		t.Error("saved into a world-writable session dir")
+	}
+}
+
+// useSessionCache points the user's cache dir, and with it the session
+// files, at a fresh temp dir.
+func useSessionCache(t *testing.T) {
+	cache := t.TempDir()
+	t.Setenv("XDG_CACHE_HOME", cache)
+	t.Setenv("HOME", cache)
+}
+
+func TestProfileDeniesSessionDirWrites(t *testing.T) {
+	useSessionCache(t)
+	dir, err := sessionDirPath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cache, _ := os.UserCacheDir(); !isWithin(dir, cache) {
+		t.Errorf("session dir %s is not in the cache dir %s", dir, cache)
+	}
+
+	// Even a config that grants the whole home directory can't write there
+	profile := GenerateProfile(SandboxConfig{AllowWrite: []string{"~"}}, false, false)
+	deny := "(deny file-write* (subpath " + sbplString(dir) + "))"
+	if !strings.Contains(profile, deny) {
+		t.Errorf("profile lacks %s:\n%s", deny, profile)
+	}
+	if strings.Index(profile, deny) < strings.Index(profile, "(allow file-write* (subpath "+sbplString(os.Getenv("HOME"))+"))") {
+		t.Error("the deny must follow the home write grant to override it")
+	}
+}
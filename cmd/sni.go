@@ -0,0 +1,117 @@
+package cmd
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// sniPeekTimeout bounds how long a tunnel waits for the client's first
+// bytes. TLS clients send their ClientHello right after "200 Connection
+// Established", so this only delays protocols where the server speaks
+// first.
+const sniPeekTimeout = 2 * time.Second
+
+// errHelloRead stops the handshake once the ClientHello has been parsed.
+var errHelloRead = errors.New("client hello read")
+
+// helloConn feeds a TLS handshake from r while recording every byte, so
+// the bytes can be replayed upstream. Writes fail: the proxy never answers
+// the handshake itself.
+type helloConn struct {
+	net.Conn
+	r   io.Reader
+	buf bytes.Buffer
+}
+
+func (c *helloConn) Read(b []byte) (int, error) {
+	n, err := c.r.Read(b)
+	c.buf.Write(b[:n])
+	return n, err
+}
+
+func (c *helloConn) Write(b []byte) (int, error) {
+	return 0, io.ErrClosedPipe
+}
+
+// peekSNI reads the start of a tunnel from r and returns the server name
+// of its TLS ClientHello, if any, along with the bytes read, which the
+// caller must forward upstream. Streams that aren't TLS, or stay silent
+// until the server speaks, come back with no name and no error; a TLS
+// stream that breaks off mid-handshake is an error.
+func peekSNI(conn net.Conn, r io.Reader) (serverName string, peeked []byte, err error) {
+	var isTLS bool
+	hc := &helloConn{Conn: conn, r: r}
+	conn.SetReadDeadline(time.Now().Add(sniPeekTimeout))
+	defer conn.SetReadDeadline(time.Time{})
+
+	handshakeErr := tls.Server(hc, &tls.Config{
+		GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			serverName, isTLS = hello.ServerName, true
+			return nil, errHelloRead
+		},
+	}).Handshake()
+
+	peeked = hc.buf.Bytes()
+	switch {
+	case isTLS:
+		return serverName, peeked, nil
+	case len(peeked) == 0 || peeked[0] != 0x16:
+		// Not a TLS handshake record: nothing to check
+		return "", peeked, nil
+	default:
+		return "", peeked, fmt.Errorf("incomplete TLS ClientHello: %v", handshakeErr)
+	}
+}
+
+// sniMatches reports whether the ClientHello's server name is the domain
+// the tunnel was approved for. No server name matches anything: clients
+// leave it out for IP literals, and the tunnel still only reaches the
+// approved host.
+func sniMatches(serverName, domain string) bool {
+	if serverName == "" {
+		return true
+	}
+	return strings.EqualFold(strings.TrimSuffix(serverName, "."), strings.TrimSuffix(domain, "."))
+}
+
+// SetStrictSNI makes tunnels to a hostname require a TLS ClientHello that
+// names it. By default a tunnel that isn't TLS, or whose ClientHello has
+// no server name, is let through; strict mode closes it, so nothing but
+// TLS to the approved name can use the tunnel. Tunnels to IP literals are
+// unaffected, since clients send no server name for them.
+func (p *NetworkProxy) SetStrictSNI(strict bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.strictSNI = strict
+}
+
+// checkTunnelSNI reads the ClientHello of an established tunnel and
+// returns the bytes it read, for the caller to forward. If the ClientHello
+// doesn't name domain, it reports the refusal and returns the verdict for
+// noteDecision instead; the caller closes both connections.
+func (p *NetworkProxy) checkTunnelSNI(domain string, clientConn net.Conn, r io.Reader) (peeked []byte, refused string) {
+	serverName, peeked, err := peekSNI(clientConn, r)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ddash: closed tunnel to %s: %v\n", domain, err)
+		return nil, "blocked (bad ClientHello)"
+	}
+	p.mu.Lock()
+	strict := p.strictSNI
+	p.mu.Unlock()
+	if strict && serverName == "" && net.ParseIP(domain) == nil {
+		fmt.Fprintf(os.Stderr, "ddash: closed tunnel to %s: no TLS server name (strict_sni)\n", domain)
+		return nil, "blocked (no SNI)"
+	}
+	if !sniMatches(serverName, domain) {
+		fmt.Fprintf(os.Stderr, "ddash: closed tunnel to %s: TLS server name is %s\n", domain, serverName)
+		return nil, "blocked (SNI " + serverName + ")"
+	}
+	return peeked, ""
+}
@@ -0,0 +1,211 @@
+package cmd
+
+import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSNIMatches(t *testing.T) {
+	tests := []struct {
+		serverName, domain string
+		want               bool
+	}{
+		{"registry.npmjs.org", "registry.npmjs.org", true},
+		{"Registry.NPMjs.org.", "registry.npmjs.org", true},
+		{"", "203.0.113.7", true},
+		{"evil.example.com", "registry.npmjs.org", false},
+		{"registry.npmjs.org.evil.example.com", "registry.npmjs.org", false},
+		{"example.com", "203.0.113.7", false},
+	}
+	for _, tt := range tests {
+		if got := sniMatches(tt.serverName, tt.domain); got != tt.want {
+			t.Errorf("sniMatches(%q, %q) = %v, want %v", tt.serverName, tt.domain, got, tt.want)
+		}
+	}
+}
+
+func TestPeekSNIPlainStream(t *testing.T) {
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+
+	go client.Write([]byte("SSH-2.0-OpenSSH_9.6\r\n"))
+
+	name, peeked, err := peekSNI(server, server)
+	if err != nil {
+		t.Fatalf("peekSNI failed on a non-TLS stream: %v", err)
+	}
+	if name != "" || len(peeked) == 0 || peeked[0] != 'S' {
+		t.Errorf("got name %q, peeked %q; want no name and the stream's first bytes", name, peeked)
+	}
+}
+
+// craftClientHello builds a minimal TLS 1.2 ClientHello record carrying
+// serverName in its SNI extension, or no extensions if serverName is "".
+func craftClientHello(serverName string) []byte {
+	u16 := func(n int) []byte { return []byte{byte(n >> 8), byte(n)} }
+
+	var ext []byte
+	if serverName != "" {
+		name := append([]byte{0}, append(u16(len(serverName)), serverName...)...)
+		list := append(u16(len(name)), name...)
+		ext = append(append([]byte{0, 0}, u16(len(list))...), list...)
+	}
+
+	body := []byte{0x03, 0x03}               // legacy version
+	body = append(body, make([]byte, 32)...) // random
+	body = append(body, 0)                   // session ID
+	body = append(body, 0, 2, 0xc0, 0x2f)    // one cipher suite
+	body = append(body, 1, 0)                // null compression
+	body = append(body, u16(len(ext))...)    // extensions
+	body = append(body, ext...)
+
+	hs := append([]byte{0x01, 0, byte(len(body) >> 8), byte(len(body))}, body...)
+	return append(append([]byte{0x16, 0x03, 0x01}, u16(len(hs))...), hs...)
+}
+
+func TestPeekSNICraftedHello(t *testing.T) {
+	tests := []struct {
+		name    string
+		hello   []byte
+		want    string
+		wantErr bool
+	}{
+		{"with SNI", craftClientHello("registry.npmjs.org"), "registry.npmjs.org", false},
+		{"without SNI", craftClientHello(""), "", false},
+		{"truncated", craftClientHello("registry.npmjs.org")[:20], "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, client := net.Pipe()
+			defer server.Close()
+			go func() {
+				client.Write(tt.hello)
+				client.Close()
+			}()
+
+			name, peeked, err := peekSNI(server, server)
+			if (err != nil) != tt.wantErr || name != tt.want {
+				t.Fatalf("peekSNI = %q, %v; want %q, error %v", name, err, tt.want, tt.wantErr)
+			}
+			if !tt.wantErr && string(peeked) != string(tt.hello) {
+				t.Errorf("peeked %d bytes, want the whole %d-byte ClientHello", len(peeked), len(tt.hello))
+			}
+		})
+	}
+}
+
+// tunnelTLS opens a CONNECT tunnel to connectHost through p, then runs a
+// TLS handshake with serverName and a GET over it.
+func tunnelTLS(t *testing.T, p *NetworkProxy, connectHost, serverName string) (string, error) {
+	t.Helper()
+	conn, err := net.Dial("tcp", p.Addr())
+	if err != nil {
+		t.Fatalf("dial proxy: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	fmt.Fprintf(conn, "CONNECT %s HTTP/1.1\r\nHost: %s\r\n\r\n", connectHost, connectHost)
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatalf("read CONNECT response: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200 for an allowed CONNECT, got %d", resp.StatusCode)
+	}
+
+	tlsConn := tls.Client(conn, &tls.Config{ServerName: serverName, InsecureSkipVerify: true})
+	if err := tlsConn.Handshake(); err != nil {
+		return "", err
+	}
+	fmt.Fprintf(tlsConn, "GET / HTTP/1.1\r\nHost: %s\r\nConnection: close\r\n\r\n", serverName)
+	tunneled, err := http.ReadResponse(bufio.NewReader(tlsConn), nil)
+	if err != nil {
+		return "", err
+	}
+	defer tunneled.Body.Close()
+	body, _ := io.ReadAll(tunneled.Body)
+	return string(body), nil
+}
+
+func TestProxyCONNECTSNI(t *testing.T) {
+	backend := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("tls-ok"))
+	}))
+	defer backend.Close()
+	backendURL, _ := url.Parse(backend.URL)
+
+	p, err := NewProxy(map[string]string{"localhost": "always", "127.0.0.1": "always"}, "test")
+	if err != nil {
+		t.Fatalf("NewProxy failed: %v", err)
+	}
+	defer p.Shutdown()
+	auditPath := filepath.Join(t.TempDir(), "audit.log")
+	if err := p.SetAuditLog(AuditConfig{Path: auditPath}); err != nil {
+		t.Fatal(err)
+	}
+	p.Start()
+
+	body, err := tunnelTLS(t, p, net.JoinHostPort("localhost", backendURL.Port()), "localhost")
+	if err != nil || body != "tls-ok" {
+		t.Errorf("matching SNI: got %q, %v; want tls-ok", body, err)
+	}
+
+	if _, err := tunnelTLS(t, p, backendURL.Host, "evil.example.com"); err == nil {
+		t.Error("expected the tunnel to be closed when SNI names another host")
+	}
+	if blocked := p.DeniedDomains(); len(blocked) != 1 || blocked[0] != "127.0.0.1" {
+		t.Errorf("DeniedDomains = %v, want [127.0.0.1]", blocked)
+	}
+
+	// Each tunnel is one decision: the refused one isn't also an allow
+	if st := p.Stats(); st.Allowed != 1 || st.Denied != 1 {
+		t.Errorf("Stats = %d allowed, %d denied; want 1 and 1", st.Allowed, st.Denied)
+	}
+	log, _ := os.ReadFile(auditPath)
+	if lines := strings.Split(strings.TrimSpace(string(log)), "\n"); len(lines) != 2 || !strings.HasSuffix(lines[1], "blocked (SNI evil.example.com)") {
+		t.Errorf("audit log = %q, want an allow and the SNI refusal", log)
+	}
+}
+
+func TestProxyStrictSNI(t *testing.T) {
+	backend := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("tls-ok"))
+	}))
+	defer backend.Close()
+	backendURL, _ := url.Parse(backend.URL)
+	connectHost := net.JoinHostPort("localhost", backendURL.Port())
+
+	p, err := NewProxy(map[string]string{"localhost": "always"}, "test")
+	if err != nil {
+		t.Fatalf("NewProxy failed: %v", err)
+	}
+	defer p.Shutdown()
+	p.Start()
+
+	// Lenient by default: a ClientHello without a server name passes
+	if body, err := tunnelTLS(t, p, connectHost, ""); err != nil || body != "tls-ok" {
+		t.Errorf("no SNI, default mode: got %q, %v; want tls-ok", body, err)
+	}
+
+	p.SetStrictSNI(true)
+	if _, err := tunnelTLS(t, p, connectHost, ""); err == nil {
+		t.Error("strict mode should close a tunnel whose ClientHello has no server name")
+	}
+	if body, err := tunnelTLS(t, p, connectHost, "localhost"); err != nil || body != "tls-ok" {
+		t.Errorf("matching SNI, strict mode: got %q, %v; want tls-ok", body, err)
+	}
+}
@@ -2,19 +2,26 @@ package cmd
 
 import (
 	"bufio"
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"sort"
 	"strings"
+	"time"
 )
 
 const traceUsage = `Trace a command's access and suggest a sandbox policy
 
 Usage:
   ddash trace [flags] -- <command> [args...]
+  ddash trace --from <dump> [flags]
 
 Runs the command with full permissions while monitoring what it accesses.
 After the command exits, ddash summarizes the access and suggests a
@@ -24,15 +31,108 @@ Examples:
   ddash trace -- python train.py
   ddash trace -- npm run build
   ddash trace --save -- ./my-script.sh    Auto-save suggested config
+  ddash trace --root ../.. -- npm test    Root the policy at the repo, not cwd
+  ddash trace --dump raw.json -- make     Keep the raw access data
+  ddash trace --from raw.json             Re-suggest from a dump, no re-run
+  ddash trace --runs 3 -- make test       Keep only access seen in every run
+  ddash trace --verify -- make            Re-run under the suggestion to test it
+  ddash trace --exclude '*.log' -- make   Leave logs out of the suggestion
 
 Flags:
   --save        Automatically save the suggested config to .ddash.json
+  --root <dir>  Project root for the suggested policy (default: cwd).
+                Writes under it collapse to "."; .ddash.json is saved there
+  --dump <file> Write the raw captured access (every read, write and
+                network host with counts) as JSON
+  --from <file> Analyze a previous --dump instead of running a command
+  --runs <n>    Run the command n times; suggest only network hosts and
+                writes seen in enough runs (reads are combined). Piped
+                stdin is read up front and replayed to every run
+  --quorum <m>  With --runs, how many runs must see an entry (default: all)
+  --verify      Run the command again under the suggested policy and
+                report whether it succeeds or hits denials. With --save,
+                a policy that fails verification is not saved
+  --exclude <glob>
+                Leave file access matching <glob> out of the summary and
+                suggestion (repeatable). A glob without '/' matches any
+                path element ("*.log"); one with '/' matches the path,
+                absolute or relative to --root, or a directory above it
+  --no-default-excludes
+                Keep what is excluded by default: __pycache__, *.pyc,
+                .pytest_cache, .mypy_cache, .ruff_cache and .DS_Store
+  --sandbox-exec <path>
+                Use this sandbox-exec binary instead of the one on PATH
+                (default: $DDASH_SANDBOX_EXEC if set)
   -h, --help    Show help`
 
+const initFromTraceUsage = `Trace a command and save the suggested policy as .ddash.json
+
+Usage:
+  ddash init-from-trace [flags] -- <command> [args...]
+
+Runs the command once with full permissions, like 'ddash trace', then
+writes the minimal suggested policy to .ddash.json without asking and
+prints it. Access 'ddash trace' excludes by default (__pycache__ and other
+caches) is left out here too. The fast way to get a working config for a
+new project: review the printed policy, then use 'ddash run'.
+
+Examples:
+  ddash init-from-trace -- npm test
+  ddash init-from-trace --root ../.. -- make    Save at the repo root
+
+Flags:
+  --root <dir>  Project root for the policy (default: cwd). Writes under
+                it collapse to "."; .ddash.json is saved there
+  -h, --help    Show help`
+
+type traceFlags struct {
+	save        bool
+	root        string
+	dump        string
+	from        string
+	runs        int
+	quorum      int
+	verify      bool
+	sandboxExec string
+	excludes    []string
+	noDefaults  bool
+}
+
 type accessLog struct {
 	netOut     map[string]int
 	fileReads  map[string]int
 	fileWrites map[string]int
+
+	// excluded counts the file accesses dropped by excludeAccess.
+	excluded int
+
+	// processes breaks the access down by the process that made it, for
+	// trace lines that name one ("curl(4242)"); nil entries are not kept.
+	processes map[string]*processAccess
+}
+
+// processAccess is what one process (by name, across pids) accessed.
+// Reads and Writes key the operations by path, as in the log's own file
+// maps, so excludeAccess can take them out here too.
+type processAccess struct {
+	NetOut     map[string]int `json:"net_out,omitempty"` // host -> connections
+	FileReads  int            `json:"file_reads"`        // read operations
+	FileWrites int            `json:"file_writes"`       // write operations
+	Reads      map[string]int `json:"reads,omitempty"`   // path -> read operations
+	Writes     map[string]int `json:"writes,omitempty"`  // path -> write operations
+}
+
+// process returns the entry for name, creating it on first use.
+func (l *accessLog) process(name string) *processAccess {
+	if l.processes == nil {
+		l.processes = make(map[string]*processAccess)
+	}
+	pa := l.processes[name]
+	if pa == nil {
+		pa = &processAccess{NetOut: make(map[string]int), Reads: make(map[string]int), Writes: make(map[string]int)}
+		l.processes[name] = pa
+	}
+	return pa
 }
 
 func traceCmd() error {
@@ -41,36 +141,296 @@ func traceCmd() error {
 		return nil
 	}
 
-	autoSave := false
-	cmdStart := -1
+	flags, command, err := parseTraceArgs(os.Args[2:])
+	if errors.Is(err, flag.ErrHelp) {
+		fmt.Println(traceUsage)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if command == nil && flags.from == "" {
+		fmt.Println(traceUsage)
+		return fmt.Errorf("no command specified; use -- before the command")
+	}
+
+	root := flags.root
+	var log *accessLog
+	if flags.from != "" {
+		dump, err := loadAccessDump(flags.from)
+		if err != nil {
+			return err
+		}
+		log = dump.accessLog()
+		if root == "" {
+			root = dump.Root
+		}
+		fmt.Fprintf(os.Stderr, "ddash: analyzing recorded trace %s\n\n", flags.from)
+	}
+
+	cwd, _ := os.Getwd()
+	if root == "" {
+		root = cwd
+	} else {
+		abs, err := filepath.Abs(root)
+		if err != nil {
+			return fmt.Errorf("invalid --root %s: %w", root, err)
+		}
+		if info, err := os.Stat(abs); err != nil || !info.IsDir() {
+			return fmt.Errorf("--root %s is not a directory", root)
+		}
+		root = abs
+	}
 
-	for i := 2; i < len(os.Args); i++ {
-		switch os.Args[i] {
-		case "--save":
-			autoSave = true
-		case "-h", "--help":
-			fmt.Println(traceUsage)
-			return nil
-		case "--":
-			if i+1 < len(os.Args) {
-				cmdStart = i + 1
+	if log == nil {
+		// The first run would drain piped input and leave the others EOF,
+		// so they'd be tracing different behavior; each gets the same
+		// copy instead. A terminal is shared as is.
+		var input []byte
+		if flags.runs > 1 && !isTerminal(os.Stdin) {
+			if input, err = io.ReadAll(os.Stdin); err != nil {
+				return fmt.Errorf("failed to read stdin for --runs: %w", err)
 			}
 		}
-		if cmdStart != -1 {
-			break
+		var logs []*accessLog
+		for i := 1; i <= flags.runs; i++ {
+			if flags.runs > 1 {
+				fmt.Fprintf(os.Stderr, "ddash: run %d of %d\n", i, flags.runs)
+			}
+			stdin := io.Reader(os.Stdin)
+			if input != nil {
+				stdin = bytes.NewReader(input)
+			}
+			runLog, err := captureTrace(command, root, flags.sandboxExec, stdin)
+			if err != nil {
+				return err
+			}
+			logs = append(logs, runLog)
+		}
+		log = logs[0]
+		if flags.runs > 1 {
+			log = quorumLog(logs, flags.quorum)
+			fmt.Fprintf(os.Stderr, "ddash: kept network hosts and writes seen in at least %d of %d runs\n\n",
+				flags.quorum, flags.runs)
 		}
 	}
 
-	if cmdStart == -1 {
-		fmt.Println(traceUsage)
+	if flags.dump != "" {
+		if err := writeAccessDump(flags.dump, log, root); err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "ddash: raw access data written to %s\n", flags.dump)
+	}
+
+	// The dump keeps everything, so --from can be re-run with other excludes
+	excludes := flags.excludes
+	if !flags.noDefaults {
+		excludes = append(excludes, defaultTraceExcludes...)
+	}
+	excludeAccess(log, root, excludes)
+
+	// Print summary
+	printTraceSummary(log, root)
+
+	// Suggest config
+	cfg := suggestConfig(log, root)
+	savePath := filepath.Join(root, configPath())
+
+	fmt.Fprintf(os.Stderr, "\nSuggested .ddash.json:\n")
+	data, _ := json.MarshalIndent(cfg, "  ", "  ")
+	fmt.Fprintf(os.Stderr, "  %s\n", string(data))
+
+	if err := writeLastTrace(root, command, cfg); err != nil {
+		fmt.Fprintf(os.Stderr, "ddash: %v\n", err)
+	} else {
+		fmt.Fprintf(os.Stderr, "\nTry it without saving: ddash run --use-trace -- <command>\n")
+	}
+
+	if flags.verify {
+		fmt.Fprintf(os.Stderr, "\nddash: verifying the suggested policy\n\n")
+		result, err := verifySuggestion(cfg, root, command, flags.sandboxExec)
+		if err != nil {
+			return err
+		}
+		if !reportVerification(os.Stderr, result) && flags.save {
+			return fmt.Errorf("suggested policy failed verification; %s not saved", savePath)
+		}
+	}
+
+	if flags.save {
+		return saveConfig(cfg, savePath)
+	}
+
+	// Prompt to save
+	fmt.Fprintf(os.Stderr, "\nSave this config? [Y/n] ")
+	reader := bufio.NewReader(os.Stdin)
+	answer, _ := reader.ReadString('\n')
+	answer = strings.TrimSpace(strings.ToLower(answer))
+
+	if answer == "" || answer == "y" || answer == "yes" {
+		return saveConfig(cfg, savePath)
+	}
+
+	fmt.Fprintf(os.Stderr, "Config not saved.\n")
+	return nil
+}
+
+func initFromTraceCmd() error {
+	var root string
+	flagArgs, command := splitCommand(os.Args[2:])
+	fs := newFlagSet("init-from-trace")
+	fs.StringVar(&root, "root", "", "")
+	err := fs.Parse(flagArgs)
+	if errors.Is(err, flag.ErrHelp) {
+		fmt.Println(initFromTraceUsage)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return fmt.Errorf("unknown flag: %s\nUse -- before the command, e.g.: ddash init-from-trace -- %s", fs.Arg(0), fs.Arg(0))
+	}
+	if command == nil {
+		fmt.Println(initFromTraceUsage)
 		return fmt.Errorf("no command specified; use -- before the command")
 	}
 
-	args := os.Args[cmdStart:]
+	if root == "" {
+		root, _ = os.Getwd()
+	}
+	root, err = filepath.Abs(root)
+	if err != nil {
+		return fmt.Errorf("invalid --root: %w", err)
+	}
+	if info, err := os.Stat(root); err != nil || !info.IsDir() {
+		return fmt.Errorf("--root %s is not a directory", root)
+	}
+
+	log, err := captureTrace(command, root, "", os.Stdin)
+	if err != nil {
+		return err
+	}
+	excludeAccess(log, root, defaultTraceExcludes)
+	printTraceSummary(log, root)
+	fmt.Fprintln(os.Stderr)
+	return initFromLog(os.Stdout, log, root, command)
+}
+
+// initFromLog saves the policy suggested by log as root's .ddash.json and
+// prints the saved file to w.
+func initFromLog(w io.Writer, log *accessLog, root string, command []string) error {
+	cfg := suggestConfig(log, root)
+	path := filepath.Join(root, configPath())
+	if err := saveConfig(cfg, path); err != nil {
+		return err
+	}
+	if err := writeLastTrace(root, command, cfg); err != nil {
+		fmt.Fprintf(os.Stderr, "ddash: %v\n", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	w.Write(data)
+	return nil
+}
+
+// verifySuggestion runs command again, this time under the suggested
+// policy cfg, collecting sandbox denials.
+func verifySuggestion(cfg SandboxConfig, root string, command []string, sandboxExec string) (ExitResult, error) {
+	opts := RunOptions{LogDenials: true, ForwardSignals: true, SandboxExec: sandboxExec}
+	cwd, _ := os.Getwd()
+	if root != cwd {
+		// Policy paths are relative to root, but the command was traced in
+		// cwd: resolve the one and run the other
+		if err := os.Chdir(root); err != nil {
+			return ExitResult{}, err
+		}
+		defer os.Chdir(cwd)
+		opts.Dir = cwd
+	}
+	return Run(context.Background(), cfg, command, opts)
+}
+
+// reportVerification tells w how the command fared under the suggested
+// policy and reports whether it passed. The permissive trace run saw no
+// denials, so every denial listed here is one the suggestion introduced.
+func reportVerification(w io.Writer, result ExitResult) bool {
+	if result.ExitCode == 0 && len(result.Denials) == 0 {
+		fmt.Fprintf(w, "\nddash: verify: the suggested policy works (exit 0, no denials)\n")
+		return true
+	}
+	if result.ExitCode == 0 {
+		fmt.Fprintf(w, "\nddash: verify: the command succeeded under the suggested policy, but hit denials\n")
+	} else {
+		fmt.Fprintf(w, "\nddash: verify: the command failed under the suggested policy (exit %d)\n", result.ExitCode)
+	}
+	printDenials(w, result.Denials)
+	if len(result.Denials) > 0 {
+		fmt.Fprintf(w, "ddash: widen allow_read, allow_write or allow_net to cover these before saving\n")
+	}
+	return false
+}
+
+// parseTraceArgs parses the flags of 'ddash trace' (args excludes
+// "ddash trace") and returns the child command that follows "--". It
+// returns flag.ErrHelp for -h/--help.
+func parseTraceArgs(args []string) (traceFlags, []string, error) {
+	var flags traceFlags
+	flagArgs, command := splitCommand(args)
+
+	fs := newFlagSet("trace")
+	fs.BoolVar(&flags.save, "save", false, "")
+	fs.StringVar(&flags.root, "root", "", "")
+	fs.StringVar(&flags.dump, "dump", "", "")
+	fs.StringVar(&flags.from, "from", "", "")
+	fs.IntVar(&flags.runs, "runs", 1, "")
+	fs.IntVar(&flags.quorum, "quorum", 0, "")
+	fs.BoolVar(&flags.verify, "verify", false, "")
+	fs.StringVar(&flags.sandboxExec, "sandbox-exec", "", "")
+	fs.Var((*stringList)(&flags.excludes), "exclude", "")
+	fs.BoolVar(&flags.noDefaults, "no-default-excludes", false, "")
+
+	if err := fs.Parse(flagArgs); err != nil {
+		return flags, nil, err
+	}
+	if fs.NArg() > 0 {
+		return flags, nil, fmt.Errorf("unknown flag: %s\nUse -- before the command, e.g.: ddash trace -- %s", fs.Arg(0), fs.Arg(0))
+	}
+
+	if flags.runs < 1 {
+		return flags, nil, fmt.Errorf("--runs must be at least 1")
+	}
+	if flags.quorum == 0 {
+		flags.quorum = flags.runs
+	}
+	if flags.quorum < 1 || flags.quorum > flags.runs {
+		return flags, nil, fmt.Errorf("--quorum must be between 1 and --runs (%d)", flags.runs)
+	}
+	if flags.runs > 1 && flags.from != "" {
+		return flags, nil, fmt.Errorf("--runs can't be combined with --from")
+	}
+	if flags.verify && command == nil {
+		return flags, nil, fmt.Errorf("--verify needs the command to re-run after --")
+	}
+	for _, pattern := range flags.excludes {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return flags, nil, fmt.Errorf("invalid --exclude pattern %q: %w", pattern, err)
+		}
+	}
+	return flags, command, nil
+}
 
+// captureTrace runs args permissively under sandbox-exec and returns the
+// access it observed. root is the project root for the suggested policy;
+// sandboxExec overrides the sandbox-exec binary (see findSandboxExec);
+// stdin is the command's input.
+func captureTrace(args []string, root, sandboxExec string, stdin io.Reader) (*accessLog, error) {
 	binary, err := exec.LookPath(args[0])
 	if err != nil {
-		return fmt.Errorf("command not found: %s", args[0])
+		return nil, fmt.Errorf("command not found: %s", redactSecrets(args[0]))
 	}
 
 	// Generate a trace profile that allows everything but logs denials
@@ -80,13 +440,13 @@ func traceCmd() error {
 	// Create a temp file for the sandbox trace log
 	logFile, err := os.CreateTemp("", "ddash-trace-*.log")
 	if err != nil {
-		return fmt.Errorf("failed to create trace log: %w", err)
+		return nil, fmt.Errorf("failed to create trace log: %w", err)
 	}
 	logPath := logFile.Name()
 	logFile.Close()
 	defer os.Remove(logPath)
 
-	fmt.Fprintf(os.Stderr, "ddash: tracing %s (all access allowed, logging to %s)\n\n", args[0], logPath)
+	fmt.Fprintf(os.Stderr, "ddash: tracing %s (all access allowed, logging to %s)\n\n", redactSecrets(args[0]), logPath)
 
 	// Run with a permissive profile but log file access via dtrace-style approach
 	// Since sandbox-exec trace output goes to syslog, we'll use a different approach:
@@ -94,61 +454,220 @@ func traceCmd() error {
 	cmdArgs := []string{"-p", traceProfile, binary}
 	cmdArgs = append(cmdArgs, args[1:]...)
 
-	sandboxExec, err := exec.LookPath("sandbox-exec")
+	sandboxExec, err = findSandboxExec(os.Stderr, sandboxExec)
 	if err != nil {
-		return fmt.Errorf("sandbox-exec not found")
+		return nil, err
 	}
 
 	// First, run the actual command with sandbox-exec in permissive trace mode
-	cmd := exec.Command(sandboxExec, cmdArgs...)
-	cmd.Stdin = os.Stdin
+	cmd := execCommand(context.Background(), sandboxExec, cmdArgs...)
+	cmd.Stdin = stdin
 	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+	var flushStderr func()
+	cmd.Stderr, flushStderr = stderrFilter(os.Stderr)
 	cmd.Env = append(os.Environ(), "SANDBOX_LOG_FILE="+logPath)
 
+	// Parse the trace log while the command runs
+	done := make(chan struct{})
+	parsed := followTrace(logPath, done)
+
 	runErr := cmd.Run()
+	flushStderr()
+	close(done)
 
 	fmt.Fprintf(os.Stderr, "\n")
 
 	if runErr != nil {
-		fmt.Fprintf(os.Stderr, "ddash: command exited with error: %v\n\n", runErr)
+		if err := checkProfile(os.Stderr, sandboxExec, traceProfile); err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(os.Stderr, "ddash: command exited with error: %s\n\n", redactSecrets(runErr.Error()))
 	}
 
-	// Analyze the sandbox trace log
-	log := analyzeTrace(logPath)
+	log := <-parsed
 
 	// Also do a basic analysis based on the command itself
-	cwd, _ := os.Getwd()
-	enrichFromCommand(log, args, cwd)
+	enrichFromCommand(log, args, root)
 
-	// Print summary
-	printTraceSummary(log, cwd)
+	return log, nil
+}
 
-	// Suggest config
-	cfg := suggestConfig(log, cwd)
+// quorumLog merges the logs of repeated runs of the same command. Network
+// hosts and written files are kept only if at least min runs saw them, so
+// incidental access (random temp files, a one-off telemetry ping) drops
+// out of the suggested policy. Reads are combined from all runs, since
+// missing one makes the command fail. Counts are summed across runs.
+func quorumLog(logs []*accessLog, min int) *accessLog {
+	merged := &accessLog{
+		netOut:     quorum(logs, min, func(l *accessLog) map[string]int { return l.netOut }),
+		fileReads:  quorum(logs, 1, func(l *accessLog) map[string]int { return l.fileReads }),
+		fileWrites: quorum(logs, min, func(l *accessLog) map[string]int { return l.fileWrites }),
+	}
+	// The per-process view is informational, so every run counts
+	for _, l := range logs {
+		for name, pa := range l.processes {
+			into := merged.process(name)
+			for host, n := range pa.NetOut {
+				into.NetOut[host] += n
+			}
+			into.FileReads += pa.FileReads
+			into.FileWrites += pa.FileWrites
+			for path, n := range pa.Reads {
+				into.Reads[path] += n
+			}
+			for path, n := range pa.Writes {
+				into.Writes[path] += n
+			}
+		}
+	}
+	return merged
+}
 
-	fmt.Fprintf(os.Stderr, "\nSuggested .ddash.json:\n")
-	data, _ := json.MarshalIndent(cfg, "  ", "  ")
-	fmt.Fprintf(os.Stderr, "  %s\n", string(data))
+// quorum sums the counts of one category of logs, keeping entries that
+// appear in at least min of them.
+func quorum(logs []*accessLog, min int, category func(*accessLog) map[string]int) map[string]int {
+	seen := make(map[string]int)
+	total := make(map[string]int)
+	for _, l := range logs {
+		for entry, count := range category(l) {
+			seen[entry]++
+			total[entry] += count
+		}
+	}
 
-	if autoSave {
-		return saveConfig(cfg)
+	result := make(map[string]int)
+	for entry, runs := range seen {
+		if runs >= min {
+			result[entry] = total[entry]
+		}
 	}
+	return result
+}
 
-	// Prompt to save
-	fmt.Fprintf(os.Stderr, "\nSave this config? [Y/n] ")
-	reader := bufio.NewReader(os.Stdin)
-	answer, _ := reader.ReadString('\n')
-	answer = strings.TrimSpace(strings.ToLower(answer))
+// accessDump is the on-disk form of an accessLog written by --dump, so
+// policy synthesis can be re-run later without re-executing the command.
+type accessDump struct {
+	Root       string                    `json:"root"`
+	NetOut     map[string]int            `json:"net_out"`
+	FileReads  map[string]int            `json:"file_reads"`
+	FileWrites map[string]int            `json:"file_writes"`
+	Processes  map[string]*processAccess `json:"processes,omitempty"`
+}
 
-	if answer == "" || answer == "y" || answer == "yes" {
-		return saveConfig(cfg)
+func (d accessDump) accessLog() *accessLog {
+	log := &accessLog{
+		netOut:     make(map[string]int),
+		fileReads:  make(map[string]int),
+		fileWrites: make(map[string]int),
 	}
+	for k, v := range d.NetOut {
+		log.netOut[k] = v
+	}
+	for k, v := range d.FileReads {
+		log.fileReads[k] = v
+	}
+	for k, v := range d.FileWrites {
+		log.fileWrites[k] = v
+	}
+	for name, pa := range d.Processes {
+		if pa == nil {
+			continue
+		}
+		into := log.process(name)
+		for host, n := range pa.NetOut {
+			into.NetOut[host] = n
+		}
+		into.FileReads, into.FileWrites = pa.FileReads, pa.FileWrites
+		for path, n := range pa.Reads {
+			into.Reads[path] = n
+		}
+		for path, n := range pa.Writes {
+			into.Writes[path] = n
+		}
+	}
+	return log
+}
 
-	fmt.Fprintf(os.Stderr, "Config not saved.\n")
+func writeAccessDump(path string, log *accessLog, root string) error {
+	dump := accessDump{
+		Root:       root,
+		NetOut:     log.netOut,
+		FileReads:  log.fileReads,
+		FileWrites: log.fileWrites,
+		Processes:  log.processes,
+	}
+	data, err := json.MarshalIndent(dump, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal trace dump: %w", err)
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write trace dump: %w", err)
+	}
+	return nil
+}
+
+func loadAccessDump(path string) (accessDump, error) {
+	var dump accessDump
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return dump, fmt.Errorf("failed to read trace dump: %w", err)
+	}
+	if err := json.Unmarshal(data, &dump); err != nil {
+		return dump, fmt.Errorf("failed to parse trace dump: %w", err)
+	}
+	return dump, nil
+}
+
+// lastTracePath is where trace caches its suggestion, relative to the
+// project root, for 'ddash run --use-trace'.
+var lastTracePath = filepath.Join(".ddash", "last-trace.json")
+
+// lastTrace is the cached suggestion of the most recent trace.
+type lastTrace struct {
+	TracedAt string        `json:"traced_at"`
+	Command  string        `json:"command"`
+	Root     string        `json:"root"`
+	Config   SandboxConfig `json:"config"`
+}
+
+// writeLastTrace caches cfg, suggested for command, under root.
+func writeLastTrace(root string, command []string, cfg SandboxConfig) error {
+	path := filepath.Join(root, lastTracePath)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("failed to cache trace: %w", err)
+	}
+	data, err := json.MarshalIndent(lastTrace{
+		TracedAt: time.Now().UTC().Format(time.RFC3339),
+		Command:  redactSecrets(strings.Join(command, " ")),
+		Root:     root,
+		Config:   cfg,
+	}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal trace cache: %w", err)
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0600); err != nil {
+		return fmt.Errorf("failed to cache trace: %w", err)
+	}
 	return nil
 }
 
+// readLastTrace loads the suggestion cached under dir.
+func readLastTrace(dir string) (lastTrace, error) {
+	var trace lastTrace
+	path := filepath.Join(dir, lastTracePath)
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return trace, fmt.Errorf("no cached trace in %s; run 'ddash trace -- <command>' first", lastTracePath)
+	}
+	if err != nil {
+		return trace, fmt.Errorf("failed to read cached trace: %w", err)
+	}
+	if err := json.Unmarshal(data, &trace); err != nil {
+		return trace, fmt.Errorf("failed to parse cached trace %s: %w", path, err)
+	}
+	return trace, nil
+}
+
 func generateTraceProfile() string {
 	var sb strings.Builder
 	sb.WriteString("(version 1)\n")
@@ -158,41 +677,182 @@ func generateTraceProfile() string {
 	return sb.String()
 }
 
-func analyzeTrace(logPath string) *accessLog {
-	log := &accessLog{
-		netOut:     make(map[string]int),
-		fileReads:  make(map[string]int),
-		fileWrites: make(map[string]int),
+// maxTraceEntriesPerDir caps how many distinct files are tracked per
+// directory. Beyond it, further files collapse into a "dir/*" entry, so a
+// big build churning through object files can't grow the maps without
+// bound. suggestConfig works on directories, so nothing it needs is lost.
+const maxTraceEntriesPerDir = 256
+
+// traceFollowInterval is how often the trace log is polled for new lines
+// while the traced command is still running.
+const traceFollowInterval = 100 * time.Millisecond
+
+// traceAggregator builds an accessLog one trace line at a time.
+type traceAggregator struct {
+	log       *accessLog
+	readDirs  map[string]int // dir -> distinct files tracked in fileReads
+	writeDirs map[string]int // dir -> distinct files tracked in fileWrites
+}
+
+func newTraceAggregator() *traceAggregator {
+	return &traceAggregator{
+		log: &accessLog{
+			netOut:     make(map[string]int),
+			fileReads:  make(map[string]int),
+			fileWrites: make(map[string]int),
+		},
+		readDirs:  make(map[string]int),
+		writeDirs: make(map[string]int),
 	}
+}
 
-	data, err := os.ReadFile(logPath)
-	if err != nil {
-		return log
+// add parses one sandbox trace log line.
+func (a *traceAggregator) add(line string) {
+	if strings.Contains(line, "file-read") {
+		if path := extractPath(line); path != "" {
+			key := addCapped(a.log.fileReads, a.readDirs, path)
+			if name := traceProcess(line); name != "" {
+				pa := a.log.process(name)
+				pa.FileReads++
+				pa.Reads[key]++
+			}
+		}
+	} else if strings.Contains(line, "file-write") {
+		if path := extractPath(line); path != "" {
+			key := addCapped(a.log.fileWrites, a.writeDirs, path)
+			if name := traceProcess(line); name != "" {
+				pa := a.log.process(name)
+				pa.FileWrites++
+				pa.Writes[key]++
+			}
+		}
+	} else if strings.Contains(line, "network-outbound") {
+		if host := extractHost(line); host != "" {
+			a.log.netOut[host]++
+			if name := traceProcess(line); name != "" {
+				a.log.process(name).NetOut[host]++
+			}
+		}
 	}
+}
 
-	for _, line := range strings.Split(string(data), "\n") {
-		line = strings.TrimSpace(line)
-		if line == "" {
+// traceProcess returns the name of the process a trace line is about,
+// from a "name(pid)" field before the target, as in
+//
+//	Sandbox: curl(4242) allow network-outbound "registry.npmjs.org"
+//
+// It returns "" for lines that name no process.
+func traceProcess(line string) string {
+	head := line
+	if quote := strings.IndexByte(line, '"'); quote >= 0 {
+		head = line[:quote]
+	}
+	if strings.IndexByte(head, '(') < 0 {
+		return ""
+	}
+	for _, field := range strings.Fields(head) {
+		open := strings.IndexByte(field, '(')
+		if open <= 0 || !strings.HasSuffix(field, ")") {
 			continue
 		}
+		pid := field[open+1 : len(field)-1]
+		if pid == "" || strings.Trim(pid, "0123456789") != "" {
+			continue
+		}
+		// "deny(1)" and "allow(1)" carry a count, not a pid
+		if name := field[:open]; name != "deny" && name != "allow" {
+			return name
+		}
+	}
+	return ""
+}
 
-		// Parse sandbox trace log lines
-		if strings.Contains(line, "file-read") {
-			if path := extractPath(line); path != "" {
-				log.fileReads[path]++
-			}
-		} else if strings.Contains(line, "file-write") {
-			if path := extractPath(line); path != "" {
-				log.fileWrites[path]++
-			}
-		} else if strings.Contains(line, "network-outbound") {
-			if host := extractHost(line); host != "" {
-				log.netOut[host]++
-			}
+// addCapped counts path in counts, collapsing it into "dir/*" once its
+// directory already has maxTraceEntriesPerDir distinct entries. It
+// returns the key the access was counted under.
+func addCapped(counts, dirs map[string]int, path string) string {
+	if _, ok := counts[path]; ok {
+		counts[path]++
+		return path
+	}
+	dir := filepath.Dir(path)
+	if dirs[dir] >= maxTraceEntriesPerDir {
+		key := filepath.Join(dir, "*")
+		counts[key]++
+		return key
+	}
+	dirs[dir]++
+	counts[path] = 1
+	return path
+}
+
+// analyzeTrace parses a finished sandbox trace log.
+func analyzeTrace(logPath string) *accessLog {
+	f, err := os.Open(logPath)
+	if err != nil {
+		return newTraceAggregator().log
+	}
+	defer f.Close()
+	return analyzeTraceReader(f)
+}
+
+// analyzeTraceReader streams trace lines from r, so memory use depends on
+// the distinct entries seen, not on the size of the log.
+func analyzeTraceReader(r io.Reader) *accessLog {
+	agg := newTraceAggregator()
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" {
+			agg.add(line)
 		}
 	}
+	return agg.log
+}
 
-	return log
+// followTrace parses the trace log at logPath while the traced command is
+// still writing it, so no post-processing pass is left once it exits.
+// Close done when the command has exited; the result is sent after the
+// remaining lines are parsed.
+func followTrace(logPath string, done <-chan struct{}) <-chan *accessLog {
+	result := make(chan *accessLog, 1)
+	go func() {
+		f, err := os.Open(logPath)
+		if err != nil {
+			result <- newTraceAggregator().log
+			return
+		}
+		defer f.Close()
+		result <- analyzeTraceReader(&followReader{f: f, done: done})
+	}()
+	return result
+}
+
+// followReader reads a growing file like tail -f. At end of file it waits
+// for more data until done is closed, then drains what is left.
+type followReader struct {
+	f    *os.File
+	done <-chan struct{}
+}
+
+func (r *followReader) Read(p []byte) (int, error) {
+	for {
+		n, err := r.f.Read(p)
+		if n > 0 || err != io.EOF {
+			return n, err
+		}
+		select {
+		case <-r.done:
+			// The writer has exited; one last read picks up its final lines
+			n, err := r.f.Read(p)
+			if n > 0 {
+				return n, nil
+			}
+			return 0, err
+		case <-time.After(traceFollowInterval):
+		}
+	}
 }
 
 func extractPath(line string) string {
@@ -216,9 +876,11 @@ func extractHost(line string) string {
 	return ""
 }
 
-func enrichFromCommand(log *accessLog, args []string, cwd string) {
-	// Add the cwd as a known read path
-	log.fileReads[cwd]++
+// enrichFromCommand records access implied by the command line itself.
+// root is the project root the policy is suggested for.
+func enrichFromCommand(log *accessLog, args []string, root string) {
+	// Add the project root as a known read path
+	log.fileReads[root]++
 
 	// If the command is a script, note its path
 	if len(args) > 1 {
@@ -262,29 +924,140 @@ func printTraceSummary(log *accessLog, cwd string) {
 		}
 		fmt.Fprintf(os.Stderr, "  File writes: %d (%s)\n", len(writePaths), strings.Join(displayed, ", "))
 	}
+
+	switch log.excluded {
+	case 0:
+	case 1:
+		fmt.Fprintf(os.Stderr, "  Excluded:    1 file access (matched an exclude pattern)\n")
+	default:
+		fmt.Fprintf(os.Stderr, "  Excluded:    %d file accesses (matched exclude patterns)\n", log.excluded)
+	}
+
+	if len(log.processes) > 0 {
+		fmt.Fprintf(os.Stderr, "  By process:\n")
+		for _, line := range processSummary(log) {
+			fmt.Fprintf(os.Stderr, "    %s\n", line)
+		}
+	}
+}
+
+// processSummary describes each process's access in one line, e.g.
+// "curl: 3 network hosts" or "python3: 12 file reads, 2 file writes".
+func processSummary(log *accessLog) []string {
+	var lines []string
+	for _, name := range sortedKeys(log.processes) {
+		pa := log.processes[name]
+		var parts []string
+		if n := len(pa.NetOut); n > 0 {
+			parts = append(parts, plural(n, "network host"))
+		}
+		if pa.FileReads > 0 {
+			parts = append(parts, plural(pa.FileReads, "file read"))
+		}
+		if pa.FileWrites > 0 {
+			parts = append(parts, plural(pa.FileWrites, "file write"))
+		}
+		lines = append(lines, name+": "+strings.Join(parts, ", "))
+	}
+	return lines
+}
+
+// plural formats n and noun, adding an s unless n is 1.
+func plural(n int, noun string) string {
+	if n == 1 {
+		return "1 " + noun
+	}
+	return fmt.Sprintf("%d %ss", n, noun)
 }
 
-func suggestConfig(log *accessLog, cwd string) SandboxConfig {
+// defaultTraceExcludes are left out of every trace unless
+// --no-default-excludes is given: bytecode and tool caches that commands
+// write as a side effect and run fine without.
+var defaultTraceExcludes = []string{"__pycache__", "*.pyc", ".pytest_cache", ".mypy_cache", ".ruff_cache", ".DS_Store"}
+
+// excludeAccess drops the file reads and writes in log that match one of
+// patterns (see excludedPath), adding how many accesses that removed to
+// log.excluded. Network hosts are kept.
+func excludeAccess(log *accessLog, root string, patterns []string) {
+	if len(patterns) == 0 {
+		return
+	}
+	log.excluded += dropExcluded(log.fileReads, root, patterns)
+	log.excluded += dropExcluded(log.fileWrites, root, patterns)
+	// The "By process" summary must not count what was left out above
+	for name, pa := range log.processes {
+		pa.FileReads -= dropExcluded(pa.Reads, root, patterns)
+		pa.FileWrites -= dropExcluded(pa.Writes, root, patterns)
+		if len(pa.NetOut) == 0 && pa.FileReads <= 0 && pa.FileWrites <= 0 {
+			delete(log.processes, name)
+		}
+	}
+}
+
+// dropExcluded deletes the paths in counts that match patterns and
+// returns how many accesses they had.
+func dropExcluded(counts map[string]int, root string, patterns []string) int {
+	dropped := 0
+	for path, n := range counts {
+		if excludedPath(path, root, patterns) {
+			delete(counts, path)
+			dropped += n
+		}
+	}
+	return dropped
+}
+
+// excludedPath reports whether path matches one of the --exclude globs. A
+// glob without a '/' matches any single element of path, so "__pycache__"
+// covers everything beneath such a directory. One with a '/' matches path
+// or a directory above it, either absolute or relative to root.
+func excludedPath(path, root string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if !strings.Contains(pattern, "/") {
+			for _, elem := range strings.Split(path, "/") {
+				if ok, _ := filepath.Match(pattern, elem); ok && elem != "" {
+					return true
+				}
+			}
+			continue
+		}
+		for p := path; p != "/" && p != "."; p = filepath.Dir(p) {
+			if ok, _ := filepath.Match(pattern, p); ok {
+				return true
+			}
+			if rel, err := filepath.Rel(root, p); err == nil && !strings.HasPrefix(rel, "..") {
+				if ok, _ := filepath.Match(pattern, rel); ok {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// suggestConfig derives a minimal policy from traced access. Paths under
+// root collapse to "."; everything else stays absolute.
+func suggestConfig(log *accessLog, root string) SandboxConfig {
 	cfg := SandboxConfig{
-		Name:      filepath.Base(cwd),
+		Name:      filepath.Base(root),
 		Version:   Version,
-		Isolation: "process",
-		AllowNet:  []string{},
-		AllowRead: []string{"."},
+		Isolation: isolationProcess,
+		AllowNet:  []NetEntry{},
+		AllowRead: pathEntries("."),
 	}
 
 	// Suggest network if any was used
 	if len(log.netOut) > 0 {
 		hosts := sortedKeys(log.netOut)
-		cfg.AllowNet = hosts
+		cfg.AllowNet = netEntries(hosts...)
 	}
 
 	// Suggest write paths
 	writeDirs := make(map[string]bool)
 	for path := range log.fileWrites {
 		dir := filepath.Dir(path)
-		// Normalize to relative if under cwd
-		if rel, err := filepath.Rel(cwd, dir); err == nil && !strings.HasPrefix(rel, "..") {
+		// Normalize to relative if under root
+		if rel, err := filepath.Rel(root, dir); err == nil && !strings.HasPrefix(rel, "..") {
 			writeDirs["."] = true
 		} else if strings.HasPrefix(dir, "/tmp") || strings.HasPrefix(dir, "/private/tmp") {
 			// /tmp is allowed by default, skip
@@ -302,20 +1075,16 @@ func suggestConfig(log *accessLog, cwd string) SandboxConfig {
 	return cfg
 }
 
-func saveConfig(cfg SandboxConfig) error {
-	path := configPath()
+func saveConfig(cfg SandboxConfig, path string) error {
 	if _, err := os.Stat(path); err == nil {
 		fmt.Fprintf(os.Stderr, "Overwriting existing %s\n", path)
 	}
 
+	cfg = normalizeConfig(cfg)
 	cfg.CreatedAt = ""
-	data, err := json.MarshalIndent(cfg, "", "  ")
-	if err != nil {
-		return fmt.Errorf("failed to marshal config: %w", err)
-	}
-
-	if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil {
-		return fmt.Errorf("failed to write config: %w", err)
+	cfg.Checksum = computeChecksum(cfg)
+	if err := writeConfig(path, cfg); err != nil {
+		return err
 	}
 
 	fmt.Fprintf(os.Stderr, "Saved to %s\n", path)
@@ -341,7 +1110,7 @@ func categorizeFiles(files map[string]int, cwd string) (system, project int) {
 	return
 }
 
-func sortedKeys(m map[string]int) []string {
+func sortedKeys[V any](m map[string]V) []string {
 	keys := make([]string, 0, len(m))
 	for k := range m {
 		keys = append(keys, k)
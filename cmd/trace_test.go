@@ -0,0 +1,509 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newAccessLog() *accessLog {
+	return &accessLog{
+		netOut:     make(map[string]int),
+		fileReads:  make(map[string]int),
+		fileWrites: make(map[string]int),
+	}
+}
+
+func TestSuggestConfigRoot(t *testing.T) {
+	root := "/Users/mark/monorepo"
+
+	log := newAccessLog()
+	log.fileWrites[root+"/packages/web/dist/index.js"]++
+	log.fileWrites["/Users/mark/.cache/tool/blob"]++
+	log.fileWrites["/private/tmp/scratch"]++
+
+	cfg := suggestConfig(log, root)
+
+	if cfg.Name != "monorepo" {
+		t.Errorf("expected name from root basename, got %q", cfg.Name)
+	}
+	if len(cfg.AllowWrite) != 2 || cfg.AllowWrite[0] != "." || cfg.AllowWrite[1] != "/Users/mark/.cache/tool" {
+		t.Errorf("expected writes under root to collapse to '.', got %v", cfg.AllowWrite)
+	}
+}
+
+func TestTraceSavesConfigUnderRoot(t *testing.T) {
+	root := t.TempDir()
+	path := filepath.Join(root, configPath())
+
+	cfg := suggestConfig(newAccessLog(), root)
+	if err := saveConfig(cfg, path); err != nil {
+		t.Fatalf("saveConfig failed: %v", err)
+	}
+
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("expected config saved under root at %s: %v", path, err)
+	}
+}
+
+func TestAccessDumpRoundTrip(t *testing.T) {
+	root := "/Users/mark/project"
+	log := newAccessLog()
+	log.netOut["registry.npmjs.org"] = 4
+	log.fileReads[root+"/package.json"] = 2
+	log.fileWrites[root+"/node_modules/.cache/x"] = 7
+
+	path := filepath.Join(t.TempDir(), "raw.json")
+	if err := writeAccessDump(path, log, root); err != nil {
+		t.Fatalf("writeAccessDump failed: %v", err)
+	}
+
+	dump, err := loadAccessDump(path)
+	if err != nil {
+		t.Fatalf("loadAccessDump failed: %v", err)
+	}
+	if dump.Root != root {
+		t.Errorf("expected root %q, got %q", root, dump.Root)
+	}
+
+	loaded := dump.accessLog()
+	if loaded.netOut["registry.npmjs.org"] != 4 || loaded.fileReads[root+"/package.json"] != 2 ||
+		loaded.fileWrites[root+"/node_modules/.cache/x"] != 7 {
+		t.Errorf("counts not preserved: %+v", loaded)
+	}
+
+	// Re-running synthesis on the dump gives the same suggestion
+	orig := suggestConfig(log, root)
+	again := suggestConfig(loaded, dump.Root)
+	if len(orig.AllowNet) != len(again.AllowNet) || len(orig.AllowWrite) != len(again.AllowWrite) {
+		t.Errorf("suggestion differs after reload: %+v vs %+v", orig, again)
+	}
+}
+
+func TestParseTraceArgsChildFlags(t *testing.T) {
+	flags, command, err := parseTraceArgs([]string{"--root", "/repo", "--", "./build.sh", "--save", "--dump", "x"})
+	if err != nil {
+		t.Fatalf("parseTraceArgs: %v", err)
+	}
+	if flags.save || flags.dump != "" {
+		t.Errorf("flags after -- were consumed by ddash: %+v", flags)
+	}
+	if flags.root != "/repo" {
+		t.Errorf("root = %q, want /repo", flags.root)
+	}
+	if len(command) != 4 || command[1] != "--save" || command[2] != "--dump" {
+		t.Errorf("command = %v, want [./build.sh --save --dump x]", command)
+	}
+
+	flags, command, err = parseTraceArgs([]string{"--save", "--from", "raw.json"})
+	if err != nil {
+		t.Fatalf("parseTraceArgs: %v", err)
+	}
+	if !flags.save || flags.from != "raw.json" || command != nil {
+		t.Errorf("flags = %+v, command = %v", flags, command)
+	}
+}
+
+func TestQuorumLogIntersection(t *testing.T) {
+	a, b, c := newAccessLog(), newAccessLog(), newAccessLog()
+	for _, l := range []*accessLog{a, b, c} {
+		l.netOut["registry.npmjs.org"] = 2
+		l.fileWrites["/repo/dist/app.js"] = 1
+	}
+	a.netOut["telemetry.example.com"] = 1
+	a.fileWrites["/tmp/rand-1234"] = 1
+	b.fileWrites["/tmp/rand-5678"] = 1
+	b.fileReads["/repo/extra.json"] = 1
+
+	got := quorumLog([]*accessLog{a, b, c}, 3)
+
+	if len(got.netOut) != 1 || got.netOut["registry.npmjs.org"] != 6 {
+		t.Errorf("netOut = %v, want only registry.npmjs.org with summed count 6", got.netOut)
+	}
+	if len(got.fileWrites) != 1 || got.fileWrites["/repo/dist/app.js"] != 3 {
+		t.Errorf("fileWrites = %v, want only /repo/dist/app.js", got.fileWrites)
+	}
+	if got.fileReads["/repo/extra.json"] != 1 {
+		t.Errorf("reads should be combined across runs, got %v", got.fileReads)
+	}
+}
+
+func TestQuorumLogThreshold(t *testing.T) {
+	a, b, c := newAccessLog(), newAccessLog(), newAccessLog()
+	a.netOut["cdn.example.com"] = 1
+	b.netOut["cdn.example.com"] = 1
+	c.netOut["once.example.com"] = 1
+
+	got := quorumLog([]*accessLog{a, b, c}, 2)
+	if _, ok := got.netOut["cdn.example.com"]; !ok {
+		t.Error("entry seen in 2 of 3 runs should meet a quorum of 2")
+	}
+	if _, ok := got.netOut["once.example.com"]; ok {
+		t.Error("entry seen in 1 of 3 runs should not meet a quorum of 2")
+	}
+
+	if got := quorumLog([]*accessLog{a, b, c}, 1); len(got.netOut) != 2 {
+		t.Errorf("quorum 1 should be the union, got %v", got.netOut)
+	}
+}
+
+func TestParseTraceArgsRuns(t *testing.T) {
+	flags, _, err := parseTraceArgs([]string{"--runs", "3", "--", "make"})
+	if err != nil {
+		t.Fatalf("parseTraceArgs: %v", err)
+	}
+	if flags.runs != 3 || flags.quorum != 3 {
+		t.Errorf("runs=%d quorum=%d, want quorum defaulting to runs", flags.runs, flags.quorum)
+	}
+
+	for _, args := range [][]string{
+		{"--runs", "0", "--", "make"},
+		{"--runs", "2", "--quorum", "3", "--", "make"},
+		{"--runs", "2", "--from", "raw.json"},
+		{"--verify", "--from", "raw.json"},
+	} {
+		if _, _, err := parseTraceArgs(args); err == nil {
+			t.Errorf("parseTraceArgs(%v) should fail", args)
+		}
+	}
+}
+
+func TestTraceRunsReplayStdin(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(dir)
+	seen := filepath.Join(dir, "seen")
+	t.Setenv("DDASH_TEST_SEEN", seen)
+	stubExecCommand(t, `cat >> "$DDASH_TEST_SEEN"`)
+
+	stdin := filepath.Join(dir, "input")
+	os.WriteFile(stdin, []byte("answer\n"), 0644)
+	f, err := os.Open(stdin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	origStdin, origArgs := os.Stdin, os.Args
+	os.Stdin, os.Args = f, []string{"ddash", "trace", "--runs", "2", "--", "sh"}
+	defer func() { os.Stdin, os.Args = origStdin, origArgs }()
+
+	if err := traceCmd(); err != nil {
+		t.Fatalf("traceCmd: %v", err)
+	}
+	if got, _ := os.ReadFile(seen); string(got) != "answer\nanswer\n" {
+		t.Errorf("runs read %q, want the same input twice", got)
+	}
+}
+
+func TestAnalyzeTraceCapsEntriesPerDir(t *testing.T) {
+	var sb strings.Builder
+	for i := 0; i < maxTraceEntriesPerDir+10; i++ {
+		fmt.Fprintf(&sb, "file-write-create \"/repo/build/obj%d.o\"\n", i)
+	}
+	sb.WriteString("file-write-data \"/repo/build/obj0.o\"\n")
+	sb.WriteString("network-outbound \"registry.npmjs.org\"\n")
+
+	log := analyzeTraceReader(strings.NewReader(sb.String()))
+
+	if got := len(log.fileWrites); got != maxTraceEntriesPerDir+1 {
+		t.Errorf("tracked %d write entries, want %d plus one collapsed entry", got, maxTraceEntriesPerDir)
+	}
+	if got := log.fileWrites["/repo/build/*"]; got != 10 {
+		t.Errorf("collapsed entry count = %d, want 10", got)
+	}
+	if got := log.fileWrites["/repo/build/obj0.o"]; got != 2 {
+		t.Errorf("existing entry should keep counting past the cap, got %d", got)
+	}
+	if log.netOut["registry.npmjs.org"] != 1 {
+		t.Errorf("netOut = %v", log.netOut)
+	}
+}
+
+func TestTraceProcess(t *testing.T) {
+	tests := []struct {
+		line, want string
+	}{
+		{`Sandbox: curl(4242) allow network-outbound "registry.npmjs.org"`, "curl"},
+		{`python3(17) file-read-data "/repo/main.py"`, "python3"},
+		{`Sandbox: node(99) deny(1) file-write-create "/etc/hosts"`, "node"},
+		{`Sandbox: deny(1) file-write-create "/etc/hosts"`, ""},
+		{`file-read-data "/repo/notes(1).txt"`, ""},
+		{`file-read-data "/repo/main.go"`, ""},
+		{`make(abc) file-read-data "/repo/Makefile"`, ""},
+	}
+	for _, tt := range tests {
+		if got := traceProcess(tt.line); got != tt.want {
+			t.Errorf("traceProcess(%q) = %q, want %q", tt.line, got, tt.want)
+		}
+	}
+}
+
+func TestAnalyzeTraceByProcess(t *testing.T) {
+	trace := strings.Join([]string{
+		`Sandbox: curl(4242) allow network-outbound "registry.npmjs.org"`,
+		`Sandbox: curl(4242) allow network-outbound "cdn.example.com"`,
+		`Sandbox: curl(4250) allow network-outbound "registry.npmjs.org"`,
+		`Sandbox: python3(17) allow file-read-data "/repo/a.py"`,
+		`Sandbox: python3(17) allow file-read-data "/repo/b.py"`,
+		`Sandbox: python3(17) allow file-write-create "/repo/out.txt"`,
+		`file-read-data "/repo/unattributed"`,
+	}, "\n")
+	log := analyzeTraceReader(strings.NewReader(trace))
+
+	if log.netOut["registry.npmjs.org"] != 2 || log.fileReads["/repo/unattributed"] != 1 {
+		t.Errorf("overall totals changed: net %v, reads %v", log.netOut, log.fileReads)
+	}
+	got := processSummary(log)
+	want := []string{
+		"curl: 2 network hosts",
+		"python3: 2 file reads, 1 file write",
+	}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("processSummary = %q, want %q", got, want)
+	}
+
+	// Dumps keep the breakdown for --from
+	path := filepath.Join(t.TempDir(), "raw.json")
+	if err := writeAccessDump(path, log, "/repo"); err != nil {
+		t.Fatal(err)
+	}
+	dump, err := loadAccessDump(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := processSummary(dump.accessLog()); strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("after a dump round trip, processSummary = %q", got)
+	}
+}
+
+func TestFollowTraceWhileWriting(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "trace.log")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	done := make(chan struct{})
+	parsed := followTrace(path, done)
+
+	fmt.Fprintln(f, `file-read-data "/repo/main.go"`)
+	time.Sleep(2 * traceFollowInterval)
+	// A line split across writes must still be parsed once
+	fmt.Fprint(f, `file-write-data "/repo/`)
+	time.Sleep(2 * traceFollowInterval)
+	fmt.Fprintln(f, `out.txt"`)
+	close(done)
+
+	select {
+	case log := <-parsed:
+		if log.fileReads["/repo/main.go"] != 1 || log.fileWrites["/repo/out.txt"] != 1 {
+			t.Errorf("reads = %v, writes = %v", log.fileReads, log.fileWrites)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("followTrace did not finish after done")
+	}
+}
+
+// BenchmarkAnalyzeTrace parses a synthetic one-million-line trace log.
+func BenchmarkAnalyzeTrace(b *testing.B) {
+	var sb strings.Builder
+	for i := 0; i < 1000000; i++ {
+		switch i % 4 {
+		case 0, 1:
+			fmt.Fprintf(&sb, "file-read-data \"/repo/src/pkg%d/file%d.go\"\n", i%50, i%5000)
+		case 2:
+			fmt.Fprintf(&sb, "file-write-create \"/repo/build/obj%d.o\"\n", i)
+		case 3:
+			fmt.Fprintf(&sb, "network-outbound \"host%d.example.com\"\n", i%20)
+		}
+	}
+	data := sb.String()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		analyzeTraceReader(strings.NewReader(data))
+	}
+}
+
+func TestLastTraceRoundTrip(t *testing.T) {
+	root := t.TempDir()
+	if _, err := readLastTrace(root); err == nil || !strings.Contains(err.Error(), "ddash trace") {
+		t.Errorf("expected a hint to run trace first, got %v", err)
+	}
+
+	cfg := SandboxConfig{Name: "traced", AllowNet: netEntries("registry.npmjs.org"), AllowRead: pathEntries(".")}
+	if err := writeLastTrace(root, []string{"npm", "install"}, cfg); err != nil {
+		t.Fatalf("writeLastTrace failed: %v", err)
+	}
+	info, err := os.Stat(filepath.Join(root, lastTracePath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("cached trace has mode %v, want 0600", info.Mode().Perm())
+	}
+
+	trace, err := readLastTrace(root)
+	if err != nil {
+		t.Fatalf("readLastTrace failed: %v", err)
+	}
+	if trace.Command != "npm install" || trace.Root != root || trace.TracedAt == "" {
+		t.Errorf("unexpected cached trace: %+v", trace)
+	}
+	if trace.Config.Name != "traced" || len(trace.Config.AllowNet) != 1 {
+		t.Errorf("cached config = %+v, want the suggestion", trace.Config)
+	}
+}
+
+func TestLoadTraceConfig(t *testing.T) {
+	origDir, _ := os.Getwd()
+	dir := t.TempDir()
+	os.Chdir(dir)
+	defer os.Chdir(origDir)
+
+	writeLastTrace(dir, []string{"make"}, SandboxConfig{Name: "traced", AllowRead: pathEntries(".")})
+
+	var out strings.Builder
+	cfg, err := loadTraceConfig(&out)
+	if err != nil {
+		t.Fatalf("loadTraceConfig failed: %v", err)
+	}
+	if cfg.Isolation != isolationProcess || len(cfg.AllowWrite) != 1 {
+		t.Errorf("expected run defaults to be applied, got %+v", cfg)
+	}
+	if !strings.Contains(out.String(), `trace of "make"`) || !strings.Contains(out.String(), "ephemeral") {
+		t.Errorf("expected the trace source and an ephemeral warning, got %q", out.String())
+	}
+	if _, err := os.Stat(filepath.Join(dir, ".ddash.json")); err == nil {
+		t.Error("--use-trace must not write .ddash.json")
+	}
+}
+
+func TestInitFromLogWritesValidConfig(t *testing.T) {
+	root := t.TempDir()
+
+	// What tracing "sh -c 'curl -so out.json https://api.github.com'" sees
+	log := newAccessLog()
+	log.netOut["api.github.com"]++
+	log.fileReads["/bin/sh"]++
+	log.fileWrites[filepath.Join(root, "out.json")]++
+
+	var out strings.Builder
+	if err := initFromLog(&out, log, root, []string{"sh", "-c", "curl"}); err != nil {
+		t.Fatalf("initFromLog failed: %v", err)
+	}
+
+	cfg, err := readConfig(filepath.Join(root, configPath()))
+	if err != nil {
+		t.Fatalf("saved config does not load: %v", err)
+	}
+	if !checksumValid(cfg) {
+		t.Error("saved config should carry a valid checksum")
+	}
+	if len(cfg.AllowNet) != 1 || cfg.AllowNet[0].Host != "api.github.com" ||
+		len(cfg.AllowWrite) != 1 || cfg.AllowWrite[0] != "." {
+		t.Errorf("unexpected policy: net %v, write %v", cfg.AllowNet, cfg.AllowWrite)
+	}
+	if !strings.Contains(out.String(), `"api.github.com"`) {
+		t.Errorf("expected the saved config to be printed, got:\n%s", out.String())
+	}
+	if _, err := readLastTrace(root); err != nil {
+		t.Errorf("expected the trace to be cached for --use-trace: %v", err)
+	}
+}
+
+func TestReportVerification(t *testing.T) {
+	var out strings.Builder
+	if !reportVerification(&out, ExitResult{ExitCode: 0}) {
+		t.Error("a clean run should pass verification")
+	}
+	if !strings.Contains(out.String(), "works") {
+		t.Errorf("unexpected report: %s", out.String())
+	}
+
+	out.Reset()
+	failed := ExitResult{
+		ExitCode: 1,
+		Denials:  []Denial{{Process: "node", Operation: "file-read-data", Target: "/opt/tool/lib.js", Count: 2}},
+	}
+	if reportVerification(&out, failed) {
+		t.Error("a failing run should not pass verification")
+	}
+	report := out.String()
+	for _, want := range []string{"exit 1", "file-read-data /opt/tool/lib.js", "widen"} {
+		if !strings.Contains(report, want) {
+			t.Errorf("report missing %q:\n%s", want, report)
+		}
+	}
+
+	out.Reset()
+	if reportVerification(&out, ExitResult{ExitCode: 0, Denials: failed.Denials}) {
+		t.Error("denials should fail verification even when the command exits 0")
+	}
+}
+
+func TestExcludeAccess(t *testing.T) {
+	root := "/Users/mark/project"
+	log := newAccessLog()
+	log.netOut["pypi.org"] = 1
+	log.fileReads[root+"/app/main.py"] = 3
+	log.fileReads[root+"/app/__pycache__/main.cpython-312.pyc"] = 2
+	log.fileWrites["/opt/python/lib/site-packages/six/__pycache__/six.cpython-312.pyc"] = 1
+	log.fileWrites["/opt/python/lib/site-packages/six/six.pyc"] = 1
+	log.fileWrites[root+"/build/out/app.bin"] = 4
+	log.fileWrites["/Users/mark/.local/state/tool/log"] = 1
+
+	excludeAccess(log, root, append([]string{"build/*", "/Users/mark/.local"}, defaultTraceExcludes...))
+
+	if got := strings.Join(sortedKeys(log.fileReads), ","); got != root+"/app/main.py" {
+		t.Errorf("reads = %s, want only main.py", got)
+	}
+	if len(log.fileWrites) != 0 {
+		t.Errorf("writes = %v, want all excluded", log.fileWrites)
+	}
+	if log.excluded != 9 {
+		t.Errorf("excluded = %d, want 9 accesses", log.excluded)
+	}
+	if log.netOut["pypi.org"] != 1 {
+		t.Error("network hosts should not be excluded")
+	}
+
+	// Excluded writes outside root no longer widen the suggestion
+	if cfg := suggestConfig(log, root); len(cfg.AllowWrite) != 1 || cfg.AllowWrite[0] != "." {
+		t.Errorf("AllowWrite = %v, want [.]", cfg.AllowWrite)
+	}
+}
+
+func TestExcludeAccessByProcess(t *testing.T) {
+	root := "/Users/mark/project"
+	log := analyzeTraceReader(strings.NewReader(strings.Join([]string{
+		`Sandbox: python3(1) allow file-read-data "` + root + `/app/main.py"`,
+		`Sandbox: python3(1) allow file-write-create "` + root + `/app/__pycache__/main.pyc"`,
+		`Sandbox: python3(1) allow file-write-data "` + root + `/app/__pycache__/main.pyc"`,
+		`Sandbox: black(2) allow file-write-create "` + root + `/.ruff_cache/x"`,
+	}, "\n")))
+
+	excludeAccess(log, root, defaultTraceExcludes)
+
+	// The summary agrees with the "Excluded: 3" line above it
+	if got := strings.Join(processSummary(log), "; "); got != "python3: 1 file read" {
+		t.Errorf("by process = %q, want only python3's read", got)
+	}
+}
+
+func TestParseTraceArgsExclude(t *testing.T) {
+	flags, _, err := parseTraceArgs([]string{"--exclude", "*.log", "--exclude", "dist/*", "--no-default-excludes", "--", "make"})
+	if err != nil {
+		t.Fatalf("parseTraceArgs: %v", err)
+	}
+	if strings.Join(flags.excludes, " ") != "*.log dist/*" || !flags.noDefaults {
+		t.Errorf("flags = %+v", flags)
+	}
+	if _, _, err := parseTraceArgs([]string{"--exclude", "[a-", "--", "make"}); err == nil {
+		t.Error("a malformed glob should be rejected")
+	}
+}
@@ -0,0 +1,126 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// whoisTimeout bounds a [w]hois lookup so the prompt never hangs on it.
+const whoisTimeout = 3 * time.Second
+
+// whoisRoot answers which whois server is authoritative for a TLD.
+const whoisRoot = "whois.iana.org:43"
+
+// whoisFields are the registration details shown by [w]hois, matched
+// case-insensitively at the start of a response line. A freshly created
+// domain is a classic sign of an exfiltration endpoint.
+var whoisFields = []string{
+	"creation date",
+	"created",
+	"registrar:",
+	"registry expiry date",
+	"updated date",
+}
+
+// whoisLookup fetches the raw whois response for a domain.
+type whoisLookup func(ctx context.Context, domain string) (string, error)
+
+// lookupWhois queries IANA for the TLD's whois server, then asks that
+// server about the registrable part of domain.
+func lookupWhois(ctx context.Context, domain string) (string, error) {
+	name := registrableDomain(domain)
+	tld := name[strings.LastIndex(name, ".")+1:]
+
+	referral, err := queryWhois(ctx, whoisRoot, tld)
+	if err != nil {
+		return "", err
+	}
+	server := ""
+	for _, line := range strings.Split(referral, "\n") {
+		if v, ok := strings.CutPrefix(strings.TrimSpace(line), "refer:"); ok {
+			server = strings.TrimSpace(v)
+			break
+		}
+	}
+	if server == "" {
+		return "", fmt.Errorf("no whois server for .%s", tld)
+	}
+
+	return queryWhois(ctx, net.JoinHostPort(server, "43"), name)
+}
+
+// queryWhois sends one whois query over TCP and returns the response.
+func queryWhois(ctx context.Context, addr, query string) (string, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	if _, err := fmt.Fprintf(conn, "%s\r\n", query); err != nil {
+		return "", err
+	}
+	data, err := io.ReadAll(io.LimitReader(conn, 64<<10))
+	if err != nil && len(data) == 0 {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// registrableDomain trims subdomains, keeping the last two labels
+// (api.cdn.example.com -> example.com). Good enough for whois; it doesn't
+// know about multi-label suffixes like co.uk.
+func registrableDomain(domain string) string {
+	labels := strings.Split(strings.TrimSuffix(domain, "."), ".")
+	if len(labels) <= 2 {
+		return strings.Join(labels, ".")
+	}
+	return strings.Join(labels[len(labels)-2:], ".")
+}
+
+// summarizeWhois picks the whoisFields lines out of a whois response.
+func summarizeWhois(response string) []string {
+	var lines []string
+	scanner := bufio.NewScanner(strings.NewReader(response))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		lower := strings.ToLower(line)
+		for _, field := range whoisFields {
+			if strings.HasPrefix(lower, field) {
+				lines = append(lines, line)
+				break
+			}
+		}
+	}
+	return lines
+}
+
+// writeWhois prints registration details for domain using lookup, giving
+// up after whoisTimeout.
+func writeWhois(w io.Writer, lookup whoisLookup, domain string) {
+	ctx, cancel := context.WithTimeout(context.Background(), whoisTimeout)
+	defer cancel()
+
+	response, err := lookup(ctx, domain)
+	if err != nil {
+		fmt.Fprintf(w, "       whois:           lookup failed (%v)\n", err)
+		return
+	}
+	lines := summarizeWhois(response)
+	if len(lines) == 0 {
+		fmt.Fprintf(w, "       whois:           no registration details for %s\n", registrableDomain(domain))
+		return
+	}
+	for _, line := range lines {
+		fmt.Fprintf(w, "       %s\n", line)
+	}
+}
@@ -0,0 +1,55 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestRegistrableDomain(t *testing.T) {
+	tests := map[string]string{
+		"api.cdn.example.com": "example.com",
+		"example.com":         "example.com",
+		"example.com.":        "example.com",
+		"localhost":           "localhost",
+	}
+	for in, want := range tests {
+		if got := registrableDomain(in); got != want {
+			t.Errorf("registrableDomain(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestWriteWhois(t *testing.T) {
+	response := "Domain Name: EXAMPLE.COM\n" +
+		"   Registrar: Example Registrar, Inc.\n" +
+		"   Creation Date: 2026-10-16T00:00:00Z\n" +
+		"   Name Server: NS1.EXAMPLE.COM\n"
+	lookup := func(ctx context.Context, domain string) (string, error) {
+		if _, ok := ctx.Deadline(); !ok {
+			t.Error("lookup should run with a deadline")
+		}
+		return response, nil
+	}
+
+	var buf strings.Builder
+	writeWhois(&buf, lookup, "api.example.com")
+	out := buf.String()
+	for _, want := range []string{"Registrar: Example Registrar", "Creation Date: 2026-10-16"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("whois output missing %q:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "Name Server") {
+		t.Errorf("whois output should only show registration fields:\n%s", out)
+	}
+
+	buf.Reset()
+	writeWhois(&buf, func(context.Context, string) (string, error) {
+		return "", errors.New("timeout")
+	}, "example.com")
+	if !strings.Contains(buf.String(), "lookup failed (timeout)") {
+		t.Errorf("expected failure message, got %q", buf.String())
+	}
+}